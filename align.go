@@ -0,0 +1,82 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// alignment will work out what the Ring's header and data have to be
+// aligned to. Unless the options say otherwise, that's whatever the header
+// on disk says the Ring was created with, falling back to the page size for
+// a new Ring (or one from before the alignment was stored).
+func alignment(fd *os.File, options Options) (uintptr, error) {
+	var stored uintptr
+	if options.ReserveHeader && options.CustomHeader == nil {
+		var err error
+		if stored, err = storedAlignment(fd); err != nil {
+			return 0, err
+		}
+	}
+
+	align := uintptr(options.Alignment)
+	switch {
+	case align == 0 && stored != 0:
+		align = stored
+	case align == 0:
+		align = uintptr(pageSize())
+	case stored != 0 && stored != align:
+		return 0, fmt.Errorf("diskring: ring is aligned to %d bytes, not %d", stored, align)
+	}
+
+	if page := uintptr(pageSize()); align%page != 0 {
+		return 0, fmt.Errorf("diskring: alignment of %d bytes isn't a multiple of the page size (%d)", align, page)
+	}
+	return align, nil
+}
+
+// storedAlignment will read the alignment out of the library header at the
+// start of the file, before anything's been mapped, returning 0 if there's
+// no header there yet, or it doesn't know its alignment.
+func storedAlignment(fd *os.File) (uintptr, error) {
+	var hdr header
+	if err := readFull(fd, hdr.bytes(), 0); err != nil {
+		return 0, err
+	}
+	if hdr.magic != headerMagic {
+		return 0, nil
+	}
+	return uintptr(hdr.alignment), nil
+}
+
+// readFull will fill buf from the file at offset, leaving anything past the
+// end of the file zeroed.
+func readFull(fd *os.File, buf []byte, offset int64) error {
+	_, err := fd.ReadAt(buf, offset)
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// vim: foldmethod=marker
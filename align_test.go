@@ -0,0 +1,87 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"os"
+	"testing"
+)
+
+func TestRoundSize(t *testing.T) {
+	path := testRingPath(t)
+	page := pageSize()
+	fd, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+	if err := fd.Truncate(int64(4*page + 100)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewWithOptions(fd, Options{}); err == nil {
+		t.Fatal("expected an unaligned file to be refused")
+	}
+	r, err := NewWithOptions(fd, Options{RoundSize: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if r.Cap() != 4*page {
+		t.Fatalf("expected the Ring to be rounded down to %d bytes, got %d", 4*page, r.Cap())
+	}
+}
+
+func TestAlignment(t *testing.T) {
+	path := testRingPath(t)
+	page := pageSize()
+	options := Options{ReserveHeader: true, Alignment: 2 * page, CreateSize: 3 * page}
+	r := openTestRingAt(t, path, options)
+	// The size is rounded up to the alignment, and the header takes up
+	// a whole aligned chunk of its own.
+	if r.Cap() != 4*page {
+		t.Fatalf("expected %d bytes, got %d", 4*page, r.Cap())
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if stat, err := os.Stat(path); err != nil || stat.Size() != int64(6*page) {
+		t.Fatalf("expected a %d byte file (%v)", 6*page, err)
+	}
+
+	// The alignment is picked back up from the header.
+	r = openTestRingAt(t, path, Options{ReserveHeader: true})
+	if r.Cap() != 4*page {
+		t.Fatalf("expected %d bytes after reopening, got %d", 4*page, r.Cap())
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenWithOptions(path, Options{ReserveHeader: true, Alignment: 4 * page}); err == nil {
+		t.Fatal("expected a different alignment to be refused")
+	}
+	if _, err := OpenWithOptions(path, Options{ReserveHeader: true, Alignment: page + 1}); err == nil {
+		t.Fatal("expected an alignment that isn't a multiple of the page size to be refused")
+	}
+}
+
+// vim: foldmethod=marker
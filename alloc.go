@@ -21,6 +21,7 @@
 package diskring
 
 import (
+	"context"
 	"io"
 	"unsafe"
 )
@@ -32,12 +33,244 @@ var (
 	uintptrSize = unsafe.Sizeof(uintptr(0))
 )
 
+const (
+	// sectorSize is the size of the blocks that records are kept inside of
+	// when the record format is sector aligned (but not page aligned).
+	sectorSize = 4096
+)
+
+// UNSAFE
+//
+// Read the word (a length, or the size of some padding) at the provided
+// offset, which is either a uintptr or a uint32, depending on the record
+// format.
+func (r *Ring) word(off uintptr) uintptr {
+	if r.layout.wordSize == 4 {
+		return uintptr(*(*uint32)(unsafe.Pointer(&r.buf[off])))
+	}
+	return *(*uintptr)(unsafe.Pointer(&r.buf[off]))
+}
+
+// UNSAFE
+//
+// Write the word (a length, or the size of some padding) at the provided
+// offset.
+func (r *Ring) putWord(off, value uintptr) {
+	if r.layout.wordSize == 4 {
+		*(*uint32)(unsafe.Pointer(&r.buf[off])) = uint32(value)
+		return
+	}
+	*(*uintptr)(unsafe.Pointer(&r.buf[off])) = value
+}
+
 // UNSAFE
 //
 // Reset the cursor to 0, 0, "unlinking" all entries.
 func (r *Ring) reset() {
-	r.cursor.head = 0
-	r.cursor.tail = 0
+	if r.layout.has(formatFlags) {
+		for off := *r.head; off != *r.tail; off = r.nextEntry(off) {
+			r.dropEntry(off)
+		}
+	}
+	r.resetCursor()
+}
+
+// UNSAFE
+//
+// Reset the cursor to 0, 0 like reset, but without cleaning up anything the
+// entries hold outside the ring, for when something else has taken them
+// over.
+func (r *Ring) resetCursor() {
+	r.beginUpdate()
+	r.setHead(0, r.header.tailSeq)
+	r.setTail(0, r.header.tailSeq)
+	r.header.last = 0
+	r.endUpdate()
+	r.releaseOwned()
+	r.consumed()
+	if r.indexed {
+		r.index = r.index[:0]
+	}
+	r.sparse = r.sparse[:0]
+	if r.keys != nil {
+		r.keys = map[string]keyEntry{}
+	}
+	r.notify()
+}
+
+// UNSAFE
+//
+// Return the offset of the length of the entry at the provided offset,
+// skipping over any padding in front of it.
+func (r *Ring) entryStart(off uintptr) uintptr {
+	if !r.layout.has(formatSector) {
+		return off
+	}
+	if word := r.word(off); word&r.layout.padBit != 0 {
+		return off + word&^r.layout.padBit
+	}
+	return off
+}
+
+// UNSAFE
+//
+// Read the length of the entry at the provided offset.
+func (r *Ring) entryLength(off uintptr) uintptr {
+	return r.word(r.entryStart(off))
+}
+
+// UNSAFE
+//
+// Return the number of bytes an entry with length bytes of data takes up in
+// the ring, including the length and the envelope, but not any padding in
+// front of it.
+func (r *Ring) entrySize(length uintptr) uintptr {
+	wordSize := r.layout.wordSize
+	size := wordSize + r.layout.envelopeSize + length + r.layout.trailerSize
+	if r.layout.has(formatSector) {
+		size = (size + wordSize - 1) &^ (wordSize - 1)
+	}
+	return size
+}
+
+// UNSAFE
+//
+// Return the number of bytes of padding needed in front of an entry with
+// length bytes of data to be written at the provided offset.
+//
+// If the record format is sector aligned, any entry that fits inside a
+// sector is pushed forward to the start of the next sector rather than
+// straddling two. Since every entry starts on a word boundary, the length
+// of an entry never straddles two sectors either. If it's page aligned,
+// the sectors are pages, and entries too large to fit in one are pushed
+// forward to the start of the next page too.
+func (r *Ring) entryPadding(off, length uintptr) uintptr {
+	if !r.layout.has(formatSector) {
+		return 0
+	}
+	size := r.entrySize(length)
+	sector := r.layout.sectorSize
+	if off%sector+size <= sector || off%sector == 0 {
+		return 0
+	}
+	if size > sector && !r.layout.has(formatPage) {
+		return 0
+	}
+	return sector - off%sector
+}
+
+// UNSAFE
+//
+// Return the offset of the entry following the entry at the provided offset.
+func (r *Ring) nextEntry(off uintptr) uintptr {
+	start := r.entryStart(off)
+	return (start + r.entrySize(r.entryLength(off))) % r.size
+}
+
+// UNSAFE
+//
+// Write an entry containing buf (after the envelope's key, if it has one)
+// at the provided offset, returning the total number of bytes the entry
+// takes up in the ring.
+func (r *Ring) putEntry(off uintptr, e envelope, buf []byte) uintptr {
+	length := uintptr(len(e.key) + len(buf))
+	pad := r.entryPadding(off, length)
+	start := off + pad + r.layout.wordSize + r.layout.envelopeSize
+	copy(r.buf[start:], e.key)
+	copy(r.buf[start+uintptr(len(e.key)):], buf)
+	return r.sealEntry(off, pad, e, length)
+}
+
+// UNSAFE
+//
+// Write the padding, length, envelope and trailer of an entry whose length
+// bytes of data have already been copied into place after pad bytes of
+// padding, returning the total number of bytes the entry takes up in the
+// ring.
+func (r *Ring) sealEntry(off, pad uintptr, e envelope, length uintptr) uintptr {
+	start := off + pad
+	wordSize := r.layout.wordSize
+	if pad > 0 {
+		r.putWord(off, r.layout.padBit|pad)
+	}
+	r.putWord(start, length)
+	r.putEnvelope(start, e)
+	if r.layout.has(formatChain) {
+		r.putChain(start, length, e.chain)
+	}
+	if r.layout.has(formatSignature) {
+		r.putSignature(start, length, e.signature)
+	}
+	if r.layout.has(formatCanary) {
+		copy(r.buf[start+wordSize+r.layout.envelopeSize+length:], canary[:])
+	}
+	if r.layout.has(formatChecksum) {
+		r.putChecksum(start, length)
+	}
+	size := r.entrySize(length)
+	if r.layout.has(formatTrailer) {
+		r.putWord(start+size-wordSize, length)
+		if r.layout.has(formatSector) {
+			r.putWord(start+size-2*wordSize, pad)
+		}
+	}
+	r.written(off, pad+size)
+	return pad + size
+}
+
+// UNSAFE
+//
+// Return the offset of the entry before the entry at the provided offset,
+// using the trailing length stored after each entry's data. The entry at
+// off must not be the head.
+func (r *Ring) prevEntry(off uintptr) uintptr {
+	wordSize := r.layout.wordSize
+	length := r.word((off + r.size - wordSize) % r.size)
+	size := r.entrySize(length)
+	if r.layout.has(formatSector) {
+		size += r.word((off + r.size - 2*wordSize) % r.size)
+	}
+	return (off + r.size - size) % r.size
+}
+
+// UNSAFE
+//
+// Clean up anything held outside the ring by the entry at the provided
+// offset, which is about to be consumed or overwritten.
+func (r *Ring) dropEntry(off uintptr) {
+	e := envelope{flags: r.entryFlags(off)}
+	r.unspill(e, r.entryData(off))
+}
+
+// UNSAFE
+//
+// Return the data of the record stored in the entry at the provided offset.
+// If the record was spilled to a side file, this will read it back in,
+// otherwise this is a slice into the mapping, so it must be copied out
+// before the mutex is released. With a VerifyKey, this fails with
+// ErrBadSignature if the entry's signature doesn't check out.
+func (r *Ring) recordData(off uintptr) ([]byte, error) {
+	data := r.entryData(off)
+	if r.verifyKey != nil && !r.signatureValid(off) {
+		return nil, ErrBadSignature
+	}
+	switch flags := r.entryFlags(off); {
+	case flags&flagSpilled != 0:
+		return r.unspillData(data)
+	case flags&flagDictionary != 0:
+		return r.decompress(data)
+	}
+	return data, nil
+}
+
+// UNSAFE
+//
+// Return the data of the entry at the provided offset, after its key (if it
+// has one). This is a slice into the mapping, so it must be copied out
+// before the mutex is released.
+func (r *Ring) entryData(off uintptr) []byte {
+	start := r.entryStart(off) + r.layout.wordSize + r.layout.envelopeSize
+	return r.buf[start+r.entryKeyLength(off) : start+r.entryLength(off)]
 }
 
 // UNSAFE
@@ -52,8 +285,22 @@ func (r *Ring) advanceHead() error {
 	if r.len() == 0 {
 		return io.EOF
 	}
-	length := *(*uintptr)(unsafe.Pointer(&r.buf[r.cursor.head]))
-	r.cursor.head = (r.cursor.head + length + uintptrSize) % r.size
+	if r.tier != nil {
+		if err := r.tierHead(); err != nil {
+			return err
+		}
+	}
+	r.dropForConsumers()
+	r.dropEntry(*r.head)
+	r.dropKey(*r.head, r.header.headSeq)
+	r.beginUpdate()
+	r.setHead(r.nextEntry(*r.head), r.header.headSeq+1)
+	r.endUpdate()
+	if r.indexed {
+		r.index = r.index[1:]
+	}
+	r.releaseOwned()
+	r.dropSparse()
 	return nil
 }
 
@@ -73,6 +320,54 @@ func (r *Ring) freeBytes() uintptr {
 	return r.size - r.len()
 }
 
+// UNSAFE
+//
+// Return the offset of the most recently written entry. If we don't know
+// where that is (since it wasn't stored with the cursor), we'll walk the
+// ring to find it. The ring must not be empty.
+func (r *Ring) lastEntry() uintptr {
+	if r.header.last == 0 {
+		last := *r.head
+		for off := *r.head; off != *r.tail; off = r.nextEntry(off) {
+			last = off
+		}
+		r.header.last = last + 1
+	}
+	return r.header.last - 1
+}
+
+// UNSAFE
+//
+// Determine how many records are in the ring buffer.
+func (r *Ring) records() uint64 {
+	return r.header.tailSeq - r.header.headSeq
+}
+
+// UNSAFE
+//
+// Walk the ring to count the records between the head and the tail, and
+// fix up the sequence numbers to match. This is needed when the sequence
+// numbers weren't stored with the cursor (such as an in-memory header over
+// an existing file, or a file from before they were tracked).
+//
+// On a very large ring this can take a while, so it'll give up if the
+// context is done.
+func (r *Ring) recount(ctx context.Context) error {
+	var count uint64
+	for off := *r.head; off != *r.tail; off = r.nextEntry(off) {
+		count++
+		if count%4096 == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+	}
+	r.beginUpdate()
+	r.setTail(*r.tail, r.header.headSeq+count)
+	r.endUpdate()
+	return nil
+}
+
 // UNSAFE
 //
 // Determine how many bytes have been written to the ring buffer.
@@ -81,15 +376,15 @@ func (r *Ring) len() uintptr {
 	switch {
 	// If the head is past the tail, we have used all the data from the head
 	// to Size, then from 0 to Tail
-	case r.cursor.head > r.cursor.tail:
-		return (r.size - r.cursor.head) + r.cursor.tail
+	case *r.head > *r.tail:
+		return (r.size - *r.head) + *r.tail
 
 	// If the tail is past the head, we have used all the data from the head
 	// to the tail
-	case r.cursor.head < r.cursor.tail:
-		return r.cursor.tail - r.cursor.head
+	case *r.head < *r.tail:
+		return *r.tail - *r.head
 
-	// r.cursor.head == r.cursor.tail
+	// *r.head == *r.tail
 	default:
 		return 0
 	}
@@ -0,0 +1,124 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSectorAlign(t *testing.T) {
+	path := testRingPath(t)
+	r := openTestRingAt(t, path, Options{ReserveHeader: true, SectorAlign: true, NonBlockingReads: true})
+
+	var records []string
+	for _, size := range []int{1000, 3000, 10, 4000, 5000, 2000, 100, 3500} {
+		records = append(records, strings.Repeat(string(rune('a'+len(records))), size))
+	}
+	writeRecords(t, r, records...)
+
+	r.mutex.Lock()
+	sector := r.layout.sectorSize
+	for off := *r.head; off != *r.tail; off = r.nextEntry(off) {
+		start := r.entryStart(off)
+		if start%sector+r.layout.wordSize > sector {
+			t.Errorf("the length at %d straddles a sector", start)
+		}
+		size := r.entrySize(r.entryLength(off))
+		if size <= sector && start%sector+size > sector {
+			t.Errorf("the entry at %d (%d bytes) straddles a sector", start, size)
+		}
+	}
+	r.mutex.Unlock()
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r = openTestRingAt(t, path, Options{ReserveHeader: true, SectorAlign: true, NonBlockingReads: true})
+	defer r.Close()
+	for _, want := range records {
+		if record := readRecord(t, r); record != want {
+			t.Fatalf("expected a %d byte record, got %d bytes", len(want), len(record))
+		}
+	}
+}
+
+func TestPageAlign(t *testing.T) {
+	path := testRingPath(t)
+	options := Options{ReserveHeader: true, PageAlign: true, NonBlockingReads: true, CreateSize: 1 << 17}
+	r := openTestRingAt(t, path, options)
+
+	var records []string
+	for _, size := range []int{1000, 3000, 10000, 10, 5000, 100, 4000} {
+		records = append(records, strings.Repeat(string(rune('a'+len(records))), size))
+	}
+	writeRecords(t, r, records...)
+
+	r.mutex.Lock()
+	page := r.layout.sectorSize
+	if page != uintptr(pageSize()) {
+		t.Errorf("expected records to be padded to %d byte pages, got %d", pageSize(), page)
+	}
+	for off := *r.head; off != *r.tail; off = r.nextEntry(off) {
+		start := r.entryStart(off)
+		size := r.entrySize(r.entryLength(off))
+		if size > page && start%page != 0 {
+			t.Errorf("the entry at %d (%d bytes) doesn't start a page", start, size)
+		}
+		if size <= page && start%page+size > page {
+			t.Errorf("the entry at %d (%d bytes) straddles a page", start, size)
+		}
+	}
+	r.mutex.Unlock()
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r = openTestRingAt(t, path, options)
+	defer r.Close()
+	for _, want := range records {
+		if record := readRecord(t, r); record != want {
+			t.Fatalf("expected a %d byte record, got %d bytes", len(want), len(record))
+		}
+	}
+}
+
+func TestCompactLength(t *testing.T) {
+	path := testRingPath(t)
+	r := openTestRingAt(t, path, Options{ReserveHeader: true, CompactLength: true, NonBlockingReads: true})
+	writeRecords(t, r, "hello", "world")
+	if want := 2 * (4 + 5); r.Len() != want {
+		t.Fatalf("expected %d bytes used, got %d", want, r.Len())
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r = openTestRingAt(t, path, Options{ReserveHeader: true, CompactLength: true, NonBlockingReads: true})
+	defer r.Close()
+	for _, want := range []string{"hello", "world"} {
+		if record := readRecord(t, r); record != want {
+			t.Fatalf("expected %q, got %q", want, record)
+		}
+	}
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,64 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+//go:build linux
+// +build linux
+
+package diskring
+
+import (
+	"os"
+	"syscall"
+)
+
+// preallocate will have the filesystem set aside blocks for the first size
+// bytes of the file, filling in any holes, so that writing through the
+// mapping can't run out of disk space partway (which kills the process with
+// a SIGBUS, rather than returning an error). This returns an ENOSPC if
+// there isn't room. Filesystems (and files, such as block devices) that
+// can't do this are left as they are.
+func preallocate(fd *os.File, size int64) error {
+	for {
+		err := syscall.Fallocate(int(fd.Fd()), 0, 0, size)
+		switch err {
+		case syscall.EINTR:
+			continue
+		case syscall.EOPNOTSUPP, syscall.ENOSYS, syscall.ENODEV:
+			return nil
+		}
+		return err
+	}
+}
+
+// sparse will check if the file is a regular file with holes in it, which
+// the filesystem hasn't set aside any blocks for yet.
+func sparse(fd *os.File) (bool, error) {
+	stat, err := fd.Stat()
+	if err != nil {
+		return false, err
+	}
+	sys, ok := stat.Sys().(*syscall.Stat_t)
+	if !ok || !stat.Mode().IsRegular() {
+		return false, nil
+	}
+	return sys.Blocks*512 < stat.Size(), nil
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,68 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"os"
+	"testing"
+)
+
+func TestPreallocate(t *testing.T) {
+	path := testRingPath(t)
+	r := openTestRingAt(t, path, Options{})
+	holes, err := sparse(r.file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if holes {
+		t.Fatal("expected a new ring's file to be allocated up front")
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	sparsePath := testRingPath(t)
+	r = openTestRingAt(t, sparsePath, Options{AllowSparse: true})
+	defer r.Close()
+	if holes, err = sparse(r.file); err != nil {
+		t.Fatal(err)
+	}
+	if !holes {
+		t.Skip("the filesystem doesn't leave holes in files")
+	}
+
+	// Opening a sparse file again fills in the holes.
+	r2 := openTestRingAt(t, sparsePath, Options{})
+	defer r2.Close()
+	fd, err := os.Open(sparsePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+	if holes, err = sparse(fd); err != nil {
+		t.Fatal(err)
+	}
+	if holes {
+		t.Fatal("expected the holes to be filled in when the ring was opened")
+	}
+}
+
+// vim: foldmethod=marker
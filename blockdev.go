@@ -0,0 +1,62 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+//go:build !diskring_portable && !wasip1
+// +build !diskring_portable,!wasip1
+
+package diskring
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// blkGetSize64 is the BLKGETSIZE64 ioctl, which returns the size of a block
+// device in bytes.
+const blkGetSize64 = 0x80081272
+
+// fileSize will return the number of bytes of the file that can be used to
+// back the ring.
+//
+// For a regular file, this is just the size of the file. For a block device
+// (such as a partition dedicated to the ring), stat(2) reports a size of 0,
+// so we'll ask the kernel how large the device is, and round that down to
+// the page size, since partitions don't tend to be nicely aligned.
+func fileSize(fd *os.File) (int64, error) {
+	stat, err := fd.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if stat.Mode()&os.ModeDevice == 0 || stat.Mode()&os.ModeCharDevice != 0 {
+		return stat.Size(), nil
+	}
+
+	var size uint64
+	_, _, e1 := syscall.Syscall(syscall.SYS_IOCTL, fd.Fd(), blkGetSize64,
+		uintptr(unsafe.Pointer(&size)))
+	if e1 != 0 {
+		return 0, e1
+	}
+	pageSize := uint64(pageSize())
+	return int64(size - size%pageSize), nil
+}
+
+// vim: foldmethod=marker
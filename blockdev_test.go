@@ -0,0 +1,84 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+//go:build !diskring_portable && !wasip1
+// +build !diskring_portable,!wasip1
+
+package diskring
+
+import (
+	"os"
+	"testing"
+)
+
+func TestFileSize(t *testing.T) {
+	path := testRingPath(t)
+	fd, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+	if err := fd.Truncate(12345); err != nil {
+		t.Fatal(err)
+	}
+	if size, err := fileSize(fd); err != nil || size != 12345 {
+		t.Fatalf("expected a regular file to be 12345 bytes, got %d (%v)", size, err)
+	}
+}
+
+// TestBlockDevice will back a Ring with the block device named by
+// DISKRING_TEST_BLOCKDEV (such as a loop device), whose contents are
+// overwritten.
+func TestBlockDevice(t *testing.T) {
+	path := os.Getenv("DISKRING_TEST_BLOCKDEV")
+	if path == "" {
+		t.Skip("DISKRING_TEST_BLOCKDEV isn't set")
+	}
+	fd, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+
+	size, err := fileSize(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size == 0 || size%int64(pageSize()) != 0 {
+		t.Fatalf("expected a page aligned device size, got %d", size)
+	}
+
+	r, err := NewWithOptions(fd, Options{ReserveHeader: true, NonBlockingReads: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if want := int(size) - pageSize(); r.Cap() != want {
+		t.Fatalf("expected the Ring to hold %d bytes, got %d", want, r.Cap())
+	}
+	r.Reset()
+	writeRecords(t, r, "on", "the", "device")
+	for _, want := range []string{"on", "the", "device"} {
+		if record := readRecord(t, r); record != want {
+			t.Fatalf("expected %q, got %q", want, record)
+		}
+	}
+}
+
+// vim: foldmethod=marker
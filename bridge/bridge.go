@@ -0,0 +1,136 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+// Package bridge contains components which tail a diskring.Ring and forward
+// each record on to some other system, using the Ring as a local durability
+// buffer for when that system can't be reached.
+//
+// Records are only committed (consumed) from the Ring once they have been
+// accepted by the other end, so a crash or restart will pick up where the
+// last commit left off (if the Ring has a header on disk to persist the
+// cursor in). This means records may be forwarded more than once, so each
+// record is sent along with its sequence number to allow for deduplication
+// (and, for records coalesced into a batch, which share the batch's
+// sequence number, its position in the batch).
+//
+// The bridges only depend on small interfaces describing the client they
+// need, so that this package doesn't pull in any heavy client libraries.
+// Adapters for a specific client library belong in their own package.
+package bridge
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"pault.ag/go/diskring"
+)
+
+// Options controls how a bridge consumes records from the Ring.
+type Options struct {
+	// BatchSize is the largest number of records fetched from the Ring
+	// at once. If 0, 64 records will be fetched at a time.
+	BatchSize int
+
+	// PollInterval is how long to wait before checking the Ring again
+	// after finding it empty. If 0, this will be 100ms.
+	PollInterval time.Duration
+
+	// RetryInterval is how long to wait before trying to send a record
+	// again after a failure. If 0, this will be 1s.
+	RetryInterval time.Duration
+}
+
+func (o Options) batchSize() int {
+	if o.BatchSize <= 0 {
+		return 64
+	}
+	return o.BatchSize
+}
+
+func (o Options) pollInterval() time.Duration {
+	if o.PollInterval <= 0 {
+		return 100 * time.Millisecond
+	}
+	return o.PollInterval
+}
+
+func (o Options) retryInterval() time.Duration {
+	if o.RetryInterval <= 0 {
+		return time.Second
+	}
+	return o.RetryInterval
+}
+
+// sendFunc sends a single record on to the other system. The sequence
+// number and position of the record (see diskring.Batch) tell it apart from
+// every other record in the Ring.
+type sendFunc func(ctx context.Context, sequence uint64, position int, record []byte) error
+
+// pump will fetch records from the Ring and send each one, retrying each
+// record until it goes through, and committing each batch once all the
+// records in it have been sent. This only returns when the context is
+// done, or the Ring returns an error.
+func pump(ctx context.Context, ring *diskring.Ring, options Options, send sendFunc) error {
+	for {
+		batch, err := ring.Fetch(diskring.FetchOptions{
+			MaxRecords: options.batchSize(),
+		})
+		if err == io.EOF {
+			if err := sleep(ctx, options.pollInterval()); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		for i, record := range batch.Records {
+			for {
+				err := send(ctx, batch.Sequences[i], batch.Positions[i], record)
+				if err == nil {
+					break
+				}
+				if err := sleep(ctx, options.retryInterval()); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := ring.Commit(batch.Token); err != nil {
+			return err
+		}
+	}
+}
+
+// sleep will wait for the provided duration, or until the context is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,262 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"pault.ag/go/diskring"
+)
+
+// testPublisher is a JetStreamPublisher that keeps every message, and drops
+// duplicates, like a stream would.
+type testPublisher struct {
+	mutex    sync.Mutex
+	messages map[string]string
+	want     int
+	done     chan struct{}
+}
+
+func (p *testPublisher) Publish(ctx context.Context, subject string, msgID string, data []byte) (uint64, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if _, ok := p.messages[msgID]; ok {
+		return uint64(len(p.messages)), nil
+	}
+	p.messages[msgID] = string(data)
+	if len(p.messages) == p.want {
+		close(p.done)
+	}
+	return uint64(len(p.messages)), nil
+}
+
+// openTestRing will create a Ring in a temporary directory with the provided
+// Options, which is closed and removed when the test is done.
+func openTestRing(t *testing.T, options diskring.Options) *diskring.Ring {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "diskring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	options.CreateIfMissing = true
+	options.CreateSize = 1 << 16
+	ring, err := diskring.OpenWithOptions(filepath.Join(dir, "test.ring"), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ring.Close() })
+	return ring
+}
+
+// writeRecords will write each record to the Ring.
+func writeRecords(t *testing.T, ring *diskring.Ring, records ...string) {
+	t.Helper()
+	for _, record := range records {
+		if _, err := ring.Write([]byte(record)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// testProducer is a KafkaProducer that fails the first time it's called,
+// and keeps every message after that.
+type testProducer struct {
+	mutex    sync.Mutex
+	failed   bool
+	messages []KafkaMessage
+	want     int
+	done     chan struct{}
+}
+
+func (p *testProducer) Produce(ctx context.Context, message KafkaMessage) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if !p.failed {
+		p.failed = true
+		return errors.New("broker unreachable")
+	}
+	p.messages = append(p.messages, message)
+	if len(p.messages) == p.want {
+		close(p.done)
+	}
+	return nil
+}
+
+// runUntil will run a bridge until done is closed, and then stop it,
+// waiting for it to return.
+func runUntil(t *testing.T, run func(context.Context) error, done chan struct{}) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error)
+	go func() { stopped <- run(ctx) }()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Error("timed out waiting for the records to be sent")
+	}
+	cancel()
+	if err := <-stopped; err != context.Canceled {
+		t.Fatalf("expected the bridge to stop with context.Canceled, got %v", err)
+	}
+}
+
+// fastOptions retry and poll without waiting around.
+var fastOptions = Options{PollInterval: time.Millisecond, RetryInterval: time.Millisecond}
+
+func TestKafka(t *testing.T) {
+	ring := openTestRing(t, diskring.Options{})
+	writeRecords(t, ring, "one", "two", "three")
+
+	producer := &testProducer{want: 3, done: make(chan struct{})}
+	runUntil(t, (&Kafka{Ring: ring, Topic: "test", Producer: producer, Options: fastOptions}).Run, producer.done)
+
+	for i, message := range producer.messages {
+		if message.Topic != "test" || message.Sequence != uint64(i) ||
+			!bytes.Equal(message.Key, []byte{0, 0, 0, 0, 0, 0, 0, byte(i)}) {
+			t.Fatalf("message %d is wrong: %+v", i, message)
+		}
+	}
+	if string(producer.messages[0].Value) != "one" || string(producer.messages[2].Value) != "three" {
+		t.Fatalf("records weren't sent in order: %+v", producer.messages)
+	}
+	if n := ring.Records(); n != 0 {
+		t.Fatalf("expected the records to be consumed once sent, %d left", n)
+	}
+}
+
+// testMQTT is an MQTTPublisher that keeps every message's payload, and
+// checks how it was published.
+type testMQTT struct {
+	t        *testing.T
+	payloads []string
+	done     chan struct{}
+}
+
+func (p *testMQTT) Publish(ctx context.Context, topic string, qos byte, retained bool, payload []byte) error {
+	if topic != "sensors" || qos != 1 || !retained {
+		p.t.Errorf("published to %q at QoS %d (retained %v)", topic, qos, retained)
+	}
+	p.payloads = append(p.payloads, string(payload))
+	if len(p.payloads) == 2 {
+		close(p.done)
+	}
+	return nil
+}
+
+func TestMQTT(t *testing.T) {
+	ring := openTestRing(t, diskring.Options{})
+	writeRecords(t, ring, "one", "two")
+
+	if err := (&MQTT{Ring: ring, QoS: 3}).Run(context.Background()); err == nil {
+		t.Fatal("ran with a QoS of 3")
+	}
+
+	publisher := &testMQTT{t: t, done: make(chan struct{})}
+	mqtt := &MQTT{Ring: ring, Topic: "sensors", QoS: 1, Retained: true, Publisher: publisher, Options: fastOptions}
+	runUntil(t, mqtt.Run, publisher.done)
+	if len(publisher.payloads) != 2 || publisher.payloads[0] != "one" || publisher.payloads[1] != "two" {
+		t.Fatalf("expected one and two, got %v", publisher.payloads)
+	}
+}
+
+func TestJetStreamAcked(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.ring")
+	options := diskring.Options{CreateIfMissing: true, CreateSize: 1 << 16, ReserveHeader: true}
+
+	ring, err := diskring.OpenWithOptions(path, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeRecords(t, ring, "one", "two")
+	publisher := &testPublisher{messages: map[string]string{}, want: 2, done: make(chan struct{})}
+	runUntil(t, (&JetStream{Ring: ring, Subject: "test", MsgIDPrefix: "edge-", Publisher: publisher, Options: fastOptions}).Run, publisher.done)
+	if publisher.messages["edge-0"] != "one" || publisher.messages["edge-1"] != "two" {
+		t.Fatalf("expected edge-0 and edge-1, got %v", publisher.messages)
+	}
+	if err := ring.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The acknowledged stream sequence survives a restart.
+	ring, err = diskring.OpenWithOptions(path, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ring.Close()
+	if acked := (&JetStream{Ring: ring}).Acked(); acked != 2 {
+		t.Fatalf("expected stream sequence 2 to be acknowledged, got %d", acked)
+	}
+	if n := ring.Records(); n != 0 {
+		t.Fatalf("expected the records to be consumed, %d left", n)
+	}
+}
+
+func TestJetStreamCoalesced(t *testing.T) {
+	ring := openTestRing(t, diskring.Options{Coalesce: 64})
+
+	records := map[string]bool{}
+	for i := 0; i < 20; i++ {
+		record := fmt.Sprint("record", i)
+		if _, err := ring.Write([]byte(record)); err != nil {
+			t.Fatal(err)
+		}
+		records[record] = true
+	}
+	if err := ring.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	publisher := &testPublisher{messages: map[string]string{}, want: len(records), done: make(chan struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go (&JetStream{Ring: ring, Subject: "test", Publisher: publisher}).Run(ctx)
+	select {
+	case <-publisher.done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("only %d of %d records were published", len(publisher.messages), len(records))
+	}
+	cancel()
+
+	for _, record := range publisher.messages {
+		delete(records, record)
+	}
+	if len(records) != 0 {
+		t.Fatalf("records were dropped as duplicates: %v", records)
+	}
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,91 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package bridge
+
+import (
+	"context"
+	"strconv"
+
+	"pault.ag/go/diskring"
+)
+
+// JetStreamPublisher is the part of a NATS JetStream client needed to
+// forward records. Publish must set the message ID as the Nats-Msg-Id
+// header, so the stream can drop any records sent twice, and only return
+// once the stream has acknowledged the message, returning the stream
+// sequence from the acknowledgement.
+type JetStreamPublisher interface {
+	Publish(ctx context.Context, subject string, msgID string, data []byte) (uint64, error)
+}
+
+// JetStream will tail a Ring, and publish each record into a NATS JetStream
+// stream. The stream sequence of the last acknowledged record is tracked in
+// the Ring's checkpoint (see Ring.SetCheckpoint), so it's persisted in the
+// Ring header along with the cursor.
+//
+// Each record is published with a message ID built from MsgIDPrefix and the
+// record's sequence number in the Ring (followed by a dot and its position
+// in its batch, for a record coalesced into a batch other than the first),
+// so a record re-sent after a crash is dropped by the stream's duplicate
+// detection.
+type JetStream struct {
+	// Ring is the Ring to consume records from.
+	Ring *diskring.Ring
+
+	// Subject is the subject to publish records to.
+	Subject string
+
+	// MsgIDPrefix is prepended to the sequence number of the record to
+	// build the message ID. This should be unique to the Ring if more
+	// than one Ring is publishing to the same stream.
+	MsgIDPrefix string
+
+	// Publisher is the JetStream client used to publish records.
+	Publisher JetStreamPublisher
+
+	// Options controls how records are consumed from the Ring.
+	Options Options
+}
+
+// Acked will return the stream sequence of the last record acknowledged by
+// JetStream, as persisted in the Ring.
+func (j *JetStream) Acked() uint64 {
+	return j.Ring.Checkpoint()
+}
+
+// Run will publish records until the context is done, or the Ring returns
+// an error.
+func (j *JetStream) Run(ctx context.Context) error {
+	return pump(ctx, j.Ring, j.Options, func(ctx context.Context, sequence uint64, position int, record []byte) error {
+		msgID := j.MsgIDPrefix + strconv.FormatUint(sequence, 10)
+		if position > 0 {
+			msgID += "." + strconv.Itoa(position)
+		}
+		acked, err := j.Publisher.Publish(ctx, j.Subject, msgID, record)
+		if err != nil {
+			return err
+		}
+		j.Ring.SetCheckpoint(acked)
+		return nil
+	})
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,96 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package bridge
+
+import (
+	"context"
+	"encoding/binary"
+
+	"pault.ag/go/diskring"
+)
+
+// KafkaMessage is a single record to be produced to a Kafka topic.
+type KafkaMessage struct {
+	// Topic is the topic the message should be produced to.
+	Topic string
+
+	// Key is the big endian sequence number of the record in the Ring,
+	// which keeps records in order within a partition, and lets consumers
+	// drop any records sent twice. For a record coalesced into a batch,
+	// other than the first in it, this is followed by its big endian
+	// uint32 Position in the batch, since the records in a batch share a
+	// sequence number.
+	Key []byte
+
+	// Value is the record itself.
+	Value []byte
+
+	// Sequence is the sequence number of the record in the Ring, and
+	// Position is where it is in its batch of coalesced records (see
+	// diskring.Batch).
+	Sequence uint64
+	Position int
+}
+
+// KafkaProducer is the part of a Kafka client needed to forward records.
+// Produce must only return once the broker has acknowledged the message,
+// since the record will be consumed from the Ring right after.
+type KafkaProducer interface {
+	Produce(ctx context.Context, message KafkaMessage) error
+}
+
+// Kafka will tail a Ring, and produce each record to a Kafka topic.
+type Kafka struct {
+	// Ring is the Ring to consume records from.
+	Ring *diskring.Ring
+
+	// Topic is the Kafka topic to produce records to.
+	Topic string
+
+	// Producer is the Kafka client used to produce records.
+	Producer KafkaProducer
+
+	// Options controls how records are consumed from the Ring.
+	Options Options
+}
+
+// Run will forward records until the context is done, or the Ring returns
+// an error. If Kafka can't be reached, records will stay in the Ring and be
+// retried until it can be.
+func (k *Kafka) Run(ctx context.Context) error {
+	return pump(ctx, k.Ring, k.Options, func(ctx context.Context, sequence uint64, position int, record []byte) error {
+		key := make([]byte, 8, 12)
+		binary.BigEndian.PutUint64(key, sequence)
+		if position > 0 {
+			key = key[:12]
+			binary.BigEndian.PutUint32(key[8:], uint32(position))
+		}
+		return k.Producer.Produce(ctx, KafkaMessage{
+			Topic:    k.Topic,
+			Key:      key,
+			Value:    record,
+			Sequence: sequence,
+			Position: position,
+		})
+	})
+}
+
+// vim: foldmethod=marker
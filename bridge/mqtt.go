@@ -0,0 +1,73 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package bridge
+
+import (
+	"context"
+	"fmt"
+
+	"pault.ag/go/diskring"
+)
+
+// MQTTPublisher is the part of an MQTT client needed to forward records.
+// Publish must only return once the message has been delivered at the
+// requested QoS, since the record will be consumed from the Ring right after.
+type MQTTPublisher interface {
+	Publish(ctx context.Context, topic string, qos byte, retained bool, payload []byte) error
+}
+
+// MQTT will tail a Ring, and publish each record to an MQTT topic. While the
+// broker is offline, records will stay buffered in the Ring.
+type MQTT struct {
+	// Ring is the Ring to consume records from.
+	Ring *diskring.Ring
+
+	// Topic is the MQTT topic to publish records to.
+	Topic string
+
+	// QoS is the MQTT quality of service level (0, 1 or 2) to publish
+	// records with. Records published at QoS 0 are consumed from the Ring
+	// as soon as they're sent, so they may be lost if the broker drops
+	// them.
+	QoS byte
+
+	// Retained sets the retain flag on each published message.
+	Retained bool
+
+	// Publisher is the MQTT client used to publish records.
+	Publisher MQTTPublisher
+
+	// Options controls how records are consumed from the Ring.
+	Options Options
+}
+
+// Run will publish records until the context is done, or the Ring returns
+// an error.
+func (m *MQTT) Run(ctx context.Context) error {
+	if m.QoS > 2 {
+		return fmt.Errorf("bridge: invalid MQTT QoS %d", m.QoS)
+	}
+	return pump(ctx, m.Ring, m.Options, func(ctx context.Context, sequence uint64, position int, record []byte) error {
+		return m.Publisher.Publish(ctx, m.Topic, m.QoS, m.Retained, record)
+	})
+}
+
+// vim: foldmethod=marker
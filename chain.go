@@ -0,0 +1,163 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+// chainSize is the size of the hash stored in each record with HashChain.
+const chainSize = sha256.Size
+
+var (
+	// ErrNoChain is returned by VerifyChain when the Ring wasn't opened
+	// with HashChain.
+	ErrNoChain = errors.New("diskring: records aren't hash chained (see Options.HashChain)")
+
+	// ErrChainBroken is matched (using errors.Is) by every ChainError.
+	ErrChainBroken = errors.New("diskring: hash chain is broken")
+)
+
+// ChainError is returned by VerifyChain when a record's hash doesn't match
+// the record before it and its own data.
+type ChainError struct {
+	// Sequence is the sequence number of the first record that doesn't
+	// match.
+	Sequence uint64
+}
+
+// Error implements the error interface.
+func (e *ChainError) Error() string {
+	return fmt.Sprintf("diskring: hash chain is broken (sequence=%d)", e.Sequence)
+}
+
+// Is allows matching against ErrChainBroken with errors.Is.
+func (e *ChainError) Is(target error) bool {
+	return target == ErrChainBroken
+}
+
+// UNSAFE
+//
+// Return the hash of prev and the key and data of the entry whose length
+// (after any padding) is at start.
+func (r *Ring) link(prev []byte, start, length uintptr) [chainSize]byte {
+	data := start + r.layout.wordSize + r.layout.envelopeSize
+	h := sha256.New()
+	h.Write(prev)
+	h.Write(r.buf[data : data+length])
+	var sum [chainSize]byte
+	h.Sum(sum[:0])
+	return sum
+}
+
+// UNSAFE
+//
+// Write the hash into the entry whose length is at start, or if it's nil,
+// link the entry onto the end of the chain.
+func (r *Ring) putChain(start, length uintptr, chain []byte) {
+	if chain == nil {
+		r.chain = r.link(r.chain[:], start, length)
+		chain = r.chain[:]
+	}
+	copy(r.buf[start+r.layout.wordSize+r.layout.chainOffset:], chain)
+}
+
+// UNSAFE
+//
+// Return the hash stored in the entry at the provided offset. This is a
+// slice into the mapping, so it must be copied out before the mutex is
+// released.
+func (r *Ring) entryChain(off uintptr) []byte {
+	base := r.entryStart(off) + r.layout.wordSize + r.layout.chainOffset
+	return r.buf[base : base+chainSize]
+}
+
+// UNSAFE
+//
+// Pick the chain up from the last entry in the ring, if there is one, since
+// the chain head in the header may be ahead of it if the tail was rolled
+// back (see rollBackTorn).
+func (r *Ring) loadChain() {
+	if r.len() == 0 {
+		r.chain = r.header.chain
+		return
+	}
+	last := r.header.last
+	if last == 0 {
+		for off := *r.head; off != *r.tail; off = r.nextEntry(off) {
+			last = off + 1
+		}
+	}
+	copy(r.chain[:], r.entryChain(last-1))
+	r.header.chain = r.chain
+}
+
+// ChainHead will return the hash of the last record written to the Ring
+// (see HashChain), which every record before it still in the Ring can be
+// checked against with VerifyChain. Keeping hold of this somewhere else
+// makes it possible to tell if the records have been changed since, even
+// by someone who went on to fix up the hashes to match.
+func (r *Ring) ChainHead() []byte {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]byte{}, r.header.chain[:]...)
+}
+
+// VerifyChain will walk every record in the Ring from the head to the tail,
+// checking that the hash stored in each is the hash of the record before it
+// and its own data, and that the last matches the chain head in the header.
+// The first record that doesn't is returned as a ChainError.
+//
+// The hash of the record at the head can't be checked, since the record
+// before it is gone, so compare ChainHead against one noted earlier to tell
+// if everything was changed, right from the head.
+func (r *Ring) VerifyChain() error {
+	if !r.layout.has(formatChain) {
+		return ErrNoChain
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var prev []byte
+	seq := r.header.headSeq
+	for off := *r.head; off != *r.tail; off = r.nextEntry(off) {
+		chain := r.entryChain(off)
+		if prev != nil {
+			start := r.entryStart(off)
+			sum := r.link(prev, start, r.word(start))
+			if !bytes.Equal(chain, sum[:]) {
+				return &ChainError{Sequence: seq}
+			}
+		}
+		prev = chain
+		seq++
+	}
+	if prev != nil && !bytes.Equal(prev, r.header.chain[:]) {
+		return &ChainError{Sequence: seq - 1}
+	}
+	return nil
+}
+
+// vim: foldmethod=marker
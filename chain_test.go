@@ -0,0 +1,81 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestVerifyChain(t *testing.T) {
+	if err := openTestRing(t, Options{}).VerifyChain(); err != ErrNoChain {
+		t.Fatalf("expected ErrNoChain, got %v", err)
+	}
+
+	r := openTestRing(t, Options{HashChain: true})
+	writeRecords(t, r, "one")
+	head := r.ChainHead()
+	writeRecords(t, r, "two", "three")
+	if bytes.Equal(head, r.ChainHead()) {
+		t.Fatal("expected the chain head to move on with each record")
+	}
+	if err := r.VerifyChain(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changing a record breaks the chain at that record.
+	i := bytes.Index(r.buf[:r.size], []byte("two"))
+	if i < 0 {
+		t.Fatal("record not found in the ring")
+	}
+	r.buf[i] = 'T'
+	err := r.VerifyChain()
+	if !errors.Is(err, ErrChainBroken) {
+		t.Fatalf("expected ErrChainBroken, got %v", err)
+	}
+	if chainErr, ok := err.(*ChainError); !ok || chainErr.Sequence != 1 {
+		t.Fatalf("expected the second record to be blamed, got %v", err)
+	}
+}
+
+func TestChainAcrossReopen(t *testing.T) {
+	path := testRingPath(t)
+	options := Options{ReserveHeader: true, HashChain: true}
+	r := openTestRingAt(t, path, options)
+	writeRecords(t, r, "one", "two")
+	head := r.ChainHead()
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r = openTestRingAt(t, path, options)
+	defer r.Close()
+	if !bytes.Equal(head, r.ChainHead()) {
+		t.Fatal("expected the chain head to be kept in the header")
+	}
+	writeRecords(t, r, "three")
+	if err := r.VerifyChain(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,108 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+)
+
+// UNSAFE
+//
+// Return the checksum of the entry whose length (after any padding) is at
+// start, which covers the length, the envelope and the data.
+func (r *Ring) entrySum(start, length uintptr) uint32 {
+	end := start + r.layout.wordSize + r.layout.envelopeSize + length
+	return crc32.Checksum(r.buf[start:end], crcTable)
+}
+
+// UNSAFE
+//
+// Write the checksum of the entry whose length is at start into its
+// trailer.
+func (r *Ring) putChecksum(start, length uintptr) {
+	at := start + r.layout.wordSize + r.layout.envelopeSize + length + r.layout.checksumOffset
+	binary.LittleEndian.PutUint32(r.buf[at:], r.entrySum(start, length))
+}
+
+// UNSAFE
+//
+// Determine if the entry at the provided offset is all there, going by its
+// checksum. The entry has to fit in the remaining bytes, so that a length
+// that was never written out can't send us off the end of the ring.
+func (r *Ring) entryIntact(off, remaining uintptr) bool {
+	start := r.entryStart(off)
+	if start-off >= r.layout.sectorSize || start-off >= remaining {
+		return false
+	}
+	length := r.word(start)
+	if length > r.size || start-off+r.entrySize(length) > remaining {
+		return false
+	}
+	return r.checksumMatches(start, length)
+}
+
+// UNSAFE
+//
+// Determine if the checksum stored in the trailer of the entry whose length
+// is at start matches the entry.
+func (r *Ring) checksumMatches(start, length uintptr) bool {
+	at := start + r.layout.wordSize + r.layout.envelopeSize + length + r.layout.checksumOffset
+	return binary.LittleEndian.Uint32(r.buf[at:]) == r.entrySum(start, length)
+}
+
+// UNSAFE
+//
+// Roll the tail back to the first entry that isn't all there, going by
+// the checksums, throwing away it and everything after it. This is for
+// when the process (or the machine) went down part way through a write,
+// or before everything written made it out to disk, but the tail did.
+//
+// Everything before the tail of the last commit of the header (see
+// shadow.go) was flushed out before the commit, so only the entries since
+// then need to be checked, if we can tell where they start.
+func (r *Ring) rollBackTorn() {
+	off, seq := *r.head, r.header.headSeq
+	if r.libraryHeader {
+		shadow := shadowHeader(r.headerPage)
+		if shadow.committed() && shadow.generation == r.header.generation &&
+			shadow.tailSeq >= seq && shadow.tailSeq <= r.header.tailSeq {
+			off, seq = shadow.tail, shadow.tailSeq
+		}
+	}
+
+	remaining := (*r.tail + r.size - off) % r.size
+	for off != *r.tail {
+		if !r.entryIntact(off, remaining) {
+			r.beginUpdate()
+			r.setTail(off, seq)
+			r.header.last = 0
+			r.endUpdate()
+			return
+		}
+		next := r.nextEntry(off)
+		remaining -= (next + r.size - off) % r.size
+		off = next
+		seq++
+	}
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,70 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestRollBackTorn(t *testing.T) {
+	path := testRingPath(t)
+	options := Options{ReserveHeader: true, Checksums: true, NonBlockingReads: true}
+	r := openTestRingAt(t, path, options)
+	writeRecords(t, r, "record-one", "record-two")
+	if err := r.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	writeRecords(t, r, "record-three", "record-four")
+
+	// Take a copy of the file as it is now, as if the machine went down
+	// with the header written out, but not all of the third record.
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	at := bytes.Index(data, []byte("record-three"))
+	if at < 0 {
+		t.Fatal("the third record isn't in the file")
+	}
+	data[at+len("record-")] = 'T'
+	crashed := path + ".crashed"
+	if err := ioutil.WriteFile(crashed, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r = openTestRingAt(t, crashed, options)
+	defer r.Close()
+	var records []string
+	for r.Records() > 0 {
+		records = append(records, readRecord(t, r))
+	}
+	if want := []string{"record-one", "record-two"}; !reflect.DeepEqual(records, want) {
+		t.Fatalf("expected the torn record and everything after it to be rolled back, got %q", records)
+	}
+}
+
+// vim: foldmethod=marker
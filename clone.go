@@ -0,0 +1,96 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"os"
+	"unsafe"
+)
+
+// CloneTo will copy the contents of the Ring (and its header, if it has one)
+// to a new file at the provided path, producing an independent Ring which
+// can be opened with the same Options. The file must not already exist.
+//
+// Writes are blocked while the copy is made, but readers are only held up
+// for as long as it takes to copy the header. If the Ring doesn't have a
+// header on disk, the cursor isn't copied, just like it isn't persisted.
+func (r *Ring) CloneTo(path string) error {
+	r.writeMutex.Lock()
+	defer r.writeMutex.Unlock()
+
+	// Readers can still move the head along while we're copying, but they
+	// never touch the data, and writers are all blocked. So we only need
+	// to hold the mutex for long enough to get a consistent header.
+	r.mutex.Lock()
+	// Anything waiting to be coalesced has already been accepted, so it
+	// has to be in the clone.
+	if err := r.flushBatch(); err != nil {
+		r.mutex.Unlock()
+		return err
+	}
+	var headerPage []byte
+	if r.headerPage != nil {
+		headerPage = append([]byte{}, r.headerPage...)
+		if r.libraryHeader {
+			// The header in use might be an in-memory copy (if the
+			// cursor is read only), so copy that over what's on disk.
+			copy(headerPage, r.header.bytes())
+
+			// The clone is a Ring of its own, so nobody holds its
+			// write lease yet.
+			clone := (*header)(unsafe.Pointer(&headerPage[0]))
+			clone.lease, clone.owners = 0, 0
+			shadow := shadowHeader(headerPage)
+			shadow.lease, shadow.owners = 0, 0
+		}
+	}
+	r.mutex.Unlock()
+
+	fd, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if err := r.perms.apply(fd); err != nil {
+		fd.Close()
+		os.Remove(path)
+		return err
+	}
+	if err := r.cloneInto(fd, headerPage); err != nil {
+		fd.Close()
+		os.Remove(path)
+		return err
+	}
+	return fd.Close()
+}
+
+// cloneInto will write the header page and the ring data into the file, and
+// sync it to disk.
+func (r *Ring) cloneInto(fd *os.File, headerPage []byte) error {
+	if _, err := fd.Write(headerPage); err != nil {
+		return err
+	}
+	if _, err := fd.Write(r.buf[:r.size]); err != nil {
+		return err
+	}
+	return fd.Sync()
+}
+
+// vim: foldmethod=marker
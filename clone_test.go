@@ -0,0 +1,73 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// cloneTestRing will clone r into a new file, and open it with the provided
+// options.
+func cloneTestRing(t *testing.T, r *Ring, options Options) *Ring {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "diskring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "clone.ring")
+	if err := r.CloneTo(path); err != nil {
+		t.Fatal(err)
+	}
+	clone, err := OpenWithOptions(path, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { clone.Close() })
+	return clone
+}
+
+func TestCloneCoalesced(t *testing.T) {
+	options := Options{ReserveHeader: true, Coalesce: 64, CoalesceDelay: time.Hour}
+	r := openTestRing(t, options)
+	for _, record := range []string{"one", "two"} {
+		if _, err := r.Write([]byte(record)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	clone := cloneTestRing(t, r, options)
+	c, err := clone.Cursor("c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records := readAll(t, c); !reflect.DeepEqual(records, []string{"one", "two"}) {
+		t.Fatalf("expected the batch in the clone, got %v", records)
+	}
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,299 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// With Options.Coalesce, small records are packed together into a batch in
+// memory, which is written to the Ring as one entry flagged with
+// flagBatch. The data of a batch is each record's length as a uvarint,
+// followed by the record. Readers that unpack batches keep a batchCursor,
+// saying how far through the batch they are.
+
+// defaultCoalesceDelay is the longest a record waits in a batch, if the
+// options don't say.
+const defaultCoalesceDelay = 10 * time.Millisecond
+
+// errBadBatch is returned when a batch of records doesn't unpack.
+var errBadBatch = errors.New("diskring: batch of coalesced records is corrupt")
+
+// ErrCoalesced is returned by At and ReadOffset when the record asked for is
+// a batch of coalesced records, since a batch only has the one sequence
+// number (and index) for all of the records in it, so there's no telling
+// which of them was meant.
+var ErrCoalesced = errors.New("diskring: record is a batch of coalesced records")
+
+// UNSAFE
+//
+// Add a record to the batch, writing the batch out first if there isn't
+// room for it. The caller must hold both the writeMutex and the mutex.
+func (r *Ring) coalesceWrite(buf []byte) (int, error) {
+	if err := r.checkWrite(len(buf)); err != nil {
+		return 0, err
+	}
+	var length [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(length[:], uint64(len(buf)))
+	if len(r.batch)+n+len(buf) > r.coalesce {
+		if err := r.flushBatch(); err != nil {
+			return 0, err
+		}
+	}
+	r.batch = append(r.batch, length[:n]...)
+	r.batch = append(r.batch, buf...)
+	return len(buf), nil
+}
+
+// UNSAFE
+//
+// Write the batch out to the Ring as one entry, if there's anything in it.
+// Nothing can be staged after the tail. If the write fails, the batch is
+// kept, since its records have already been accepted, so that it can be
+// tried again.
+func (r *Ring) flushBatch() error {
+	if len(r.batch) == 0 {
+		return nil
+	}
+	e := r.newEnvelope()
+	e.flags |= flagBatch
+	if _, err := r.writeEntry(e, r.batch); err != nil {
+		return err
+	}
+	r.batch = r.batch[:0]
+	return nil
+}
+
+// Flush will write out any records waiting to be coalesced (see
+// Options.Coalesce) to the Ring now, rather than waiting for the batch to
+// fill up.
+func (r *Ring) Flush() error {
+	r.writeMutex.Lock()
+	defer r.writeMutex.Unlock()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.flushBatch()
+}
+
+// flushInBackground writes out the batch, for the flusher. If that fails,
+// the batch is kept, and the next Flush, Sync or Close (or write that fills
+// the batch) will try again, and return the error.
+func (r *Ring) flushInBackground() {
+	r.Flush()
+}
+
+// batchCursor is how far a reader is through a batch.
+type batchCursor struct {
+	// seq is the sequence number of the batch, and pos is the offset of
+	// the next record into it. If the reader isn't on the batch with
+	// this sequence number, it's at the start of whatever batch it's on.
+	seq uint64
+	pos int
+}
+
+// UNSAFE
+//
+// Return the next record in the batch in the entry at the provided offset,
+// whose sequence number is seq, along with the offset of the record after
+// it, or 0 if that was the last.
+func (b *batchCursor) next(r *Ring, off uintptr, seq uint64) ([]byte, int, error) {
+	data, err := r.recordData(off)
+	if err != nil {
+		return nil, 0, err
+	}
+	pos := 0
+	if b.seq == seq {
+		pos = b.pos
+	}
+	rec, next, err := unbatch(data, pos)
+	if err != nil {
+		return nil, 0, err
+	}
+	if next == len(data) {
+		next = 0
+	}
+	return rec, next, nil
+}
+
+// unbatch will return the record at pos in a batch, and the offset of the
+// record after it.
+func unbatch(data []byte, pos int) ([]byte, int, error) {
+	if pos >= len(data) {
+		return nil, 0, errBadBatch
+	}
+	length, n := binary.Uvarint(data[pos:])
+	if n <= 0 || length > uint64(len(data)-pos-n) {
+		return nil, 0, errBadBatch
+	}
+	start := pos + n
+	end := start + int(length)
+	return data[start:end], end, nil
+}
+
+// unbatchAll will return every record in a batch of coalesced records.
+func unbatchAll(data []byte) ([][]byte, error) {
+	var records [][]byte
+	for pos := 0; pos < len(data); {
+		rec, next, err := unbatch(data, pos)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+		pos = next
+	}
+	if len(records) == 0 {
+		return nil, errBadBatch
+	}
+	return records, nil
+}
+
+// UNSAFE
+//
+// Copy out the records in the entry at the provided offset, whose sequence
+// number is seq, along with their envelope: the entry's record, or if it's
+// a batch, each record in the batch that the head reader hasn't read yet,
+// all sharing the batch's envelope. This also returns where the first of
+// them is in the batch (counting records, not bytes).
+func (r *Ring) entryRecords(off uintptr, seq uint64) ([]Record, int, error) {
+	rec, err := r.entryRecord(off)
+	if err != nil {
+		return nil, 0, err
+	}
+	if r.entryFlags(off)&flagBatch == 0 {
+		return []Record{rec}, 0, nil
+	}
+	pos, first := 0, 0
+	if r.batched.seq == seq && seq == r.header.headSeq {
+		for ; pos < r.batched.pos; first++ {
+			if _, pos, err = unbatch(rec.Data, pos); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+	records, err := unbatchRecord(rec, pos)
+	return records, first, err
+}
+
+// unbatchRecord will split a Record holding a batch of coalesced records up
+// into a Record for each record in the batch from pos on, all sharing the
+// batch's envelope. The Data of each is a slice of the batch's Data, capped
+// so that appending to one can't run over the next.
+func unbatchRecord(batch Record, pos int) ([]Record, error) {
+	var records []Record
+	for pos < len(batch.Data) {
+		data, next, err := unbatch(batch.Data, pos)
+		if err != nil {
+			return nil, err
+		}
+		rec := batch
+		rec.Data = data[:len(data):len(data)]
+		records = append(records, rec)
+		pos = next
+	}
+	if len(records) == 0 {
+		return nil, errBadBatch
+	}
+	return records, nil
+}
+
+// UNSAFE
+//
+// Copy the next record in the batch at the head into buf, moving on to the
+// next record, or past the batch if that was the last.
+func (r *Ring) readBatched(buf []byte) (int, error) {
+	data, next, err := r.batched.next(r, *r.head, r.header.headSeq)
+	if err != nil {
+		return 0, err
+	}
+	if len(buf) < len(data) {
+		return 0, &ShortBufferError{Need: len(data), Have: len(buf)}
+	}
+	n := copy(buf, data)
+	return n, r.pastBatched(next)
+}
+
+// UNSAFE
+//
+// Move the head reader on to the record at next in the batch at the head,
+// or past the batch if next is 0.
+func (r *Ring) pastBatched(next int) error {
+	if next != 0 {
+		r.batched = batchCursor{seq: r.header.headSeq, pos: next}
+		return nil
+	}
+	r.batched = batchCursor{}
+	if err := r.advanceHead(); err != nil {
+		return err
+	}
+	r.consumed()
+	return nil
+}
+
+// UNSAFE
+//
+// Copy the last record in the batch in the entry at the provided offset
+// into buf.
+func (r *Ring) peekBatched(off uintptr, buf []byte) (int, error) {
+	data, err := r.recordData(off)
+	if err != nil {
+		return 0, err
+	}
+	var last []byte
+	for pos := 0; pos < len(data); {
+		if last, pos, err = unbatch(data, pos); err != nil {
+			return 0, err
+		}
+	}
+	if len(buf) < len(last) {
+		return 0, &ShortBufferError{Need: len(last), Have: len(buf)}
+	}
+	return copy(buf, last), nil
+}
+
+// UNSAFE
+//
+// Copy the next record in the batch the Consumer is on into buf, moving
+// the Consumer on to the next record, or past the batch if that was the
+// last.
+func (c *Consumer) readBatched(buf []byte) (int, error) {
+	r := c.r
+	data, next, err := c.batched.next(r, c.off, c.seq)
+	if err != nil {
+		return 0, err
+	}
+	if len(buf) < len(data) {
+		return 0, &ShortBufferError{Need: len(data), Have: len(buf)}
+	}
+	n := copy(buf, data)
+	if next != 0 {
+		c.batched = batchCursor{seq: c.seq, pos: next}
+		return n, nil
+	}
+	c.batched = batchCursor{}
+	c.off = r.nextEntry(c.off)
+	c.seq++
+	return n, nil
+}
+
+// vim: foldmethod=marker
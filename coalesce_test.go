@@ -0,0 +1,209 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// openCoalescedRing will create a Ring with Coalesce on, and ten records
+// written to it in batches.
+func openCoalescedRing(t *testing.T, options Options) (*Ring, []string) {
+	t.Helper()
+	options.Coalesce = 16
+	r := openTestRing(t, options)
+	var records []string
+	for i := 0; i < 10; i++ {
+		record := fmt.Sprint("rec", i)
+		if _, err := r.Write([]byte(record)); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	if err := r.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if r.Records() >= len(records) {
+		t.Fatalf("expected the records to be coalesced, got %d entries", r.Records())
+	}
+	return r, records
+}
+
+func TestFetchCoalesced(t *testing.T) {
+	r, records := openCoalescedRing(t, Options{})
+
+	// Read one record, so the head reader is part way through a batch.
+	buf := make([]byte, 64)
+	if _, err := r.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	batch, err := r.Fetch(FetchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, rec := range batch.Records {
+		got = append(got, string(rec))
+	}
+	if !reflect.DeepEqual(got, records[1:]) {
+		t.Fatalf("expected %v, got %v", records[1:], got)
+	}
+	if batch.Positions[0] != 1 || batch.Sequences[0] != batch.Sequences[1] {
+		t.Fatalf("expected the first record to be second in its batch, got sequences %v, positions %v",
+			batch.Sequences, batch.Positions)
+	}
+	type position struct {
+		seq uint64
+		pos int
+	}
+	seen := map[position]bool{}
+	for i := range batch.Records {
+		p := position{batch.Sequences[i], batch.Positions[i]}
+		if seen[p] {
+			t.Fatalf("record %d has the same sequence and position as another, %v", i, p)
+		}
+		seen[p] = true
+	}
+
+	batch, err = r.Fetch(FetchOptions{MaxRecords: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Commit(batch.Token); err != nil {
+		t.Fatal(err)
+	}
+	n, err := r.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := records[1+len(batch.Records)]; string(buf[:n]) != expected {
+		t.Fatalf("expected %q after the commit, got %q", expected, buf[:n])
+	}
+}
+
+func TestIteratorCoalesced(t *testing.T) {
+	r, records := openCoalescedRing(t, Options{})
+
+	var got []string
+	it := r.IterSnapshot()
+	for it.Next() {
+		got = append(got, string(it.Bytes()))
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, records) {
+		t.Fatalf("expected %v, got %v", records, got)
+	}
+
+	got = got[:0]
+	it = r.IterReverse()
+	for it.Next() {
+		got = append(got, string(it.Bytes()))
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	for i := range records {
+		if got[i] != records[len(records)-1-i] {
+			t.Fatalf("expected %v reversed, got %v", records, got)
+		}
+	}
+}
+
+func TestHistoryCoalesced(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, records := openCoalescedRing(t, Options{TierDir: dir})
+	buf := make([]byte, 64)
+	for i := 0; i < 7; i++ {
+		if _, err := r.Read(buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []string
+	it := r.IterHistory(0)
+	for it.Next() {
+		got = append(got, string(it.Record().Data))
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, records) {
+		t.Fatalf("expected %v, got %v", records, got)
+	}
+}
+
+func TestRedactCoalesced(t *testing.T) {
+	r, records := openCoalescedRing(t, Options{NonBlockingReads: true})
+	redacted, err := r.Redact(func(data []byte) bool {
+		return string(data) == "rec3"
+	}, func(data []byte) {
+		for i := range data {
+			data[i] = 'x'
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if redacted != 1 {
+		t.Fatalf("expected 1 record redacted, got %d", redacted)
+	}
+	records[3] = "xxxx"
+
+	var got []string
+	buf := make([]byte, 64)
+	for {
+		n, err := r.Read(buf)
+		if err == ErrEmpty {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(buf[:n]))
+	}
+	if !reflect.DeepEqual(got, records) {
+		t.Fatalf("expected %v, got %v", records, got)
+	}
+}
+
+func TestAtCoalesced(t *testing.T) {
+	r, _ := openCoalescedRing(t, Options{})
+	if _, err := r.At(0); err != ErrCoalesced {
+		t.Fatalf("expected ErrCoalesced, got %v", err)
+	}
+	if _, err := r.ReadOffset(0); err != ErrCoalesced {
+		t.Fatalf("expected ErrCoalesced, got %v", err)
+	}
+}
+
+// vim: foldmethod=marker
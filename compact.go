@@ -0,0 +1,248 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"context"
+	"fmt"
+)
+
+// Compact will reclaim the space taken up by records that have been
+// superseded by a newer record with the same key, like a compacted Kafka
+// topic. The newest record for each key, and every record without a key, is
+// kept, in the order it was written, and everything else is dropped, so that
+// slowly changing state can live in the Ring indefinitely, as long as the
+// latest value of each key fits. This returns the number of records
+// dropped.
+//
+// The records that are kept are moved up towards the head, and renumbered
+// so that the newest record keeps its sequence number, so the sequence
+// numbers (and Offsets) of anything written before a compaction that drops
+// records don't survive it. The Ring's generation changes too, so any
+// CursorState or saved Consumer position from before it is stale. Consumers
+// that are open are moved along with the record they're on (or on to the
+// next record that's kept, if theirs is dropped), so they carry on reading
+// where they left off.
+//
+// This requires the Keys option, and waits for any open Txn like Write.
+// See Options.CompactInterval to run this in the background.
+func (r *Ring) Compact() (int, error) {
+	r.writeMutex.Lock()
+	defer r.writeMutex.Unlock()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.keys == nil {
+		return 0, ErrNoKeys
+	}
+	if r.readOnly {
+		return 0, fmt.Errorf("diskring: read only")
+	}
+	return r.compact(func(off uintptr, seq uint64) bool {
+		key := r.entryKey(off)
+		return len(key) > 0 && r.keys[string(key)].seq != seq
+	}), nil
+}
+
+// compactInBackground is Compact, for the background compactor, which has nowhere to
+// send the result.
+func (r *Ring) compactInBackground() {
+	r.Compact()
+}
+
+// consumerMove is where compact moves an open Consumer to: seq is the
+// number of entries kept in front of it, off is where the entry it's on
+// ended up, and batched is set if it's part way through that entry's batch,
+// which it can carry on with.
+type consumerMove struct {
+	c       *Consumer
+	seq     uint64
+	off     uintptr
+	batched bool
+}
+
+// UNSAFE
+//
+// Drop every entry that drop says should go, given its offset and sequence
+// number, moving the rest up towards the head, returning the number of
+// entries dropped.
+//
+// An entry is only ever moved back, and can only get smaller (it may need
+// less padding in front of it, but never more), so moving the entries in
+// order never writes over one that hasn't been moved yet.
+func (r *Ring) compact(drop func(uintptr, uint64) bool) int {
+	behind := r.dropped()
+	headSeq := r.header.headSeq
+	generation := r.header.generation
+	original := r.owned
+	owned := original
+	r.owned = nil
+
+	// Open Consumers are found by the entry they're on, so they can be
+	// moved along with it. Anything behind the head is caught up first,
+	// so that what it missed is counted before the renumbering.
+	at := map[uint64][]*Consumer{}
+	for _, c := range r.consumers {
+		if c.generation != generation {
+			continue
+		}
+		c.catchUp()
+		at[c.seq] = append(at[c.seq], c)
+	}
+	var moved []consumerMove
+	headKept := false
+
+	var (
+		kept    uint64
+		dropped int
+		last    uintptr
+		to      = *r.head
+		seq     = headSeq
+	)
+	for from := *r.head; from != *r.tail; seq++ {
+		next := r.nextEntry(from)
+		var o *ownedRecord
+		if len(owned) > 0 && owned[0].seq == seq {
+			o = &owned[0]
+			owned = owned[1:]
+		}
+
+		gone := drop(from, seq)
+		if seq == headSeq {
+			headKept = !gone
+		}
+		for _, c := range at[seq] {
+			moved = append(moved, consumerMove{c: c, seq: kept, off: to, batched: !gone && c.inBatch()})
+		}
+
+		if gone {
+			r.dropEntry(from)
+			if o != nil {
+				o.producer.used -= o.size
+			}
+			dropped++
+			from = next
+			continue
+		}
+
+		size := r.moveEntry(from, to)
+		if o != nil {
+			o.producer.used += size - o.size
+			// The sequence number is fixed up once we know how many
+			// entries were kept.
+			r.owned = append(r.owned, ownedRecord{seq: kept, size: size, producer: o.producer})
+		}
+		last = to
+		to = (to + size) % r.size
+		kept++
+		from = next
+	}
+
+	if dropped == 0 {
+		r.owned = original
+		return 0
+	}
+	for _, c := range at[seq] {
+		moved = append(moved, consumerMove{c: c, seq: kept, off: to})
+	}
+
+	newHeadSeq := r.header.tailSeq - kept
+	for i := range r.owned {
+		r.owned[i].seq += newHeadSeq
+	}
+
+	r.beginUpdate()
+	r.setHead(*r.head, newHeadSeq)
+	r.setTail(to, r.header.tailSeq)
+	r.header.last = 0
+	if kept > 0 {
+		r.header.last = last + 1
+	}
+	r.header.generation = newGeneration()
+	r.endUpdate()
+
+	for _, m := range moved {
+		m.c.seq, m.c.off = newHeadSeq+m.seq, m.off
+		m.c.generation = r.header.generation
+		if m.batched {
+			m.c.batched.seq = m.c.seq
+		} else {
+			m.c.batched = batchCursor{}
+		}
+	}
+	if r.batched.seq == headSeq && headKept {
+		r.batched.seq = newHeadSeq
+	} else {
+		r.batched = batchCursor{}
+	}
+
+	// Anything the reader hadn't seen before is still unseen, and anything
+	// it had missed is still missed.
+	r.readSeq = newHeadSeq
+	if behind < newHeadSeq {
+		r.readSeq = newHeadSeq - behind
+	}
+
+	// Everything we know about where entries are is out of date, so it has
+	// to be built again. None of this can fail, since nothing else can be
+	// done with the ring while we're at it.
+	if r.indexed {
+		r.buildIndex(context.Background())
+	}
+	if r.keys != nil {
+		r.buildKeys(context.Background())
+	}
+	if r.sparseEvery > 0 {
+		r.buildSparse()
+	}
+	r.notify()
+	return dropped
+}
+
+// UNSAFE
+//
+// Move the entry at from to to, which must not be after from, returning the
+// total number of bytes the entry takes up in the ring at its new offset.
+func (r *Ring) moveEntry(from, to uintptr) uintptr {
+	if from == to {
+		return (r.nextEntry(from) + r.size - from) % r.size
+	}
+	e := envelope{
+		flags:       r.entryFlags(from),
+		contentType: r.entryContentType(from),
+		producer:    r.entryProducer(from),
+		schema:      r.entrySchema(from),
+		key:         append([]byte{}, r.entryKey(from)...),
+	}
+	if r.layout.has(formatTimestamp) {
+		e.time = r.entryTime(from)
+	}
+	if r.layout.has(formatChain) {
+		e.chain = append([]byte{}, r.entryChain(from)...)
+	}
+	if r.layout.has(formatSignature) {
+		e.signature = append([]byte{}, r.entrySignature(from)...)
+	}
+	return r.putEntry(to, e, append([]byte{}, r.entryData(from)...))
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,140 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"fmt"
+	"io"
+	"testing"
+)
+
+// readAll will read every record left for the Consumer.
+func readAll(t *testing.T, c *Consumer) []string {
+	t.Helper()
+	var records []string
+	buf := make([]byte, 1024)
+	for {
+		n, err := c.Read(buf)
+		if err == io.EOF {
+			return records
+		}
+		if err != nil {
+			t.Fatalf("reading record %d: %v", len(records), err)
+		}
+		records = append(records, string(buf[:n]))
+	}
+}
+
+func TestConsumerAfterCompact(t *testing.T) {
+	r := openTestRing(t, Options{Keys: true})
+	for i := 0; i < 100; i++ {
+		if _, err := r.WriteKey([]byte(fmt.Sprint("key", i%5)), []byte(fmt.Sprint("record", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, err := r.Cursor("c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1024)
+	for i := 0; i < 97; i++ {
+		if _, err := c.Read(buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if dropped, err := r.Compact(); err != nil || dropped != 95 {
+		t.Fatalf("Compact dropped %d records (%v), not 95", dropped, err)
+	}
+	for i := 100; i < 300; i++ {
+		if _, err := r.WriteKey([]byte(fmt.Sprint("key", i%5)), []byte(fmt.Sprint("record", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	records := readAll(t, c)
+	if len(records) != 203 || records[0] != "record97" || records[202] != "record299" {
+		t.Fatalf("read %d records after Compact, from %q to %q", len(records), records[0], records[len(records)-1])
+	}
+	if dropped := c.Dropped(); dropped != 0 {
+		t.Fatalf("Consumer dropped %d records", dropped)
+	}
+}
+
+func TestConsumerOnDroppedRecordAfterCompact(t *testing.T) {
+	r := openTestRing(t, Options{Keys: true})
+	for i := 0; i < 10; i++ {
+		r.WriteKey([]byte(fmt.Sprint("key", i%2)), []byte(fmt.Sprint("record", i)))
+	}
+	c, _ := r.Cursor("c1")
+	buf := make([]byte, 1024)
+	for i := 0; i < 3; i++ {
+		c.Read(buf)
+	}
+	r.Compact()
+
+	// The record the Consumer was on is gone, so it picks up from the
+	// next one that was kept.
+	records := readAll(t, c)
+	if len(records) != 2 || records[0] != "record8" || records[1] != "record9" {
+		t.Fatalf("read %q after Compact", records)
+	}
+}
+
+func TestConsumerAfterErase(t *testing.T) {
+	r := openTestRing(t, Options{})
+	for i := 0; i < 50; i++ {
+		r.Write([]byte(fmt.Sprint("record", i)))
+	}
+	c, _ := r.Cursor("c1")
+	buf := make([]byte, 1024)
+	for i := 0; i < 20; i++ {
+		c.Read(buf)
+	}
+	erased, err := r.Erase(func(data []byte) bool {
+		return string(data) < "record2"
+	})
+	if err != nil || erased != 12 {
+		t.Fatalf("erased %d records (%v), not 12", erased, err)
+	}
+
+	records := readAll(t, c)
+	if len(records) != 30 || records[0] != "record20" {
+		t.Fatalf("read %d records after Erase, starting with %q", len(records), records[0])
+	}
+}
+
+func TestConsumerStaleGeneration(t *testing.T) {
+	r := openTestRing(t, Options{})
+	r.Write([]byte("record"))
+	c, _ := r.Cursor("c1")
+
+	// As if another process compacted the Ring, and this one followed.
+	r.mutex.Lock()
+	r.header.generation = newGeneration()
+	r.mutex.Unlock()
+
+	if _, err := c.Read(make([]byte, 1024)); err != ErrStaleCursor {
+		t.Fatalf("Read after the generation changed returned %v", err)
+	}
+}
+
+// vim: foldmethod=marker
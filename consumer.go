@@ -0,0 +1,589 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// Consumer is a named reader of a Ring, which has its own position in the
+// Ring, separate from the head. Any number of Consumers can read the same
+// records, without consuming them for anyone else. Create one with
+// Ring.Cursor.
+//
+// Consumers don't hold records back from being overwritten, so a Consumer
+// that falls too far behind will miss records (see Dropped).
+type Consumer struct {
+	// dropped and droppedBytes are what the Consumer has missed. They're
+	// only changed with the Ring's mutex held, but always atomically, so
+	// that Stats can read them without it. They come first so that
+	// they're 64-bit aligned.
+	dropped      uint64
+	droppedBytes uint64
+
+	// skipped is the number of records passed over by the Consumer's
+	// Sampling, and paused is 1 between Pause and Resume, both kept the
+	// same way.
+	skipped uint64
+	paused  uint32
+
+	r     *Ring
+	name  string
+	store cursorStore
+
+	// producer, if it's not 0, is the only Producer whose records the
+	// Consumer reads (see Namespace.Cursor).
+	producer uint16
+
+	// everything below is protected by the Ring's mutex.
+
+	seq uint64
+	off uintptr
+
+	// generation is the Ring's generation the position belongs to. If the
+	// records were moved around without the Consumer being moved along
+	// with them (such as by a Compact in another process), the position
+	// is no good any more, and Read returns ErrStaleCursor.
+	generation uint64
+
+	// batched is how far the Consumer is through the batch it's on, if
+	// it's on one (see coalesce.go).
+	batched batchCursor
+
+	// sampler is which records the Consumer reads (see sample.go).
+	sampler sampler
+
+	// pausedDropped is what Dropped was when the Consumer was paused.
+	pausedDropped uint64
+}
+
+// ConsumerStats is a summary of what an open Consumer has missed.
+type ConsumerStats struct {
+	// Name is the name of the Consumer.
+	Name string
+
+	// Dropped is the number of records that left the Ring before the
+	// Consumer could read them (see Consumer.Dropped), and DroppedBytes
+	// is how much data they held (see Consumer.DroppedBytes).
+	Dropped      uint64
+	DroppedBytes uint64
+
+	// Skipped is the number of records passed over because of the
+	// Consumer's Sampling (see Consumer.Skipped).
+	Skipped uint64
+
+	// Paused is set if the Consumer is paused (see Consumer.Pause).
+	Paused bool
+}
+
+// cursorStore is somewhere a Consumer's position is persisted.
+type cursorStore interface {
+	// load will return the stored position, or false if there isn't one.
+	load() (CursorState, bool, error)
+
+	// save will store the position.
+	save(CursorState) error
+}
+
+// Cursor will return the Consumer with the provided name, which picks up
+// from wherever it was last committed (see Consumer.Commit), or from the
+// oldest record in the Ring if it's never been committed.
+//
+// If the Ring has a header on disk, the position of up to 8 Consumers is
+// kept in the header. Otherwise (or once those are all taken), the position
+// of each Consumer is persisted in a small file next to the Ring's file,
+// named after the Ring's file and the Consumer.
+func (r *Ring) Cursor(name string) (*Consumer, error) {
+	return r.cursor(name, 0)
+}
+
+// cursor will return the Consumer with the provided name, which only reads
+// records written by the provided Producer, unless that's 0.
+func (r *Ring) cursor(name string, producer uint16) (*Consumer, error) {
+	sidecar, err := r.sidecar(name)
+	if err != nil {
+		return nil, err
+	}
+	store, err := r.consumerStore(name, sidecar)
+	if err != nil {
+		return nil, err
+	}
+	return r.openConsumer(name, store, producer)
+}
+
+// RemoveCursor will forget the position of the Consumer with the provided
+// name, freeing up its slot in the header (or removing its file).
+func (r *Ring) RemoveCursor(name string) error {
+	sidecar, err := r.sidecar(name)
+	if err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	if r.libraryHeader {
+		if slot := r.header.consumer(consumerHash(name)); slot != nil {
+			*slot = consumerSlot{}
+		}
+	}
+	r.consumersMutex.Lock()
+	delete(r.consumers, name)
+	r.consumersMutex.Unlock()
+	r.mutex.Unlock()
+
+	if err := os.Remove(sidecar.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// consumerStore will pick where to keep the named Consumer's position. A
+// slot in the header is used if the Consumer already has one, or if one is
+// free and the Consumer doesn't already have a sidecar file.
+func (r *Ring) consumerStore(name string, sidecar *sidecarStore) (cursorStore, error) {
+	if !r.libraryHeader {
+		return sidecar, nil
+	}
+	hash := consumerHash(name)
+
+	r.mutex.Lock()
+	slot := r.header.consumer(hash)
+	r.mutex.Unlock()
+	if slot != nil {
+		return &slotStore{r: r, hash: hash}, nil
+	}
+
+	if _, err := os.Stat(sidecar.path); err == nil {
+		return sidecar, nil
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if slot = r.header.consumer(0); slot == nil {
+		return sidecar, nil
+	}
+	*slot = consumerSlot{
+		hash: hash,
+		seq:  r.header.headSeq,
+		off:  uint64(*r.head),
+	}
+	return &slotStore{r: r, hash: hash}, nil
+}
+
+// openConsumer will create a Consumer whose position is kept in the store.
+func (r *Ring) openConsumer(name string, store cursorStore, producer uint16) (*Consumer, error) {
+	state, ok, err := store.load()
+	if err != nil {
+		return nil, err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	c := &Consumer{
+		r:          r,
+		name:       name,
+		store:      store,
+		producer:   producer,
+		seq:        r.header.headSeq,
+		off:        *r.head,
+		generation: r.header.generation,
+	}
+	if ok {
+		if state.Generation != r.header.generation {
+			return nil, ErrStaleCursor
+		}
+		if state.Sequence <= r.header.headSeq {
+			// Anything before the head is gone, so we'll note what we
+			// missed the next time we read.
+			c.seq = state.Sequence
+		} else {
+			off, err := r.findSequence(state.Sequence)
+			if err != nil {
+				return nil, err
+			}
+			if uint64(off) != state.Offset {
+				return nil, ErrStaleCursor
+			}
+			c.seq, c.off = state.Sequence, off
+		}
+	}
+
+	r.consumersMutex.Lock()
+	if r.consumers == nil {
+		r.consumers = map[string]*Consumer{}
+	}
+	r.consumers[name] = c
+	r.consumersMutex.Unlock()
+	return c, nil
+}
+
+// Name will return the name of the Consumer.
+func (c *Consumer) Name() string {
+	return c.name
+}
+
+// Read will copy the next record into buf, and move the Consumer past it.
+// This doesn't consume the record from the Ring.
+//
+// Read won't block; if the Consumer has read everything in the Ring, this
+// will return an io.EOF. If the records were moved around without the
+// Consumer being moved along with them (such as by a Compact in another
+// process that this Ring follows), this returns ErrStaleCursor, and the
+// Consumer has to be opened again.
+func (c *Consumer) Read(buf []byte) (int, error) {
+	r := c.r
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if atomic.LoadUint32(&c.paused) != 0 {
+		return 0, ErrPaused
+	}
+	if c.generation != r.header.generation {
+		return 0, ErrStaleCursor
+	}
+	c.catchUp()
+	for c.seq < r.header.tailSeq && !c.inBatch() && !(c.wants(c.off) && c.sample()) {
+		c.off = r.nextEntry(c.off)
+		c.seq++
+	}
+	if c.seq >= r.header.tailSeq {
+		return 0, io.EOF
+	}
+	if r.entryFlags(c.off)&flagBatch != 0 {
+		return c.readBatched(buf)
+	}
+	n, err := r.copyEntry(c.off, buf)
+	if err != nil {
+		return 0, err
+	}
+	c.off = r.nextEntry(c.off)
+	c.seq++
+	return n, nil
+}
+
+// UNSAFE
+//
+// If the Consumer has fallen behind the head, move it up to the head, and
+// count what it missed.
+func (c *Consumer) catchUp() {
+	r := c.r
+	if c.seq > r.header.headSeq {
+		return
+	}
+	atomic.AddUint64(&c.dropped, r.header.headSeq-c.seq)
+	c.seq, c.off = r.header.headSeq, *r.head
+}
+
+// Pause will stop the Consumer from reading, until Resume is called; Read
+// returns an ErrPaused in the meantime. The Consumer keeps its place, but
+// like any Consumer, it doesn't hold records back from being overwritten,
+// so anything that leaves the Ring while it's paused is missed, and Resume
+// says how much that was. Pausing a paused Consumer does nothing.
+func (c *Consumer) Pause() {
+	r := c.r
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if atomic.LoadUint32(&c.paused) != 0 {
+		return
+	}
+	c.catchUp()
+	atomic.StoreUint32(&c.paused, 1)
+	c.pausedDropped = c.Dropped()
+}
+
+// Resume will let a paused Consumer read again, picking up where it left
+// off, and return the number of records it missed while it was paused (which
+// are counted by Dropped too). Resuming a Consumer that isn't paused does
+// nothing, and returns 0.
+func (c *Consumer) Resume() uint64 {
+	r := c.r
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if atomic.LoadUint32(&c.paused) == 0 {
+		return 0
+	}
+	c.catchUp()
+	atomic.StoreUint32(&c.paused, 0)
+	return c.Dropped() - c.pausedDropped
+}
+
+// Paused will return true if the Consumer is paused.
+func (c *Consumer) Paused() bool {
+	return atomic.LoadUint32(&c.paused) != 0
+}
+
+// UNSAFE
+//
+// Check if the Consumer reads the entry at the provided offset, rather than
+// skipping over it because it belongs to some other Producer.
+func (c *Consumer) wants(off uintptr) bool {
+	return c.producer == 0 || c.r.entryProducer(off) == c.producer
+}
+
+// UNSAFE
+//
+// Check if the Consumer is part of the way through a batch, and so has
+// already decided to read it.
+func (c *Consumer) inBatch() bool {
+	return c.batched.pos != 0 && c.batched.seq == c.seq
+}
+
+// Dropped will return the number of records that left the Ring (whether they
+// were overwritten, or consumed from the head) before the Consumer could
+// read them, since it was opened.
+func (c *Consumer) Dropped() uint64 {
+	return atomic.LoadUint64(&c.dropped)
+}
+
+// DroppedBytes will return how much data (including keys) the records
+// counted by Dropped held. Records that were gone before the Consumer was
+// opened, or were skipped over in bulk (such as by Reset, or SeekToSequence
+// on a Ring without RecordFlags) are counted by Dropped, but not here,
+// since there's no telling how large they were.
+func (c *Consumer) DroppedBytes() uint64 {
+	return atomic.LoadUint64(&c.droppedBytes)
+}
+
+// UNSAFE
+//
+// Note that the entry at the head is about to leave the ring, counting it
+// as dropped by every open Consumer that hasn't read it yet, and moving them
+// past it.
+func (r *Ring) dropForConsumers() {
+	if len(r.consumers) == 0 {
+		return
+	}
+	length := uint64(r.entryLength(*r.head))
+	for _, c := range r.consumers {
+		if c.seq > r.header.headSeq || c.generation != r.header.generation {
+			continue
+		}
+		c.catchUp()
+		if c.wants(*r.head) {
+			atomic.AddUint64(&c.dropped, 1)
+			atomic.AddUint64(&c.droppedBytes, length)
+		}
+		c.seq, c.off = r.header.headSeq+1, r.nextEntry(*r.head)
+	}
+}
+
+// consumerStats will return what every open Consumer has missed, sorted by
+// name. This doesn't take the Ring's mutex.
+func (r *Ring) consumerStats() []ConsumerStats {
+	r.consumersMutex.Lock()
+	defer r.consumersMutex.Unlock()
+	if len(r.consumers) == 0 {
+		return nil
+	}
+	stats := make([]ConsumerStats, 0, len(r.consumers))
+	for name, c := range r.consumers {
+		stats = append(stats, ConsumerStats{
+			Name:         name,
+			Dropped:      c.Dropped(),
+			DroppedBytes: c.DroppedBytes(),
+			Skipped:      c.Skipped(),
+			Paused:       c.Paused(),
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Name < stats[j].Name
+	})
+	return stats
+}
+
+// State will return the position of the Consumer.
+func (c *Consumer) State() CursorState {
+	r := c.r
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	c.catchUp()
+	return CursorState{
+		Sequence:   c.seq,
+		Offset:     uint64(c.off),
+		Generation: c.generation,
+	}
+}
+
+// Commit will persist the position of the Consumer, so that the next time
+// the Consumer is opened, it picks up from here.
+func (c *Consumer) Commit() error {
+	return c.store.save(c.State())
+}
+
+// UNSAFE
+//
+// Find the offset of the record with the provided sequence number, which
+// must be in the ring (or be the tail sequence).
+func (r *Ring) findSequence(seq uint64) (uintptr, error) {
+	if seq < r.header.headSeq || seq > r.header.tailSeq {
+		return 0, ErrOutOfRange
+	}
+	n := seq - r.header.headSeq
+	switch {
+	case seq == r.header.tailSeq:
+		return *r.tail, nil
+	case r.indexed:
+		return r.index[n], nil
+	default:
+		off, at := r.nearestSequence(seq)
+		for ; at < seq; at++ {
+			off = r.nextEntry(off)
+		}
+		return off, nil
+	}
+}
+
+// consumerHash will hash the name of a Consumer for its slot in the header,
+// which is never 0.
+func consumerHash(name string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	if sum := h.Sum64(); sum != 0 {
+		return sum
+	}
+	return 1
+}
+
+// slotStore keeps a Consumer's position in a slot in the header.
+type slotStore struct {
+	r    *Ring
+	hash uint64
+}
+
+// load implements cursorStore.
+func (s *slotStore) load() (CursorState, bool, error) {
+	s.r.mutex.Lock()
+	defer s.r.mutex.Unlock()
+
+	slot := s.r.header.consumer(s.hash)
+	if slot == nil {
+		return CursorState{}, false, nil
+	}
+	return CursorState{
+		Sequence:   slot.seq,
+		Offset:     slot.off,
+		Generation: s.r.header.generation,
+	}, true, nil
+}
+
+// save implements cursorStore.
+func (s *slotStore) save(state CursorState) error {
+	s.r.mutex.Lock()
+	defer s.r.mutex.Unlock()
+
+	slot := s.r.header.consumer(s.hash)
+	if slot == nil {
+		// Someone removed the Consumer out from under us.
+		return fmt.Errorf("diskring: consumer has been removed")
+	}
+	slot.seq, slot.off = state.Sequence, state.Offset
+	return nil
+}
+
+// sidecarStore keeps a Consumer's position in a file of its own, which is
+// replaced (by renaming a new file over it) every time it's saved.
+type sidecarStore struct {
+	path  string
+	perms filePerms
+}
+
+// sidecar will return the store for the named Consumer's sidecar file.
+func (r *Ring) sidecar(name string) (*sidecarStore, error) {
+	if name == "" || strings.ContainsRune(name, os.PathSeparator) || name != filepath.Clean(name) {
+		return nil, fmt.Errorf("diskring: invalid consumer name %q", name)
+	}
+	return &sidecarStore{
+		path:  r.file.Name() + "." + name + ".cursor",
+		perms: r.perms,
+	}, nil
+}
+
+// load implements cursorStore.
+func (s *sidecarStore) load() (CursorState, bool, error) {
+	buf, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return CursorState{}, false, nil
+	}
+	if err != nil {
+		return CursorState{}, false, err
+	}
+	var state CursorState
+	if err := state.UnmarshalBinary(buf); err != nil {
+		return CursorState{}, false, err
+	}
+	return state, true, nil
+}
+
+// save implements cursorStore.
+func (s *sidecarStore) save(state CursorState) error {
+	buf, err := state.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return replaceFile(s.path, buf, s.perms)
+}
+
+// replaceFile will write buf to a new file with the provided permissions,
+// and rename it over the file at path, so that anyone reading the file will
+// see either the old contents or the new ones, but never anything in
+// between.
+func replaceFile(path string, buf []byte, perms filePerms) error {
+	dir, base := filepath.Split(path)
+	fd, err := ioutil.TempFile(dir, base+".*")
+	if err != nil {
+		return err
+	}
+	if err := perms.apply(fd); err != nil {
+		fd.Close()
+		os.Remove(fd.Name())
+		return err
+	}
+	if _, err := fd.Write(buf); err != nil {
+		fd.Close()
+		os.Remove(fd.Name())
+		return err
+	}
+	if err := fd.Sync(); err != nil {
+		fd.Close()
+		os.Remove(fd.Name())
+		return err
+	}
+	if err := fd.Close(); err != nil {
+		os.Remove(fd.Name())
+		return err
+	}
+	return os.Rename(fd.Name(), path)
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,219 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestConsumerSidecar(t *testing.T) {
+	r := openTestRing(t, Options{})
+	writeRecords(t, r, "one", "two", "three", "four")
+	if _, err := r.Cursor("../escape"); err == nil {
+		t.Fatal("expected an invalid name to be refused")
+	}
+
+	c, err := r.Cursor("audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 16)
+	for i := 0; i < 2; i++ {
+		if _, err := c.Read(buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	sidecar := r.file.Name() + ".audit.cursor"
+	if _, err := os.Stat(sidecar); err != nil {
+		t.Fatal(err)
+	}
+
+	// Opening the Consumer again picks up from the sidecar file, and
+	// reading with it doesn't consume anything for anyone else.
+	c, err = r.Cursor("audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records := readAll(t, c); !reflect.DeepEqual(records, []string{"three", "four"}) {
+		t.Fatalf("expected to pick up from the third record, got %q", records)
+	}
+	if r.Records() != 4 {
+		t.Fatalf("expected 4 records left, got %d", r.Records())
+	}
+
+	if err := r.RemoveCursor("audit"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(sidecar); !os.IsNotExist(err) {
+		t.Fatalf("expected the sidecar file to be removed, got %v", err)
+	}
+}
+
+func TestConsumerSlots(t *testing.T) {
+	path := testRingPath(t)
+	r := openTestRingAt(t, path, Options{ReserveHeader: true})
+	writeRecords(t, r, "one", "two", "three")
+
+	// One more Consumer than there are slots, each a record further on
+	// than the last (wrapping around).
+	buf := make([]byte, 16)
+	for i := 0; i <= maxConsumers; i++ {
+		c, err := r.Cursor(fmt.Sprintf("consumer-%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j := 0; j < i%3; j++ {
+			if _, err := c.Read(buf); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := c.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i <= maxConsumers; i++ {
+		_, err := os.Stat(fmt.Sprintf("%s.consumer-%d.cursor", path, i))
+		if sidecar := i == maxConsumers; sidecar != (err == nil) {
+			t.Fatalf("consumer %d has a sidecar file: %t", i, err == nil)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r = openTestRingAt(t, path, Options{ReserveHeader: true})
+	defer r.Close()
+	for i := 0; i <= maxConsumers; i++ {
+		c, err := r.Cursor(fmt.Sprintf("consumer-%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"one", "two", "three"}[i%3:]
+		if records := readAll(t, c); !reflect.DeepEqual(records, want) {
+			t.Fatalf("consumer %d: expected %q, got %q", i, want, records)
+		}
+	}
+
+	// Removing a Consumer frees up its slot for the next one.
+	if err := r.RemoveCursor("consumer-0"); err != nil {
+		t.Fatal(err)
+	}
+	c, err := r.Cursor("another")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".another.cursor"); !os.IsNotExist(err) {
+		t.Fatalf("expected the new Consumer to take the free slot, got %v", err)
+	}
+}
+
+func TestConsumerDropped(t *testing.T) {
+	r := openTestRing(t, Options{CreateSize: 4096})
+	fast, err := r.Cursor("fast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	slow, err := r.Cursor("slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeRecords(t, r, "one", "two")
+	if records := readAll(t, fast); !reflect.DeepEqual(records, []string{"one", "two"}) {
+		t.Fatalf("expected both records, got %q", records)
+	}
+
+	overwriteRecords(t, r, 5)
+	overwritten := r.Overwritten()
+	if slow.Dropped() != overwritten {
+		t.Fatalf("expected the slow consumer to miss %d records, got %d", overwritten, slow.Dropped())
+	}
+	if fast.Dropped() != overwritten-2 {
+		t.Fatalf("expected the fast consumer to miss %d records, got %d", overwritten-2, fast.Dropped())
+	}
+	if fast.DroppedBytes() == 0 || slow.DroppedBytes() <= fast.DroppedBytes() {
+		t.Fatalf("expected the slow consumer to miss more data, got %d and %d bytes", slow.DroppedBytes(), fast.DroppedBytes())
+	}
+
+	stats, err := r.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ConsumerStats{
+		{Name: "fast", Dropped: fast.Dropped(), DroppedBytes: fast.DroppedBytes()},
+		{Name: "slow", Dropped: slow.Dropped(), DroppedBytes: slow.DroppedBytes()},
+	}
+	if !reflect.DeepEqual(stats.Consumers, want) {
+		t.Fatalf("expected %+v, got %+v", want, stats.Consumers)
+	}
+
+	if err := r.RemoveCursor("fast"); err != nil {
+		t.Fatal(err)
+	}
+	if stats, err = r.Stats(); err != nil {
+		t.Fatal(err)
+	}
+	if len(stats.Consumers) != 1 || stats.Consumers[0].Name != "slow" {
+		t.Fatalf("expected only the slow consumer left, got %+v", stats.Consumers)
+	}
+}
+
+func TestConsumerPause(t *testing.T) {
+	r := openTestRing(t, Options{CreateSize: 4096})
+	c, err := r.Cursor("paused")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeRecords(t, r, "one")
+	c.Pause()
+	c.Pause()
+	if _, err := c.Read(make([]byte, 16)); err != ErrPaused {
+		t.Fatalf("expected ErrPaused, got %v", err)
+	}
+	stats, err := r.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats.Consumers) != 1 || !stats.Consumers[0].Paused {
+		t.Fatalf("expected the Consumer to be paused, got %+v", stats.Consumers)
+	}
+
+	overwriteRecords(t, r, 3)
+	if missed := c.Resume(); missed != r.Overwritten() || missed != c.Dropped() {
+		t.Fatalf("expected %d records to be missed while paused, got %d", r.Overwritten(), missed)
+	}
+	if c.Paused() || c.Resume() != 0 {
+		t.Fatal("expected resuming a Consumer that isn't paused to do nothing")
+	}
+	if records := readAll(t, c); len(records) != r.Records() {
+		t.Fatalf("expected to read the %d records left, got %d", r.Records(), len(records))
+	}
+}
+
+// vim: foldmethod=marker
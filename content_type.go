@@ -0,0 +1,86 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ContentType is a small code saying what kind of data a record holds (such
+// as metrics, logs or traces), so that different kinds of records can share
+// a Ring. 0 means the content type isn't known.
+//
+// The codes mean whatever the application wants them to, but registering a
+// name for each (see RegisterContentType) makes them much easier to make
+// sense of when debugging.
+type ContentType uint16
+
+var (
+	contentTypesMutex sync.Mutex
+	contentTypes      = map[ContentType]string{}
+)
+
+// RegisterContentType will register a name for a content type code. Each
+// code can only be registered once, so this is best called from an init
+// function.
+func RegisterContentType(code ContentType, name string) error {
+	if code == 0 {
+		return fmt.Errorf("diskring: content type 0 is reserved")
+	}
+
+	contentTypesMutex.Lock()
+	defer contentTypesMutex.Unlock()
+
+	if existing, ok := contentTypes[code]; ok {
+		return fmt.Errorf("diskring: content type %d is already registered as %q", code, existing)
+	}
+	contentTypes[code] = name
+	return nil
+}
+
+// LookupContentType will return the content type registered with the
+// provided name, if there is one.
+func LookupContentType(name string) (ContentType, bool) {
+	contentTypesMutex.Lock()
+	defer contentTypesMutex.Unlock()
+
+	for code, registered := range contentTypes {
+		if registered == name {
+			return code, true
+		}
+	}
+	return 0, false
+}
+
+// String will return the registered name of the content type, or its code
+// if it hasn't been registered.
+func (c ContentType) String() string {
+	contentTypesMutex.Lock()
+	defer contentTypesMutex.Unlock()
+
+	if name, ok := contentTypes[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("ContentType(%d)", uint16(c))
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,76 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"testing"
+)
+
+func TestContentTypes(t *testing.T) {
+	const metrics, logs ContentType = 0xfff0, 0xfff1
+	if err := RegisterContentType(metrics, "test/metrics"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		contentTypesMutex.Lock()
+		delete(contentTypes, metrics)
+		contentTypesMutex.Unlock()
+	})
+	if err := RegisterContentType(metrics, "test/other"); err == nil {
+		t.Fatal("registered the same content type twice")
+	}
+	if err := RegisterContentType(0, "test/zero"); err == nil {
+		t.Fatal("registered content type 0")
+	}
+	if code, ok := LookupContentType("test/metrics"); !ok || code != metrics {
+		t.Fatalf("expected to find %d, got %d", metrics, code)
+	}
+	if metrics.String() != "test/metrics" || logs.String() != "ContentType(65521)" {
+		t.Fatalf("expected names, got %q and %q", metrics, logs)
+	}
+
+	r := openTestRing(t, Options{ContentTypes: true, NonBlockingReads: true})
+	for _, rec := range []Record{
+		{Data: []byte("cpu=3"), ContentType: metrics},
+		{Data: []byte("hello"), ContentType: logs},
+		{Data: []byte("unknown")},
+	} {
+		if _, err := r.WriteRecord(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, want := range []ContentType{metrics, logs, 0} {
+		rec, err := r.ReadRecord()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec.ContentType != want {
+			t.Fatalf("expected %s, got %s", want, rec.ContentType)
+		}
+	}
+
+	plain := openTestRing(t, Options{})
+	if _, err := plain.WriteRecord(Record{Data: []byte("x"), ContentType: logs}); err != ErrNoContentTypes {
+		t.Fatalf("expected ErrNoContentTypes, got %v", err)
+	}
+}
+
+// vim: foldmethod=marker
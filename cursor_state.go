@@ -0,0 +1,140 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// ErrStaleCursor is returned when seeking to a CursorState that doesn't
+// belong to this Ring, or that points somewhere no record starts.
+var ErrStaleCursor = errors.New("diskring: cursor doesn't match this ring")
+
+// cursorStateSize is the size of a CursorState's binary encoding.
+const cursorStateSize = 24
+
+// CursorState is the position of the reader in a Ring, which can be stored
+// somewhere else (such as alongside whatever the records were processed
+// into), and used to put the reader back where it was later on.
+//
+// The fields are stable, and can be serialized however is convenient, or
+// with MarshalBinary.
+type CursorState struct {
+	// Sequence is the sequence number of the next record to be read.
+	Sequence uint64
+
+	// Offset is where that record starts in the Ring's data.
+	Offset uint64
+
+	// Generation identifies the Ring the CursorState came from.
+	Generation uint64
+}
+
+// MarshalBinary will encode the CursorState into a fixed size, big endian
+// byte slice.
+func (c CursorState) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, cursorStateSize)
+	binary.BigEndian.PutUint64(buf[0:], c.Sequence)
+	binary.BigEndian.PutUint64(buf[8:], c.Offset)
+	binary.BigEndian.PutUint64(buf[16:], c.Generation)
+	return buf, nil
+}
+
+// UnmarshalBinary will decode a CursorState encoded by MarshalBinary.
+func (c *CursorState) UnmarshalBinary(buf []byte) error {
+	if len(buf) != cursorStateSize {
+		return errors.New("diskring: cursor state is the wrong size")
+	}
+	c.Sequence = binary.BigEndian.Uint64(buf[0:])
+	c.Offset = binary.BigEndian.Uint64(buf[8:])
+	c.Generation = binary.BigEndian.Uint64(buf[16:])
+	return nil
+}
+
+// ExportCursor will return the current position of the reader.
+func (r *Ring) ExportCursor() CursorState {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return CursorState{
+		Sequence:   r.header.headSeq,
+		Offset:     uint64(*r.head),
+		Generation: r.header.generation,
+	}
+}
+
+// SeekCursor will move the reader to the position in the CursorState,
+// consuming every record before it.
+//
+// If the records at that position have already been overwritten, the reader
+// is left at the oldest record in the Ring, and the records that were lost
+// are counted as dropped (see Record.Dropped and Batch.Dropped). If the
+// CursorState came from another Ring, this will return an ErrStaleCursor.
+//
+// If the Readahead option is set, the records from the new position on will
+// be prefetched.
+func (r *Ring) SeekCursor(state CursorState) error {
+	if err := r.seekCursor(state); err != nil {
+		return err
+	}
+	if r.readahead {
+		r.Prefetch()
+	}
+	return nil
+}
+
+// seekCursor will move the reader to the position in the CursorState.
+func (r *Ring) seekCursor(state CursorState) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if state.Generation != r.header.generation {
+		return ErrStaleCursor
+	}
+	if state.Sequence > r.header.tailSeq {
+		return ErrOutOfRange
+	}
+	if state.Sequence < r.header.headSeq {
+		r.readSeq = state.Sequence
+		return nil
+	}
+
+	// Before we consume anything, make sure the record at the sequence
+	// number starts where the CursorState says it does.
+	off, err := r.findSequence(state.Sequence)
+	if err != nil {
+		return err
+	}
+	if uint64(off) != state.Offset {
+		return ErrStaleCursor
+	}
+
+	for r.header.headSeq < state.Sequence {
+		if err := r.advanceHead(); err != nil {
+			return err
+		}
+	}
+	r.consumed()
+	return nil
+}
+
+// vim: foldmethod=marker
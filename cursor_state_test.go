@@ -0,0 +1,87 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"testing"
+)
+
+func TestSeekCursor(t *testing.T) {
+	r := openTestRing(t, Options{CreateSize: 4096, NonBlockingReads: true})
+	start := r.ExportCursor()
+	records := []string{"zero", "one", "two", "three", "four"}
+	writeRecords(t, r, records...)
+
+	// Work out where the fourth record starts.
+	state := CursorState{Sequence: start.Sequence + 3, Offset: start.Offset, Generation: start.Generation}
+	r.mutex.Lock()
+	for _, record := range records[:3] {
+		state.Offset += uint64(r.entrySize(uintptr(len(record))))
+	}
+	r.mutex.Unlock()
+
+	buf, err := state.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded CursorState
+	if err := decoded.UnmarshalBinary(buf); err != nil || decoded != state {
+		t.Fatalf("expected %+v back, got %+v (%v)", state, decoded, err)
+	}
+
+	for _, bad := range []CursorState{
+		{Sequence: state.Sequence, Offset: state.Offset + 8, Generation: state.Generation},
+		{Sequence: state.Sequence, Offset: state.Offset, Generation: state.Generation + 1},
+	} {
+		if err := r.SeekCursor(bad); err != ErrStaleCursor {
+			t.Fatalf("expected ErrStaleCursor for %+v, got %v", bad, err)
+		}
+	}
+	if err := r.SeekCursor(CursorState{Sequence: 99, Generation: state.Generation}); err != ErrOutOfRange {
+		t.Fatalf("expected ErrOutOfRange, got %v", err)
+	}
+
+	if err := r.SeekCursor(decoded); err != nil {
+		t.Fatal(err)
+	}
+	if r.ExportCursor() != state {
+		t.Fatalf("expected the cursor to be at %+v, got %+v", state, r.ExportCursor())
+	}
+	if record := readRecord(t, r); record != "three" {
+		t.Fatalf("expected \"three\", got %q", record)
+	}
+
+	// Seeking back to records that are gone counts them as dropped.
+	overwriteRecords(t, r, 5)
+	head := r.ExportCursor()
+	if err := r.SeekCursor(start); err != nil {
+		t.Fatal(err)
+	}
+	rec, err := r.ReadRecord()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := head.Sequence - start.Sequence; rec.Dropped != want {
+		t.Fatalf("expected %d records to be dropped, got %d", want, rec.Dropped)
+	}
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,114 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+)
+
+// canary is written after the data of each record when the Debug option is
+// set, so that anything writing past the end of a record is caught.
+var canary = [4]byte{0xde, 0xad, 0xbe, 0xef}
+
+// UNSAFE
+//
+// If the Debug option is set, check the Ring over, and panic with a
+// description of what's wrong if anything is. The caller must hold the
+// mutex.
+func (r *Ring) check() {
+	if !r.debug {
+		return
+	}
+	off, err := r.checkInvariants()
+	if err == nil {
+		return
+	}
+	panic(fmt.Sprintf(
+		"diskring: invariant violated: %s\n"+
+			"size=%d head=%d tail=%d headSeq=%d tailSeq=%d format=%#x\n"+
+			"at offset %d:\n%s",
+		err,
+		r.size, *r.head, *r.tail, r.header.headSeq, r.header.tailSeq, r.layout.format,
+		off, hex.Dump(r.buf[off:off+64]),
+	))
+}
+
+// UNSAFE
+//
+// Walk every entry from the head to the tail, making sure each one is sane,
+// and that they add up to the cursor and the sequence numbers. On failure,
+// this returns the offset of the entry that's wrong.
+func (r *Ring) checkInvariants() (uintptr, error) {
+	head, tail := *r.head, *r.tail
+	if head >= r.size || tail >= r.size {
+		return 0, fmt.Errorf("cursor is outside the ring")
+	}
+	if r.header.tailSeq < r.header.headSeq {
+		return head, fmt.Errorf("tail sequence is before the head sequence")
+	}
+	if r.layout.has(formatSector) && (head%r.layout.wordSize != 0 || tail%r.layout.wordSize != 0) {
+		return head, fmt.Errorf("cursor isn't word aligned")
+	}
+
+	var (
+		remaining = r.len()
+		count     uint64
+	)
+	for off := head; off != tail; off = r.nextEntry(off) {
+		start := r.entryStart(off)
+		if pad := start - off; pad >= r.layout.sectorSize {
+			return off, fmt.Errorf("padding of %d bytes is larger than a sector", pad)
+		}
+		length := r.word(start)
+		if length > r.size {
+			return off, fmt.Errorf("length %d is larger than the ring", length)
+		}
+		size := start - off + r.entrySize(length)
+		if size > remaining {
+			return off, fmt.Errorf("entry of %d bytes runs past the tail", size)
+		}
+		remaining -= size
+
+		end := start + r.layout.wordSize + r.layout.envelopeSize + length
+		if r.layout.has(formatCanary) && !bytes.Equal(r.buf[end:end+uintptr(len(canary))], canary[:]) {
+			return off, fmt.Errorf("canary after entry of %d bytes is clobbered", length)
+		}
+		if r.layout.has(formatTrailer) {
+			wordSize := r.layout.wordSize
+			if trailer := r.word(off + size - wordSize); trailer != length {
+				return off, fmt.Errorf("trailing length %d doesn't match length %d", trailer, length)
+			}
+			if pad := start - off; r.layout.has(formatSector) && r.word(off+size-2*wordSize) != pad {
+				return off, fmt.Errorf("trailing padding doesn't match padding of %d bytes", pad)
+			}
+		}
+		count++
+	}
+
+	if count != r.records() {
+		return head, fmt.Errorf("found %d entries, but the sequence numbers say %d", count, r.records())
+	}
+	return 0, nil
+}
+
+// vim: foldmethod=marker
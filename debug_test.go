@@ -0,0 +1,55 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDebug(t *testing.T) {
+	r := openTestRing(t, Options{CreateSize: 4096, Debug: true, NonBlockingReads: true})
+	// Every write and read is checked over, so this shouldn't panic,
+	// even once the records wrap around.
+	for i := 0; i < 100; i++ {
+		writeRecords(t, r, fmt.Sprintf("record %d", i), strings.Repeat("x", i))
+		readRecord(t, r)
+	}
+
+	// Scribble over the canary after the newest record.
+	r.mutex.Lock()
+	off := r.header.last - 1
+	end := r.entryStart(off) + r.layout.wordSize + r.layout.envelopeSize + r.entryLength(off)
+	r.buf[end] ^= 0xff
+	r.mutex.Unlock()
+
+	defer func() {
+		msg, ok := recover().(string)
+		if !ok || !strings.Contains(msg, "canary") {
+			t.Fatalf("expected a panic about the canary, got %q", msg)
+		}
+	}()
+	r.Write([]byte("one more"))
+	t.Fatal("expected the clobbered canary to be caught")
+}
+
+// vim: foldmethod=marker
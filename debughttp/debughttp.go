@@ -0,0 +1,205 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+// Package debughttp contains an http.Handler that renders the state of a
+// diskring.Ring, for diagnosing things like a consumer falling behind in
+// production. It's intended to be mounted somewhere like /debug/diskring:
+//
+//	http.Handle("/debug/diskring", &debughttp.Handler{Ring: ring, Newest: 10})
+//
+// The state is rendered as HTML, or as JSON if the request has a
+// "format=json" query parameter or accepts "application/json".
+package debughttp
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"pault.ag/go/diskring"
+)
+
+// Handler is an http.Handler that renders the state of a Ring.
+type Handler struct {
+	// Ring is the Ring to render.
+	Ring *diskring.Ring
+
+	// Newest is the number of the most recently written records to include,
+	// or 0 to not include any records. If this is set, it can be lowered
+	// per request with the "newest" query parameter.
+	//
+	// Finding the newest records is cheap with the TrailingLength, Index
+	// or SparseIndex options, but otherwise means walking the Ring from
+	// the head (see diskring.Ring.Tail), so be careful with this on very
+	// large Rings.
+	Newest int
+}
+
+// Lag is how far behind an open Consumer of the Ring is.
+type Lag struct {
+	// Consumer is the name of the Consumer.
+	Consumer string
+
+	// Records is the number of records the Consumer has yet to read.
+	Records int
+
+	// Bytes is the number of bytes of the Ring those records take up.
+	Bytes int
+
+	// Oldest is how long ago the oldest of those records was written, if
+	// the Ring has Timestamps turned on.
+	Oldest time.Duration
+}
+
+// Record is a single record in the Ring.
+type Record struct {
+	Data []byte
+}
+
+// State is everything the Handler renders.
+type State struct {
+	Stats  diskring.Stats
+	Lag    []Lag
+	Newest []Record
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	newest := h.Newest
+	if n := req.URL.Query().Get("newest"); n != "" && h.Newest > 0 {
+		var err error
+		newest, err = strconv.Atoi(n)
+		if err != nil || newest < 0 {
+			http.Error(w, "invalid newest parameter", http.StatusBadRequest)
+			return
+		}
+		if newest > h.Newest {
+			newest = h.Newest
+		}
+	}
+
+	state, err := h.state(newest)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if req.URL.Query().Get("format") == "json" ||
+		strings.Contains(req.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(state)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	page.Execute(w, state)
+}
+
+// state will collect the State of the Ring.
+func (h *Handler) state(newest int) (*State, error) {
+	stats, err := h.Ring.Stats()
+	if err != nil {
+		return nil, err
+	}
+	state := &State{Stats: stats}
+
+	for _, consumer := range stats.Consumers {
+		records, bytes, oldest, err := h.Ring.Lag(consumer.Name)
+		if errors.Is(err, diskring.ErrNoConsumer) || errors.Is(err, diskring.ErrStaleCursor) {
+			// Closed since Stats was called, or stuck behind a
+			// compaction; either way there's nothing to show.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		state.Lag = append(state.Lag, Lag{
+			Consumer: consumer.Name,
+			Records:  records,
+			Bytes:    bytes,
+			Oldest:   oldest,
+		})
+	}
+
+	if newest == 0 {
+		return state, nil
+	}
+	records, err := h.Ring.Tail(newest)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(records) - 1; i >= 0; i-- {
+		state.Newest = append(state.Newest, Record{Data: records[i]})
+	}
+	return state, nil
+}
+
+var page = template.Must(template.New("page").Funcs(template.FuncMap{
+	"printable": func(data []byte) string {
+		if len(data) > 256 {
+			return strconv.Quote(string(data[:256])) + "..."
+		}
+		return strconv.Quote(string(data))
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><title>diskring</title></head>
+<body>
+<h1>diskring</h1>
+<h2>Stats</h2>
+<table>
+<tr><th>Size</th><td>{{.Stats.Size}}</td></tr>
+<tr><th>Used</th><td>{{.Stats.Used}}</td></tr>
+<tr><th>Records</th><td>{{.Stats.Records}}</td></tr>
+<tr><th>Head</th><td>{{.Stats.Head}} (sequence {{.Stats.HeadSequence}})</td></tr>
+<tr><th>Tail</th><td>{{.Stats.Tail}} (sequence {{.Stats.TailSequence}})</td></tr>
+<tr><th>Wraps</th><td>{{.Stats.Wraps}}</td></tr>
+<tr><th>Overwritten</th><td>{{.Stats.OverwrittenRecords}} records ({{.Stats.OverwrittenBytes}} bytes)</td></tr>
+</table>
+{{if .Lag}}
+<h2>Consumer lag</h2>
+<table>
+<tr><th>Name</th><th>Records</th><th>Bytes</th><th>Oldest</th></tr>
+{{range .Lag}}<tr><td>{{.Consumer}}</td><td>{{.Records}}</td><td>{{.Bytes}}</td><td>{{.Oldest}}</td></tr>
+{{end}}</table>
+{{end}}
+{{if .Stats.Consumers}}
+<h2>Consumers</h2>
+<table>
+<tr><th>Name</th><th>Dropped records</th><th>Dropped bytes</th><th>Skipped records</th><th>Paused</th></tr>
+{{range .Stats.Consumers}}<tr><td>{{.Name}}</td><td>{{.Dropped}}</td><td>{{.DroppedBytes}}</td><td>{{.Skipped}}</td><td>{{.Paused}}</td></tr>
+{{end}}</table>
+{{end}}
+{{if .Newest}}
+<h2>Newest records</h2>
+<table>
+<tr><th>Data</th></tr>
+{{range .Newest}}<tr><td><code>{{printable .Data}}</code></td></tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
+
+// vim: foldmethod=marker
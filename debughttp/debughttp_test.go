@@ -0,0 +1,113 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package debughttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"pault.ag/go/diskring"
+)
+
+// openTestRing will create a Ring with ten records in it, in a temporary
+// directory.
+func openTestRing(t *testing.T) *diskring.Ring {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "diskring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	ring, err := diskring.OpenWithOptions(filepath.Join(dir, "test.ring"), diskring.Options{
+		CreateIfMissing: true,
+		CreateSize:      1 << 16,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ring.Close() })
+	for i := 0; i < 10; i++ {
+		if _, err := ring.Write([]byte(fmt.Sprintf("record%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ring
+}
+
+// get will fetch the State from the Handler as JSON.
+func get(t *testing.T, h *Handler, query string) State {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/?format=json&"+query, nil))
+	var state State
+	if err := json.NewDecoder(w.Body).Decode(&state); err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+func TestNewest(t *testing.T) {
+	ring := openTestRing(t)
+
+	h := &Handler{Ring: ring}
+	if state := get(t, h, "newest=5"); len(state.Newest) != 0 {
+		t.Fatalf("expected no records without Newest set, got %d", len(state.Newest))
+	}
+
+	h.Newest = 3
+	state := get(t, h, "")
+	if len(state.Newest) != 3 || string(state.Newest[0].Data) != "record9" {
+		t.Fatalf("expected the newest 3 records, got %v", state.Newest)
+	}
+	if state := get(t, h, "newest=100"); len(state.Newest) != 3 {
+		t.Fatalf("expected newest to be capped at 3, got %d", len(state.Newest))
+	}
+	if state := get(t, h, "newest=1"); len(state.Newest) != 1 {
+		t.Fatalf("expected 1 record, got %d", len(state.Newest))
+	}
+}
+
+func TestLag(t *testing.T) {
+	ring := openTestRing(t)
+	c, err := ring.Cursor("reader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	for i := 0; i < 4; i++ {
+		if _, err := c.Read(buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	state := get(t, &Handler{Ring: ring}, "")
+	if len(state.Lag) != 1 || state.Lag[0].Consumer != "reader" || state.Lag[0].Records != 6 {
+		t.Fatalf("expected reader to be 6 records behind, got %+v", state.Lag)
+	}
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,61 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"fmt"
+	"os"
+)
+
+// Detach will close the Ring just like Close, except that the file it was
+// using is left open, and handed back, so that it can be passed on to
+// something else (such as New, or another process across an exec, with
+// exec.Cmd's ExtraFiles) without ever being closed. This works however the
+// Ring was opened, and the file belongs to the caller from then on.
+//
+// Everything written to the Ring is flushed out to disk first, along with
+// the cursor (see Sync), so that whoever picks up the file next sees the
+// Ring just as it was left. If that fails, the Ring is left open, and can
+// still be used. As with DontCloseFile, any lock the Ring took on the file
+// (see Options.Lock) is let go of.
+//
+// A Ring made from more than one file (see NewMulti) can't be detached,
+// since there'd be more than one file to hand back.
+func (r *Ring) Detach() (*os.File, error) {
+	if len(r.files) != 1 {
+		return nil, fmt.Errorf("diskring: only a Ring with a single file can be detached")
+	}
+	if !r.readOnly {
+		if err := r.Sync(); err != nil {
+			return nil, err
+		}
+	}
+
+	fd := r.files[0]
+	r.dontCloseFile = true
+	if err := r.Close(); err != nil {
+		fd.Close()
+		return nil, err
+	}
+	return fd, nil
+}
+
+// vim: foldmethod=marker
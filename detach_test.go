@@ -0,0 +1,82 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDetach(t *testing.T) {
+	path := testRingPath(t)
+	r := openTestRingAt(t, path, Options{ReserveHeader: true, Lock: canLock})
+	writeRecords(t, r, "one", "two")
+	fd, err := r.Detach()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+	if _, err := fd.Stat(); err != nil {
+		t.Fatalf("expected the file to be left open, got %v", err)
+	}
+
+	// The lock is let go of, so the file can be opened again.
+	if canLock {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		locked, err := OpenContext(ctx, path, Options{ReserveHeader: true, Lock: true})
+		if err != nil {
+			t.Fatalf("expected the lock to be let go of, got %v", err)
+		}
+		if err := locked.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Whoever picks up the file sees the Ring as it was left.
+	r, err = NewWithOptions(fd, Options{ReserveHeader: true, DontCloseFile: true, NonBlockingReads: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	for _, want := range []string{"one", "two"} {
+		if record := readRecord(t, r); record != want {
+			t.Fatalf("expected %s, got %q", want, record)
+		}
+	}
+}
+
+func TestDetachMulti(t *testing.T) {
+	dir := filepath.Dir(testRingPath(t))
+	files := openMultiFiles(t, dir, int64(pageSize())*2, "a.ring", "b.ring")
+	r, err := NewMulti(files, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := r.Detach(); err == nil {
+		t.Fatal("expected a Ring of more than one file not to be detached")
+	}
+}
+
+// vim: foldmethod=marker
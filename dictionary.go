@@ -0,0 +1,235 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+)
+
+// Records smaller than CompressBelow are compressed with DEFLATE, using a
+// preset dictionary (see Options.Dictionaries), and flagged with
+// flagDictionary. The data of a compressed record is the ID of the
+// dictionary it was compressed with (the CRC32C of the dictionary), and
+// then the DEFLATE stream.
+
+// defaultCompressBelow is the size records have to be under to be
+// compressed, if the options don't say.
+const defaultCompressBelow = 256
+
+// maxDictionary is the largest dictionary that's any use, since DEFLATE
+// can't look back any further than this.
+const maxDictionary = 32 << 10
+
+var (
+	// ErrUnknownDictionary is returned when reading a record that was
+	// compressed with a dictionary that isn't in Options.Dictionaries.
+	ErrUnknownDictionary = errors.New("diskring: record was compressed with an unknown dictionary")
+
+	// ErrNoSamples is returned by TrainDictionary when there aren't any
+	// records small enough to be compressed to train it with.
+	ErrNoSamples = errors.New("diskring: no records small enough to train a dictionary with")
+)
+
+// dictionaries is every dictionary the Ring knows of, and the DEFLATE state
+// for the one records are being compressed with.
+type dictionaries struct {
+	known   map[uint32][]byte
+	current uint32
+
+	// writer compresses with the current dictionary, into out. This is
+	// only used with the writeMutex held.
+	writer *flate.Writer
+	out    bytes.Buffer
+
+	// reader is kept around to be Reset for each record, since they're
+	// not cheap to make. This is only used with the mutex held.
+	reader io.ReadCloser
+}
+
+// dictionaryID will return the ID a dictionary is known by in records
+// compressed with it.
+func dictionaryID(dictionary []byte) uint32 {
+	return crc32.Checksum(dictionary, crcTable)
+}
+
+// UNSAFE
+//
+// Add a dictionary, and start compressing records with it.
+func (r *Ring) addDictionary(dictionary []byte) {
+	if r.dictionaries == nil {
+		r.dictionaries = &dictionaries{known: map[uint32][]byte{}}
+	}
+	d := r.dictionaries
+	d.current = dictionaryID(dictionary)
+	d.known[d.current] = append([]byte{}, dictionary...)
+	// This only fails for a bad level.
+	d.writer, _ = flate.NewWriterDict(&d.out, flate.BestCompression, d.known[d.current])
+}
+
+// UNSAFE
+//
+// Compress buf with the current dictionary, returning the data to store
+// for it. This is only good until the next call.
+func (r *Ring) compress(buf []byte) []byte {
+	d := r.dictionaries
+	d.out.Reset()
+	var id [4]byte
+	binary.LittleEndian.PutUint32(id[:], d.current)
+	d.out.Write(id[:])
+	d.writer.Reset(&d.out)
+	d.writer.Write(buf)
+	d.writer.Close()
+	return d.out.Bytes()
+}
+
+// UNSAFE
+//
+// Decompress the data stored for a compressed record, into a new buffer.
+func (r *Ring) decompress(data []byte) ([]byte, error) {
+	if r.dictionaries == nil || len(data) < 4 {
+		return nil, ErrUnknownDictionary
+	}
+	d := r.dictionaries
+	dictionary, ok := d.known[binary.LittleEndian.Uint32(data)]
+	if !ok {
+		return nil, ErrUnknownDictionary
+	}
+	stream := bytes.NewReader(data[4:])
+	if d.reader == nil {
+		d.reader = flate.NewReaderDict(stream, dictionary)
+	} else if err := d.reader.(flate.Resetter).Reset(stream, dictionary); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(d.reader)
+}
+
+// UNSAFE
+//
+// Redact the data of the compressed record in the entry at the provided
+// offset, if it matches, by compressing it again with the same dictionary.
+// Anything left over is zeroed, since DEFLATE knows where its stream ends.
+// If the redacted data doesn't compress down to fit, the record is left
+// empty instead.
+func (r *Ring) redactCompressed(off uintptr, match func([]byte) bool, redact func([]byte)) (bool, error) {
+	stored := r.entryData(off)
+	data, err := r.decompress(stored)
+	if err != nil {
+		return false, err
+	}
+	if !match(data) {
+		return false, nil
+	}
+	redact(data)
+
+	var out bytes.Buffer
+	out.Write(stored[:4])
+	writer, _ := flate.NewWriterDict(&out, flate.BestCompression, r.dictionaries.known[binary.LittleEndian.Uint32(stored)])
+	writer.Write(data)
+	writer.Close()
+	if out.Len() > len(stored) {
+		// It doesn't fit, so all that's left is to drop the whole
+		// record, rather than leave any of it there.
+		out.Truncate(4)
+		writer.Reset(&out)
+		writer.Close()
+	}
+	n := copy(stored, out.Bytes())
+	for i := range stored[n:] {
+		stored[n+i] = 0
+	}
+
+	start := r.entryStart(off)
+	length := r.entryLength(off)
+	if r.layout.has(formatChecksum) {
+		r.putChecksum(start, length)
+	}
+	r.written(off, start-off+r.entrySize(length))
+	return true, nil
+}
+
+// TrainDictionary will make a compression dictionary out of the most recent
+// samples records in the Ring that are small enough to be compressed (see
+// Options.Dictionaries), and start compressing records written from here on
+// with it. The dictionary is returned, so that it can be kept, and added to
+// the Dictionaries the next time the Ring is opened.
+//
+// The dictionary is the sample records themselves, most recent last, less
+// any repeats, and cut down to the 32KiB that DEFLATE can make use of. This
+// is simple, but it works well for records that share a lot of structure,
+// such as JSON or log lines, which are the ones that need it.
+//
+// The Ring has to store RecordFlags (which Dictionaries turns on), to mark
+// which records are compressed.
+func (r *Ring) TrainDictionary(samples int) ([]byte, error) {
+	if !r.layout.has(formatFlags) {
+		return nil, ErrNoFlags
+	}
+
+	r.writeMutex.Lock()
+	defer r.writeMutex.Unlock()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// Walk the whole Ring, keeping the last samples records small enough
+	// to be compressed.
+	var picked [][]byte
+	for off := *r.head; off != *r.tail; off = r.nextEntry(off) {
+		data, err := r.recordData(off)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) >= r.compressBelow {
+			continue
+		}
+		picked = append(picked, append([]byte{}, data...))
+		if len(picked) > samples {
+			picked = picked[1:]
+		}
+	}
+	if len(picked) == 0 {
+		return nil, ErrNoSamples
+	}
+
+	var dictionary []byte
+	seen := map[string]bool{}
+	for _, sample := range picked {
+		if seen[string(sample)] {
+			continue
+		}
+		seen[string(sample)] = true
+		dictionary = append(dictionary, sample...)
+	}
+	if len(dictionary) > maxDictionary {
+		dictionary = dictionary[len(dictionary)-maxDictionary:]
+	}
+
+	r.addDictionary(dictionary)
+	return append([]byte{}, dictionary...), nil
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,87 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"fmt"
+	"testing"
+)
+
+// logLine will return a small record that looks a lot like every other.
+func logLine(i int) string {
+	return fmt.Sprintf(`{"level":"info","service":"checkout","message":"order placed","order":%d}`, i)
+}
+
+func TestTrainDictionary(t *testing.T) {
+	if _, err := openTestRing(t, Options{}).TrainDictionary(10); err != ErrNoFlags {
+		t.Fatalf("expected ErrNoFlags, got %v", err)
+	}
+
+	path := testRingPath(t)
+	options := Options{ReserveHeader: true, RecordFlags: true, NonBlockingReads: true}
+	r := openTestRingAt(t, path, options)
+	if _, err := r.TrainDictionary(10); err != ErrNoSamples {
+		t.Fatalf("expected ErrNoSamples, got %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		writeRecords(t, r, logLine(i))
+	}
+	plain := r.Len()
+	dictionary, err := r.TrainDictionary(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 20; i < 40; i++ {
+		writeRecords(t, r, logLine(i))
+	}
+	if compressed := r.Len() - plain; compressed >= plain {
+		t.Fatalf("expected the records to take up less room than %d bytes, got %d", plain, compressed)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without the dictionary, the compressed records can't be read,
+	// though the ones written before it was trained still can.
+	r = openTestRingAt(t, path, options)
+	for i := 0; i < 20; i++ {
+		if record := readRecord(t, r); record != logLine(i) {
+			t.Fatalf("expected %q, got %q", logLine(i), record)
+		}
+	}
+	if _, err := r.Read(make([]byte, 1024)); err != ErrUnknownDictionary {
+		t.Fatalf("expected ErrUnknownDictionary, got %v", err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	options.Dictionaries = [][]byte{dictionary}
+	r = openTestRingAt(t, path, options)
+	defer r.Close()
+	for i := 20; i < 40; i++ {
+		if record := readRecord(t, r); record != logLine(i) {
+			t.Fatalf("expected %q, got %q", logLine(i), record)
+		}
+	}
+}
+
+// vim: foldmethod=marker
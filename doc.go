@@ -24,6 +24,32 @@
 //
 // This buffer does not have fixed sizes, rather, it enocdes the length with
 // the written data. Data is added and removed at the chunk level.
+//
+// By default, the file is mmapped, which needs a platform with mmap(2) (and
+// works best on Linux). Building with the diskring_portable tag swaps that
+// out for ordinary reads and writes of a copy on the heap, which is slower,
+// but works anywhere Go does. Files can be moved between the two. Builds for
+// wasip1 always use the portable backend, so the Ring can be used from WASM
+// runtimes against whatever directories they've been given.
+//
+// A Ring belongs to the process that opened it. Go can't fork without an
+// exec (which throws away the mapping, along with everything else), so
+// there's never a child process left holding a copy of a Ring's mapping,
+// mutexes and waiting readers, and nothing to reattach. A child process
+// that needs the Ring should open it itself, either by path, or by being
+// handed the file (such as with exec.Cmd's ExtraFiles) and passing it to
+// NewWithOptions. Files the library opens are never inherited across an
+// exec unless they're handed over like this.
+//
+// A file that's handed over shares its flock(2) lock (see Options.Lock)
+// with the parent, since the lock belongs to the open file, rather than the
+// process or the file descriptor: the child's Lock is taken straight away,
+// and closing either Ring leaves the lock held, since the other still has
+// the file open. The lock is only let go of once every copy of the file
+// descriptor has been closed, or once a Ring that doesn't close its file
+// (see Options.DontCloseFile and Detach) is closed, since that unlocks the
+// file outright, for both of them. A child that needs a lock of its own
+// should open the file by path.
 package diskring
 
 // vim: foldmethod=marker
@@ -0,0 +1,43 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+//go:build linux && !diskring_portable
+// +build linux,!diskring_portable
+
+package diskring
+
+// madvDontDump is MADV_DONTDUMP, which the syscall package doesn't have.
+const madvDontDump = 0x10
+
+// UNSAFE
+//
+// Leave the ring's data (both halves of the mirror) and the header page out
+// of any core dump of the process (see Options.DontDump).
+func (r *Ring) dontDump() error {
+	if err := madvise(addressOf(r.buf), r.size<<1, madvDontDump); err != nil {
+		return err
+	}
+	if r.headerPage != nil {
+		return madvise(addressOf(r.headerPage), uintptr(len(r.headerPage)), madvDontDump)
+	}
+	return nil
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,96 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+//go:build !diskring_portable
+// +build !diskring_portable
+
+package diskring
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+// vmFlags will return the flags /proc/self/smaps lists for the mapping
+// holding addr, or nil if nothing is mapped there.
+func vmFlags(t *testing.T, addr uintptr) []string {
+	t.Helper()
+	fd, err := os.Open("/proc/self/smaps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+	scanner := bufio.NewScanner(fd)
+	within := false
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if !strings.HasSuffix(fields[0], ":") {
+			var start, end uintptr
+			if _, err := fmt.Sscanf(fields[0], "%x-%x", &start, &end); err != nil {
+				t.Fatal(err)
+			}
+			within = start <= addr && addr < end
+			continue
+		}
+		if within && fields[0] == "VmFlags:" {
+			return fields[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return nil
+}
+
+// dumped will check if the mapping holding addr is left in core dumps.
+func dumped(t *testing.T, addr uintptr) bool {
+	t.Helper()
+	for _, flag := range vmFlags(t, addr) {
+		if flag == "dd" {
+			return false
+		}
+	}
+	return true
+}
+
+func TestDontDump(t *testing.T) {
+	r := openTestRing(t, Options{ReserveHeader: true})
+	if !dumped(t, addressOf(r.buf)) {
+		t.Fatal("expected the ring to be dumped without DontDump")
+	}
+
+	r = openTestRing(t, Options{ReserveHeader: true, DontDump: true})
+	for name, addr := range map[string]uintptr{
+		"header": addressOf(r.headerPage),
+		"ring":   addressOf(r.buf),
+		"mirror": addressOf(r.buf) + r.size,
+	} {
+		if dumped(t, addr) {
+			t.Errorf("expected the %s to be left out of core dumps", name)
+		}
+	}
+}
+
+// vim: foldmethod=marker
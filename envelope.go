@@ -0,0 +1,411 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"errors"
+	"time"
+	"unsafe"
+)
+
+// ErrFormatMismatch is returned when opening a Ring with Options that would
+// lay records out differently to how they're already laid out in the file.
+var ErrFormatMismatch = errors.New("diskring: record format options don't match the file")
+
+// Bits of the record format, which say which optional fields are stored in
+// the envelope of each entry (between the length and the data).
+const (
+	formatTimestamp uint64 = 1 << iota
+	formatTrailer
+	formatFlags
+	formatSector
+	formatCompact
+	formatContentType
+	formatProducer
+	formatCanary
+	formatKey
+	formatChecksum
+	formatChain
+	formatSignature
+	formatPage
+	formatSchema
+)
+
+// Bits of the flags field of the envelope which the library uses itself,
+// from the bits reserved for it (see Flags).
+const (
+	flagSpilled uint16 = 1 << 4
+
+	// flagDictionary marks a record whose data was compressed with a
+	// dictionary (see dictionary.go).
+	flagDictionary uint16 = 1 << 5
+
+	// flagBatch marks an entry holding a batch of records, which were
+	// coalesced into one (see coalesce.go).
+	flagBatch uint16 = 1 << 6
+)
+
+// layout is where each of the optional fields lives in the envelope of an
+// entry, worked out from the record format when the Ring is opened.
+type layout struct {
+	format uint64
+
+	// wordSize is the size of the length of each entry (and the size of
+	// any padding in front of it), and padBit is set in that word if it's
+	// actually the size of the padding in front of the entry. This is
+	// only used if the record format is sector aligned, since a length
+	// can never be that large.
+	wordSize uintptr
+	padBit   uintptr
+
+	// sectorSize is the size of the blocks entries are kept inside of,
+	// if the record format is sector aligned: 4K, or the Ring's
+	// alignment if the record format is page aligned too.
+	sectorSize uintptr
+
+	// envelopeSize is the total size of the optional fields.
+	envelopeSize uintptr
+
+	// timeOffset is the offset of the timestamp into the envelope.
+	timeOffset uintptr
+
+	// flagsOffset is the offset of the flags into the envelope.
+	flagsOffset uintptr
+
+	// typeOffset is the offset of the content type into the envelope.
+	typeOffset uintptr
+
+	// producerOffset is the offset of the producer ID into the envelope.
+	producerOffset uintptr
+
+	// keyOffset is the offset of the length of the key into the envelope.
+	// The key itself is stored in front of the data.
+	keyOffset uintptr
+
+	// schemaOffset is the offset of the schema version into the envelope.
+	schemaOffset uintptr
+
+	// signatureOffset is the offset of the signature into the envelope.
+	signatureOffset uintptr
+
+	// chainOffset is the offset of the hash chain into the envelope. It's
+	// always at the end of the envelope, right in front of the key and the
+	// data, so that the signature can cover all three in one piece.
+	chainOffset uintptr
+
+	// trailerSize is the size of anything stored after the data, which
+	// starts with the canary, if there is one.
+	trailerSize uintptr
+
+	// checksumOffset is the offset of the checksum into the trailer.
+	checksumOffset uintptr
+}
+
+// newLayout will work out the layout of the envelope for a record format,
+// in a Ring with the provided alignment.
+func newLayout(format uint64, align uintptr) layout {
+	l := layout{format: format, wordSize: uintptrSize, sectorSize: sectorSize}
+	if format&formatPage != 0 {
+		l.sectorSize = align
+	}
+	if format&formatCompact != 0 {
+		l.wordSize = 4
+	}
+	l.padBit = uintptr(1) << (8*l.wordSize - 1)
+
+	if format&formatTimestamp != 0 {
+		l.timeOffset = l.envelopeSize
+		l.envelopeSize += 8
+	}
+	if format&formatFlags != 0 {
+		l.flagsOffset = l.envelopeSize
+		l.envelopeSize += 2
+	}
+	if format&formatContentType != 0 {
+		l.typeOffset = l.envelopeSize
+		l.envelopeSize += 2
+	}
+	if format&formatProducer != 0 {
+		l.producerOffset = l.envelopeSize
+		l.envelopeSize += 2
+	}
+	if format&formatKey != 0 {
+		l.keyOffset = l.envelopeSize
+		l.envelopeSize += 2
+	}
+	if format&formatSchema != 0 {
+		l.schemaOffset = l.envelopeSize
+		l.envelopeSize++
+	}
+	if format&formatSignature != 0 {
+		l.signatureOffset = l.envelopeSize
+		l.envelopeSize += signatureSize
+	}
+	if format&formatChain != 0 {
+		l.chainOffset = l.envelopeSize
+		l.envelopeSize += chainSize
+	}
+	if format&formatCanary != 0 {
+		l.trailerSize += uintptr(len(canary))
+	}
+	if format&formatChecksum != 0 {
+		l.checksumOffset = l.trailerSize
+		l.trailerSize += 4
+	}
+	if format&formatTrailer != 0 {
+		l.trailerSize += l.wordSize
+		if format&formatSector != 0 {
+			// The padding in front of the entry is stored too, so
+			// that we can find the start of it going backwards.
+			l.trailerSize += l.wordSize
+		}
+	}
+	return l
+}
+
+// has will check if the record format has all the provided bits set.
+func (l layout) has(bits uint64) bool {
+	return l.format&bits == bits
+}
+
+// format will return the record format bits the Options ask for.
+func (o Options) format() uint64 {
+	var format uint64
+	if o.Timestamps {
+		format |= formatTimestamp
+	}
+	if o.TrailingLength {
+		format |= formatTrailer
+	}
+	if o.SpillDir != "" || o.RecordFlags || len(o.Dictionaries) > 0 || o.Coalesce > 0 {
+		format |= formatFlags
+	}
+	if o.SectorAlign || o.PageAlign {
+		format |= formatSector
+	}
+	if o.PageAlign {
+		format |= formatPage
+	}
+	if o.CompactLength {
+		format |= formatCompact
+	}
+	if o.ContentTypes {
+		format |= formatContentType
+	}
+	if o.ProducerIDs {
+		format |= formatProducer
+	}
+	if o.Debug {
+		format |= formatCanary
+	}
+	if o.Keys {
+		format |= formatKey
+	}
+	if o.Checksums {
+		format |= formatChecksum
+	}
+	if o.HashChain {
+		format |= formatChain
+	}
+	if o.SigningKey != nil || o.VerifyKey != nil {
+		format |= formatSignature
+	}
+	if o.SchemaVersions || o.SchemaVersion != 0 {
+		format |= formatSchema
+	}
+	return format
+}
+
+// formatOptions will return the Options that lay records out in the record
+// format, which is the opposite of Options.format. A format with signatures
+// needs a SigningKey or VerifyKey too, which this can't make up.
+func formatOptions(format uint64) Options {
+	l := layout{format: format}
+	return Options{
+		Timestamps:     l.has(formatTimestamp),
+		TrailingLength: l.has(formatTrailer),
+		RecordFlags:    l.has(formatFlags),
+		SectorAlign:    l.has(formatSector),
+		PageAlign:      l.has(formatPage),
+		CompactLength:  l.has(formatCompact),
+		ContentTypes:   l.has(formatContentType),
+		ProducerIDs:    l.has(formatProducer),
+		Debug:          l.has(formatCanary),
+		Keys:           l.has(formatKey),
+		Checksums:      l.has(formatChecksum),
+		HashChain:      l.has(formatChain),
+		SchemaVersions: l.has(formatSchema),
+	}
+}
+
+// envelope is the values of the optional fields of an entry.
+type envelope struct {
+	time        int64
+	flags       uint16
+	contentType uint16
+	producer    uint16
+	schema      uint8
+	key         []byte
+
+	// chain is the hash to store in the entry, or nil to link it onto
+	// the end of the chain (see HashChain).
+	chain []byte
+
+	// signature is the signature to store in the entry, or nil to sign
+	// it with the SigningKey.
+	signature []byte
+}
+
+// newEnvelope will create the envelope for an entry being written now.
+func (r *Ring) newEnvelope() envelope {
+	e := envelope{schema: r.schema}
+	if r.layout.has(formatTimestamp) {
+		e.time = time.Now().UnixNano()
+	}
+	return e
+}
+
+// UNSAFE
+//
+// Return the envelope of the entry at the provided offset, ready for the
+// entry's record to be written out again with writeEntry, which spills,
+// compresses, chains and signs it afresh.
+func (r *Ring) entryEnvelope(off uintptr) envelope {
+	e := envelope{
+		flags:       r.entryFlags(off) &^ (flagSpilled | flagDictionary),
+		contentType: r.entryContentType(off),
+		producer:    r.entryProducer(off),
+		schema:      r.entrySchema(off),
+		key:         append([]byte{}, r.entryKey(off)...),
+	}
+	if r.layout.has(formatTimestamp) {
+		e.time = r.entryTime(off)
+	}
+	return e
+}
+
+// UNSAFE
+//
+// Write the envelope into the entry whose length is at the provided offset
+// (after any padding).
+func (r *Ring) putEnvelope(start uintptr, e envelope) {
+	base := start + r.layout.wordSize
+	if r.layout.has(formatTimestamp) {
+		*(*int64)(unsafe.Pointer(&r.buf[base+r.layout.timeOffset])) = e.time
+	}
+	if r.layout.has(formatFlags) {
+		*(*uint16)(unsafe.Pointer(&r.buf[base+r.layout.flagsOffset])) = e.flags
+	}
+	if r.layout.has(formatContentType) {
+		*(*uint16)(unsafe.Pointer(&r.buf[base+r.layout.typeOffset])) = e.contentType
+	}
+	if r.layout.has(formatProducer) {
+		*(*uint16)(unsafe.Pointer(&r.buf[base+r.layout.producerOffset])) = e.producer
+	}
+	if r.layout.has(formatKey) {
+		*(*uint16)(unsafe.Pointer(&r.buf[base+r.layout.keyOffset])) = uint16(len(e.key))
+	}
+	if r.layout.has(formatSchema) {
+		r.buf[base+r.layout.schemaOffset] = e.schema
+	}
+}
+
+// UNSAFE
+//
+// Read the flags of the entry at the provided offset, which are always 0 if
+// the record format doesn't have flags.
+func (r *Ring) entryFlags(off uintptr) uint16 {
+	if !r.layout.has(formatFlags) {
+		return 0
+	}
+	base := r.entryStart(off) + r.layout.wordSize
+	return *(*uint16)(unsafe.Pointer(&r.buf[base+r.layout.flagsOffset]))
+}
+
+// UNSAFE
+//
+// Read the content type of the entry at the provided offset, which is
+// always 0 if the record format doesn't have content types.
+func (r *Ring) entryContentType(off uintptr) uint16 {
+	if !r.layout.has(formatContentType) {
+		return 0
+	}
+	base := r.entryStart(off) + r.layout.wordSize
+	return *(*uint16)(unsafe.Pointer(&r.buf[base+r.layout.typeOffset]))
+}
+
+// UNSAFE
+//
+// Read the ID of the Producer that wrote the entry at the provided offset,
+// which is always 0 if the record format doesn't have producer IDs (or the
+// entry wasn't written by a Producer).
+func (r *Ring) entryProducer(off uintptr) uint16 {
+	if !r.layout.has(formatProducer) {
+		return 0
+	}
+	base := r.entryStart(off) + r.layout.wordSize
+	return *(*uint16)(unsafe.Pointer(&r.buf[base+r.layout.producerOffset]))
+}
+
+// UNSAFE
+//
+// Read the schema version of the entry at the provided offset, which is
+// always 0 if the record format doesn't have schema versions.
+func (r *Ring) entrySchema(off uintptr) uint8 {
+	if !r.layout.has(formatSchema) {
+		return 0
+	}
+	base := r.entryStart(off) + r.layout.wordSize
+	return r.buf[base+r.layout.schemaOffset]
+}
+
+// UNSAFE
+//
+// Read the timestamp of the entry at the provided offset, in nanoseconds
+// since the epoch.
+func (r *Ring) entryTime(off uintptr) int64 {
+	base := r.entryStart(off) + r.layout.wordSize
+	return *(*int64)(unsafe.Pointer(&r.buf[base+r.layout.timeOffset]))
+}
+
+// UNSAFE
+//
+// Read the length of the key of the entry at the provided offset, which is
+// always 0 if the record format doesn't have keys.
+func (r *Ring) entryKeyLength(off uintptr) uintptr {
+	if !r.layout.has(formatKey) {
+		return 0
+	}
+	base := r.entryStart(off) + r.layout.wordSize
+	return uintptr(*(*uint16)(unsafe.Pointer(&r.buf[base+r.layout.keyOffset])))
+}
+
+// UNSAFE
+//
+// Return the key of the entry at the provided offset, which is empty if
+// the record format doesn't have keys. This is a slice into the mapping, so
+// it must be copied out before the mutex is released.
+func (r *Ring) entryKey(off uintptr) []byte {
+	start := r.entryStart(off) + r.layout.wordSize + r.layout.envelopeSize
+	return r.buf[start : start+r.entryKeyLength(off)]
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,231 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Erase will physically remove every record in the Ring that match says
+// should go, for when a record has to be verifiably gone from the file
+// (such as a request to delete someone's data), rather than just marked as
+// deleted. match is called with the data of every record between the head
+// and the tail (including any still waiting to be coalesced, which are
+// written out first), with batches of coalesced records (see
+// Options.Coalesce) unpacked, so that each record in a batch is matched on
+// its own. With a TierDir, match is then called with the data of every
+// record in the tier, and the segments holding any that match are
+// rewritten without them. This returns the number of records erased.
+//
+// The records that are kept are moved up towards the head, just like
+// Compact, so the same goes for their sequence numbers, and for anything
+// holding on to a position in the Ring from before an Erase that erases
+// anything. Everything in the Ring outside of the records that are kept is
+// then zeroed, including records that were already consumed but not yet
+// overwritten, since there's no telling which of them would have matched.
+// Spilled records that are erased have their side files removed. Erasing
+// some, but not all, of the records in a batch means writing the whole Ring
+// back (see Migrate), since the batch shrinks.
+//
+// Once the records are gone, the Ring is flushed out to disk (see Sync), so
+// nothing erased is left on disk once this returns. The data passed to
+// match is a slice into the Ring (or a copy of a spilled record), which must
+// not be held on to after it returns. This waits for any open Txn like
+// Write.
+func (r *Ring) Erase(match func([]byte) bool) (int, error) {
+	r.writeMutex.Lock()
+	r.mutex.Lock()
+	erased, err := r.erase(match)
+	r.mutex.Unlock()
+	r.writeMutex.Unlock()
+	if err != nil {
+		return erased, err
+	}
+
+	// Sync takes the locks itself (to write out a batch, if there is
+	// one), and anything written in the meantime wasn't erased anyway.
+	return erased, r.Sync()
+}
+
+// UNSAFE
+//
+// Erase every record match says should go, from the ring and then from the
+// tier, and zero out everything that's left over. The caller must hold both
+// the writeMutex and the mutex.
+func (r *Ring) erase(match func([]byte) bool) (int, error) {
+	if r.readOnly {
+		return 0, fmt.Errorf("diskring: read only")
+	}
+	// Records still waiting to be coalesced have to be in the ring to be
+	// matched.
+	if err := r.flushBatch(); err != nil {
+		return 0, err
+	}
+
+	// Everything that has to go is found up front, so that a spilled
+	// record that can't be read back in stops the Erase before anything
+	// has been moved.
+	var (
+		erase   = map[uint64]bool{}
+		partial = map[uint64]map[int]bool{}
+		erased  int
+		seq     = r.header.headSeq
+	)
+	for off := *r.head; off != *r.tail; off = r.nextEntry(off) {
+		data, err := r.recordData(off)
+		if err != nil {
+			return 0, err
+		}
+		if r.entryFlags(off)&flagBatch == 0 {
+			if match(data) {
+				erase[seq] = true
+				erased++
+			}
+			seq++
+			continue
+		}
+		gone, records, err := matchBatch(data, match)
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case len(gone) == 0:
+		case len(gone) == records:
+			erase[seq] = true
+		default:
+			partial[seq] = gone
+		}
+		erased += len(gone)
+		seq++
+	}
+
+	if len(partial) > 0 {
+		if err := r.eraseFromBatches(partial); err != nil {
+			return 0, err
+		}
+	}
+	if len(erase) > 0 {
+		r.compact(func(off uintptr, seq uint64) bool {
+			return erase[seq]
+		})
+	}
+	r.zeroFree()
+
+	if r.tier != nil {
+		n, err := r.tier.erase(match)
+		erased += n
+		if err != nil {
+			return erased, err
+		}
+	}
+	return erased, nil
+}
+
+// matchBatch will pass every record in a batch of coalesced records to
+// match, returning the offset into the batch of each record that matched,
+// along with the number of records in the batch.
+func matchBatch(data []byte, match func([]byte) bool) (map[int]bool, int, error) {
+	gone := map[int]bool{}
+	records := 0
+	for pos := 0; pos < len(data); records++ {
+		rec, next, err := unbatch(data, pos)
+		if err != nil {
+			return nil, 0, err
+		}
+		if match(rec) {
+			gone[pos] = true
+		}
+		pos = next
+	}
+	return gone, records, nil
+}
+
+// UNSAFE
+//
+// Take the records at the provided offsets out of the batches with the
+// provided sequence numbers. The batches shrink, so they can't be changed
+// in place, and the whole Ring is written back, just like Migrate does.
+func (r *Ring) eraseFromBatches(partial map[uint64]map[int]bool) error {
+	if err := r.checkWrite(0); err != nil {
+		return err
+	}
+	var entries []migratedEntry
+	seq := r.header.headSeq
+	for off := *r.head; off != *r.tail; off = r.nextEntry(off) {
+		data, err := r.recordData(off)
+		if err != nil {
+			return err
+		}
+		m := migratedEntry{e: r.entryEnvelope(off)}
+		if gone := partial[seq]; gone != nil {
+			if m.data, m.moved, err = eraseBatch(data, gone); err != nil {
+				return err
+			}
+		} else {
+			m.data = append([]byte{}, data...)
+		}
+		entries = append(entries, m)
+		seq++
+	}
+	return r.rewriteEntries(entries)
+}
+
+// eraseBatch will pack the records in a batch of coalesced records into a
+// new batch, leaving out the records at the offsets in gone, and return it
+// along with where each record moved to. An erased record moves to
+// wherever the record after it did, or off the end of the new batch if
+// there isn't one.
+func eraseBatch(data []byte, gone map[int]bool) ([]byte, map[int]int, error) {
+	var (
+		batch  []byte
+		moved  = map[int]int{}
+		length [binary.MaxVarintLen64]byte
+	)
+	for pos := 0; pos < len(data); {
+		rec, next, err := unbatch(data, pos)
+		if err != nil {
+			return nil, nil, err
+		}
+		moved[pos] = len(batch)
+		if !gone[pos] {
+			batch = append(batch, length[:binary.PutUvarint(length[:], uint64(len(rec)))]...)
+			batch = append(batch, rec...)
+		}
+		pos = next
+	}
+	return batch, moved, nil
+}
+
+// UNSAFE
+//
+// Zero everything in the ring between the tail and the head, which is every
+// byte that isn't part of a record the reader hasn't consumed yet.
+func (r *Ring) zeroFree() {
+	free := r.freeBytes()
+	buf := r.buf[*r.tail : *r.tail+free]
+	for i := range buf {
+		buf[i] = 0
+	}
+	r.written(*r.tail, free)
+}
+
+// vim: foldmethod=marker
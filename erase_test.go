@@ -0,0 +1,163 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestEraseCoalesced(t *testing.T) {
+	r, records := openCoalescedRing(t, Options{})
+	c, err := r.Cursor("c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	if _, err := c.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	erased, err := r.Erase(func(data []byte) bool {
+		return string(data) == "rec1" || string(data) == "rec6"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if erased != 2 {
+		t.Fatalf("expected 2 records erased, got %d", erased)
+	}
+
+	expected := []string{"rec2", "rec3", "rec4", "rec5", "rec7", "rec8", "rec9"}
+	if got := readAll(t, c); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+
+	c2, err := r.Cursor("c2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = append([]string{records[0]}, expected...)
+	if got := readAll(t, c2); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestErasePendingBatch(t *testing.T) {
+	r := openTestRing(t, Options{Coalesce: 1024, NonBlockingReads: true})
+	for i := 0; i < 5; i++ {
+		if _, err := r.Write([]byte(fmt.Sprint("rec", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	erased, err := r.Erase(func(data []byte) bool {
+		return string(data) == "rec2"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if erased != 1 {
+		t.Fatalf("expected the record waiting to be coalesced to be erased, got %d", erased)
+	}
+
+	c, err := r.Cursor("c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"rec0", "rec1", "rec3", "rec4"}
+	if got := readAll(t, c); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestEraseTier(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := openTestRing(t, Options{TierDir: dir})
+	for i := 0; i < 10; i++ {
+		if _, err := r.Write([]byte(fmt.Sprint("secret", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	buf := make([]byte, 64)
+	for i := 0; i < 5; i++ {
+		if _, err := r.Read(buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	erased, err := r.Erase(func(data []byte) bool {
+		return string(data) == "secret1" || string(data) == "secret7"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if erased != 2 {
+		t.Fatalf("expected 2 records erased, got %d", erased)
+	}
+
+	segments, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, segment := range segments {
+		data, err := ioutil.ReadFile(segment)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(data, []byte("secret1")) {
+			t.Fatalf("erased record is still in %s", segment)
+		}
+	}
+
+	var got []string
+	it := r.IterHistory(0)
+	for it.Next() {
+		got = append(got, string(it.Record().Data))
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"secret0", "secret2", "secret3", "secret4", "secret5", "secret6", "secret8", "secret9"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+
+	// The tier carries on from where it was.
+	if _, err := r.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Sync(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// vim: foldmethod=marker
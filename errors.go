@@ -0,0 +1,148 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	// ErrTooLarge is matched (using errors.Is) by every TooLargeError.
+	ErrTooLarge = errors.New("diskring: data is too large")
+
+	// ErrShortBuffer is matched (using errors.Is) by every
+	// ShortBufferError.
+	ErrShortBuffer = errors.New("diskring: buffer isn't large enough to hold record")
+
+	// ErrEvicted is matched (using errors.Is) by every EvictedError.
+	ErrEvicted = errors.New("diskring: record has been evicted")
+
+	// ErrEmpty is returned by Read when the Ring is empty and the
+	// NonBlockingReads option is set. Unlike an io.EOF, this doesn't mean
+	// that nothing more will ever be written.
+	ErrEmpty = errors.New("diskring: ring is empty")
+
+	// ErrTimeout is returned by Read when nothing was written to an empty
+	// Ring within the ReadTimeout.
+	ErrTimeout = errors.New("diskring: timed out waiting for a record")
+
+	// ErrPaused is returned by Consumer.Read while the Consumer is paused
+	// (see Consumer.Pause).
+	ErrPaused = errors.New("diskring: consumer is paused")
+
+	// ErrConflict is matched (using errors.Is) by every ConflictError.
+	ErrConflict = errors.New("diskring: ring was written to since it was last looked at")
+
+	// ErrHeaderBusy is returned when another process sharing the header
+	// was changing the cursor, and didn't finish within a second, most
+	// likely because it went away partway through. Opening the Ring with
+	// the Lock option repairs the header.
+	ErrHeaderBusy = errors.New("diskring: header is still being changed by another process")
+)
+
+// TooLargeError is returned when writing a record (or a transaction) that's
+// larger than the Ring will accept.
+type TooLargeError struct {
+	// Size is the number of bytes that were being written.
+	Size int
+
+	// Limit is the largest number of bytes that can be written.
+	Limit int
+}
+
+// Error implements the error interface.
+func (e *TooLargeError) Error() string {
+	return fmt.Sprintf("diskring: data is too large (size=%d, limit=%d)", e.Size, e.Limit)
+}
+
+// Is allows matching against ErrTooLarge with errors.Is.
+func (e *TooLargeError) Is(target error) bool {
+	return target == ErrTooLarge
+}
+
+// ShortBufferError is returned when reading a record into a buffer that's
+// too small to hold it. The record is not consumed, so it can be read again
+// with a buffer of at least Need bytes.
+type ShortBufferError struct {
+	// Need is the size of the record.
+	Need int
+
+	// Have is the size of the buffer provided.
+	Have int
+}
+
+// Error implements the error interface.
+func (e *ShortBufferError) Error() string {
+	return fmt.Sprintf(
+		"buffer isn't large enough to hold chunk (need=%d, have=%d)",
+		e.Need, e.Have,
+	)
+}
+
+// Is allows matching against ErrShortBuffer (or io.ErrShortBuffer) with
+// errors.Is.
+func (e *ShortBufferError) Is(target error) bool {
+	return target == ErrShortBuffer || target == io.ErrShortBuffer
+}
+
+// EvictedError is returned when asking for a record by its Offset after it's
+// been consumed or overwritten.
+type EvictedError struct {
+	// Offset is the record that was asked for.
+	Offset Offset
+
+	// Oldest is the Offset of the oldest record still in the Ring.
+	Oldest Offset
+}
+
+// Error implements the error interface.
+func (e *EvictedError) Error() string {
+	return fmt.Sprintf("diskring: record has been evicted (offset=%d, oldest=%d)", e.Offset, e.Oldest)
+}
+
+// Is allows matching against ErrEvicted with errors.Is.
+func (e *EvictedError) Is(target error) bool {
+	return target == ErrEvicted
+}
+
+// ConflictError is returned by WriteIfSequence when the newest record in the
+// Ring isn't the one the caller expected, because something else wrote to
+// the Ring in the meantime.
+type ConflictError struct {
+	// Expected is the sequence number the caller expected the newest
+	// record to have, and Last is the sequence number it actually has.
+	Expected uint64
+	Last     uint64
+}
+
+// Error implements the error interface.
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("diskring: ring was written to since it was last looked at (expected=%d, last=%d)", e.Expected, e.Last)
+}
+
+// Is allows matching against ErrConflict with errors.Is.
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+// vim: foldmethod=marker
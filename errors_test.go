@@ -0,0 +1,61 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestTooLargeError(t *testing.T) {
+	r := openTestRing(t, Options{MaxRecordSize: 100})
+	_, err := r.Write(bytes.Repeat([]byte{'x'}, 101))
+	var tooLarge *TooLargeError
+	if !errors.As(err, &tooLarge) || !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("expected a TooLargeError, got %v", err)
+	}
+	if tooLarge.Size != 101 || tooLarge.Limit != 100 {
+		t.Fatalf("expected size 101 and limit 100, got %+v", tooLarge)
+	}
+}
+
+func TestShortBufferError(t *testing.T) {
+	r := openTestRing(t, Options{})
+	writeRecords(t, r, "hello, world")
+
+	_, err := r.Read(make([]byte, 5))
+	var short *ShortBufferError
+	if !errors.As(err, &short) || !errors.Is(err, ErrShortBuffer) || !errors.Is(err, io.ErrShortBuffer) {
+		t.Fatalf("expected a ShortBufferError, got %v", err)
+	}
+	if short.Need != 12 || short.Have != 5 {
+		t.Fatalf("expected need 12 and have 5, got %+v", short)
+	}
+
+	// The record is still there to be read with a big enough buffer.
+	if record := readRecord(t, r); record != "hello, world" {
+		t.Fatalf("expected hello, world, got %q", record)
+	}
+}
+
+// vim: foldmethod=marker
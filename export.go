@@ -0,0 +1,170 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"time"
+)
+
+// Columns is a batch of records, with each field stored as a column of its
+// own, oldest record first, for writing out to a columnar file (such as
+// Parquet or Arrow) to load into analytics tools. Every column is the same
+// length.
+type Columns struct {
+	// Sequence is the sequence number of each record.
+	Sequence []uint64
+
+	// Time is when each record was written, which is the zero Time if the
+	// Ring doesn't store timestamps.
+	Time []time.Time
+
+	// Producer is the ID of the Producer that wrote each record, or 0.
+	Producer []uint16
+
+	// ContentType is the content type of each record, or 0.
+	ContentType []ContentType
+
+	// Flags are the flags of each record, or 0.
+	Flags []Flags
+
+	// Key is the key of each record, which is empty if it doesn't have
+	// one.
+	Key [][]byte
+
+	// Data is the data of each record.
+	Data [][]byte
+}
+
+// Len will return the number of records in the Columns.
+func (c *Columns) Len() int {
+	return len(c.Sequence)
+}
+
+// add will append a record to the end of each column.
+func (c *Columns) add(seq uint64, rec Record) {
+	c.Sequence = append(c.Sequence, seq)
+	c.Time = append(c.Time, rec.Time)
+	c.Producer = append(c.Producer, rec.Producer)
+	c.ContentType = append(c.ContentType, rec.ContentType)
+	c.Flags = append(c.Flags, rec.Flags)
+	c.Key = append(c.Key, rec.Key)
+	c.Data = append(c.Data, rec.Data)
+}
+
+// ColumnWriter writes batches of records out to a columnar file. The library
+// doesn't come with one, so that it doesn't have to depend on any particular
+// file format; wrapping whichever Parquet or Arrow library is already in use
+// only takes a few lines.
+//
+// The Columns passed to WriteColumns are only valid until it returns.
+type ColumnWriter interface {
+	WriteColumns(Columns) error
+}
+
+// ExportOptions controls how Export walks the Ring.
+type ExportOptions struct {
+	// BatchSize is the largest number of records to pass to each call to
+	// WriteColumns.
+	//
+	// Default: 1024
+	BatchSize int
+
+	// Drain will consume each batch of records from the Ring once it's
+	// been written out. If writing a batch fails, it's left in the Ring,
+	// so nothing is lost, though some records may be written out again
+	// by the next Export.
+	//
+	// Default: false (leave every record in the Ring)
+	Drain bool
+}
+
+// Export will walk the records in the Ring, oldest first, and write them
+// out in batches to the ColumnWriter, along with their sequence numbers and
+// everything else stored alongside them, returning the number of records
+// written out. Records written after Export starts aren't included, so
+// this always finishes, even if writers keep on writing. Batches of
+// coalesced records (see Options.Coalesce) are unpacked, and each record in
+// a batch is written out with the batch's sequence number.
+//
+// The Ring is only locked for as long as it takes to copy out each batch.
+// Anything overwritten by a writer in the meantime is skipped. If the Ring
+// is compacted while it's being exported, this stops with ErrStaleCursor,
+// since the sequence numbers no longer line up.
+func (r *Ring) Export(w ColumnWriter, options ExportOptions) (int, error) {
+	batchSize := options.BatchSize
+	if batchSize <= 0 {
+		batchSize = 1024
+	}
+
+	r.mutex.Lock()
+	generation := r.header.generation
+	seq, off, end := r.header.headSeq, *r.head, r.header.tailSeq
+	r.mutex.Unlock()
+
+	exported := 0
+	for seq < end {
+		cols := Columns{}
+
+		r.mutex.Lock()
+		if r.header.generation != generation {
+			r.mutex.Unlock()
+			return exported, ErrStaleCursor
+		}
+		if seq < r.header.headSeq {
+			seq, off = r.header.headSeq, *r.head
+		}
+		for ; seq < end && cols.Len() < batchSize; seq++ {
+			records, _, err := r.entryRecords(off, seq)
+			if err != nil {
+				r.mutex.Unlock()
+				return exported, err
+			}
+			for _, rec := range records {
+				cols.add(seq, rec)
+			}
+			off = r.nextEntry(off)
+		}
+		r.mutex.Unlock()
+
+		if cols.Len() == 0 {
+			break
+		}
+		if err := w.WriteColumns(cols); err != nil {
+			return exported, err
+		}
+		exported += cols.Len()
+
+		if options.Drain {
+			r.mutex.Lock()
+			err := ErrStaleCursor
+			if r.header.generation == generation {
+				err = r.commit(seq)
+			}
+			r.mutex.Unlock()
+			if err != nil {
+				return exported, err
+			}
+		}
+	}
+	return exported, nil
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,87 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// testColumnWriter keeps each batch of Columns it's asked to write, and
+// fails once it's been given failAfter of them, unless that's 0.
+type testColumnWriter struct {
+	batches   []Columns
+	failAfter int
+}
+
+func (w *testColumnWriter) WriteColumns(cols Columns) error {
+	if w.failAfter > 0 && len(w.batches) == w.failAfter {
+		return errors.New("disk full")
+	}
+	w.batches = append(w.batches, cols)
+	return nil
+}
+
+func TestExport(t *testing.T) {
+	r := openTestRing(t, Options{Timestamps: true, RecordFlags: true, Keys: true})
+	for _, rec := range []Record{
+		{Data: []byte("one")},
+		{Data: []byte("two"), Key: []byte("k")},
+		{Data: []byte("three"), Flags: FlagTombstone},
+		{Data: []byte("four")},
+		{Data: []byte("five")},
+	} {
+		if _, err := r.WriteRecord(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := &testColumnWriter{}
+	if n, err := r.Export(w, ExportOptions{BatchSize: 2}); n != 5 || err != nil {
+		t.Fatalf("expected 5 records exported, got %d (%v)", n, err)
+	}
+	if len(w.batches) != 3 {
+		t.Fatalf("expected 3 batches, got %d", len(w.batches))
+	}
+	first := w.batches[0]
+	if !reflect.DeepEqual(first.Sequence, []uint64{0, 1}) || string(first.Data[1]) != "two" || string(first.Key[1]) != "k" || first.Time[0].IsZero() {
+		t.Fatalf("unexpected first batch: %+v", first)
+	}
+	if w.batches[1].Flags[0] != FlagTombstone {
+		t.Fatalf("expected the tombstone flag, got %x", w.batches[1].Flags[0])
+	}
+	if r.Records() != 5 {
+		t.Fatalf("expected Export to leave the records alone, got %d", r.Records())
+	}
+
+	// Draining stops at the batch that couldn't be written out, leaving
+	// it in the Ring.
+	w = &testColumnWriter{failAfter: 1}
+	if n, err := r.Export(w, ExportOptions{BatchSize: 2, Drain: true}); n != 2 || err == nil {
+		t.Fatalf("expected the second batch to fail, got %d (%v)", n, err)
+	}
+	if r.Records() != 3 {
+		t.Fatalf("expected 3 records left, got %d", r.Records())
+	}
+}
+
+// vim: foldmethod=marker
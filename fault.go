@@ -0,0 +1,97 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"unsafe"
+)
+
+// Writing to a page of the mapping that the filesystem can't find a block
+// for (because the disk is full), or touching a page past the end of a file
+// that's been truncated, raises a SIGBUS, which Go turns into a fatal error.
+// To stop that from taking the whole process down, the file is preallocated
+// (see allocate_linux.go), and the places that copy records in and out of
+// the mapping ask the runtime to turn a fault into a panic instead, which is
+// caught and turned into an ErrFault.
+
+// ErrFault is returned once the Ring has faulted touching its mapping, such
+// as when the disk filled up under a sparse file (see AllowSparse), or the
+// file was truncated. After that, Read, ReadRecord and Write return it (or
+// ErrTruncated, if that's what happened) rather than touching the Ring, and
+// the Ring should be closed (see Reopen).
+var ErrFault = errors.New("diskring: fault accessing the ring's file (is the disk full?)")
+
+// fillHoles will have the filesystem set aside blocks for any holes in the
+// first size bytes of the file, returning an error if there isn't room for
+// them, rather than finding out with a SIGBUS later on.
+func fillHoles(fd *os.File, size int64) error {
+	holes, err := sparse(fd)
+	if err != nil || !holes {
+		return err
+	}
+	if err := preallocate(fd, size); err != nil {
+		return fmt.Errorf("diskring: can't fill in the holes in %s: %w", fd.Name(), err)
+	}
+	return nil
+}
+
+// UNSAFE
+//
+// Catch a fault touching the mapping, as a deferred call, with the runtime
+// set to panic on faults (see debug.SetPanicOnFault), setting err to the
+// reason the Ring can't be used any more. Any other panic carries on up.
+func (r *Ring) catchFault(err *error) {
+	e := recover()
+	if e == nil {
+		return
+	}
+	fault, ok := e.(interface{ Addr() uintptr })
+	if !ok || !r.mapped(fault.Addr()) {
+		panic(e)
+	}
+	if r.checkFile(); r.fileErr == nil {
+		r.fileErr = ErrFault
+		if r.waiting > 0 {
+			close(r.wakeup)
+			r.wakeup = make(chan struct{})
+		}
+	}
+	*err = r.fileErr
+}
+
+// UNSAFE
+//
+// Check if the provided address is in the mapping of the ring or the header.
+func (r *Ring) mapped(addr uintptr) bool {
+	within := func(buf []byte) bool {
+		if len(buf) == 0 {
+			return false
+		}
+		base := uintptr(unsafe.Pointer(&buf[0]))
+		return addr >= base && addr < base+uintptr(len(buf))
+	}
+	return within(r.buf) || within(r.headerPage)
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,58 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+//go:build !diskring_portable && !wasip1
+// +build !diskring_portable,!wasip1
+
+package diskring
+
+import (
+	"os"
+	"testing"
+)
+
+func TestFault(t *testing.T) {
+	path := testRingPath(t)
+	r := openTestRingAt(t, path, Options{NonBlockingReads: true})
+	defer r.Close()
+	writeRecords(t, r, "one")
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	// Nothing has checked the file, so the write faults on the mapping,
+	// which is turned into an error rather than killing the process.
+	if _, err := r.Write([]byte("two")); err != ErrTruncated {
+		t.Fatalf("expected the fault to be reported as ErrTruncated, got %v", err)
+	}
+
+	// Put the file back, so that closing the Ring doesn't fault.
+	if err := os.Truncate(path, info.Size()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Read(make([]byte, 16)); err != ErrTruncated {
+		t.Fatalf("expected ErrTruncated to stick, got %v", err)
+	}
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,262 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// FetchOptions controls how many records a call to Fetch will return.
+type FetchOptions struct {
+	// MaxRecords is the largest number of records to return, or 0 for
+	// no limit.
+	MaxRecords int
+
+	// MaxBytes is the largest number of bytes (of record data) to return,
+	// or 0 for no limit. At least one record is always returned, even if
+	// that one record is larger than MaxBytes.
+	//
+	// A batch of coalesced records (see Options.Coalesce) is returned whole
+	// or not at all, since it can only be committed as a whole, so the
+	// first batch is returned even if it's over either limit.
+	MaxBytes int
+
+	// MaxWait is how long to wait for a record to be written if the Ring
+	// is empty, or 0 to not wait at all.
+	MaxWait time.Duration
+}
+
+// CommitToken is an opaque marker returned with a Batch, which can be passed
+// to Commit to consume the records in that Batch.
+type CommitToken struct {
+	seq uint64
+}
+
+// Batch is a set of records returned by Fetch, which have not yet been
+// consumed from the Ring.
+type Batch struct {
+	// Records contains a copy of the data of each record, oldest first.
+	Records [][]byte
+
+	// Sequence is the sequence number of the first record in Records.
+	Sequence uint64
+
+	// Sequences is the sequence number of each record in Records, and
+	// Positions is where each one is in its batch of coalesced records
+	// (see Options.Coalesce), or 0 if it wasn't coalesced. Every record in
+	// a batch has the batch's sequence number, so it takes both to tell
+	// the records apart.
+	Sequences []uint64
+	Positions []int
+
+	// Dropped is the number of records that were overwritten by writers
+	// before they could be read, since the last batch was committed (or
+	// the last record was read). A consumer that's keeping up will always
+	// see 0 here.
+	Dropped uint64
+
+	// Token can be passed to Commit once the Records have been processed.
+	Token CommitToken
+}
+
+// Fetch will copy records from the head of the Ring without consuming them.
+// The records will stay in the Ring until the returned Batch's Token is
+// passed to Commit, so calling Fetch again without a Commit will return the
+// same records.
+//
+// This gives at-least-once delivery: if the consumer crashes between the
+// Fetch and the Commit, the records will be returned again next time. If
+// the records were overwritten by a writer in the meantime, they're gone
+// all the same.
+//
+// If the Ring is empty, Fetch will wait up to MaxWait for a record to be
+// written, and then return as many as the options allow, so that a consumer
+// can poll without spinning, and still get records in batches when it's
+// busy. If nothing is written in time, this will return an io.EOF.
+func (r *Ring) Fetch(options FetchOptions) (*Batch, error) {
+	return r.FetchContext(context.Background(), options)
+}
+
+// FetchContext is Fetch, but it'll stop waiting for a record to be written
+// if the context is done.
+func (r *Ring) FetchContext(ctx context.Context, options FetchOptions) (*Batch, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.empty() {
+		if options.MaxWait <= 0 {
+			return nil, io.EOF
+		}
+		timer := time.NewTimer(options.MaxWait)
+		defer timer.Stop()
+		if err := r.waitForWrite(ctx, timer.C); err != nil {
+			if err == ErrTimeout {
+				return nil, io.EOF
+			}
+			return nil, err
+		}
+	}
+
+	var (
+		batch = &Batch{Sequence: r.header.headSeq, Dropped: r.dropped()}
+		size  int
+		seq   = r.header.headSeq
+	)
+	for off := *r.head; off != *r.tail; off = r.nextEntry(off) {
+		records, first, err := r.entryRecords(off, seq)
+		if err != nil {
+			return nil, err
+		}
+		length := 0
+		for _, rec := range records {
+			length += len(rec.Data)
+		}
+		if len(batch.Records) > 0 {
+			if options.MaxRecords > 0 && len(batch.Records)+len(records) > options.MaxRecords {
+				break
+			}
+			if options.MaxBytes > 0 && size+length > options.MaxBytes {
+				break
+			}
+		}
+		for i, rec := range records {
+			batch.Records = append(batch.Records, rec.Data)
+			batch.Sequences = append(batch.Sequences, seq)
+			batch.Positions = append(batch.Positions, first+i)
+		}
+		size += length
+		seq++
+	}
+	batch.Token = CommitToken{seq: seq}
+	return batch, nil
+}
+
+// Commit will consume every record up to the end of the Batch the token
+// was returned with, advancing the head of the Ring. If the Ring has a
+// header on disk, the new position is persisted along with it.
+//
+// Records which have already been consumed or overwritten are skipped, so
+// committing the same token twice is harmless.
+func (r *Ring) Commit(token CommitToken) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.commit(token.seq)
+}
+
+// Consume will wait for something to be written to the Ring (or for the
+// context to be done), Fetch every record in it, and pass the Batch to fn.
+// The records are only consumed if fn returns nil, in which case the new
+// position is persisted (if the Ring has a header on disk) before Consume
+// returns. If fn returns an error, nothing is consumed, and the error is
+// returned, so the same records will be handed out again next time.
+//
+// A crash before the position is persisted replays the whole Batch, even if
+// fn had finished with it, so for exactly-once processing, whatever fn does
+// has to be safe to repeat, such as by storing Batch.Sequence along with the
+// results, and skipping anything already stored.
+//
+// Only one goroutine should be calling Consume (or Fetch and Commit) at a
+// time, or they'll be handed the same records.
+func (r *Ring) Consume(ctx context.Context, fn func(*Batch) error) error {
+	for {
+		r.mutex.Lock()
+		err := r.waitForWrite(ctx, nil)
+		r.mutex.Unlock()
+		if err != nil {
+			return err
+		}
+
+		batch, err := r.FetchContext(ctx, FetchOptions{})
+		if err == io.EOF {
+			// Someone else got to the records first.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := fn(batch); err != nil {
+			return err
+		}
+
+		r.mutex.Lock()
+		err = r.commit(batch.Token.seq)
+		if err == nil {
+			err = r.persistCursor()
+		}
+		r.mutex.Unlock()
+		return err
+	}
+}
+
+// UNSAFE
+//
+// Flush the cursor out to disk, if the Ring has a header there.
+func (r *Ring) persistCursor() error {
+	switch {
+	case r.headerPage == nil || r.readOnly:
+		return nil
+	case !r.libraryHeader:
+		return r.syncHeader()
+	case r.fileErr != nil:
+		return r.fileErr
+	default:
+		return r.commitHeader()
+	}
+}
+
+// UNSAFE
+//
+// Consume everything before the provided sequence number.
+func (r *Ring) commit(seq uint64) error {
+	defer r.consumed()
+	for r.header.headSeq < seq {
+		if err := r.advanceHead(); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Checkpoint will return the value last passed to SetCheckpoint.
+func (r *Ring) Checkpoint() uint64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.header.checkpoint
+}
+
+// SetCheckpoint will store an opaque value alongside the cursor, which is
+// persisted in the header if the Ring has one on disk. This is useful for
+// consumers forwarding records somewhere else to keep track of how far the
+// other end has gotten (such as the last sequence acknowledged by a remote
+// system), so that both ends can resume from the right place after a crash.
+func (r *Ring) SetCheckpoint(value uint64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.header.checkpoint = value
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,185 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fetchStrings will Fetch records from the Ring as strings, along with the
+// Batch they came in.
+func fetchStrings(t *testing.T, r *Ring, options FetchOptions) ([]string, *Batch) {
+	t.Helper()
+	batch, err := r.Fetch(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []string
+	for _, record := range batch.Records {
+		records = append(records, string(record))
+	}
+	return records, batch
+}
+
+func TestFetchCommit(t *testing.T) {
+	path := testRingPath(t)
+	options := Options{ReserveHeader: true}
+	r := openTestRingAt(t, path, options)
+	for _, record := range []string{"one", "two", "three"} {
+		if _, err := r.Write([]byte(record)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	records, batch := fetchStrings(t, r, FetchOptions{MaxRecords: 2})
+	if !reflect.DeepEqual(records, []string{"one", "two"}) || batch.Sequence != 0 {
+		t.Fatalf("expected one and two at 0, got %v at %d", records, batch.Sequence)
+	}
+
+	// Nothing is consumed until the batch is committed.
+	if again, _ := fetchStrings(t, r, FetchOptions{MaxRecords: 2}); !reflect.DeepEqual(again, records) {
+		t.Fatalf("expected the same records again, got %v", again)
+	}
+	if err := r.Commit(batch.Token); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Commit(batch.Token); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r = openTestRingAt(t, path, options)
+	defer r.Close()
+	records, batch = fetchStrings(t, r, FetchOptions{})
+	if !reflect.DeepEqual(records, []string{"three"}) || batch.Sequence != 2 {
+		t.Fatalf("expected three at 2 after reopening, got %v at %d", records, batch.Sequence)
+	}
+	if err := r.Commit(batch.Token); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Fetch(FetchOptions{}); err != io.EOF {
+		t.Fatalf("expected io.EOF once everything was committed, got %v", err)
+	}
+}
+
+func TestFetchMaxBytes(t *testing.T) {
+	r := openTestRing(t, Options{})
+	writeRecords(t, r, "aaaa", "bbbb", "cccc", "a much longer record")
+
+	if records, _ := fetchStrings(t, r, FetchOptions{MaxBytes: 10}); !reflect.DeepEqual(records, []string{"aaaa", "bbbb"}) {
+		t.Fatalf("expected two records in 10 bytes, got %v", records)
+	}
+	records, batch := fetchStrings(t, r, FetchOptions{MaxBytes: 10, MaxRecords: 1})
+	if !reflect.DeepEqual(records, []string{"aaaa"}) {
+		t.Fatalf("expected one record, got %v", records)
+	}
+	if err := r.Commit(batch.Token); err != nil {
+		t.Fatal(err)
+	}
+	records, batch = fetchStrings(t, r, FetchOptions{MaxBytes: 8})
+	if err := r.Commit(batch.Token); err != nil {
+		t.Fatal(err)
+	}
+	// The first record is always returned, even if it's too large.
+	if records, _ = fetchStrings(t, r, FetchOptions{MaxBytes: 8}); !reflect.DeepEqual(records, []string{"a much longer record"}) {
+		t.Fatalf("expected the long record on its own, got %v", records)
+	}
+}
+
+func TestFetchMaxWait(t *testing.T) {
+	r := openTestRing(t, Options{})
+	start := time.Now()
+	if _, err := r.Fetch(FetchOptions{MaxWait: 20 * time.Millisecond}); err != io.EOF {
+		t.Fatalf("expected io.EOF once MaxWait was up, got %v", err)
+	}
+	if waited := time.Since(start); waited < 20*time.Millisecond {
+		t.Fatalf("expected Fetch to wait out MaxWait, gave up after %s", waited)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := r.FetchContext(ctx, FetchOptions{MaxWait: time.Minute}); err != context.Canceled {
+		t.Fatalf("expected the context's error, got %v", err)
+	}
+
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		if _, err := r.Write([]byte("woken")); err != nil {
+			t.Error(err)
+		}
+	}()
+	if records, _ := fetchStrings(t, r, FetchOptions{MaxWait: 5 * time.Second}); !reflect.DeepEqual(records, []string{"woken"}) {
+		t.Fatalf("expected the record written while waiting, got %v", records)
+	}
+}
+
+func TestConsume(t *testing.T) {
+	path := testRingPath(t)
+	options := Options{ReserveHeader: true}
+	r := openTestRingAt(t, path, options)
+	writeRecords(t, r, "one", "two")
+
+	// A failed batch is left to be handed out again.
+	errFailed := errors.New("failed")
+	if err := r.Consume(context.Background(), func(*Batch) error { return errFailed }); err != errFailed {
+		t.Fatalf("expected the callback's error, got %v", err)
+	}
+	if r.Records() != 2 {
+		t.Fatalf("expected nothing to be consumed, got %d records left", r.Records())
+	}
+
+	var records []string
+	if err := r.Consume(context.Background(), func(batch *Batch) error {
+		for _, record := range batch.Records {
+			records = append(records, string(record))
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(records, []string{"one", "two"}) {
+		t.Fatalf("expected both records, got %q", records)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The position was persisted, so nothing's replayed.
+	r = openTestRingAt(t, path, options)
+	defer r.Close()
+	if r.Records() != 0 {
+		t.Fatalf("expected the batch to stay consumed, got %d records", r.Records())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := r.Consume(ctx, func(*Batch) error { return nil }); err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,327 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"runtime"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+const (
+	// headerMagic is written to the first word of a library managed header
+	// ("diskring", little endian). Files that predate the library header
+	// store a bare Cursor there instead, and since a head offset will never
+	// be that large, it's enough to tell the two apart.
+	headerMagic uint64 = 0x676e69726b736964
+
+	// headerVersion is the current layout of the header struct.
+	headerVersion uint64 = 2
+
+	// cacheLine is the size of a CPU cache line, which the head and tail
+	// are kept at least this far apart by.
+	cacheLine = 64
+
+	// maxProducers is the number of producer sessions the header can
+	// remember the last sequence of.
+	maxProducers = 32
+
+	// maxConsumers is the number of Consumers whose positions can be kept
+	// in the header.
+	maxConsumers = 8
+
+	// settleTimeout is how long to wait for another process to finish
+	// changing the cursor in a shared header before giving up on it (see
+	// settle).
+	settleTimeout = time.Second
+
+	// libraryHeaderSize is how much of the header page is kept for the
+	// library's header. The rest of the page is left for the user (see
+	// UserHeader).
+	libraryHeaderSize = 2048
+)
+
+// producerSlot is the last sequence number written by a single producer.
+// A slot with an id of 0 is unused.
+type producerSlot struct {
+	id  uint64
+	seq uint64
+}
+
+// consumerSlot is the position of a single Consumer. A slot with a hash of 0
+// is unused.
+type consumerSlot struct {
+	hash uint64
+	seq  uint64
+	off  uint64
+}
+
+// header is the layout of the library managed header, which lives in the
+// first page of the file when ReserveHeader is set (and no CustomHeader
+// is provided), or in memory otherwise.
+//
+// New fields must only ever be appended to the end of this struct, since
+// older files will have zeros there.
+type header struct {
+	magic   uint64
+	version uint64
+
+	// update is the seqlock around changes to the cursor and sequence
+	// numbers (see seqlock.go). It's kept here, rather than in the Ring,
+	// so that other processes sharing the header can follow it too. It
+	// takes up the start of where the head and tail were kept before
+	// version 2 of the header (see legacyCursor), so until a header is
+	// migrated (which zeroes them), it's the legacy head, and not a
+	// seqlock at all (see hasSeqlock).
+	update uint32
+
+	// owners counts the Rings that have opened the header with a write
+	// lease, to give each of them its own ID to hold the lease under (see
+	// lease.go). It shares the space left over by the old cursor with the
+	// seqlock.
+	owners uint32
+	_      [unsafe.Sizeof(Cursor{}) - 8]byte
+
+	producers [maxProducers]producerSlot
+
+	// headSeq is the sequence number of the record at the head, and tailSeq
+	// is the sequence number the next record written will get.
+	headSeq uint64
+	tailSeq uint64
+
+	// checkpoint is an opaque value persisted on behalf of a consumer, see
+	// Ring.SetCheckpoint.
+	checkpoint uint64
+
+	// format is the record format bits, saying which optional fields are
+	// stored in the envelope of each entry.
+	format uint64
+
+	// last is one more than the offset of the most recently written entry,
+	// or 0 if we don't know where that is.
+	last uintptr
+
+	// head and tail are the offsets of the read and write cursors. The
+	// reader and the writer each keep moving one of them, so they're kept
+	// on their own cache lines to stop them fighting over one.
+	_    [cacheLine]byte
+	head uintptr
+	_    [cacheLine]byte
+	tail uintptr
+
+	// chain is the hash of the last record written (see HashChain). Only
+	// the writer changes it, right along with the tail, so it shares the
+	// tail's cache line, taking up what used to be padding.
+	chain [chainSize]byte
+
+	// wraps is the number of times the tail has wrapped around the end
+	// of the ring, and overwrittenRecords and overwrittenBytes are the
+	// records (and the bytes of their keys and data) writers have
+	// overwritten to make room, since the header was created. These are
+	// only changed by the writer too, but always atomically, so that
+	// Stats can read them without the mutex.
+	wraps              uint64
+	overwrittenRecords uint64
+	overwrittenBytes   uint64
+	_                  [cacheLine - chainSize - 3*8]byte
+
+	// generation is a random number picked when the header is created,
+	// so that a CursorState from one Ring can't be used with another.
+	generation uint64
+
+	// consumers is the position of each Consumer kept in the header, by
+	// the hash of its name.
+	consumers [maxConsumers]consumerSlot
+
+	// activity is when the writer last showed signs of life, in
+	// nanoseconds since the epoch (see Options.Heartbeat).
+	activity int64
+
+	// alignment is what the header and the data were aligned to when the
+	// Ring was created (see Options.Alignment), or 0 if we don't know.
+	alignment uint64
+
+	// checksum is the checksum of the header (with this set to 0) as of
+	// the last time it was committed (see shadow.go), or 0 if it's never
+	// been committed.
+	checksum uint64
+
+	// lease is who holds the write lease, and until when (see lease.go).
+	// This fills the header right up to the shadow copy.
+	lease uint64
+}
+
+// newHeader will create a fresh in-memory header.
+func newHeader() *header {
+	return &header{
+		magic:      headerMagic,
+		version:    headerVersion,
+		generation: newGeneration(),
+	}
+}
+
+// newGeneration will pick a random generation for a new header.
+func newGeneration() uint64 {
+	var buf [8]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return uint64(time.Now().UnixNano())
+	}
+	return binary.LittleEndian.Uint64(buf[:])
+}
+
+// UNSAFE
+//
+// Bring the header up to the current layout. Files written before the
+// library had its own header only stored a Cursor at the start of the
+// page, so we'll move that into place and zero out the rest.
+func (h *header) migrate() {
+	if h.magic != headerMagic {
+		legacy := *(*Cursor)(unsafe.Pointer(h))
+		*h = header{
+			magic:      headerMagic,
+			version:    headerVersion,
+			head:       legacy.head,
+			tail:       legacy.tail,
+			generation: newGeneration(),
+		}
+		return
+	}
+
+	if h.version < 2 {
+		// Version 1 kept the head and tail next to each other.
+		legacy := h.legacyCursor()
+		h.head, h.tail = legacy.head, legacy.tail
+		*legacy = Cursor{}
+		h.version = 2
+	}
+	if h.generation == 0 {
+		h.generation = newGeneration()
+	}
+}
+
+// UNSAFE
+//
+// Return where the head and tail were kept before version 2 of the header.
+func (h *header) legacyCursor() *Cursor {
+	return (*Cursor)(unsafe.Pointer(&h.update))
+}
+
+// UNSAFE
+//
+// Check if the header has been migrated to a version with the seqlock in it.
+// Before then (or if it isn't a library header at all yet), where the
+// seqlock goes is part of the legacy cursor.
+func (h *header) hasSeqlock() bool {
+	return h.magic == headerMagic && h.version >= 2
+}
+
+// UNSAFE
+//
+// Wait for any change to the cursor that another process sharing the header
+// is making to finish, so that the header holds together. If it doesn't
+// finish within the settleTimeout, whoever was making it most likely went
+// away partway through (such as by crashing).
+//
+// Only the caller holding an exclusive lock on the file (see Options.Lock)
+// can know nobody else is still writing, so only then is the seqlock let go
+// of. Without the lock, there's no telling a dead writer from a slow one,
+// so ErrHeaderBusy is returned instead. The wait is still needed with the
+// lock, since a process handed the file shares it (see the package
+// documentation).
+func (h *header) settle(locked bool) error {
+	if !h.hasSeqlock() || atomic.LoadUint32(&h.update)%2 == 0 {
+		return nil
+	}
+	deadline := time.Now().Add(settleTimeout)
+	for atomic.LoadUint32(&h.update)%2 != 0 {
+		if time.Now().After(deadline) {
+			if !locked {
+				return ErrHeaderBusy
+			}
+			atomic.AddUint32(&h.update, 1)
+			return nil
+		}
+		runtime.Gosched()
+	}
+	return nil
+}
+
+// UNSAFE
+//
+// Return a copy of the header, taken while nobody sharing it is changing
+// the cursor, or ErrHeaderBusy if that doesn't happen within the
+// settleTimeout.
+func (h *header) load() (header, error) {
+	if !h.hasSeqlock() {
+		return *h, nil
+	}
+	deadline := time.Now().Add(settleTimeout)
+	for {
+		update := atomic.LoadUint32(&h.update)
+		if update%2 == 0 {
+			copied := *h
+			if atomic.LoadUint32(&h.update) == update {
+				return copied, nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return header{}, ErrHeaderBusy
+		}
+		runtime.Gosched()
+	}
+}
+
+// UNSAFE
+//
+// Return the header as a byte slice, for copying it somewhere else.
+func (h *header) bytes() []byte {
+	return (*[unsafe.Sizeof(header{})]byte)(unsafe.Pointer(h))[:]
+}
+
+// UNSAFE
+//
+// Find the slot for the provided producer, or nil if it's not known.
+func (h *header) producer(id uint64) *producerSlot {
+	for i := range h.producers {
+		if h.producers[i].id == id {
+			return &h.producers[i]
+		}
+	}
+	return nil
+}
+
+// UNSAFE
+//
+// Find the slot for the Consumer with the provided name hash, or nil if it's
+// not known.
+func (h *header) consumer(hash uint64) *consumerSlot {
+	for i := range h.consumers {
+		if h.consumers[i].hash == hash {
+			return &h.consumers[i]
+		}
+	}
+	return nil
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,68 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"testing"
+	"unsafe"
+)
+
+func TestSettleVersion1(t *testing.T) {
+	// A version 1 header keeps the head where the seqlock is now, so an
+	// odd head mustn't look like a change in progress.
+	h := &header{magic: headerMagic, version: 1}
+	*h.legacyCursor() = Cursor{head: 3, tail: 9}
+
+	if err := h.settle(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.settle(false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.load(); err != nil {
+		t.Fatal(err)
+	}
+
+	h.migrate()
+	if h.head != 3 || h.tail != 9 || h.update != 0 {
+		t.Fatalf("migrated to head %d, tail %d, update %d", h.head, h.tail, h.update)
+	}
+}
+
+func TestHeaderCacheLines(t *testing.T) {
+	var h header
+	// The head has a cache line to itself, and the tail only shares its
+	// line with what the writer changes along with it.
+	for _, field := range []struct {
+		name     string
+		from, to uintptr
+	}{
+		{"last to head", unsafe.Offsetof(h.last), unsafe.Offsetof(h.head)},
+		{"head to tail", unsafe.Offsetof(h.head), unsafe.Offsetof(h.tail)},
+		{"tail to generation", unsafe.Offsetof(h.tail), unsafe.Offsetof(h.generation)},
+	} {
+		if field.to-field.from < cacheLine {
+			t.Errorf("%s is only %d bytes", field.name, field.to-field.from)
+		}
+	}
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,67 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// newHeartbeat will start noting the time in the Ring's header every
+// interval, starting now.
+func newHeartbeat(r *Ring, interval time.Duration) *periodic {
+	r.beat()
+	return newPeriodic(interval, r.beat)
+}
+
+// beat will note the time in the header.
+func (r *Ring) beat() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.fileErr != nil {
+		return
+	}
+	atomic.StoreInt64(&r.header.activity, time.Now().UnixNano())
+	r.headerWritten()
+}
+
+// LastWriterActivity will return when the writer last noted the time in the
+// header (see Options.Heartbeat), or the zero time if it never has. If this
+// is further in the past than the writer's Heartbeat, the writer has most
+// likely gone away, rather than just having nothing to write.
+//
+// With the ReadOnlyCursor option, this still reads the header on disk, so a
+// reader in another process can keep an eye on the writer. Like Stats, this
+// never takes the Ring's lock.
+func (r *Ring) LastWriterActivity() time.Time {
+	hdr := r.header
+	if r.libraryHeader {
+		hdr = (*header)(unsafe.Pointer(&r.headerPage[0]))
+	}
+	activity := atomic.LoadInt64(&hdr.activity)
+	if activity == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, activity)
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,49 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHeartbeat(t *testing.T) {
+	if !openTestRing(t, Options{ReserveHeader: true}).LastWriterActivity().IsZero() {
+		t.Fatal("expected no activity without a Heartbeat")
+	}
+
+	before := time.Now()
+	r := openTestRing(t, Options{ReserveHeader: true, Heartbeat: 10 * time.Millisecond})
+	first := r.LastWriterActivity()
+	if first.Before(before) {
+		t.Fatalf("expected a heartbeat when the Ring was opened, got %s", first)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !r.LastWriterActivity().After(first) {
+		if time.Now().After(deadline) {
+			t.Fatal("the heartbeat never came")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,83 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrOutOfRange is returned when asking for a record past the end of the Ring.
+var ErrOutOfRange = errors.New("diskring: record index out of range")
+
+// At will return a copy of the i-th oldest record in the Ring (so 0 is the
+// record at the head), without consuming anything. This is handy for paging
+// through the Ring. If the record is a batch of coalesced records (see
+// Options.Coalesce), this returns ErrCoalesced.
+//
+// With the Index option, this finds the record right away; otherwise the
+// Ring is walked from the head to find it.
+func (r *Ring) At(i int) ([]byte, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if i < 0 || uint64(i) >= r.records() {
+		return nil, ErrOutOfRange
+	}
+
+	var off uintptr
+	if r.indexed {
+		off = r.index[i]
+	} else {
+		off = *r.head
+		for ; i > 0; i-- {
+			off = r.nextEntry(off)
+		}
+	}
+	if r.entryFlags(off)&flagBatch != 0 {
+		return nil, ErrCoalesced
+	}
+	data, err := r.recordData(off)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{}, data...), nil
+}
+
+// UNSAFE
+//
+// Walk the ring to build the index of every entry's offset. On a very large
+// ring this can take a while, so it'll give up if the context is done.
+func (r *Ring) buildIndex(ctx context.Context) error {
+	r.index = make([]uintptr, 0, r.records())
+	for off := *r.head; off != *r.tail; off = r.nextEntry(off) {
+		r.index = append(r.index, off)
+		if len(r.index)%4096 == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+	}
+	r.indexed = true
+	return nil
+}
+
+// vim: foldmethod=marker
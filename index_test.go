@@ -0,0 +1,55 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestAt(t *testing.T) {
+	for _, options := range []Options{{CreateSize: 4096}, {CreateSize: 4096, Index: true}} {
+		r := openTestRing(t, options)
+		for i := 0; i < 300; i++ {
+			writeRecords(t, r, fmt.Sprintf("record %03d", i))
+		}
+		readRecord(t, r)
+
+		first := 300 - r.Records()
+		for i := 0; i < r.Records(); i++ {
+			data, err := r.At(i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := fmt.Sprintf("record %03d", first+i); string(data) != want {
+				t.Fatalf("expected %q at %d, got %q", want, i, data)
+			}
+		}
+		if _, err := r.At(r.Records()); err != ErrOutOfRange {
+			t.Fatalf("expected ErrOutOfRange, got %v", err)
+		}
+		if _, err := r.At(-1); err != ErrOutOfRange {
+			t.Fatalf("expected ErrOutOfRange, got %v", err)
+		}
+	}
+}
+
+// vim: foldmethod=marker
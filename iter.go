@@ -0,0 +1,197 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"errors"
+)
+
+// ErrOverwritten is returned by an Iterator when the next record it was
+// going to return has been consumed or overwritten since it was created.
+var ErrOverwritten = errors.New("diskring: record was overwritten during iteration")
+
+// Iterator walks over the records in a Ring without consuming them. The
+// Ring is only locked for as long as it takes to copy out each record, so
+// writes can carry on while iterating. Batches of coalesced records (see
+// Options.Coalesce) are unpacked, and each record in a batch is returned on
+// its own, with the batch's sequence number.
+//
+//	it := ring.IterReverse()
+//	for it.Next() {
+//		fmt.Printf("%d: %s\n", it.Sequence(), it.Bytes())
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type Iterator struct {
+	r *Ring
+
+	// off and seq are the offset and sequence number of the next record,
+	// and remaining is how many records are left to return.
+	off       uintptr
+	seq       uint64
+	remaining uint64
+
+	// forward is set if the Iterator is working from the oldest record to
+	// the newest, rather than the other way around, and generation is the
+	// Ring's generation when it was created, which changes if the records
+	// are moved around (such as by Compact).
+	forward    bool
+	generation uint64
+
+	// offsets is every record's offset, oldest first, for walking a Ring
+	// backwards without trailing lengths to follow.
+	offsets []uintptr
+
+	// batch is what's left of the batch of coalesced records the last
+	// record came out of, in the order they're to be returned.
+	batch [][]byte
+
+	data    []byte
+	dataSeq uint64
+	err     error
+}
+
+// IterReverse will return an Iterator over the records in the Ring, starting
+// with the newest record and working back to the oldest. This is usually
+// what you want for looking at the last few things that happened.
+//
+// This is cheapest with the TrailingLength option, which lets the Iterator
+// step backwards from one record to the one before it. Without it, the
+// offset of every record has to be found up front.
+func (r *Ring) IterReverse() *Iterator {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	it := &Iterator{r: r, generation: r.header.generation}
+	if r.empty() {
+		return it
+	}
+	it.off = r.lastEntry()
+	it.seq = r.header.tailSeq - 1
+	it.remaining = r.records()
+
+	if !r.layout.has(formatTrailer) {
+		it.offsets = make([]uintptr, 0, it.remaining)
+		for off := *r.head; off != *r.tail; off = r.nextEntry(off) {
+			it.offsets = append(it.offsets, off)
+		}
+	}
+	return it
+}
+
+// IterSnapshot will return an Iterator over exactly the records that are in
+// the Ring right now, oldest first. Records written after this returns
+// aren't included, and writes carry on while iterating, so if the writer
+// catches up and overwrites a record before the Iterator gets to it (or
+// the records are consumed, or moved around by Compact), the Iterator
+// stops, and Err returns ErrOverwritten.
+func (r *Ring) IterSnapshot() *Iterator {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return &Iterator{
+		r:          r,
+		off:        *r.head,
+		seq:        r.header.headSeq,
+		remaining:  r.records(),
+		forward:    true,
+		generation: r.header.generation,
+	}
+}
+
+// Next will move the Iterator to the next record, returning false when there
+// are no more records, or if the next record has been overwritten (in which
+// case Err will return ErrOverwritten).
+func (it *Iterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	if len(it.batch) > 0 {
+		it.data, it.batch = it.batch[0], it.batch[1:]
+		return true
+	}
+	if it.remaining == 0 {
+		return false
+	}
+
+	r := it.r
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if it.seq < r.header.headSeq || it.generation != r.header.generation {
+		it.err = ErrOverwritten
+		return false
+	}
+
+	off := it.off
+	if it.offsets != nil {
+		off = it.offsets[it.remaining-1]
+	}
+	records, _, err := r.entryRecords(off, it.seq)
+	if err != nil {
+		it.err = err
+		return false
+	}
+	it.batch = it.batch[:0]
+	for _, rec := range records {
+		it.batch = append(it.batch, rec.Data)
+	}
+	if !it.forward {
+		for i, j := 0, len(it.batch)-1; i < j; i, j = i+1, j-1 {
+			it.batch[i], it.batch[j] = it.batch[j], it.batch[i]
+		}
+	}
+	it.data, it.batch = it.batch[0], it.batch[1:]
+	it.dataSeq = it.seq
+	it.remaining--
+
+	switch {
+	case it.remaining == 0:
+	case it.forward:
+		it.off = r.nextEntry(off)
+		it.seq++
+	default:
+		if it.offsets == nil {
+			it.off = r.prevEntry(off)
+		}
+		it.seq--
+	}
+	return true
+}
+
+// Bytes will return the data of the current record. This is only valid
+// until the next call to Next.
+func (it *Iterator) Bytes() []byte {
+	return it.data
+}
+
+// Sequence will return the sequence number of the current record.
+func (it *Iterator) Sequence() uint64 {
+	return it.dataSeq
+}
+
+// Err will return the error that stopped the Iterator, if any.
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// vim: foldmethod=marker
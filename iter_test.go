@@ -0,0 +1,108 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// iterate will return the records and sequence numbers the Iterator walks
+// over.
+func iterate(t *testing.T, it *Iterator) ([]string, []uint64) {
+	t.Helper()
+	var (
+		records   []string
+		sequences []uint64
+	)
+	for it.Next() {
+		records = append(records, string(it.Bytes()))
+		sequences = append(sequences, it.Sequence())
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return records, sequences
+}
+
+func TestIterReverse(t *testing.T) {
+	for _, options := range []Options{{}, {TrailingLength: true}} {
+		r := openTestRing(t, options)
+		if records, _ := iterate(t, r.IterReverse()); len(records) != 0 {
+			t.Fatalf("expected nothing from an empty ring, got %v", records)
+		}
+
+		writeRecords(t, r, "one", "two", "three")
+		records, sequences := iterate(t, r.IterReverse())
+		if !reflect.DeepEqual(records, []string{"three", "two", "one"}) || !reflect.DeepEqual(sequences, []uint64{2, 1, 0}) {
+			t.Fatalf("expected the records newest first, got %v at %v", records, sequences)
+		}
+		if r.Records() != 3 {
+			t.Fatal("iterating consumed records")
+		}
+	}
+}
+
+func TestIterReverseWrapped(t *testing.T) {
+	r := openTestRing(t, Options{CreateSize: 4096, TrailingLength: true})
+	for i := 0; i < 500; i++ {
+		writeRecords(t, r, fmt.Sprintf("record %03d", i))
+	}
+	records, _ := iterate(t, r.IterReverse())
+	if len(records) != r.Records() || records[0] != "record 499" {
+		t.Fatalf("expected %d records from record 499 back, got %d from %q", r.Records(), len(records), records[0])
+	}
+	for i, record := range records {
+		if want := fmt.Sprintf("record %03d", 499-i); record != want {
+			t.Fatalf("expected %q, got %q", want, record)
+		}
+	}
+}
+
+func TestIterSnapshot(t *testing.T) {
+	r := openTestRing(t, Options{CreateSize: 4096})
+	writeRecords(t, r, "one", "two", "three")
+
+	// Records written after the snapshot aren't included.
+	it := r.IterSnapshot()
+	writeRecords(t, r, "four")
+	records, sequences := iterate(t, it)
+	if !reflect.DeepEqual(records, []string{"one", "two", "three"}) || !reflect.DeepEqual(sequences, []uint64{0, 1, 2}) {
+		t.Fatalf("expected the records at the snapshot, got %v at %v", records, sequences)
+	}
+
+	// If the writer overwrites records the Iterator hasn't got to, it
+	// stops there.
+	it = r.IterSnapshot()
+	if !it.Next() {
+		t.Fatal(it.Err())
+	}
+	overwriteRecords(t, r, r.Overwritten()+2)
+	if it.Next() {
+		t.Fatalf("expected the Iterator to stop, got %q", it.Bytes())
+	}
+	if it.Err() != ErrOverwritten {
+		t.Fatalf("expected ErrOverwritten, got %v", it.Err())
+	}
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,170 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"context"
+	"errors"
+)
+
+// maxKeySize is the largest key that can be stored alongside a record.
+const maxKeySize = 1<<16 - 1
+
+var (
+	// ErrNoKeys is returned when writing a record with a key to a Ring
+	// that doesn't store them, or looking up a key in one (see
+	// Options.Keys).
+	ErrNoKeys = errors.New("diskring: keys aren't stored in this ring")
+
+	// ErrKeyTooLarge is returned when writing a record with a key that's
+	// larger than 65535 bytes.
+	ErrKeyTooLarge = errors.New("diskring: key is too large")
+
+	// ErrKeyNotFound is returned by Lookup when there's no record with
+	// the key in the Ring.
+	ErrKeyNotFound = errors.New("diskring: no record with that key")
+)
+
+// keyEntry is where the newest entry for a key is.
+type keyEntry struct {
+	seq uint64
+	off uintptr
+}
+
+// WriteKey will write a block of data into the disk ring, just like Write,
+// with the key stored alongside it. The newest record written with each key
+// can be found with Lookup, for as long as it's in the Ring.
+//
+// This requires the Keys option. The same size limits as Write apply to the
+// key and the data together.
+func (r *Ring) WriteKey(key, buf []byte) (int, error) {
+	if err := r.checkKey(key); err != nil {
+		return 0, err
+	}
+	if err := r.validate(buf); err != nil {
+		return 0, err
+	}
+
+	r.writeMutex.Lock()
+	defer r.writeMutex.Unlock()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	e := r.newEnvelope()
+	e.key = key
+	return r.write(e, buf)
+}
+
+// Lookup will return a copy of the data of the newest record written with
+// the provided key, without consuming anything. If there's no such record
+// in the Ring (it was never written, or it's been consumed or overwritten
+// since), this returns an ErrKeyNotFound.
+//
+// This requires the Keys option.
+func (r *Ring) Lookup(key []byte) ([]byte, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.keys == nil {
+		return nil, ErrNoKeys
+	}
+	entry, ok := r.keys[string(key)]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	if entry.seq < r.header.headSeq {
+		delete(r.keys, string(key))
+		return nil, ErrKeyNotFound
+	}
+	data, err := r.recordData(entry.off)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{}, data...), nil
+}
+
+// checkKey will make sure that the key can be stored alongside a record.
+func (r *Ring) checkKey(key []byte) error {
+	if len(key) == 0 {
+		return nil
+	}
+	if !r.layout.has(formatKey) {
+		return ErrNoKeys
+	}
+	if len(key) > maxKeySize {
+		return ErrKeyTooLarge
+	}
+	return nil
+}
+
+// UNSAFE
+//
+// Note the entry at the provided offset, with the sequence number seq, as
+// the newest entry for its key, if it has one.
+func (r *Ring) noteKey(off uintptr, seq uint64) {
+	if r.keys == nil {
+		return
+	}
+	if key := r.entryKey(off); len(key) > 0 {
+		r.keys[string(key)] = keyEntry{seq: seq, off: off}
+	}
+}
+
+// UNSAFE
+//
+// Forget about the entry at the provided offset, with the sequence number
+// seq, which is about to be consumed or overwritten, if it's the newest
+// entry for its key.
+func (r *Ring) dropKey(off uintptr, seq uint64) {
+	if r.keys == nil {
+		return
+	}
+	key := r.entryKey(off)
+	if len(key) == 0 {
+		return
+	}
+	if entry, ok := r.keys[string(key)]; ok && entry.seq == seq {
+		delete(r.keys, string(key))
+	}
+}
+
+// UNSAFE
+//
+// Walk the ring to build the index of the newest entry for each key. On a
+// very large ring this can take a while, so it'll give up if the context
+// is done.
+func (r *Ring) buildKeys(ctx context.Context) error {
+	r.keys = map[string]keyEntry{}
+	seq := r.header.headSeq
+	for off := *r.head; off != *r.tail; off = r.nextEntry(off) {
+		r.noteKey(off, seq)
+		seq++
+		if seq%4096 == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// vim: foldmethod=marker
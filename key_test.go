@@ -0,0 +1,84 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"testing"
+)
+
+// lookup will Lookup the key in the Ring as a string.
+func lookup(t *testing.T, r *Ring, key string) (string, error) {
+	t.Helper()
+	data, err := r.Lookup([]byte(key))
+	return string(data), err
+}
+
+func TestKeys(t *testing.T) {
+	path := testRingPath(t)
+	options := Options{ReserveHeader: true, Keys: true, NonBlockingReads: true}
+	r := openTestRingAt(t, path, options)
+	for _, kv := range [][2]string{
+		{"temperature", "20C"},
+		{"humidity", "40%"},
+		{"temperature", "21C"},
+		{"", "no key"},
+	} {
+		if _, err := r.WriteKey([]byte(kv[0]), []byte(kv[1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if value, err := lookup(t, r, "temperature"); err != nil || value != "21C" {
+		t.Fatalf("expected the newest temperature, got %q (%v)", value, err)
+	}
+	if _, err := lookup(t, r, "pressure"); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+	if _, err := r.WriteKey(make([]byte, maxKeySize+1), nil); err != ErrKeyTooLarge {
+		t.Fatalf("expected ErrKeyTooLarge, got %v", err)
+	}
+
+	// Once the record for a key is consumed, it can't be looked up.
+	readRecord(t, r)
+	readRecord(t, r)
+	if _, err := lookup(t, r, "humidity"); err != ErrKeyNotFound {
+		t.Fatalf("expected the consumed humidity to be gone, got %v", err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The keys are found again when the Ring is opened.
+	r = openTestRingAt(t, path, options)
+	defer r.Close()
+	if value, err := lookup(t, r, "temperature"); err != nil || value != "21C" {
+		t.Fatalf("expected the temperature after reopening, got %q (%v)", value, err)
+	}
+
+	plain := openTestRing(t, Options{})
+	if _, err := plain.WriteKey([]byte("key"), nil); err != ErrNoKeys {
+		t.Fatalf("expected ErrNoKeys, got %v", err)
+	}
+	if _, err := plain.Lookup([]byte("key")); err != ErrNoKeys {
+		t.Fatalf("expected ErrNoKeys, got %v", err)
+	}
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,110 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNoConsumer is returned by Lag for a Consumer that isn't open, and has
+// never committed a position.
+var ErrNoConsumer = errors.New("diskring: no such consumer")
+
+// Lag will return how far behind the named Consumer is: the number of
+// records it has yet to read, the number of bytes of the Ring they take up
+// (including the space used to store their lengths and envelopes), and how
+// long ago the oldest of them was written. oldest is 0 if the Consumer has
+// caught up, or the Ring doesn't have Timestamps turned on.
+//
+// If the Consumer is open, its position is used as it stands, otherwise its
+// last committed position is used. With an empty name, this is how far
+// behind the Ring's own reader (at the head) is. This is cheap enough to be
+// polled by autoscaling or alerting, but unlike Stats, it takes the Ring's
+// lock.
+func (r *Ring) Lag(consumer string) (records int, bytes int, oldest time.Duration, err error) {
+	var (
+		state  CursorState
+		stored bool
+	)
+	if consumer != "" {
+		sidecar, err := r.sidecar(consumer)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		// Reading the sidecar file has to happen without the mutex.
+		if state, stored, err = sidecar.load(); err != nil {
+			return 0, 0, 0, err
+		}
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	seq, off := r.header.headSeq, *r.head
+	switch c := r.consumers[consumer]; {
+	case consumer == "":
+	case c != nil:
+		if c.generation != r.header.generation {
+			return 0, 0, 0, ErrStaleCursor
+		}
+		if c.seq > seq {
+			seq, off = c.seq, c.off
+		}
+	default:
+		if slot := r.slot(consumer); slot != nil {
+			state = CursorState{Sequence: slot.seq, Offset: slot.off, Generation: r.header.generation}
+			stored = true
+		}
+		if !stored {
+			return 0, 0, 0, ErrNoConsumer
+		}
+		if state.Generation != r.header.generation {
+			return 0, 0, 0, ErrStaleCursor
+		}
+		if state.Sequence > seq {
+			if off, err = r.findSequence(state.Sequence); err != nil {
+				return 0, 0, 0, err
+			}
+			seq = state.Sequence
+		}
+	}
+
+	records = int(r.header.tailSeq - seq)
+	bytes = int((*r.tail + r.size - off) % r.size)
+	if records > 0 && r.layout.has(formatTimestamp) {
+		oldest = time.Since(time.Unix(0, r.entryTime(off)))
+	}
+	return records, bytes, oldest, nil
+}
+
+// UNSAFE
+//
+// Return the named Consumer's slot in the header, or nil if it doesn't have
+// one.
+func (r *Ring) slot(consumer string) *consumerSlot {
+	if !r.libraryHeader {
+		return nil
+	}
+	return r.header.consumer(consumerHash(consumer))
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,89 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLag(t *testing.T) {
+	path := testRingPath(t)
+	options := Options{ReserveHeader: true, Timestamps: true}
+	r := openTestRingAt(t, path, options)
+	writeRecords(t, r, "one", "two", "three")
+	time.Sleep(10 * time.Millisecond)
+
+	records, bytes, oldest, err := r.Lag("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records != 3 || bytes != r.Len() || oldest < 10*time.Millisecond {
+		t.Fatalf("expected the reader to be 3 records behind, got %d records in %d bytes, %s old", records, bytes, oldest)
+	}
+	if _, _, _, err := r.Lag("missing"); err != ErrNoConsumer {
+		t.Fatalf("expected ErrNoConsumer, got %v", err)
+	}
+
+	c, err := r.Cursor("audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Read(make([]byte, 16)); err != nil {
+		t.Fatal(err)
+	}
+	open, openBytes, _, err := r.Lag("audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if open != 2 || openBytes >= bytes {
+		t.Fatalf("expected the consumer to be 2 records behind, got %d records in %d bytes", open, openBytes)
+	}
+	if err := c.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Once the Ring is opened again, the committed position is used.
+	r = openTestRingAt(t, path, options)
+	defer r.Close()
+	records, bytes, _, err = r.Lag("audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records != open || bytes != openBytes {
+		t.Fatalf("expected %d records in %d bytes, got %d records in %d bytes", open, openBytes, records, bytes)
+	}
+
+	// A Consumer that's caught up has no lag.
+	c, err = r.Cursor("audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	readAll(t, c)
+	if records, bytes, oldest, err = r.Lag("audit"); err != nil || records != 0 || bytes != 0 || oldest != 0 {
+		t.Fatalf("expected no lag, got %d records in %d bytes, %s old (%v)", records, bytes, oldest, err)
+	}
+}
+
+// vim: foldmethod=marker
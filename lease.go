@@ -0,0 +1,267 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// The write lease is kept in a single word of the header, so that it can be
+// taken and renewed with a compare-and-swap, which works between processes
+// just as well as between goroutines, since they all share the header's
+// mapping. The top 16 bits are the ID of the owner, which is handed out from
+// a counter in the header each time a Ring is opened, and the rest is when
+// the lease runs out, in milliseconds since the epoch. A lease of 0 isn't held
+// by anyone.
+//
+// The counter wraps around after 65535 Rings, so an ID alone doesn't prove
+// the lease is ours. Each Ring also remembers the exact word it last stored,
+// and only holds the lease while that's still what's in the header. Nobody
+// else can store the same word without the same ID and the same expiry,
+// down to the millisecond, which would take both a wrap of the counter and
+// an unlucky clock.
+
+const (
+	// leaseOwnerShift is how far up the word the owner of the lease is.
+	leaseOwnerShift = 48
+
+	// leaseExpiryMask is the part of the word that says when the lease
+	// runs out.
+	leaseExpiryMask = 1<<leaseOwnerShift - 1
+)
+
+var (
+	// ErrNoLease is returned when writing to a Ring with the WriteLease
+	// option without holding the lease, either because AcquireWrite was
+	// never called, or because the lease ran out before it was renewed
+	// and was taken by someone else.
+	ErrNoLease = errors.New("diskring: write lease isn't held")
+)
+
+// packLease will put together the word stored in the header for a lease
+// held by owner until expiry.
+func packLease(owner uint16, expiry time.Time) uint64 {
+	ms := uint64(expiry.UnixNano() / int64(time.Millisecond))
+	return uint64(owner)<<leaseOwnerShift | ms&leaseExpiryMask
+}
+
+// unpackLease will split the word stored in the header into the owner of
+// the lease, and when it runs out.
+func unpackLease(lease uint64) (uint16, time.Time) {
+	ms := int64(lease & leaseExpiryMask)
+	return uint16(lease >> leaseOwnerShift), time.Unix(0, ms*int64(time.Millisecond))
+}
+
+// UNSAFE
+//
+// Hand out the next ID to hold the lease under, which is never 0.
+func (h *header) newLeaseOwner() uint16 {
+	for {
+		if owner := uint16(atomic.AddUint32(&h.owners, 1)); owner != 0 {
+			return owner
+		}
+	}
+}
+
+// AcquireWrite will take the Ring's write lease (see Options.WriteLease),
+// waiting until whoever holds it now lets it go (by calling ReleaseWrite,
+// or closing their Ring), or lets it run out, or until the context is done.
+// Once taken, the lease is renewed in the background until ReleaseWrite or
+// Close is called.
+//
+// If the lease was last held by some other Ring, which may have been in
+// another process, anything this Ring keeps in memory about the records
+// (the Index, the keys and the sparse index) is built again, since it may
+// have been written to in the meantime.
+func (r *Ring) AcquireWrite(ctx context.Context) error {
+	if r.leaseTerm == 0 {
+		return errors.New("diskring: ring doesn't have a write lease")
+	}
+	for {
+		r.writeMutex.Lock()
+		r.mutex.Lock()
+		wait, err := r.takeLease()
+		r.mutex.Unlock()
+		r.writeMutex.Unlock()
+		if err != nil || wait == 0 {
+			return err
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// UNSAFE
+//
+// Try to take the lease, returning how long to wait before trying again if
+// someone else holds it, or 0 if it was taken. The caller must hold both the
+// writeMutex and the mutex.
+func (r *Ring) takeLease() (time.Duration, error) {
+	now := time.Now()
+	lease := atomic.LoadUint64(&r.header.lease)
+	_, expiry := unpackLease(lease)
+	if lease != 0 && lease != r.lease && now.Before(expiry) {
+		// Check back every so often, since they may let it go early.
+		wait := expiry.Sub(now) + time.Millisecond
+		if wait > r.leaseTerm/4 {
+			wait = r.leaseTerm / 4
+		}
+		return wait, nil
+	}
+	mine := packLease(r.leaseOwner, now.Add(r.leaseTerm))
+	if !atomic.CompareAndSwapUint64(&r.header.lease, lease, mine) {
+		// Someone else got in first, so go around again.
+		return time.Millisecond, nil
+	}
+	r.headerWritten()
+
+	held := lease != 0 && lease == r.lease
+	r.lease = mine
+	if !held {
+		if err := r.resync(); err != nil {
+			atomic.CompareAndSwapUint64(&r.header.lease, mine, 0)
+			r.lease = 0
+			return 0, err
+		}
+	}
+	if r.leaseRenewer == nil {
+		r.leaseRenewer = newPeriodic(r.leaseTerm/3, r.renewLease)
+	}
+	return 0, nil
+}
+
+// UNSAFE
+//
+// Build up everything kept in memory about the records again, after someone
+// else may have written to the Ring.
+func (r *Ring) resync() error {
+	r.owned = nil
+	for _, p := range r.producers {
+		p.used, p.records = 0, 0
+	}
+	if r.layout.has(formatChain) {
+		r.loadChain()
+	}
+	if r.sparseEvery > 0 {
+		r.buildSparse()
+	}
+	if r.keys != nil {
+		if err := r.buildKeys(context.Background()); err != nil {
+			return err
+		}
+	}
+	if r.indexed {
+		return r.buildIndex(context.Background())
+	}
+	return nil
+}
+
+// renewLease will push back when the lease runs out, for the renewer, as
+// long as it's still held. If it's been lost, it's left for AcquireWrite
+// to take back.
+func (r *Ring) renewLease() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	lease := atomic.LoadUint64(&r.header.lease)
+	if !r.holdsLease(lease) {
+		return
+	}
+	renewed := packLease(r.leaseOwner, time.Now().Add(r.leaseTerm))
+	if atomic.CompareAndSwapUint64(&r.header.lease, lease, renewed) {
+		r.lease = renewed
+		r.headerWritten()
+	}
+}
+
+// ReleaseWrite will give up the Ring's write lease, writing out anything
+// waiting to be coalesced first, so that someone else can take it without
+// waiting for it to run out. This does nothing if the lease isn't held.
+func (r *Ring) ReleaseWrite() error {
+	if r.leaseTerm == 0 {
+		return nil
+	}
+	r.stopRenewingLease()
+
+	r.writeMutex.Lock()
+	defer r.writeMutex.Unlock()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.releaseLease()
+}
+
+// stopRenewingLease will stop the renewer, if it's running. The renewer
+// needs the mutex, so this can't be called with it held.
+func (r *Ring) stopRenewingLease() {
+	r.mutex.Lock()
+	renewer := r.leaseRenewer
+	r.leaseRenewer = nil
+	r.mutex.Unlock()
+	if renewer != nil {
+		renewer.Close()
+	}
+}
+
+// UNSAFE
+//
+// Write out any batch, and give up the lease if it's held.
+func (r *Ring) releaseLease() error {
+	lease := atomic.LoadUint64(&r.header.lease)
+	if !r.holdsLease(lease) {
+		return nil
+	}
+	err := r.flushBatch()
+	if atomic.CompareAndSwapUint64(&r.header.lease, lease, 0) {
+		r.lease = 0
+		r.headerWritten()
+	}
+	return err
+}
+
+// UNSAFE
+//
+// Check if the lease word says this Ring holds the lease right now.
+func (r *Ring) holdsLease(lease uint64) bool {
+	_, expiry := unpackLease(lease)
+	return lease != 0 && lease == r.lease && time.Now().Before(expiry)
+}
+
+// UNSAFE
+//
+// Check that this Ring can write, if it needs the lease to.
+func (r *Ring) checkLease() error {
+	if r.leaseTerm == 0 || r.holdsLease(atomic.LoadUint64(&r.header.lease)) {
+		return nil
+	}
+	return ErrNoLease
+}
+
+// vim: foldmethod=marker
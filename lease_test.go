@@ -0,0 +1,190 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+//go:build !diskring_portable && !wasip1
+// +build !diskring_portable,!wasip1
+
+package diskring
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// openLeased will open a second Ring on the same file as r, with a write
+// lease.
+func openLeased(t *testing.T, r *Ring) *Ring {
+	t.Helper()
+	other, err := OpenWithOptions(r.file.Name(), Options{
+		ReserveHeader: true,
+		WriteLease:    time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { other.Close() })
+	return other
+}
+
+func TestLeaseOwnersDiffer(t *testing.T) {
+	r := openTestRing(t, Options{ReserveHeader: true, WriteLease: time.Second})
+	seen := map[uint16]bool{r.leaseOwner: true}
+	for i := 0; i < 8; i++ {
+		other := openLeased(t, r)
+		if seen[other.leaseOwner] {
+			t.Fatalf("lease owner %d handed out twice", other.leaseOwner)
+		}
+		seen[other.leaseOwner] = true
+	}
+}
+
+func TestLeaseSameOwner(t *testing.T) {
+	r := openTestRing(t, Options{ReserveHeader: true, WriteLease: time.Second})
+	other := openLeased(t, r)
+	// Act as if the counter wrapped around onto r's ID.
+	other.leaseOwner = r.leaseOwner
+
+	if err := r.AcquireWrite(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Write([]byte("hello")); err != ErrNoLease {
+		t.Fatalf("wrote without the lease: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := other.AcquireWrite(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("took a lease that was already held: %v", err)
+	}
+	if _, err := r.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.ReleaseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.AcquireWrite(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Write([]byte("hello")); err != ErrNoLease {
+		t.Fatalf("wrote after giving up the lease: %v", err)
+	}
+}
+
+func TestLeaseLostWhileCoalescing(t *testing.T) {
+	delay := 10 * time.Millisecond
+	r := openTestRing(t, Options{
+		ReserveHeader:    true,
+		WriteLease:       time.Second,
+		Coalesce:         64,
+		CoalesceDelay:    delay,
+		NonBlockingReads: true,
+	})
+	if err := r.AcquireWrite(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Someone else holds the lease for long enough that the flusher
+	// can't write the batch out.
+	atomic.StoreUint64(&r.header.lease, packLease(r.leaseOwner+1, time.Now().Add(5*delay)))
+	time.Sleep(3 * delay)
+
+	if err := r.AcquireWrite(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	n, err := r.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "hello" {
+		t.Fatalf("expected hello, got %q", buf[:n])
+	}
+}
+
+func TestLeaseResync(t *testing.T) {
+	r := openTestRing(t, Options{
+		ReserveHeader: true,
+		WriteLease:    time.Second,
+		Keys:          true,
+		SparseIndex:   2,
+	})
+	other, err := OpenWithOptions(r.file.Name(), Options{
+		ReserveHeader: true,
+		WriteLease:    time.Second,
+		Keys:          true,
+		SparseIndex:   2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	// Take turns writing a newer value for the same key.
+	for i, ring := range []*Ring{r, other, r, other} {
+		if err := ring.AcquireWrite(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ring.WriteKey([]byte("key"), []byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+		if err := ring.ReleaseWrite(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := r.AcquireWrite(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	value, err := r.Lookup([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(value) != 1 || value[0] != 3 {
+		t.Fatalf("expected the newest value, got %v", value)
+	}
+	if len(r.sparse) != 2 || r.sparse[1].seq != 2 {
+		t.Fatalf("sparse index wasn't built again: %v", r.sparse)
+	}
+}
+
+func TestCloneLease(t *testing.T) {
+	options := Options{ReserveHeader: true, WriteLease: time.Hour}
+	r := openTestRing(t, options)
+	if err := r.AcquireWrite(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	clone := cloneTestRing(t, r, options)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := clone.AcquireWrite(ctx); err != nil {
+		t.Fatalf("the clone's lease was held: %v", err)
+	}
+}
+
+// vim: foldmethod=marker
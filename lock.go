@@ -0,0 +1,80 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+//go:build !diskring_portable && !wasip1
+// +build !diskring_portable,!wasip1
+
+package diskring
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"time"
+)
+
+// canLock is set if files can be locked (see Options.Lock).
+const canLock = true
+
+// lockPollInterval is how often we'll try to take a lock that's held by
+// someone else.
+const lockPollInterval = 10 * time.Millisecond
+
+// lockFile will take an advisory lock on the file (shared, unless exclusive
+// is set), waiting until it can be taken or the context is done.
+//
+// flock(2) can't be interrupted by a context, so rather than blocking in
+// the syscall we'll keep trying with LOCK_NB until we get it.
+func lockFile(ctx context.Context, fd *os.File, exclusive bool) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	for {
+		err := syscall.Flock(int(fd.Fd()), how|syscall.LOCK_NB)
+		if err == nil {
+			return nil
+		}
+		if err != syscall.EWOULDBLOCK {
+			return err
+		}
+
+		timer := time.NewTimer(lockPollInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// unlockFiles will release the advisory locks on the files.
+func unlockFiles(files []*os.File) error {
+	var err error
+	for _, fd := range files {
+		if unlockErr := syscall.Flock(int(fd.Fd()), syscall.LOCK_UN); unlockErr != nil && err == nil {
+			err = unlockErr
+		}
+	}
+	return err
+}
+
+// vim: foldmethod=marker
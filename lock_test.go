@@ -0,0 +1,124 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+//go:build !diskring_portable && !wasip1
+// +build !diskring_portable,!wasip1
+
+package diskring
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// openLocked will try to open the Ring at path with Lock set, giving up
+// (and returning nil) if someone else is holding on to the lock.
+func openLocked(t *testing.T, path string) *Ring {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	r, err := OpenContext(ctx, path, Options{Lock: true})
+	if err == context.DeadlineExceeded {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// dupRing will open a second Ring over a copy of the first Ring's file
+// descriptor, just as a child process handed the file would.
+func dupRing(t *testing.T, r *Ring, options Options) *Ring {
+	t.Helper()
+	fd, err := syscall.Dup(int(r.file.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	options.Lock = true
+	dup, err := NewWithOptions(os.NewFile(uintptr(fd), r.file.Name()), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dup
+}
+
+func TestOpenContextLocked(t *testing.T) {
+	r := openTestRing(t, Options{Lock: true})
+
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	other, err := OpenContext(ctx, r.file.Name(), Options{Lock: true})
+	if err != context.DeadlineExceeded {
+		if other != nil {
+			other.Close()
+		}
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Fatalf("waited %s for the lock after the context was done", waited)
+	}
+}
+
+func TestLockSharedWithDup(t *testing.T) {
+	r := openTestRing(t, Options{Lock: true})
+	path := r.file.Name()
+
+	dup := dupRing(t, r, Options{})
+	if err := dup.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if other := openLocked(t, path); other != nil {
+		other.Close()
+		t.Fatal("closing the copy let go of the lock")
+	}
+
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	other := openLocked(t, path)
+	if other == nil {
+		t.Fatal("the lock was held after every copy was closed")
+	}
+	other.Close()
+}
+
+func TestLockDontCloseFile(t *testing.T) {
+	r := openTestRing(t, Options{Lock: true})
+	path := r.file.Name()
+
+	dup := dupRing(t, r, Options{DontCloseFile: true})
+	if err := dup.Close(); err != nil {
+		t.Fatal(err)
+	}
+	defer dup.file.Close()
+
+	other := openLocked(t, path)
+	if other == nil {
+		t.Fatal("closing a Ring with DontCloseFile didn't let go of the lock")
+	}
+	other.Close()
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,173 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// ScanFramed is a bufio.SplitFunc for logs of records that are each framed
+// by their length as a uvarint, just like the records in a batch of
+// coalesced ones (see Options.Coalesce), for records that can't be told
+// apart by a delimiter. It can be used with ImportLog.
+func ScanFramed(data []byte, atEOF bool) (int, []byte, error) {
+	if len(data) == 0 {
+		return 0, nil, nil
+	}
+	length, n := binary.Uvarint(data)
+	switch {
+	case n < 0:
+		return 0, nil, fmt.Errorf("diskring: record length doesn't fit in 64 bits")
+	case n == 0 || uint64(len(data)-n) < length:
+		if atEOF {
+			return 0, nil, io.ErrUnexpectedEOF
+		}
+		return 0, nil, nil
+	}
+	end := n + int(length)
+	return end, data[n:end], nil
+}
+
+// ImportLog will read a flat log of records (such as a file a service used
+// to append to) from rd, and write each of them into the Ring with Write,
+// in the order they were in, returning the number of records written. The
+// log is split into records with split, which is bufio.ScanLines if it's
+// nil, so each line of the log (without its line ending) is a record. For
+// logs of records framed by their length, use ScanFramed.
+//
+// A record that's too large to write (see Write) stops the import, as
+// does any other error, leaving the records before it in the Ring.
+func (r *Ring) ImportLog(rd io.Reader, split bufio.SplitFunc) (int, error) {
+	if split == nil {
+		split = bufio.ScanLines
+	}
+
+	// Anything larger than a record can be isn't going to be written
+	// anyway, unless it's going to be spilled, so there's no need to
+	// buffer more than one byte past that to find out.
+	limit := int(r.maxRecord) + 1
+	if r.spillDir != "" {
+		limit = math.MaxInt32
+	}
+
+	scanner := bufio.NewScanner(rd)
+	scanner.Split(split)
+	scanner.Buffer(nil, limit)
+	imported := 0
+	for scanner.Scan() {
+		if _, err := r.Write(scanner.Bytes()); err != nil {
+			return imported, err
+		}
+		imported++
+	}
+	if err := scanner.Err(); err != nil {
+		return imported, fmt.Errorf("diskring: record %d: %w", imported, err)
+	}
+	return imported, nil
+}
+
+// exportLogBatch is the most entries ExportLog copies out of the Ring at a
+// time.
+const exportLogBatch = 1024
+
+// ExportLog will write every record in the Ring out to w as a flat log,
+// oldest first, without consuming anything, returning the number of records
+// written out. Each record is followed by delim, so a delim of "\n" writes
+// a file of lines. If delim is nil, each record is framed by its length as
+// a uvarint instead (see ScanFramed), so that records can have anything at
+// all in them. Either way, ImportLog reads the log back in, but records
+// that have the delimiter in them won't come back the same.
+//
+// Only the data of each record is written out, and records coalesced into
+// batches (see Options.Coalesce) are written out one by one. To keep
+// everything stored alongside the records, use ExportNDJSON. This walks
+// the Ring just like Export.
+func (r *Ring) ExportLog(w io.Writer, delim []byte) (int, error) {
+	bw := bufio.NewWriter(w)
+
+	r.mutex.Lock()
+	generation := r.header.generation
+	seq, off, end := r.header.headSeq, *r.head, r.header.tailSeq
+	r.mutex.Unlock()
+
+	exported := 0
+	for seq < end {
+		var records [][]byte
+
+		r.mutex.Lock()
+		if r.header.generation != generation {
+			r.mutex.Unlock()
+			return exported, ErrStaleCursor
+		}
+		if seq < r.header.headSeq {
+			seq, off = r.header.headSeq, *r.head
+		}
+		for n := 0; seq < end && n < exportLogBatch; n++ {
+			data, err := r.recordData(off)
+			if err == nil && r.entryFlags(off)&flagBatch != 0 {
+				var batch [][]byte
+				batch, err = unbatchAll(data)
+				for _, rec := range batch {
+					records = append(records, append([]byte{}, rec...))
+				}
+			} else if err == nil {
+				records = append(records, append([]byte{}, data...))
+			}
+			if err != nil {
+				r.mutex.Unlock()
+				return exported, err
+			}
+			seq++
+			off = r.nextEntry(off)
+		}
+		r.mutex.Unlock()
+
+		for _, rec := range records {
+			if err := writeLogRecord(bw, rec, delim); err != nil {
+				return exported, err
+			}
+			exported++
+		}
+	}
+	return exported, bw.Flush()
+}
+
+// writeLogRecord will write a record out to a flat log, followed by delim,
+// or framed by its length if delim is nil.
+func writeLogRecord(w *bufio.Writer, rec, delim []byte) error {
+	if delim == nil {
+		var length [binary.MaxVarintLen64]byte
+		if _, err := w.Write(length[:binary.PutUvarint(length[:], uint64(len(rec)))]); err != nil {
+			return err
+		}
+	}
+	if _, err := w.Write(rec); err != nil {
+		return err
+	}
+	_, err := w.Write(delim)
+	return err
+}
+
+// vim: foldmethod=marker
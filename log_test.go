@@ -0,0 +1,121 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestImportLog(t *testing.T) {
+	r := openTestRing(t, Options{NonBlockingReads: true})
+	n, err := r.ImportLog(strings.NewReader("one\ntwo\r\nthree"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Fatalf("expected 3 records, got %d", n)
+	}
+	for _, want := range []string{"one", "two", "three"} {
+		if record := readRecord(t, r); record != want {
+			t.Fatalf("expected %s, got %q", want, record)
+		}
+	}
+
+	// Framed records can have anything in them.
+	framed := []byte("\x09line\none\n\x00\x03two")
+	if n, err = r.ImportLog(bytes.NewReader(framed), ScanFramed); err != nil || n != 3 {
+		t.Fatalf("expected 3 framed records, got %d (%v)", n, err)
+	}
+	for _, want := range []string{"line\none\n", "", "two"} {
+		if record := readRecord(t, r); record != want {
+			t.Fatalf("expected %q, got %q", want, record)
+		}
+	}
+
+	// A log cut off partway through a record is imported up to it.
+	n, err = r.ImportLog(bytes.NewReader([]byte("\x03one\x05tw")), ScanFramed)
+	if n != 1 || !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected 1 record and io.ErrUnexpectedEOF, got %d (%v)", n, err)
+	}
+}
+
+func TestImportLogTooLarge(t *testing.T) {
+	r := openTestRing(t, Options{CreateSize: 4096})
+	log := "small\n" + strings.Repeat("x", 8192) + "\nafter\n"
+	n, err := r.ImportLog(strings.NewReader(log), nil)
+	if n != 1 || err == nil {
+		t.Fatalf("expected the import to stop at the large record, got %d (%v)", n, err)
+	}
+	if r.Records() != 1 {
+		t.Fatalf("expected the record before it to be left, got %d records", r.Records())
+	}
+}
+
+func TestExportLog(t *testing.T) {
+	for name, options := range map[string]Options{
+		"plain":    {},
+		"coalesce": {Coalesce: 64},
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := openTestRing(t, options)
+			records := []string{"one", "two\nlines", "", "three"}
+			writeRecords(t, r, records...)
+			if err := r.Flush(); err != nil {
+				t.Fatal(err)
+			}
+
+			var lines bytes.Buffer
+			if n, err := r.ExportLog(&lines, []byte("\n")); err != nil || n != 4 {
+				t.Fatalf("expected 4 records, got %d (%v)", n, err)
+			}
+			if want := "one\ntwo\nlines\n\nthree\n"; lines.String() != want {
+				t.Fatalf("expected %q, got %q", want, lines.String())
+			}
+
+			// Framed records come back just as they were.
+			var framed bytes.Buffer
+			if n, err := r.ExportLog(&framed, nil); err != nil || n != 4 {
+				t.Fatalf("expected 4 records, got %d (%v)", n, err)
+			}
+			imported := openTestRing(t, Options{NonBlockingReads: true})
+			if _, err := imported.ImportLog(&framed, ScanFramed); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for imported.Records() > 0 {
+				got = append(got, readRecord(t, imported))
+			}
+			if !reflect.DeepEqual(got, records) {
+				t.Fatalf("expected %q, got %q", records, got)
+			}
+			if r.Records() == 0 {
+				t.Fatal("expected ExportLog not to consume anything")
+			}
+		})
+	}
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,266 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultManagerExtension is what the name of each Ring's file ends with,
+// if the options don't say.
+const defaultManagerExtension = ".ring"
+
+// ErrManagerClosed is returned when using a Manager after it's been closed.
+var ErrManagerClosed = errors.New("diskring: manager is closed")
+
+// ManagerOptions controls how a Manager opens and looks after its Rings.
+type ManagerOptions struct {
+	// Options is what every Ring is opened with. CreateIfMissing is
+	// always set, so CreateSize has to be too. Options that name a file
+	// or a directory (such as NotifyFile or SpillDir) would be shared by
+	// every Ring, so they should be left unset.
+	Options Options
+
+	// Extension is what the name of each Ring's file ends with, which is
+	// how the Manager tells them apart from anything else (such as the
+	// files Consumers keep their positions in) in the directory.
+	//
+	// Default: ".ring"
+	Extension string
+
+	// IdleTimeout will close any Ring that nothing has used for this
+	// long, to free up its mapping and its file. It's opened again the
+	// next time it's used.
+	//
+	// Default: 0 (Rings are left open until the Manager is closed)
+	IdleTimeout time.Duration
+}
+
+// Manager looks after a directory of Rings, one file per name, for services
+// that keep a Ring per tenant or per topic. Rings are opened (or created)
+// the first time they're used, and closed again once they've been idle for
+// a while, if the options ask for it. Create one with NewManager.
+type Manager struct {
+	dir     string
+	options ManagerOptions
+
+	// reaper closes idle Rings, if the options ask for it.
+	reaper *periodic
+
+	mutex  sync.Mutex
+	rings  map[string]*managedRing
+	closed bool
+}
+
+// managedRing is a Ring the Manager has open.
+type managedRing struct {
+	ring *Ring
+
+	// users is the number of calls to Use that are running with the Ring,
+	// and used is when the last one finished.
+	users int
+	used  time.Time
+}
+
+// ManagerStats is a summary of the state of a Manager's Rings.
+type ManagerStats struct {
+	// Files is the number of Rings in the directory, whether they're open
+	// or not.
+	Files int
+
+	// Rings is the Stats of each open Ring, by name. Rings that are
+	// closed aren't opened just to look at them.
+	Rings map[string]Stats
+
+	// Total adds up the sizes, bytes used, records, wraps and overwrites
+	// of the open Rings, like Striped.Stats.
+	Total Stats
+}
+
+// NewManager will create a Manager for the Rings in the provided directory,
+// creating the directory if need be.
+func NewManager(dir string, options ManagerOptions) (*Manager, error) {
+	if options.Options.CreateSize <= 0 {
+		return nil, fmt.Errorf("diskring: manager needs a CreateSize")
+	}
+	if options.Extension == "" {
+		options.Extension = defaultManagerExtension
+	}
+	options.Options.CreateIfMissing = true
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	m := &Manager{
+		dir:     dir,
+		options: options,
+		rings:   map[string]*managedRing{},
+	}
+	if options.IdleTimeout > 0 {
+		m.reaper = newPeriodic(options.IdleTimeout/2, m.closeIdle)
+	}
+	return m, nil
+}
+
+// Names will return the name of every Ring in the directory, whether it's
+// open or not, sorted.
+func (m *Manager) Names() ([]string, error) {
+	files, err := ioutil.ReadDir(m.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasSuffix(name, m.options.Extension) {
+			continue
+		}
+		names = append(names, strings.TrimSuffix(name, m.options.Extension))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Use will call fn with the Ring with the provided name, opening it first if
+// it isn't already open, or creating it if it doesn't exist. The Ring won't
+// be closed for being idle while fn is running, but it may be any time
+// after, so fn mustn't hold on to it.
+func (m *Manager) Use(name string, fn func(*Ring) error) error {
+	mr, err := m.acquire(name)
+	if err != nil {
+		return err
+	}
+	defer m.release(mr)
+	return fn(mr.ring)
+}
+
+// acquire will open the Ring with the provided name if it isn't already
+// open, and note that it's in use.
+func (m *Manager) acquire(name string) (*managedRing, error) {
+	if name == "" || strings.ContainsRune(name, os.PathSeparator) || name != filepath.Clean(name) {
+		return nil, fmt.Errorf("diskring: invalid ring name %q", name)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.closed {
+		return nil, ErrManagerClosed
+	}
+	mr, ok := m.rings[name]
+	if !ok {
+		ring, err := OpenWithOptions(filepath.Join(m.dir, name+m.options.Extension), m.options.Options)
+		if err != nil {
+			return nil, err
+		}
+		mr = &managedRing{ring: ring}
+		m.rings[name] = mr
+	}
+	mr.users++
+	return mr, nil
+}
+
+// release will note that a call to Use is done with the Ring.
+func (m *Manager) release(mr *managedRing) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	mr.users--
+	mr.used = time.Now()
+}
+
+// closeIdle will close every Ring that nothing has used for the IdleTimeout,
+// for the reaper. If a Ring fails to close, there's nowhere to send the
+// error, so it's forgotten about all the same.
+func (m *Manager) closeIdle() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for name, mr := range m.rings {
+		if mr.users == 0 && time.Since(mr.used) >= m.options.IdleTimeout {
+			mr.ring.Close()
+			delete(m.rings, name)
+		}
+	}
+}
+
+// Stats will return a summary of the state of the Manager's Rings.
+func (m *Manager) Stats() (ManagerStats, error) {
+	names, err := m.Names()
+	if err != nil {
+		return ManagerStats{}, err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	stats := ManagerStats{
+		Files: len(names),
+		Rings: map[string]Stats{},
+	}
+	for name, mr := range m.rings {
+		ringStats, err := mr.ring.Stats()
+		if err != nil {
+			return ManagerStats{}, err
+		}
+		stats.Rings[name] = ringStats
+		stats.Total.add(ringStats)
+	}
+	return stats, nil
+}
+
+// Close will close every open Ring, returning the first error. Nothing can
+// be using any of them (see Use) while this is going on.
+func (m *Manager) Close() error {
+	m.mutex.Lock()
+	if m.closed {
+		m.mutex.Unlock()
+		return nil
+	}
+	m.closed = true
+	m.mutex.Unlock()
+
+	// The reaper needs the mutex, so it has to be stopped without it.
+	if m.reaper != nil {
+		m.reaper.Close()
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var err error
+	for name, mr := range m.rings {
+		if closeErr := mr.ring.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		delete(m.rings, name)
+	}
+	return err
+}
+
+// vim: foldmethod=marker
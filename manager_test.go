@@ -0,0 +1,106 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestManager(t *testing.T) {
+	dir := filepath.Dir(testRingPath(t))
+	if _, err := NewManager(dir, ManagerOptions{}); err == nil {
+		t.Fatal("expected a Manager without a CreateSize to be refused")
+	}
+	m, err := NewManager(dir, ManagerOptions{
+		Options:     Options{ReserveHeader: true, CreateSize: 1 << 16, NonBlockingReads: true},
+		IdleTimeout: 20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	write := func(name string, records ...string) {
+		t.Helper()
+		if err := m.Use(name, func(r *Ring) error {
+			writeRecords(t, r, records...)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("orders", "one")
+	write("payments", "two", "three")
+	if err := m.Use("../escape", func(*Ring) error { return nil }); err == nil {
+		t.Fatal("expected an invalid name to be refused")
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := m.Names()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"orders", "payments"}) {
+		t.Fatalf("expected both rings, got %q", names)
+	}
+	stats, err := m.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Files != 2 || len(stats.Rings) != 2 || stats.Total.Records != 3 {
+		t.Fatalf("expected 3 records in 2 rings, got %d in %d", stats.Total.Records, len(stats.Rings))
+	}
+
+	// Idle Rings are closed, and opened again when they're next used.
+	deadline := time.Now().Add(5 * time.Second)
+	for len(stats.Rings) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		if stats, err = m.Stats(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(stats.Rings) != 0 || stats.Files != 2 {
+		t.Fatalf("expected the idle rings to be closed, got %d open", len(stats.Rings))
+	}
+	if err := m.Use("orders", func(r *Ring) error {
+		if record := readRecord(t, r); record != "one" {
+			t.Fatalf("expected one, got %q", record)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Use("orders", func(*Ring) error { return nil }); err != ErrManagerClosed {
+		t.Fatalf("expected ErrManagerClosed, got %v", err)
+	}
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,288 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+//go:build !diskring_portable && !wasip1
+// +build !diskring_portable,!wasip1
+
+package diskring
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// This is the mmap backend, which maps the file straight into memory, and
+// maps the ring's data in twice, back to back, so that anything running
+// off the end wraps around to the start. This is the default; see
+// portable.go for the backend used when mmap isn't around.
+
+// backend is the state the mmap backend keeps for a Ring.
+type backend struct {
+	// async is the io_uring used by SyncAsync and Prefetch, which is set
+	// up the first time it's needed (see uring).
+	async     *uring
+	uringOnce sync.Once
+}
+
+// newBackend will create the backend state for a Ring over the segments.
+func newBackend(segments []segment) backend {
+	return backend{}
+}
+
+// pageSize will return the size of a page of memory, which the header and
+// every segment of the ring have to be aligned to.
+func pageSize() int {
+	return syscall.Getpagesize()
+}
+
+// reserve will reserve size bytes of address space to map things into,
+// starting at a multiple of align, with a PROT_NONE guard page on either
+// side of it. That way, any unsafe pointer math that runs off either end
+// faults right away, rather than quietly scribbling over whatever the Go
+// runtime happened to map next door.
+func reserve(size, align uintptr) (uintptr, error) {
+	guard := uintptr(syscall.Getpagesize())
+	// mmap only promises page alignment, so we have to ask for enough
+	// extra to find an aligned start in there, and hand back the rest.
+	slack := align - guard
+	base, err := mmap(0, size+2*guard+slack,
+		syscall.PROT_NONE,
+		syscall.MAP_ANONYMOUS|syscall.MAP_PRIVATE,
+		-1, 0)
+	if err != nil {
+		return 0, err
+	}
+	start := base + guard
+	if extra := start % align; extra != 0 {
+		start += align - extra
+	}
+	if before := start - guard - base; before > 0 {
+		munmap(base, before)
+	}
+	if after := slack - (start - guard - base); after > 0 {
+		munmap(start+size+guard, after)
+	}
+	return start, nil
+}
+
+// release will unmap a reservation made with reserve, including the guard
+// pages, and everything mapped inside of it.
+func release(base, size uintptr) error {
+	guard := uintptr(syscall.Getpagesize())
+	return munmap(base-guard, size+2*guard)
+}
+
+// mapFlagsReserved are the mmap flags the backend sets (or leaves unset)
+// itself, which can't be asked for through Options.MapFlags.
+const mapFlagsReserved = syscall.MAP_SHARED | syscall.MAP_PRIVATE |
+	syscall.MAP_FIXED | syscall.MAP_ANONYMOUS
+
+// mapFixed will map size bytes of the file at offset to addr, which has to
+// be inside of a reservation, with any extra mmap flags.
+func mapFixed(addr, size uintptr, fd *os.File, offset int64, flags int) error {
+	got, err := mmap(addr, size,
+		syscall.PROT_READ|syscall.PROT_WRITE,
+		syscall.MAP_FIXED|syscall.MAP_SHARED|flags,
+		int(fd.Fd()), offset)
+	if err != nil {
+		return err
+	}
+	if got != addr {
+		return fmt.Errorf("mmap split our MAP_FIXED call")
+	}
+	return nil
+}
+
+// mapHeader will map the first size bytes of the file, which hold the
+// header, with a guard page on either side.
+func mapHeader(fd *os.File, size uintptr) ([]byte, error) {
+	// The header is one aligned chunk of the file, so it's aligned to its
+	// own size.
+	headerBase, err := reserve(size, size)
+	if err != nil {
+		return nil, err
+	}
+	if err := mapFixed(headerBase, size, fd, 0, 0); err != nil {
+		release(headerBase, size)
+		return nil, err
+	}
+	return *asByteSlice(headerBase, int(size)), nil
+}
+
+// shareHeader will check that every Ring open on a file sees the same
+// header, and changes made to it straight away, as the write lease needs.
+// With the header mapped in, they do.
+func shareHeader() error {
+	return nil
+}
+
+// mapRing will map all of the segments into memory back to back, twice over,
+// so that reads and writes which run off the end of the first copy wrap
+// around into the start of the ring. size is the total size of all the
+// segments, which is mapped at a multiple of align, and flags are any extra
+// mmap flags (see Options.MapFlags).
+func mapRing(segments []segment, size, align uintptr, flags int) ([]byte, error) {
+	if flags&mapFlagsReserved != 0 {
+		return nil, fmt.Errorf("diskring: mmap flags %#x can't be changed", flags&mapFlagsReserved)
+	}
+
+	// First, we need to reserve a chunk that's twice the size of the
+	// ring, so that we can mmap fixed offset blocks inside that block.
+	ringBase, err := reserve(size<<1, align)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, base := range []uintptr{ringBase, ringBase + size} {
+		addr := base
+		for _, segment := range segments {
+			err := mapFixed(addr, segment.size, segment.fd, segment.offset, flags)
+			if err != nil {
+				release(ringBase, size<<1)
+				return nil, err
+			}
+			addr += segment.size
+		}
+	}
+	return *asByteSlice(ringBase, int(size<<1)), nil
+}
+
+// unmap will unmap all of the mapped memory, and shut down the io_uring if
+// it was ever set up.
+func (r *Ring) unmap() error {
+	if r.backend.async != nil {
+		if err := r.backend.async.Close(); err != nil {
+			return err
+		}
+	}
+	if r.headerPage != nil {
+		if err := release(addressOf(r.headerPage), uintptr(len(r.headerPage))); err != nil {
+			return err
+		}
+	}
+	// This takes out the mappings of each file inside the reservation
+	// along with it.
+	return release(addressOf(r.buf), r.size<<1)
+}
+
+// UNSAFE
+//
+// Note that the entry at the provided offset, which takes up size bytes,
+// was just written. Since the mapping is the file, there's nothing to do.
+func (r *Ring) written(off, size uintptr) {}
+
+// UNSAFE
+//
+// Note that size bytes at the provided offset were just written by another
+// process. Since the mapping is the file, there's nothing to do.
+func (r *Ring) reread(off, size uintptr) {}
+
+// UNSAFE
+//
+// Note that the header was just changed. Since the mapping is the file,
+// there's nothing to do.
+func (r *Ring) headerWritten() {}
+
+// syncHeader will flush the header page out to disk, and block until that's
+// done.
+func (r *Ring) syncHeader() error {
+	return msync(addressOf(r.headerPage), uintptr(len(r.headerPage)))
+}
+
+// syncData will flush the ring's data out to disk, and block until that's
+// done.
+func (r *Ring) syncData() error {
+	// Both halves of the mirror map the same pages, so we only need to
+	// flush the first.
+	return msync(addressOf(r.buf), r.size)
+}
+
+// fsyncAsync will start an fsync of every file through the io_uring,
+// returning a channel for each, or nil if there's no io_uring to use (or it
+// filled up), in which case nothing is left in flight.
+func (r *Ring) fsyncAsync() []<-chan error {
+	u := r.uring()
+	if u == nil {
+		return nil
+	}
+	var pending []<-chan error
+	for _, file := range r.files {
+		ch, err := u.submit(uringSQE{
+			opcode: uringOpFsync,
+			fd:     int32(file.Fd()),
+		})
+		if err != nil {
+			// Let whatever was already submitted finish, so that
+			// the fallback isn't racing it.
+			waitAll(pending)
+			return nil
+		}
+		pending = append(pending, ch)
+	}
+	return pending
+}
+
+// prefetch will ask the kernel to start reading length bytes of the ring's
+// data at the provided offset in from disk.
+func (r *Ring) prefetch(off, length uintptr) error {
+	// Thanks to the mirror, the records are always one run of memory,
+	// even if they wrap around the end of the Ring.
+	addr := addressOf(r.buf) + off
+	pageSize := uintptr(pageSize())
+	length += addr % pageSize
+	addr -= addr % pageSize
+
+	if u := r.uring(); u != nil && uint64(length) == uint64(uint32(length)) {
+		_, err := u.submit(uringSQE{
+			opcode:  uringOpMadvise,
+			addr:    uint64(addr),
+			len:     uint32(length),
+			opFlags: syscall.MADV_WILLNEED,
+		})
+		if err == nil {
+			return nil
+		}
+	}
+	return madvise(addr, length, syscall.MADV_WILLNEED)
+}
+
+// uring will return the Ring's io_uring, setting it up the first time
+// it's needed. If the kernel won't give us one, this returns nil, and the
+// caller has to do things the slow way.
+func (r *Ring) uring() *uring {
+	r.backend.uringOnce.Do(func() {
+		u, err := newURing()
+		if err != nil {
+			return
+		}
+		r.backend.async = u
+	})
+	return r.backend.async
+}
+
+// addressOf will return the address of the start of a mapping.
+func addressOf(buf []byte) uintptr {
+	return uintptr(unsafe.Pointer(&buf[0]))
+}
+
+// vim: foldmethod=marker
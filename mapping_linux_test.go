@@ -0,0 +1,93 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+//go:build !diskring_portable
+// +build !diskring_portable
+
+package diskring
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// protection will return the permissions /proc/self/maps lists for the
+// mapping holding addr, or "" if nothing is mapped there.
+func protection(t *testing.T, addr uintptr) string {
+	t.Helper()
+	fd, err := os.Open("/proc/self/maps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		var start, end uintptr
+		var perms string
+		if _, err := fmt.Sscanf(scanner.Text(), "%x-%x %s", &start, &end, &perms); err != nil {
+			t.Fatal(err)
+		}
+		if start <= addr && addr < end {
+			return perms
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return ""
+}
+
+func TestGuardPages(t *testing.T) {
+	r := openTestRing(t, Options{ReserveHeader: true})
+	for name, region := range map[string][]byte{
+		"header": r.headerPage,
+		"ring":   r.buf,
+	} {
+		start := addressOf(region)
+		end := start + uintptr(len(region))
+		if perms := protection(t, start); !strings.HasPrefix(perms, "rw") {
+			t.Errorf("expected the %s to be mapped read/write, got %q", name, perms)
+		}
+		if perms := protection(t, start-1); perms != "---p" {
+			t.Errorf("expected a guard page before the %s, got %q", name, perms)
+		}
+		if perms := protection(t, end); perms != "---p" {
+			t.Errorf("expected a guard page after the %s, got %q", name, perms)
+		}
+	}
+}
+
+func TestMapFlags(t *testing.T) {
+	r := openTestRing(t, Options{MapFlags: syscall.MAP_POPULATE | syscall.MAP_NORESERVE, NonBlockingReads: true})
+	writeRecords(t, r, "populated")
+	if record := readRecord(t, r); record != "populated" {
+		t.Fatalf("expected \"populated\", got %q", record)
+	}
+
+	path := testRingPath(t)
+	if _, err := OpenWithOptions(path, Options{CreateIfMissing: true, CreateSize: 1 << 16, MapFlags: syscall.MAP_PRIVATE}); err == nil {
+		t.Fatal("expected MAP_PRIVATE to be refused")
+	}
+}
+
+// vim: foldmethod=marker
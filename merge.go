@@ -0,0 +1,103 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"io"
+)
+
+// MergedReader reads records from a number of Rings as a single stream,
+// oldest first. See Merge.
+type MergedReader struct {
+	rings []*Ring
+}
+
+// Merge will create a MergedReader which interleaves the records from all
+// of the provided Rings, ordered by the time they were written if the Rings
+// have Timestamps turned on, or by their sequence number if not. All of the
+// Rings should agree on that, since comparing a timestamp from one Ring to a
+// sequence number from another won't get you anything useful.
+//
+// Records are consumed from each Ring as they're read, just as with
+// Ring.Read.
+func Merge(rings ...*Ring) *MergedReader {
+	return &MergedReader{rings: rings}
+}
+
+// Read will read the oldest record at the head of any of the Rings into buf,
+// and consume it from that Ring. This won't block, if all of the Rings are
+// empty, this will return an io.EOF.
+//
+// A record being written to a Ring that was empty while picking the oldest
+// record may end up being read after a newer record in another Ring.
+func (m *MergedReader) Read(buf []byte) (int, error) {
+	for {
+		oldest := m.oldest()
+		if oldest == nil {
+			return 0, io.EOF
+		}
+
+		oldest.mutex.Lock()
+		if oldest.empty() {
+			// Someone else got to it first; go find the next one.
+			oldest.mutex.Unlock()
+			continue
+		}
+		n, err := oldest.readEntry(buf)
+		oldest.mutex.Unlock()
+		return n, err
+	}
+}
+
+// oldest will find the Ring with the oldest record at its head, or nil if
+// all the Rings are empty.
+func (m *MergedReader) oldest() *Ring {
+	var (
+		oldest    *Ring
+		oldestKey uint64
+	)
+	for _, r := range m.rings {
+		r.mutex.Lock()
+		if !r.empty() {
+			key := r.headKey()
+			if oldest == nil || key < oldestKey {
+				oldest = r
+				oldestKey = key
+			}
+		}
+		r.mutex.Unlock()
+	}
+	return oldest
+}
+
+// UNSAFE
+//
+// Return the key used to order the record at the head against records in
+// other Rings, which is the time it was written if we have it, or the
+// sequence number if not.
+func (r *Ring) headKey() uint64 {
+	if r.layout.has(formatTimestamp) {
+		return uint64(r.entryTime(*r.head))
+	}
+	return r.header.headSeq
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,62 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	a := openTestRing(t, Options{Timestamps: true})
+	b := openTestRing(t, Options{Timestamps: true})
+	for _, w := range []struct {
+		r      *Ring
+		record string
+	}{{a, "a1"}, {b, "b1"}, {b, "b2"}, {a, "a2"}, {b, "b3"}} {
+		if _, err := w.r.Write([]byte(w.record)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var records []string
+	m := Merge(a, b)
+	buf := make([]byte, 64)
+	for {
+		n, err := m.Read(buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, string(buf[:n]))
+	}
+	if !reflect.DeepEqual(records, []string{"a1", "b1", "b2", "a2", "b3"}) {
+		t.Fatalf("expected the records in the order they were written, got %v", records)
+	}
+	if a.Records() != 0 || b.Records() != 0 {
+		t.Fatal("merged records weren't consumed")
+	}
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,274 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// With Options.SchemaVersions, each entry carries a byte saying which
+// version of the application's payload format its record is in. Migrate
+// rewrites every live record from one version to another. Since the new
+// records needn't be the same size as the old ones, they can't be changed
+// in place (see Redact), so the whole Ring is written out again, from the
+// head, through the usual write path. That takes care of spilling,
+// compressing, signing and chaining the new records, and of the indexes,
+// just like any other write. Sequence numbers stay the same, but offsets
+// don't, so the generation changes, as with Compact.
+
+// migratedEntry is a live entry as it'll be written back by Migrate.
+type migratedEntry struct {
+	e    envelope
+	data []byte
+
+	// moved is where each record in a batch that was migrated has gone
+	// to, by the offset of the record into the old batch, so that readers
+	// part way through it can be moved along with it.
+	moved map[int]int
+}
+
+// Migrate will rewrite every record in the Ring stored with schema version
+// from, replacing it with what fn returns for it, and storing it with
+// schema version to, returning how many records were migrated. Records
+// stored with any other schema version are left as they are. The Ring
+// needs SchemaVersions.
+//
+// fn is called for every record to be migrated before anything is
+// changed, so if it returns an error, Migrate returns it, and the Ring is
+// left as it was. fn is called with the Ring locked, so it mustn't use the
+// Ring itself, and the slice it's passed is only good until it returns.
+//
+// Every record is written back to the Ring, so if the Ring signs records,
+// it needs its SigningKey, and the records are signed again. If the
+// migrated records have grown so much that they no longer all fit, the
+// oldest are overwritten, just as they would be by any other write.
+//
+// As with Compact, the Ring's generation changes, since records move
+// around. Consumers that are open are moved along with their records, but
+// positions saved from before the migration can't be used afterwards (see
+// ErrStaleCursor). The Ring's own SchemaVersion isn't changed; that's up
+// to the options it's opened with next.
+func (r *Ring) Migrate(from, to int, fn func(old []byte) ([]byte, error)) (int, error) {
+	if !r.layout.has(formatSchema) {
+		return 0, ErrNoSchemaVersions
+	}
+	for _, version := range []int{from, to} {
+		if version < 0 || version > 0xff {
+			return 0, fmt.Errorf("diskring: schema version %d out of range", version)
+		}
+	}
+
+	r.writeMutex.Lock()
+	defer r.writeMutex.Unlock()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if err := r.flushBatch(); err != nil {
+		return 0, err
+	}
+	if err := r.checkWrite(0); err != nil {
+		return 0, err
+	}
+
+	entries, migrated, err := r.migrateEntries(uint8(from), uint8(to), fn)
+	if err != nil || migrated == 0 {
+		return 0, err
+	}
+	if err := r.rewriteEntries(entries); err != nil {
+		return 0, err
+	}
+	r.notifyRewritten()
+	r.check()
+	return migrated, nil
+}
+
+// UNSAFE
+//
+// Read every live entry out of the Ring, with the records stored with
+// schema version from passed through fn, ready to be written back. This
+// doesn't change anything in the Ring.
+func (r *Ring) migrateEntries(from, to uint8, fn func([]byte) ([]byte, error)) ([]migratedEntry, int, error) {
+	var (
+		entries  []migratedEntry
+		migrated int
+	)
+	for off := *r.head; off != *r.tail; off = r.nextEntry(off) {
+		data, err := r.recordData(off)
+		if err != nil {
+			return nil, 0, err
+		}
+		m := migratedEntry{e: r.entryEnvelope(off)}
+
+		switch {
+		case m.e.schema != from:
+			m.data = append([]byte{}, data...)
+		case m.e.flags&flagBatch != 0:
+			var n int
+			if m.data, m.moved, n, err = migrateBatch(data, fn); err != nil {
+				return nil, 0, err
+			}
+			m.e.schema = to
+			migrated += n
+		default:
+			if m.data, err = fn(data); err != nil {
+				return nil, 0, err
+			}
+			m.data = append([]byte{}, m.data...)
+			m.e.schema = to
+			migrated++
+		}
+
+		if length := len(m.e.key) + len(m.data); r.spillDir == "" && length > int(r.maxRecord) {
+			return nil, 0, &TooLargeError{Size: length, Limit: int(r.maxRecord)}
+		}
+		entries = append(entries, m)
+	}
+	return entries, migrated, nil
+}
+
+// migrateBatch will pass every record in a batch of coalesced records
+// through fn, and pack what it returns into a new batch, returning it along
+// with where each record moved to, and how many records there were.
+func migrateBatch(data []byte, fn func([]byte) ([]byte, error)) ([]byte, map[int]int, int, error) {
+	var (
+		batch  []byte
+		moved  = map[int]int{}
+		length [binary.MaxVarintLen64]byte
+		n      int
+	)
+	for pos := 0; pos < len(data); n++ {
+		rec, next, err := unbatch(data, pos)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		if rec, err = fn(rec); err != nil {
+			return nil, nil, 0, err
+		}
+		moved[pos] = len(batch)
+		batch = append(batch, length[:binary.PutUvarint(length[:], uint64(len(rec)))]...)
+		batch = append(batch, rec...)
+		pos = next
+	}
+	return batch, moved, n, nil
+}
+
+// UNSAFE
+//
+// Empty the Ring out, and write the entries back into it from the head,
+// moving the readers along with them. Side files of spilled records are
+// only removed once everything has been written back.
+func (r *Ring) rewriteEntries(entries []migratedEntry) error {
+	type spilled struct {
+		e         envelope
+		reference []byte
+	}
+	var old []spilled
+	for off := *r.head; off != *r.tail; off = r.nextEntry(off) {
+		if flags := r.entryFlags(off); flags&flagSpilled != 0 {
+			old = append(old, spilled{
+				e:         envelope{flags: flags},
+				reference: append([]byte{}, r.entryData(off)...),
+			})
+		}
+	}
+	defer func() {
+		for _, s := range old {
+			r.unspill(s.e, s.reference)
+		}
+	}()
+
+	headSeq, generation := r.header.headSeq, r.header.generation
+	r.beginUpdate()
+	r.setTail(*r.head, headSeq)
+	r.header.last = 0
+	r.header.generation = newGeneration()
+	r.endUpdate()
+
+	if r.indexed {
+		r.index = r.index[:0]
+	}
+	if r.keys != nil {
+		r.keys = map[string]keyEntry{}
+	}
+	r.sparse = r.sparse[:0]
+
+	// The chain can't be linked onto whatever came before the first
+	// record, since that's long gone, so the first record keeps the hash it
+	// had, and everything after it is linked on again.
+	if r.layout.has(formatChain) && len(entries) > 0 {
+		entries[0].e.chain = append([]byte{}, r.entryChain(*r.head)...)
+		copy(r.chain[:], entries[0].e.chain)
+	}
+	r.rewriting = true
+	defer func() { r.rewriting = false }()
+	for _, m := range entries {
+		if _, err := r.writeEntry(m.e, m.data); err != nil {
+			return err
+		}
+	}
+
+	return r.moveReaders(headSeq, generation, entries)
+}
+
+// UNSAFE
+//
+// Find where the records the readers are on ended up after the entries
+// were written back, which started at sequence number headSeq. Consumers
+// that were already out of date (whose generation isn't the one the Ring
+// had before) are left that way. A reader part way through a batch whose
+// records from there on are all gone (see Erase) is moved past it.
+func (r *Ring) moveReaders(headSeq, generation uint64, entries []migratedEntry) error {
+	moveBatch := func(b *batchCursor) (past bool) {
+		if b.pos == 0 || b.seq < headSeq || b.seq-headSeq >= uint64(len(entries)) {
+			return false
+		}
+		m := entries[b.seq-headSeq]
+		if m.moved == nil {
+			return false
+		}
+		b.pos = m.moved[b.pos]
+		return b.pos >= len(m.data)
+	}
+
+	var err error
+	if r.batched.seq == r.header.headSeq && moveBatch(&r.batched) {
+		err = r.pastBatched(0)
+	}
+	for _, c := range r.consumers {
+		if c.generation != generation {
+			continue
+		}
+		if off, err := r.findSequence(c.seq); err == nil {
+			c.off = off
+		}
+		c.generation = r.header.generation
+		if c.inBatch() && moveBatch(&c.batched) {
+			c.batched = batchCursor{}
+			c.off = r.nextEntry(c.off)
+			c.seq++
+		}
+	}
+	return err
+}
+
+// vim: foldmethod=marker
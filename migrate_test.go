@@ -0,0 +1,117 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	if _, err := openTestRing(t, Options{}).Migrate(0, 1, nil); err != ErrNoSchemaVersions {
+		t.Fatalf("expected ErrNoSchemaVersions, got %v", err)
+	}
+
+	r := openTestRing(t, Options{SchemaVersions: true, NonBlockingReads: true})
+	writeRecords(t, r, "one", "two")
+	if _, err := r.WriteRecord(Record{Data: []byte("three"), Schema: 1}); err != nil {
+		t.Fatal(err)
+	}
+	c, err := r.Cursor("reader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Read(make([]byte, 16)); err != nil {
+		t.Fatal(err)
+	}
+
+	// If fn fails, nothing is changed.
+	errFailed := errors.New("failed")
+	if _, err := r.Migrate(0, 2, func([]byte) ([]byte, error) { return nil, errFailed }); err != errFailed {
+		t.Fatalf("expected the callback's error, got %v", err)
+	}
+
+	n, err := r.Migrate(0, 2, func(old []byte) ([]byte, error) {
+		return bytes.ToUpper(old), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("expected 2 records to be migrated, got %d", n)
+	}
+
+	// The open Consumer is moved along with its records.
+	if records := readAll(t, c); !reflect.DeepEqual(records, []string{"TWO", "three"}) {
+		t.Fatalf("expected the Consumer to carry on from the second record, got %q", records)
+	}
+	stats, err := r.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.HeadSequence != 0 || stats.TailSequence != 3 {
+		t.Fatalf("expected the sequence numbers to be kept, got %d to %d", stats.HeadSequence, stats.TailSequence)
+	}
+	for _, want := range []Record{
+		{Data: []byte("ONE"), Schema: 2},
+		{Data: []byte("TWO"), Schema: 2},
+		{Data: []byte("three"), Schema: 1},
+	} {
+		rec, err := r.ReadRecord()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(rec.Data, want.Data) || rec.Schema != want.Schema {
+			t.Fatalf("expected %q at version %d, got %q at version %d", want.Data, want.Schema, rec.Data, rec.Schema)
+		}
+	}
+}
+
+func TestConsumerAfterMigrate(t *testing.T) {
+	r := openTestRing(t, Options{SchemaVersion: 1, Coalesce: 64})
+	for i := 0; i < 40; i++ {
+		r.Write([]byte(fmt.Sprint("v1:", i)))
+	}
+	r.Flush()
+	c, _ := r.Cursor("c1")
+	buf := make([]byte, 1024)
+	for i := 0; i < 13; i++ {
+		c.Read(buf)
+	}
+
+	migrated, err := r.Migrate(1, 2, func(old []byte) ([]byte, error) {
+		return []byte("v2, which is longer:" + strings.TrimPrefix(string(old), "v1:")), nil
+	})
+	if err != nil || migrated != 40 {
+		t.Fatalf("migrated %d records (%v), not 40", migrated, err)
+	}
+
+	records := readAll(t, c)
+	if len(records) != 27 || records[0] != "v2, which is longer:13" || records[26] != "v2, which is longer:39" {
+		t.Fatalf("read %d records after Migrate, from %q to %q", len(records), records[0], records[len(records)-1])
+	}
+}
+
+// vim: foldmethod=marker
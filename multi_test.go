@@ -0,0 +1,86 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// openMultiFiles will open (creating, if needed) each of the named files in
+// dir, size bytes large, which are closed when the test is done.
+func openMultiFiles(t *testing.T, dir string, size int64, names ...string) []*os.File {
+	t.Helper()
+	var files []*os.File
+	for _, name := range names {
+		fd, err := os.OpenFile(filepath.Join(dir, name), os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { fd.Close() })
+		if err := fd.Truncate(size); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, fd)
+	}
+	return files
+}
+
+func TestNewMulti(t *testing.T) {
+	dir := filepath.Dir(testRingPath(t))
+	size := int64(pageSize()) * 2
+	names := []string{"a.ring", "b.ring", "c.ring"}
+
+	files := openMultiFiles(t, dir, size, names...)
+	r, err := NewMulti(files, Options{ReserveHeader: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int(size)*3 - pageSize(); r.Cap() != want {
+		t.Fatalf("expected the Ring to hold %d bytes, got %d", want, r.Cap())
+	}
+
+	// Enough records to run past the end of the first two files.
+	var records []string
+	for i := 0; len(records)*100 < int(size)*2; i++ {
+		records = append(records, fmt.Sprintf("%099d", i))
+	}
+	writeRecords(t, r, records...)
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files = openMultiFiles(t, dir, size, names...)
+	r, err = NewMulti(files, Options{ReserveHeader: true, NonBlockingReads: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	for _, want := range records {
+		if record := readRecord(t, r); record != want {
+			t.Fatalf("expected %q, got %q", want, record)
+		}
+	}
+}
+
+// vim: foldmethod=marker
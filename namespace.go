@@ -0,0 +1,201 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// ErrNoProducerIDs is returned when creating a Namespace on a Ring that
+// doesn't store producer IDs, since there'd be no telling which records
+// belong to which Namespace.
+var ErrNoProducerIDs = errors.New("diskring: namespaces need the ProducerIDs option")
+
+// Namespace is a named part of a Ring, so that several components can share
+// one Ring without getting in each other's way. Each Namespace writes under
+// its own Producer, so it's held to its own Quota (in particular, its
+// Capacity share of the Ring), and has its own Cursors, which only see its
+// own records. Create one with Ring.Namespace.
+type Namespace struct {
+	// rejected is the number of writes that went over the Quota. It comes
+	// first so that it's 64-bit aligned.
+	rejected uint64
+
+	producer *Producer
+}
+
+// NamespaceStats is how much of the Ring a single Namespace is using, and how
+// its Cursors are getting on.
+type NamespaceStats struct {
+	// Name and ID are what the Namespace was created with, and Capacity is
+	// the share of the Ring it's allowed (0 for no limit).
+	Name     string
+	ID       uint16
+	Capacity float64
+
+	// Records is the number of the Namespace's records in the Ring, and
+	// Bytes is how many bytes they take up. Like a Producer's usage, these
+	// are only tracked in memory, so they start from 0 each time the Ring
+	// is opened.
+	Records int
+	Bytes   int
+
+	// Rejected is the number of writes that went over the Quota, and so
+	// weren't written.
+	Rejected uint64
+
+	// Cursors is what each open Cursor of the Namespace has missed, with
+	// the names they were opened with.
+	Cursors []ConsumerStats
+}
+
+// Namespace will create a new Namespace with the provided ID and name, whose
+// writes are held to the Quota. This registers a Producer under the same ID
+// and name (see RegisterProducer), so neither can be used by anything else,
+// and the Ring needs the ProducerIDs option to be set.
+//
+// The ID is what marks a record as belonging to the Namespace, so the same
+// ID should be used for the same Namespace every time the Ring is opened.
+func (r *Ring) Namespace(id uint16, name string, quota Quota) (*Namespace, error) {
+	if !r.layout.has(formatProducer) {
+		return nil, ErrNoProducerIDs
+	}
+	if name == "" || strings.Contains(name, "@") {
+		return nil, fmt.Errorf("diskring: invalid namespace name %q", name)
+	}
+	p, err := r.RegisterProducer(id, name, quota)
+	if err != nil {
+		return nil, err
+	}
+	ns := &Namespace{producer: p}
+
+	r.mutex.Lock()
+	if r.namespaces == nil {
+		r.namespaces = map[uint16]*Namespace{}
+	}
+	r.namespaces[id] = ns
+	r.mutex.Unlock()
+	return ns, nil
+}
+
+// ID will return the ID the Namespace was created with.
+func (ns *Namespace) ID() uint16 {
+	return ns.producer.id
+}
+
+// Name will return the name the Namespace was created with.
+func (ns *Namespace) Name() string {
+	return ns.producer.name
+}
+
+// Write will write a block of data into the Ring under the Namespace, unless
+// that would go over the Namespace's Quota, in which case this will return
+// an ErrQuotaExceeded.
+func (ns *Namespace) Write(buf []byte) (int, error) {
+	n, err := ns.producer.Write(buf)
+	if err == ErrQuotaExceeded {
+		atomic.AddUint64(&ns.rejected, 1)
+	}
+	return n, err
+}
+
+// Cursor will return a Consumer of the Namespace, just like Ring.Cursor,
+// except that it skips over any records that don't belong to the Namespace.
+// Cursors of different Namespaces can share a name, since the name of the
+// Namespace is put in front of it (as "namespace@name").
+//
+// Records that went out of the Ring before the Cursor got to them are only
+// counted as dropped if they belonged to the Namespace, except for those that
+// were gone before the Cursor was opened, which are all counted.
+func (ns *Namespace) Cursor(name string) (*Consumer, error) {
+	return ns.producer.r.cursor(ns.cursorPrefix()+name, ns.producer.id)
+}
+
+// RemoveCursor will forget the position of the Namespace's Cursor with the
+// provided name.
+func (ns *Namespace) RemoveCursor(name string) error {
+	return ns.producer.r.RemoveCursor(ns.cursorPrefix() + name)
+}
+
+// cursorPrefix is put in front of the name of each of the Namespace's
+// Cursors, so that they don't clash with anyone else's.
+func (ns *Namespace) cursorPrefix() string {
+	return ns.producer.name + "@"
+}
+
+// Stats will return how much of the Ring the Namespace is using, and how its
+// Cursors are getting on.
+func (ns *Namespace) Stats() NamespaceStats {
+	r := ns.producer.r
+	r.mutex.Lock()
+	stats := ns.stats()
+	r.mutex.Unlock()
+
+	prefix := ns.cursorPrefix()
+	for _, c := range r.consumerStats() {
+		if strings.HasPrefix(c.Name, prefix) {
+			stats.Cursors = append(stats.Cursors, c)
+		}
+	}
+	return stats
+}
+
+// UNSAFE
+//
+// Return the Namespace's usage, without its Cursors.
+func (ns *Namespace) stats() NamespaceStats {
+	p := ns.producer
+	p.r.releaseOwned()
+	return NamespaceStats{
+		Name:     p.name,
+		ID:       p.id,
+		Capacity: p.quota.Capacity,
+		Records:  p.records,
+		Bytes:    int(p.used),
+		Rejected: atomic.LoadUint64(&ns.rejected),
+	}
+}
+
+// NamespaceStats will return the Stats of every Namespace created since the
+// Ring was opened, sorted by ID.
+func (r *Ring) NamespaceStats() []NamespaceStats {
+	r.mutex.Lock()
+	namespaces := make([]*Namespace, 0, len(r.namespaces))
+	for _, ns := range r.namespaces {
+		namespaces = append(namespaces, ns)
+	}
+	r.mutex.Unlock()
+
+	sort.Slice(namespaces, func(i, j int) bool {
+		return namespaces[i].producer.id < namespaces[j].producer.id
+	})
+	ret := make([]NamespaceStats, len(namespaces))
+	for i, ns := range namespaces {
+		ret[i] = ns.Stats()
+	}
+	return ret
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,96 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNamespaces(t *testing.T) {
+	if _, err := openTestRing(t, Options{}).Namespace(1, "audit", Quota{}); err != ErrNoProducerIDs {
+		t.Fatalf("expected ErrNoProducerIDs, got %v", err)
+	}
+
+	r := openTestRing(t, Options{CreateSize: 4096, ProducerIDs: true})
+	if _, err := r.Namespace(1, "bad@name", Quota{}); err == nil {
+		t.Fatal("expected a name with an @ in it to be refused")
+	}
+	metrics, err := r.Namespace(1, "metrics", Quota{Capacity: 0.25})
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit, err := r.Namespace(2, "audit", Quota{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metricsCursor, err := metrics.Cursor("reader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	auditCursor, err := audit.Cursor("reader")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The metrics Namespace is held to its share of the Ring, without
+	// holding up the audit Namespace.
+	record := []byte(strings.Repeat("m", 100))
+	written := 0
+	for {
+		if _, err := metrics.Write(record); err == ErrQuotaExceeded {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		written++
+	}
+	for _, record := range []string{"one", "two"} {
+		if _, err := audit.Write([]byte(record)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Each Namespace's Cursors only see its own records.
+	if records := readAll(t, auditCursor); !reflect.DeepEqual(records, []string{"one", "two"}) {
+		t.Fatalf("expected the audit records, got %q", records)
+	}
+	if records := readAll(t, metricsCursor); len(records) != written {
+		t.Fatalf("expected %d metrics records, got %d", written, len(records))
+	}
+
+	stats := r.NamespaceStats()
+	if len(stats) != 2 || stats[0].Name != "metrics" || stats[1].Name != "audit" {
+		t.Fatalf("expected both Namespaces in order of ID, got %+v", stats)
+	}
+	if stats[0].Records != written || stats[0].Rejected != 1 || stats[0].Capacity != 0.25 {
+		t.Fatalf("expected %d metrics records and 1 rejected, got %+v", written, stats[0])
+	}
+	if stats[1].Records != 2 || stats[1].Rejected != 0 {
+		t.Fatalf("expected 2 audit records, got %+v", stats[1])
+	}
+	if cursors := stats[1].Cursors; len(cursors) != 1 || cursors[0].Name != "audit@reader" {
+		t.Fatalf("expected the audit Cursor, got %+v", cursors)
+	}
+}
+
+// vim: foldmethod=marker
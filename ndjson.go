@@ -0,0 +1,146 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+	"unicode/utf8"
+)
+
+// ndjsonRecord is a record as it's written out by ExportNDJSON, one to a
+// line. The key and data are written as strings if they're valid UTF-8,
+// and in base64 otherwise.
+type ndjsonRecord struct {
+	Sequence    uint64     `json:"seq"`
+	Time        *time.Time `json:"time,omitempty"`
+	Producer    uint16     `json:"producer,omitempty"`
+	ContentType uint16     `json:"content_type,omitempty"`
+	Flags       uint16     `json:"flags,omitempty"`
+	Key         *string    `json:"key,omitempty"`
+	KeyBase64   []byte     `json:"key_base64,omitempty"`
+	Data        *string    `json:"data,omitempty"`
+	DataBase64  []byte     `json:"data_base64,omitempty"`
+}
+
+// ndjsonWriter is a ColumnWriter which writes each record out as a line of
+// JSON.
+type ndjsonWriter struct {
+	enc *json.Encoder
+}
+
+// WriteColumns implements the ColumnWriter interface.
+func (w ndjsonWriter) WriteColumns(cols Columns) error {
+	for i := 0; i < cols.Len(); i++ {
+		rec := ndjsonRecord{
+			Sequence:    cols.Sequence[i],
+			Producer:    cols.Producer[i],
+			ContentType: uint16(cols.ContentType[i]),
+			Flags:       uint16(cols.Flags[i]),
+		}
+		if !cols.Time[i].IsZero() {
+			rec.Time = &cols.Time[i]
+		}
+		if key := cols.Key[i]; len(key) > 0 {
+			if utf8.Valid(key) {
+				s := string(key)
+				rec.Key = &s
+			} else {
+				rec.KeyBase64 = key
+			}
+		}
+		if data := cols.Data[i]; utf8.Valid(data) {
+			s := string(data)
+			rec.Data = &s
+		} else {
+			rec.DataBase64 = data
+		}
+		if err := w.enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExportNDJSON will write every record in the Ring out to w as newline
+// delimited JSON, one record to a line, oldest first, without consuming
+// anything, returning the number of records written out. Each line has the
+// record's sequence number ("seq"), and its "time", "producer",
+// "content_type", "flags" and "key" if it has them. The key and data are
+// written as strings ("key" and "data") if they're valid UTF-8, or in
+// base64 ("key_base64" and "data_base64") if they're not.
+//
+// This walks the Ring just like Export.
+func (r *Ring) ExportNDJSON(w io.Writer) (int, error) {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return r.Export(ndjsonWriter{enc: enc}, ExportOptions{})
+}
+
+// ImportNDJSON will read records written out by ExportNDJSON from rd, and
+// write each of them into the Ring with WriteRecord, returning the number
+// of records written. The flags, content type and key are kept, as long as
+// the Ring stores them; the sequence number, time and producer are ignored,
+// since those belong to the Ring the record was exported from.
+func (r *Ring) ImportNDJSON(rd io.Reader) (int, error) {
+	dec := json.NewDecoder(rd)
+	imported := 0
+	for {
+		var rec ndjsonRecord
+		if err := dec.Decode(&rec); err != nil {
+			if err == io.EOF {
+				return imported, nil
+			}
+			return imported, fmt.Errorf("diskring: record %d: %w", imported, err)
+		}
+		out := Record{
+			Key:         rec.KeyBase64,
+			Data:        rec.DataBase64,
+			Flags:       Flags(rec.Flags),
+			ContentType: ContentType(rec.ContentType),
+		}
+		if rec.Key != nil {
+			out.Key = []byte(*rec.Key)
+		}
+		if rec.Data != nil {
+			out.Data = []byte(*rec.Data)
+		}
+		// Anything this Ring doesn't store is left behind, rather than
+		// failing the import.
+		if !r.layout.has(formatKey) {
+			out.Key = nil
+		}
+		if !r.layout.has(formatFlags) {
+			out.Flags = 0
+		}
+		if !r.layout.has(formatContentType) {
+			out.ContentType = 0
+		}
+		if _, err := r.WriteRecord(out); err != nil {
+			return imported, err
+		}
+		imported++
+	}
+}
+
+// vim: foldmethod=marker
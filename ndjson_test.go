@@ -0,0 +1,80 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestNDJSON(t *testing.T) {
+	options := Options{RecordFlags: true, Keys: true, ContentTypes: true, NonBlockingReads: true}
+	records := []Record{
+		{Data: []byte("plain text")},
+		{Data: []byte{0xff, 0x00, 0xfe}, Key: []byte{0x80}},
+		{Data: []byte("flagged"), Key: []byte("k"), Flags: FlagTombstone, ContentType: 3},
+	}
+	r := openTestRing(t, options)
+	for _, rec := range records {
+		if _, err := r.WriteRecord(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if n, err := r.ExportNDJSON(&buf); n != 3 || err != nil {
+		t.Fatalf("expected 3 records exported, got %d (%v)", n, err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[0], `"data":"plain text"`) || !strings.Contains(lines[1], `"data_base64":"/wD+"`) {
+		t.Fatalf("unexpected NDJSON:\n%s", buf.String())
+	}
+
+	other := openTestRing(t, options)
+	if n, err := other.ImportNDJSON(bytes.NewReader(buf.Bytes())); n != 3 || err != nil {
+		t.Fatalf("expected 3 records imported, got %d (%v)", n, err)
+	}
+	for _, want := range records {
+		rec, err := other.ReadRecord()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(rec.Data, want.Data) || !bytes.Equal(rec.Key, want.Key) || rec.Flags != want.Flags || rec.ContentType != want.ContentType {
+			t.Fatalf("expected %+v, got %+v", want, rec)
+		}
+	}
+
+	// A Ring that doesn't store the extra fields just gets the data.
+	plain := openTestRing(t, Options{NonBlockingReads: true})
+	if n, err := plain.ImportNDJSON(bytes.NewReader(buf.Bytes())); n != 3 || err != nil {
+		t.Fatalf("expected 3 records imported, got %d (%v)", n, err)
+	}
+	if record := readRecord(t, plain); record != "plain text" {
+		t.Fatalf("expected \"plain text\", got %q", record)
+	}
+
+	if _, err := plain.ImportNDJSON(strings.NewReader("{not json\n")); err == nil {
+		t.Fatal("expected bad JSON to be refused")
+	}
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,248 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"time"
+)
+
+// A Ring in another process can't see the mutex or the wakeup channel, so
+// it has no way of knowing when something new has been written. With
+// Options.NotifyFile, the writer rewrites a small file with where its
+// cursor is after every change, and readers with ReadOnlyCursor watch the
+// file (with inotify, where there is one), picking up the writer's cursor
+// as it changes.
+//
+// The file holds a notifyState, followed by a CRC32C of it. The file is
+// rewritten in place, so a reader can catch a write halfway through, which
+// it won't take, but the write finishing will wake it up again.
+
+// notifyStateSize is how many bytes the notifyState takes in the file,
+// including the checksum.
+const notifyStateSize = 7*8 + 4
+
+// defaultNotifyPollInterval is how often a reader checks the NotifyFile if
+// it can't be watched.
+const defaultNotifyPollInterval = 100 * time.Millisecond
+
+// notifyState is where the writer's cursor was when it last wrote to the
+// NotifyFile.
+type notifyState struct {
+	generation uint64
+	head       uint64
+	headSeq    uint64
+	tail       uint64
+	tailSeq    uint64
+	last       uint64
+
+	// rewritten is bumped every time records are changed in place (see
+	// Redact), rather than only being added or dropped.
+	rewritten uint64
+}
+
+// notifier is the NotifyFile, from whichever end of it the Ring is on.
+type notifier struct {
+	fd *os.File
+
+	// state is what the writer last wrote, or what the reader last took.
+	state notifyState
+
+	// err is the first error the writer had writing the file, which is
+	// returned by the next Sync.
+	err error
+
+	// watcher wakes the reader up when the file changes.
+	watcher watcher
+}
+
+// watcher calls a function every time a file changes, until it's closed.
+type watcher interface {
+	Close()
+}
+
+// openNotifier will open the NotifyFile at path, creating it if it's not
+// there. Readers only need to be able to read it.
+func openNotifier(path string, readOnly bool, perms filePerms) (*notifier, error) {
+	flags := os.O_RDWR
+	if readOnly {
+		flags = os.O_RDONLY
+	}
+	fd, err := os.OpenFile(path, flags|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if readOnly {
+		return &notifier{fd: fd}, nil
+	}
+	if err := perms.apply(fd); err != nil {
+		fd.Close()
+		return nil, err
+	}
+	return &notifier{fd: fd}, nil
+}
+
+// write will write the state out to the file.
+func (n *notifier) write(state notifyState) {
+	var buf [notifyStateSize]byte
+	binary.LittleEndian.PutUint64(buf[0:], state.generation)
+	binary.LittleEndian.PutUint64(buf[8:], state.head)
+	binary.LittleEndian.PutUint64(buf[16:], state.headSeq)
+	binary.LittleEndian.PutUint64(buf[24:], state.tail)
+	binary.LittleEndian.PutUint64(buf[32:], state.tailSeq)
+	binary.LittleEndian.PutUint64(buf[40:], state.last)
+	binary.LittleEndian.PutUint64(buf[48:], state.rewritten)
+	binary.LittleEndian.PutUint32(buf[56:], crc32.Checksum(buf[:56], crcTable))
+	n.state = state
+	if _, err := n.fd.WriteAt(buf[:], 0); err != nil && n.err == nil {
+		n.err = err
+	}
+}
+
+// read will read the state from the file, returning false if the writer
+// hasn't written it yet, or is halfway through writing it.
+func (n *notifier) read() (notifyState, bool) {
+	var buf [notifyStateSize]byte
+	if _, err := n.fd.ReadAt(buf[:], 0); err != nil {
+		return notifyState{}, false
+	}
+	if binary.LittleEndian.Uint32(buf[56:]) != crc32.Checksum(buf[:56], crcTable) {
+		return notifyState{}, false
+	}
+	return notifyState{
+		generation: binary.LittleEndian.Uint64(buf[0:]),
+		head:       binary.LittleEndian.Uint64(buf[8:]),
+		headSeq:    binary.LittleEndian.Uint64(buf[16:]),
+		tail:       binary.LittleEndian.Uint64(buf[24:]),
+		tailSeq:    binary.LittleEndian.Uint64(buf[32:]),
+		last:       binary.LittleEndian.Uint64(buf[40:]),
+		rewritten:  binary.LittleEndian.Uint64(buf[48:]),
+	}, true
+}
+
+// close will stop watching the file, and close it.
+func (n *notifier) close() error {
+	if n.watcher != nil {
+		n.watcher.Close()
+	}
+	return n.fd.Close()
+}
+
+// UNSAFE
+//
+// Write where the cursor is out to the NotifyFile, if there is one, to wake
+// up any readers in other processes.
+func (r *Ring) notify() {
+	if r.notifier == nil || r.readOnly {
+		return
+	}
+	state := r.notifier.state
+	state.generation = r.header.generation
+	state.head, state.headSeq = uint64(*r.head), r.header.headSeq
+	state.tail, state.tailSeq = uint64(*r.tail), r.header.tailSeq
+	state.last = uint64(r.header.last)
+	r.notifier.write(state)
+}
+
+// UNSAFE
+//
+// Note that records were changed in place, so that readers in other
+// processes know to read them again.
+func (r *Ring) notifyRewritten() {
+	if r.notifier == nil || r.readOnly {
+		return
+	}
+	r.notifier.state.rewritten++
+	r.notify()
+}
+
+// UNSAFE
+//
+// Pick up the writer's cursor from the NotifyFile, and wake up anything
+// waiting on a read if there's something new.
+//
+// Records the reader is still ahead of the writer's head on are kept where
+// they are, but if the writer has moved its head past the reader (by
+// overwriting them, or by a Reset), the reader jumps ahead to it. If the
+// writer's generation has changed (such as after a Compact), the sequence
+// numbers don't line up with the reader's any more, so it starts again
+// from the writer's head.
+func (r *Ring) follow() {
+	state, ok := r.notifier.read()
+	if !ok || state == r.notifier.state {
+		return
+	}
+	tail := uintptr(state.tail)
+	head, headSeq := *r.head, r.header.headSeq
+
+	if state.generation != r.header.generation || state.rewritten != r.notifier.state.rewritten {
+		r.reread(0, r.size)
+	} else {
+		start := *r.tail
+		if state.headSeq > r.header.tailSeq {
+			start = uintptr(state.head)
+		}
+		r.reread(start, (tail+r.size-start)%r.size)
+	}
+	if state.generation != r.header.generation || state.headSeq >= headSeq {
+		head, headSeq = uintptr(state.head), state.headSeq
+	}
+
+	r.beginUpdate()
+	r.setHead(head, headSeq)
+	r.setTail(tail, state.tailSeq)
+	r.header.last = uintptr(state.last)
+	if state.generation != r.header.generation {
+		r.header.generation = state.generation
+		r.consumed()
+	}
+	r.endUpdate()
+	r.notifier.state = state
+
+	if r.len() > 0 && r.waiting > 0 {
+		close(r.wakeup)
+		r.wakeup = make(chan struct{})
+	}
+}
+
+// followInBackground picks up the writer's cursor, for the watcher.
+func (r *Ring) followInBackground() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.follow()
+}
+
+// notifyErr will return the first error writing the NotifyFile, if there
+// was one, and clear it.
+func (r *Ring) notifyErr() error {
+	if r.notifier == nil {
+		return nil
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	err := r.notifier.err
+	r.notifier.err = nil
+	return err
+}
+
+// vim: foldmethod=marker
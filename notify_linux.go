@@ -0,0 +1,81 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+//go:build linux
+// +build linux
+
+package diskring
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// inotify is a watcher using an inotify instance watching one file.
+type inotify struct {
+	fd   *os.File
+	done chan struct{}
+}
+
+// watchFile will call f every time the file at path is written to, until
+// the returned watcher is closed. If inotify can't be used (such as when
+// the user is out of watches), this falls back to calling f every interval.
+func watchFile(path string, interval time.Duration, f func()) watcher {
+	fd, err := syscall.InotifyInit1(syscall.IN_NONBLOCK | syscall.IN_CLOEXEC)
+	if err != nil {
+		return newPeriodic(interval, f)
+	}
+	if _, err := syscall.InotifyAddWatch(fd, path, syscall.IN_MODIFY|syscall.IN_ATTRIB); err != nil {
+		syscall.Close(fd)
+		return newPeriodic(interval, f)
+	}
+	// Since the fd is non-blocking, the os package hands it to the
+	// runtime's poller, so that Close wakes up the Read in run.
+	w := &inotify{
+		fd:   os.NewFile(uintptr(fd), "inotify"),
+		done: make(chan struct{}),
+	}
+	go w.run(f)
+	return w
+}
+
+// run will call f every time there are events to read, until the inotify
+// instance is closed. There's only the one file being watched, so there's
+// no need to look at what the events are.
+func (w *inotify) run(f func()) {
+	defer close(w.done)
+	buf := make([]byte, 4096)
+	for {
+		if _, err := w.fd.Read(buf); err != nil {
+			return
+		}
+		f()
+	}
+}
+
+// Close will stop watching the file, waiting for any call to f that's
+// already running to finish.
+func (w *inotify) Close() {
+	w.fd.Close()
+	<-w.done
+}
+
+// vim: foldmethod=marker
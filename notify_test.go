@@ -0,0 +1,71 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNotifyFile(t *testing.T) {
+	path := testRingPath(t)
+	notifyFile := path + ".notify"
+	w := openTestRingAt(t, path, Options{ReserveHeader: true, NotifyFile: notifyFile})
+	defer w.Close()
+	writeRecords(t, w, "one")
+
+	r := openTestRingAt(t, path, Options{
+		ReserveHeader:  true,
+		ReadOnlyCursor: true,
+		NotifyFile:     notifyFile,
+		ReadTimeout:    5 * time.Second,
+	})
+	defer r.Close()
+	if record := readRecord(t, r); record != "one" {
+		t.Fatalf("expected one, got %q", record)
+	}
+
+	// The reader is woken up by the writer, rather than only seeing what
+	// was in the Ring when it was opened.
+	records := make(chan string)
+	go func() {
+		buf := make([]byte, 16)
+		n, err := r.Read(buf)
+		if err != nil {
+			records <- err.Error()
+			return
+		}
+		records <- string(buf[:n])
+	}()
+	time.Sleep(10 * time.Millisecond)
+	writeRecords(t, w, "two")
+	if record := <-records; record != "two" {
+		t.Fatalf("expected two, got %q", record)
+	}
+	if w.Records() != 2 {
+		t.Fatalf("expected the reader to leave the writer's records alone, got %d", w.Records())
+	}
+	if _, err := r.Write([]byte("three")); err == nil {
+		t.Fatal("expected the reader not to be able to write")
+	}
+}
+
+// vim: foldmethod=marker
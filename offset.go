@@ -0,0 +1,83 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+// Offset is the logical position of a record in the Ring, which is its
+// sequence number. Unlike where the record is in the file, this only ever
+// goes up, so it can be held onto (say, by another data structure pointing
+// at buffered records) and used to find the record again with ReadOffset,
+// for as long as it's still in the Ring.
+type Offset uint64
+
+// Append will write a block of data into the disk ring, just like Write,
+// returning the Offset of the record written.
+func (r *Ring) Append(buf []byte) (Offset, error) {
+	if err := r.validate(buf); err != nil {
+		return 0, err
+	}
+
+	r.writeMutex.Lock()
+	defer r.writeMutex.Unlock()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	off := Offset(r.header.tailSeq)
+	if _, err := r.write(r.newEnvelope(), buf); err != nil {
+		return 0, err
+	}
+	return off, nil
+}
+
+// ReadOffset will return a copy of the record at the provided Offset,
+// without consuming anything. If the record has already been consumed or
+// overwritten, this will return an EvictedError, and if it hasn't been
+// written yet, an ErrOutOfRange. If the record is a batch of coalesced
+// records (see Options.Coalesce), this returns ErrCoalesced.
+//
+// With the Index option, this finds the record right away; otherwise the
+// Ring is walked from the head to find it.
+func (r *Ring) ReadOffset(off Offset) ([]byte, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if uint64(off) < r.header.headSeq {
+		return nil, &EvictedError{Offset: off, Oldest: Offset(r.header.headSeq)}
+	}
+	if uint64(off) >= r.header.tailSeq {
+		return nil, ErrOutOfRange
+	}
+
+	entry, err := r.findSequence(uint64(off))
+	if err != nil {
+		return nil, err
+	}
+	if r.entryFlags(entry)&flagBatch != 0 {
+		return nil, ErrCoalesced
+	}
+	data, err := r.recordData(entry)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{}, data...), nil
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,82 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestReadOffset(t *testing.T) {
+	for name, options := range map[string]Options{
+		"walk":  {CreateSize: 4096},
+		"index": {CreateSize: 4096, Index: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := openTestRing(t, options)
+			for i, record := range []string{"one", "two", "three"} {
+				off, err := r.Append([]byte(record))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if off != Offset(i) {
+					t.Fatalf("expected %s at offset %d, got %d", record, i, off)
+				}
+			}
+			data, err := r.ReadOffset(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != "two" {
+				t.Fatalf("expected two, got %q", data)
+			}
+			if _, err := r.ReadOffset(3); err != ErrOutOfRange {
+				t.Fatalf("expected ErrOutOfRange, got %v", err)
+			}
+
+			overwriteRecords(t, r, 2)
+			_, evictedErr := r.ReadOffset(1)
+			if !errors.Is(evictedErr, ErrEvicted) {
+				t.Fatalf("expected ErrEvicted, got %v", evictedErr)
+			}
+			stats, err := r.Stats()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if evicted, ok := evictedErr.(*EvictedError); !ok || evicted.Oldest != Offset(stats.HeadSequence) {
+				t.Fatalf("expected the oldest offset to be %d, got %v", stats.HeadSequence, evictedErr)
+			}
+		})
+	}
+}
+
+func TestReadOffsetCoalesced(t *testing.T) {
+	r := openTestRing(t, Options{Coalesce: 64})
+	writeRecords(t, r, "one", "two")
+	if err := r.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.ReadOffset(0); err != ErrCoalesced {
+		t.Fatalf("expected ErrCoalesced, got %v", err)
+	}
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,64 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"os"
+)
+
+// FileOwner is the owner and group to give files the Ring creates (see
+// Options.FileOwner). Either can be -1 to leave it alone, just like
+// chown(2).
+type FileOwner struct {
+	UID int
+	GID int
+}
+
+// filePerms is the mode and owner to give files the Ring creates, if the
+// options asked for either.
+type filePerms struct {
+	mode  os.FileMode
+	owner *FileOwner
+}
+
+// newFilePerms will return the filePerms the options ask for.
+func newFilePerms(options Options) filePerms {
+	return filePerms{mode: options.FileMode, owner: options.FileOwner}
+}
+
+// apply will set the mode and owner of a file that was just created. The
+// mode is set with chmod, rather than when the file is created, so that it
+// isn't masked by the umask.
+func (p filePerms) apply(fd *os.File) error {
+	if p.mode != 0 {
+		if err := fd.Chmod(p.mode); err != nil {
+			return err
+		}
+	}
+	if p.owner != nil {
+		if err := fd.Chown(p.owner.UID, p.owner.GID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,78 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestFileMode(t *testing.T) {
+	path := testRingPath(t)
+	// 0666 would usually be masked by the umask, if it were set when the
+	// file was created.
+	r := openTestRing(t, Options{FileMode: 0666})
+	c, err := r.Cursor("audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	for _, created := range []string{r.file.Name(), r.file.Name() + ".audit.cursor"} {
+		stat, err := os.Stat(created)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stat.Mode().Perm() != 0666 {
+			t.Fatalf("expected %s to be 0666, got %o", created, stat.Mode().Perm())
+		}
+	}
+
+	// The mode of a file that's already there is left alone.
+	if err := ioutil.WriteFile(path, make([]byte, 1<<16), 0600); err != nil {
+		t.Fatal(err)
+	}
+	r = openTestRingAt(t, path, Options{FileMode: 0666})
+	defer r.Close()
+	if stat, err := os.Stat(path); err != nil || stat.Mode().Perm() != 0600 {
+		t.Fatalf("expected the existing file to stay 0600 (%v)", err)
+	}
+}
+
+func TestFileOwner(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("only root can give files away")
+	}
+	r := openTestRing(t, Options{FileOwner: &FileOwner{UID: 1234, GID: 5678}})
+	stat, err := os.Stat(r.file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sys := stat.Sys().(*syscall.Stat_t)
+	if sys.Uid != 1234 || sys.Gid != 5678 {
+		t.Fatalf("expected the file to be owned by 1234:5678, got %d:%d", sys.Uid, sys.Gid)
+	}
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,293 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+//go:build diskring_portable || wasip1
+// +build diskring_portable wasip1
+
+package diskring
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+)
+
+// This is the portable backend, which is used when building with the
+// diskring_portable tag, and always for wasip1, since WASM runtimes only
+// hand out plain file descriptors to a sandboxed filesystem. Rather than
+// mapping the file, it's read into a buffer on the heap (twice over, just
+// like the mmap backend's mirror), and every change is written back to the
+// file with plain old writes. That's a lot slower, but it doesn't need mmap,
+// or any syscalls past the ones the os package makes, so it'll work anywhere
+// Go does.
+//
+// Only one Ring can have a file open at a time, since nothing else will see
+// the changes until they're written back, and locking (the Lock option)
+// isn't supported. The exception is a reader with ReadOnlyCursor and a
+// NotifyFile, which reads back whatever the writer says it wrote.
+
+// errNoLock is returned when the Lock option is used with the portable
+// backend.
+var errNoLock = errors.New("diskring: file locking isn't supported by the portable backend")
+
+// errNoLease is returned when the WriteLease option is used with the
+// portable backend.
+var errNoLease = errors.New("diskring: write leases aren't supported by the portable backend")
+
+// backend is the state the portable backend keeps for a Ring.
+type backend struct {
+	segments []segment
+
+	// err is the first error writing changes back to the file, which is
+	// returned by the next Sync.
+	errMutex sync.Mutex
+	err      error
+}
+
+// newBackend will create the backend state for a Ring over the segments.
+func newBackend(segments []segment) backend {
+	return backend{segments: segments}
+}
+
+// pageSize will return the size of the header. There's no memory mapping to
+// line up with here, so this is always 4096, which is what the mmap backend
+// uses on most platforms, so that files can be shared between the two.
+func pageSize() int {
+	return 4096
+}
+
+// fileSize will return the number of bytes of the file that can be used to
+// back the ring.
+func fileSize(fd *os.File) (int64, error) {
+	stat, err := fd.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return stat.Size(), nil
+}
+
+// canLock isn't set, since locking isn't supported by the portable backend.
+const canLock = false
+
+// lockFile isn't supported by the portable backend.
+func lockFile(ctx context.Context, fd *os.File, exclusive bool) error {
+	return errNoLock
+}
+
+// shareHeader isn't supported by the portable backend, since each Ring has
+// its own copy of the header.
+func shareHeader() error {
+	return errNoLease
+}
+
+// unlockFiles has nothing to do, since the files can't have been locked.
+func unlockFiles(files []*os.File) error {
+	return nil
+}
+
+// openTmpfile isn't supported by the portable backend, so temporary files
+// are always created with a name, and then unlinked.
+func openTmpfile(dir string) (*os.File, error) {
+	return nil, errNoTmpfile
+}
+
+// mapHeader will read the first size bytes of the file, which hold the
+// header.
+func mapHeader(fd *os.File, size uintptr) ([]byte, error) {
+	page := make([]byte, size)
+	if err := readFull(fd, page, 0); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// mapRing will read all of the segments into memory back to back, twice
+// over, to match the mmap backend's mirror. size is the total size of all
+// the segments. Since nothing is mapped, there's nothing for the alignment
+// or the mmap flags to do.
+func mapRing(segments []segment, size, align uintptr, flags int) ([]byte, error) {
+	buf := make([]byte, size<<1)
+	var base uintptr
+	for _, segment := range segments {
+		if err := readFull(segment.fd, buf[base:base+segment.size], segment.offset); err != nil {
+			return nil, err
+		}
+		base += segment.size
+	}
+	copy(buf[size:], buf[:size])
+	return buf, nil
+}
+
+// unmap will write the header back one last time, to catch any changes
+// made outside of a move of the cursor (such as to the UserHeader). The
+// data was written back as it changed.
+func (r *Ring) unmap() error {
+	if r.headerPage == nil || r.readOnly {
+		return nil
+	}
+	_, err := r.file.WriteAt(r.headerPage, 0)
+	return err
+}
+
+// UNSAFE
+//
+// Note that the entry at the provided offset, which takes up size bytes,
+// was just written. Anything written past the end of the first copy of the
+// ring is copied back to the start (and the other way around), to keep the
+// two copies the same, and then the entry is written back to the file.
+func (r *Ring) written(off, size uintptr) {
+	end := off + size
+	if end > r.size {
+		copy(r.buf, r.buf[r.size:end])
+		r.writeBack(0, end-r.size)
+		end = r.size
+	}
+	copy(r.buf[r.size+off:], r.buf[off:end])
+	r.writeBack(off, end-off)
+}
+
+// UNSAFE
+//
+// Write length bytes of the ring's data at the provided offset back to the
+// segments of the files they came from. This must not run off the end of
+// the first copy of the ring.
+func (r *Ring) writeBack(off, length uintptr) {
+	var base uintptr
+	for _, segment := range r.backend.segments {
+		// This segment holds [base, base+segment.size) of the ring.
+		start, end := off, off+length
+		if start < base {
+			start = base
+		}
+		if end > base+segment.size {
+			end = base + segment.size
+		}
+		if start < end {
+			_, err := segment.fd.WriteAt(r.buf[start:end], segment.offset+int64(start-base))
+			r.failed(err)
+		}
+		base += segment.size
+	}
+}
+
+// UNSAFE
+//
+// Note that size bytes at the provided offset were just written by another
+// process, and read them back in from the file, keeping the two copies of
+// the ring the same.
+func (r *Ring) reread(off, size uintptr) {
+	end := off + size
+	if end > r.size {
+		r.readBack(0, end-r.size)
+		copy(r.buf[r.size:end], r.buf[:end-r.size])
+		end = r.size
+	}
+	r.readBack(off, end-off)
+	copy(r.buf[r.size+off:], r.buf[off:end])
+}
+
+// UNSAFE
+//
+// Read length bytes of the ring's data at the provided offset in from the
+// segments of the files they came from, just like writeBack the other way.
+func (r *Ring) readBack(off, length uintptr) {
+	var base uintptr
+	for _, segment := range r.backend.segments {
+		start, end := off, off+length
+		if start < base {
+			start = base
+		}
+		if end > base+segment.size {
+			end = base + segment.size
+		}
+		if start < end {
+			r.failed(readFull(segment.fd, r.buf[start:end], segment.offset+int64(start-base)))
+		}
+		base += segment.size
+	}
+}
+
+// UNSAFE
+//
+// Note that the header was just changed, and write it back to the file.
+func (r *Ring) headerWritten() {
+	if r.headerPage == nil || r.readOnly {
+		return
+	}
+	_, err := r.file.WriteAt(r.headerPage, 0)
+	r.failed(err)
+}
+
+// failed will hold on to the first error writing changes back to the file,
+// so that the next Sync can return it.
+func (r *Ring) failed(err error) {
+	if err == nil {
+		return
+	}
+	r.backend.errMutex.Lock()
+	defer r.backend.errMutex.Unlock()
+	if r.backend.err == nil {
+		r.backend.err = err
+	}
+}
+
+// syncHeader will write the header page back to the file, and flush it out
+// to disk, blocking until that's done.
+func (r *Ring) syncHeader() error {
+	if !r.readOnly {
+		if _, err := r.file.WriteAt(r.headerPage, 0); err != nil {
+			return err
+		}
+	}
+	return r.file.Sync()
+}
+
+// syncData will flush the ring's data out to disk, and block until that's
+// done. If anything failed to be written back since the last time, this
+// returns that error instead.
+func (r *Ring) syncData() error {
+	r.backend.errMutex.Lock()
+	err := r.backend.err
+	r.backend.err = nil
+	r.backend.errMutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for _, file := range r.files {
+		if err := file.Sync(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fsyncAsync always returns nil, since there's no io_uring to use.
+func (r *Ring) fsyncAsync() []<-chan error {
+	return nil
+}
+
+// prefetch has nothing to do, since the whole ring is already in memory.
+func (r *Ring) prefetch(off, length uintptr) error {
+	return nil
+}
+
+// vim: foldmethod=marker
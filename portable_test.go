@@ -0,0 +1,89 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+//go:build diskring_portable || wasip1
+// +build diskring_portable wasip1
+
+package diskring
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"testing"
+)
+
+func TestClearWritesBack(t *testing.T) {
+	r := openTestRing(t, Options{})
+	path := r.file.Name()
+	secret := []byte("hunter2hunter2")
+	if _, err := r.Write(secret); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, secret) {
+		t.Fatal("record could still be read from the file after Clear")
+	}
+}
+
+func TestWrapWritesBack(t *testing.T) {
+	path := testRingPath(t)
+	r := openTestRingAt(t, path, Options{CreateSize: 8192, ReserveHeader: true, NonBlockingReads: true})
+	// Records that wrap around the end of the Ring are split over both
+	// ends of the file, so both halves have to make it there.
+	var want []string
+	for i := 0; i < 60; i++ {
+		record := fmt.Sprintf("%0150d", i)
+		writeRecords(t, r, record)
+		want = append(want, record)
+		if len(want) > 10 {
+			readRecord(t, r)
+			want = want[1:]
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r = openTestRingAt(t, path, Options{ReserveHeader: true, NonBlockingReads: true})
+	defer r.Close()
+	for _, record := range want {
+		if got := readRecord(t, r); got != record {
+			t.Fatalf("expected %q, got %q", record, got)
+		}
+	}
+}
+
+// vim: foldmethod=marker
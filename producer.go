@@ -0,0 +1,99 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"errors"
+)
+
+var (
+	// ErrDuplicate is returned by WriteSequence when the producer has
+	// already written a record with that sequence number (or a later one).
+	// The record was not written again, so it's safe to treat this as
+	// a successful write when retrying.
+	ErrDuplicate = errors.New("diskring: duplicate producer sequence")
+
+	// ErrTooManyProducers is returned when every producer slot in the
+	// header is in use.
+	ErrTooManyProducers = errors.New("diskring: too many producers")
+
+	// ErrInvalidProducer is returned when a producer ID of 0 is used.
+	ErrInvalidProducer = errors.New("diskring: producer id must not be 0")
+)
+
+// WriteSequence will write a block of data into the disk ring on behalf of
+// a producer, tagged with a per-producer sequence number. The ring will
+// remember the last sequence written for each producer, and any write with
+// a sequence number at or below that will be dropped with an ErrDuplicate.
+//
+// This allows a producer to safely retry writes (say, after a crash) without
+// double-recording the same record. If the ring has a header on disk, the
+// last sequence numbers will be persisted along with the cursor, otherwise
+// they're only remembered for the lifetime of this Ring.
+func (r *Ring) WriteSequence(producer, sequence uint64, buf []byte) (int, error) {
+	if producer == 0 {
+		return 0, ErrInvalidProducer
+	}
+	if err := r.validate(buf); err != nil {
+		return 0, err
+	}
+
+	r.writeMutex.Lock()
+	defer r.writeMutex.Unlock()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	slot := r.header.producer(producer)
+	if slot != nil && sequence <= slot.seq {
+		return 0, ErrDuplicate
+	}
+	if slot == nil {
+		slot = r.header.producer(0)
+		if slot == nil {
+			return 0, ErrTooManyProducers
+		}
+	}
+
+	n, err := r.write(r.newEnvelope(), buf)
+	if err != nil {
+		return n, err
+	}
+	slot.id = producer
+	slot.seq = sequence
+	return n, nil
+}
+
+// ProducerSequence will return the last sequence number written by the
+// provided producer, and if the ring has seen that producer at all. A
+// producer coming back up can use this to figure out where to resume from.
+func (r *Ring) ProducerSequence(producer uint64) (uint64, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	slot := r.header.producer(producer)
+	if slot == nil || producer == 0 {
+		return 0, false
+	}
+	return slot.seq, true
+}
+
+// vim: foldmethod=marker
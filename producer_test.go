@@ -0,0 +1,63 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"testing"
+)
+
+func TestWriteSequence(t *testing.T) {
+	path := testRingPath(t)
+	options := Options{ReserveHeader: true}
+	r := openTestRingAt(t, path, options)
+	for seq := uint64(1); seq <= 2; seq++ {
+		if _, err := r.WriteSequence(7, seq, []byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := r.WriteSequence(7, 2, []byte("again")); err != ErrDuplicate {
+		t.Fatalf("expected ErrDuplicate, got %v", err)
+	}
+	if _, err := r.WriteSequence(0, 1, []byte("hello")); err != ErrInvalidProducer {
+		t.Fatalf("expected ErrInvalidProducer, got %v", err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The last sequence number survives the Ring being opened again.
+	r = openTestRingAt(t, path, options)
+	defer r.Close()
+	if seq, ok := r.ProducerSequence(7); !ok || seq != 2 {
+		t.Fatalf("expected sequence 2, got %d (%v)", seq, ok)
+	}
+	if _, ok := r.ProducerSequence(8); ok {
+		t.Fatal("found a producer that never wrote")
+	}
+	if _, err := r.WriteSequence(7, 1, []byte("retry")); err != ErrDuplicate {
+		t.Fatalf("expected ErrDuplicate after reopening, got %v", err)
+	}
+	if r.Records() != 2 {
+		t.Fatalf("expected 2 records, got %d", r.Records())
+	}
+}
+
+// vim: foldmethod=marker
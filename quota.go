@@ -0,0 +1,269 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ErrQuotaExceeded is returned when a Producer writes more than its Quota
+// allows. The record was not written.
+var ErrQuotaExceeded = errors.New("diskring: producer quota exceeded")
+
+// Quota limits how much a single Producer can write to a Ring, so that when
+// a Ring is shared, one busy Producer can't push everyone else's records out.
+type Quota struct {
+	// BytesPerSecond is how fast the Producer can write, on average. A
+	// Producer can save up to a second's worth of bytes while it's idle.
+	//
+	// Default: 0 (no limit)
+	BytesPerSecond int
+
+	// Capacity is the largest fraction of the Ring (between 0 and 1) that
+	// the Producer's records can take up at once.
+	//
+	// Default: 0 (no limit)
+	Capacity float64
+}
+
+// Producer is a named writer to a Ring, whose writes are held to a Quota.
+// Create one with Ring.RegisterProducer.
+type Producer struct {
+	r     *Ring
+	id    uint16
+	name  string
+	quota Quota
+
+	// everything below is protected by the Ring's mutex.
+
+	// used is the number of bytes the Producer's records currently take
+	// up in the Ring, and records is how many of them there are.
+	used    uintptr
+	records int
+
+	// tokens is the number of bytes the Producer can write right now,
+	// which goes negative if it's written a large record, and refills
+	// at BytesPerSecond since refilled.
+	tokens   float64
+	refilled time.Time
+}
+
+// ownedRecord is a record written by a Producer that may still be in the
+// Ring.
+type ownedRecord struct {
+	seq      uint64
+	size     uintptr
+	producer *Producer
+}
+
+// RegisterProducer will create a new Producer with the provided ID and name,
+// whose writes to the Ring are held to the Quota. Each ID and each name can
+// only be registered once, and the ID must not be 0.
+//
+// If the ProducerIDs option is set, the ID is stored with every record the
+// Producer writes, so the same ID should be used for the same Producer
+// every time the Ring is opened.
+//
+// How much of the Ring each Producer is using is only tracked in memory,
+// so it starts from 0 each time the Ring is opened, no matter what's
+// already in the file.
+func (r *Ring) RegisterProducer(id uint16, name string, quota Quota) (*Producer, error) {
+	if id == 0 {
+		return nil, ErrInvalidProducer
+	}
+	if quota.BytesPerSecond < 0 || quota.Capacity < 0 || quota.Capacity > 1 {
+		return nil, fmt.Errorf("diskring: invalid quota for producer %q", name)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, p := range r.producers {
+		if p.name == name || p.id == id {
+			return nil, fmt.Errorf("diskring: producer %q (%d) is already registered", p.name, p.id)
+		}
+	}
+	p := &Producer{
+		r:        r,
+		id:       id,
+		name:     name,
+		quota:    quota,
+		tokens:   float64(quota.BytesPerSecond),
+		refilled: time.Now(),
+	}
+	if r.producers == nil {
+		r.producers = map[uint16]*Producer{}
+	}
+	r.producers[id] = p
+	return p, nil
+}
+
+// ID will return the ID the Producer was registered with.
+func (p *Producer) ID() uint16 {
+	return p.id
+}
+
+// Name will return the name the Producer was registered with.
+func (p *Producer) Name() string {
+	return p.name
+}
+
+// Write will write a block of data into the Ring, just like Ring.Write,
+// unless that would go over the Producer's Quota, in which case this will
+// return an ErrQuotaExceeded.
+func (p *Producer) Write(buf []byte) (int, error) {
+	r := p.r
+	if err := r.validate(buf); err != nil {
+		return 0, err
+	}
+
+	r.writeMutex.Lock()
+	defer r.writeMutex.Unlock()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if !p.allow(r.entrySize(uintptr(len(buf)))) {
+		return 0, ErrQuotaExceeded
+	}
+
+	e := r.newEnvelope()
+	e.producer = p.id
+
+	tail := *r.tail
+	n, err := r.write(e, buf)
+	if err != nil {
+		return n, err
+	}
+	// The entry might not be the size we expected, if it was padded or
+	// spilled, so see how far the tail actually moved.
+	size := (*r.tail + r.size - tail) % r.size
+	p.used += size
+	p.records++
+	p.tokens -= float64(size)
+	r.owned = append(r.owned, ownedRecord{
+		seq:      r.header.tailSeq - 1,
+		size:     size,
+		producer: p,
+	})
+	return n, nil
+}
+
+// UNSAFE
+//
+// Check if the Producer is allowed to write an entry that takes up size
+// bytes of the Ring.
+func (p *Producer) allow(size uintptr) bool {
+	r := p.r
+	if p.quota.Capacity > 0 {
+		if float64(p.used+size) > p.quota.Capacity*float64(r.size) {
+			return false
+		}
+	}
+	if rate := float64(p.quota.BytesPerSecond); rate > 0 {
+		now := time.Now()
+		p.tokens += now.Sub(p.refilled).Seconds() * rate
+		if p.tokens > rate {
+			p.tokens = rate
+		}
+		p.refilled = now
+		// Any record is allowed as long as we're not in debt, so that
+		// a record larger than a second's worth can still be written.
+		if p.tokens <= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// ProducerStats is how much of the Ring is taken up by the records of a
+// single Producer.
+type ProducerStats struct {
+	// ID is the ID of the Producer, and Name is the name it was
+	// registered with, which is "" if it hasn't been registered since the
+	// Ring was opened.
+	ID   uint16
+	Name string
+
+	// Records is the number of the Producer's records in the Ring, and
+	// Bytes is how many bytes they take up.
+	Records int
+	Bytes   int
+}
+
+// ProducerStats will walk the Ring, and add up how much of it is taken up
+// by the records of each Producer, using the ID stored with each record, so
+// this needs the ProducerIDs option to be set. Records that weren't written
+// by a Producer are counted under an ID of 0.
+//
+// This has to look at every record in the Ring, so it can take a while on a
+// large Ring.
+func (r *Ring) ProducerStats() ([]ProducerStats, error) {
+	if !r.layout.has(formatProducer) {
+		return nil, fmt.Errorf("diskring: producer IDs aren't stored in this ring")
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	byID := map[uint16]*ProducerStats{}
+	var ids []uint16
+	for off := *r.head; off != *r.tail; {
+		next := r.nextEntry(off)
+		id := r.entryProducer(off)
+		stats, ok := byID[id]
+		if !ok {
+			stats = &ProducerStats{ID: id}
+			if p, ok := r.producers[id]; ok {
+				stats.Name = p.name
+			}
+			byID[id] = stats
+			ids = append(ids, id)
+		}
+		stats.Records++
+		stats.Bytes += int((next + r.size - off) % r.size)
+		off = next
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	ret := make([]ProducerStats, len(ids))
+	for i, id := range ids {
+		ret[i] = *byID[id]
+	}
+	return ret, nil
+}
+
+// UNSAFE
+//
+// Stop counting any records that have left the Ring against the Producers
+// that wrote them.
+func (r *Ring) releaseOwned() {
+	for len(r.owned) > 0 && r.owned[0].seq < r.header.headSeq {
+		r.owned[0].producer.used -= r.owned[0].size
+		r.owned[0].producer.records--
+		r.owned = r.owned[1:]
+	}
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,133 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProducerCapacity(t *testing.T) {
+	r := openTestRing(t, Options{CreateSize: 4096, NonBlockingReads: true})
+	p, err := r.RegisterProducer(1, "chatty", Quota{Capacity: 0.25})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.RegisterProducer(2, "chatty", Quota{}); err == nil {
+		t.Fatal("registered the same name twice")
+	}
+	if _, err := r.RegisterProducer(0, "zero", Quota{}); err != ErrInvalidProducer {
+		t.Fatalf("expected ErrInvalidProducer, got %v", err)
+	}
+
+	record := []byte(strings.Repeat("x", 100))
+	written := 0
+	for {
+		if _, err := p.Write(record); err == ErrQuotaExceeded {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		written++
+	}
+	if written == 0 || r.Len() > r.Cap()/4 {
+		t.Fatalf("%d records took up %d of %d bytes", written, r.Len(), r.Cap())
+	}
+	// Everyone else can still write.
+	writeRecords(t, r, "someone else")
+
+	// Once a record has been read, there's room for another.
+	readRecord(t, r)
+	if _, err := p.Write(record); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProducerRate(t *testing.T) {
+	r := openTestRing(t, Options{})
+	p, err := r.RegisterProducer(1, "slow", Quota{BytesPerSecond: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A record larger than a second's worth is still written, but leaves
+	// the Producer in debt.
+	if _, err := p.Write(make([]byte, 200)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Write([]byte("more")); err != ErrQuotaExceeded {
+		t.Fatalf("expected ErrQuotaExceeded, got %v", err)
+	}
+	if r.Records() != 1 {
+		t.Fatalf("expected 1 record, got %d", r.Records())
+	}
+}
+
+func TestProducerStats(t *testing.T) {
+	r := openTestRing(t, Options{ProducerIDs: true, NonBlockingReads: true})
+	logs, err := r.RegisterProducer(1, "logs", Quota{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics, err := r.RegisterProducer(7, "metrics", Quota{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []*Producer{logs, metrics, logs} {
+		if _, err := p.Write([]byte(p.Name())); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeRecords(t, r, "anonymous")
+
+	stats, err := r.ProducerStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 3 {
+		t.Fatalf("expected 3 producers, got %+v", stats)
+	}
+	for i, want := range []ProducerStats{
+		{ID: 0, Records: 1},
+		{ID: 1, Name: "logs", Records: 2},
+		{ID: 7, Name: "metrics", Records: 1},
+	} {
+		if got := stats[i]; got.ID != want.ID || got.Name != want.Name || got.Records != want.Records || got.Bytes == 0 {
+			t.Fatalf("expected %+v, got %+v", want, got)
+		}
+	}
+
+	for _, want := range []uint16{1, 7, 1, 0} {
+		rec, err := r.ReadRecord()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec.Producer != want {
+			t.Fatalf("expected %q to be from producer %d, got %d", rec.Data, want, rec.Producer)
+		}
+	}
+
+	plain := openTestRing(t, Options{})
+	if _, err := plain.ProducerStats(); err == nil {
+		t.Fatal("expected an error without ProducerIDs")
+	}
+}
+
+// vim: foldmethod=marker
@@ -21,9 +21,10 @@
 package diskring
 
 import (
-	"fmt"
+	"context"
 	"io"
-	"unsafe"
+	"runtime/debug"
+	"time"
 )
 
 // Read up to len(buf) bytes from the buffer. This will return the number of
@@ -35,31 +36,188 @@ import (
 //
 // After the data is copied to the buf, the ring buffer head will be advanced.
 func (r *Ring) Read(buf []byte) (int, error) {
+	t := r.startTiming(OpRead)
+	defer t.done()
+
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
+	t.lap(PhaseLockWait)
+
+	if err := r.waitForRecord(); err != nil {
+		return 0, err
+	}
+	t.skip()
+	defer t.lap(PhaseCopy)
+	return r.readEntry(buf)
+}
+
+// UNSAFE
+//
+// If the ring is empty, wait for a record to be written, unless reads
+// aren't meant to block, in which case this returns an ErrEmpty or an
+// io.EOF, or nothing is written before the ReadTimeout, in which case this
+// returns an ErrTimeout. The caller must hold the mutex, which is dropped
+// while waiting.
+func (r *Ring) waitForRecord() error {
+	if r.fileErr != nil {
+		return r.fileErr
+	}
+	if r.len() != 0 {
+		return nil
+	}
+	switch {
+	case r.nonBlockingReads:
+		return ErrEmpty
+	case r.dontBlockReads:
+		return io.EOF
+	}
 
-	if r.len() == 0 {
-		switch r.dontBlockReads {
-		case false:
-			r.mutex.Unlock()
-			<-r.wakeup
-			r.mutex.Lock()
-		case true:
-			return 0, io.EOF
+	var timeout <-chan time.Time
+	if r.readTimeout > 0 {
+		timer := time.NewTimer(r.readTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	return r.waitForWrite(context.Background(), timeout)
+}
+
+// UNSAFE
+//
+// Wait until the ring isn't empty, giving up with an ErrTimeout if
+// anything comes in on timeout, or the context's error if it's done. The
+// caller must hold the mutex, which is dropped while waiting.
+func (r *Ring) waitForWrite(ctx context.Context, timeout <-chan time.Time) error {
+	for r.len() == 0 {
+		wakeup := r.wakeup
+		r.waiting++
+		r.mutex.Unlock()
+		var err error
+		select {
+		case <-wakeup:
+		case <-timeout:
+			err = ErrTimeout
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		r.mutex.Lock()
+		r.waiting--
+		if err == nil {
+			err = r.fileErr
 		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UNSAFE
+//
+// Copy the entry at the head into buf, and advance the head past it. The
+// ring must not be empty.
+func (r *Ring) readEntry(buf []byte) (int, error) {
+	if r.entryFlags(*r.head)&flagBatch != 0 {
+		return r.readBatched(buf)
+	}
+	m, err := r.copyEntry(*r.head, buf)
+	if err != nil {
+		return 0, err
+	}
+	if err := r.advanceHead(); err != nil {
+		return m, err
+	}
+	r.consumed()
+	return m, nil
+}
+
+// UNSAFE
+//
+// Return the number of records that were overwritten by writers since the
+// reader last consumed anything, which are records it'll never see.
+func (r *Ring) dropped() uint64 {
+	if r.header.headSeq <= r.readSeq {
+		return 0
+	}
+	return r.header.headSeq - r.readSeq
+}
+
+// UNSAFE
+//
+// Note that the reader has consumed everything up to the head, so that
+// anything overwritten from here on is counted as dropped.
+func (r *Ring) consumed() {
+	r.readSeq = r.header.headSeq
+}
+
+// Overwritten will return the number of records that have been overwritten
+// by writers to make room for new records since the Ring was opened,
+// whether or not anything was ever going to read them.
+func (r *Ring) Overwritten() uint64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.overwritten
+}
+
+// UNSAFE
+//
+// Copy the data of the entry at the provided offset into buf, if it fits.
+func (r *Ring) copyEntry(off uintptr, buf []byte) (n int, err error) {
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer r.catchFault(&err)
+
+	data, err := r.recordData(off)
+	if err != nil {
+		return 0, err
 	}
 
-	length := *(*uintptr)(unsafe.Pointer(&r.buf[r.cursor.head]))
+	if len(buf) < len(data) {
+		return 0, &ShortBufferError{Need: len(data), Have: len(buf)}
+	}
 
-	if len(buf) < int(length) {
-		return 0, fmt.Errorf(
-			"buffer isn't large enough to hold chunk (need=%d, have=%d)",
-			length, len(buf),
-		)
+	return copy(buf, data), nil
+}
+
+// PeekLast will copy the most recently written record into buf, without
+// consuming anything from the Ring. This is handy for something that only
+// cares about the latest value, rather than the whole history.
+//
+// PeekLast won't block; if the Ring is empty, this will return an io.EOF.
+func (r *Ring) PeekLast(buf []byte) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.empty() {
+		return 0, io.EOF
+	}
+	if off := r.lastEntry(); r.entryFlags(off)&flagBatch != 0 {
+		return r.peekBatched(off, buf)
 	}
+	return r.copyEntry(r.lastEntry(), buf)
+}
 
-	m := copy(buf, r.buf[r.cursor.head+uintptrSize:r.cursor.head+uintptrSize+length])
-	return m, r.advanceHead()
+// Skip will consume up to n records from the head of the Ring without copying
+// them out, returning the number of records skipped. This is handy for a
+// consumer that's fallen far behind and wants to jump ahead to more recent
+// records.
+//
+// Skip won't block; if the Ring is empty, this will return an io.EOF.
+func (r *Ring) Skip(n int) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.empty() {
+		return 0, io.EOF
+	}
+
+	skipped := 0
+	for skipped < n && !r.empty() {
+		if err := r.advanceHead(); err != nil {
+			return skipped, err
+		}
+		skipped++
+	}
+	r.consumed()
+	return skipped, nil
 }
 
 // vim: foldmethod=marker
@@ -0,0 +1,157 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"fmt"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestSkip(t *testing.T) {
+	r := openTestRing(t, Options{})
+	writeRecords(t, r, "one", "two", "three", "four")
+
+	if n, err := r.Skip(2); n != 2 || err != nil {
+		t.Fatalf("expected to skip 2 records, skipped %d (%v)", n, err)
+	}
+	if record := readRecord(t, r); record != "three" {
+		t.Fatalf("expected three, got %q", record)
+	}
+	if n, err := r.Skip(10); n != 1 || err != nil {
+		t.Fatalf("expected to skip the 1 record left, skipped %d (%v)", n, err)
+	}
+	if _, err := r.Skip(1); err != io.EOF {
+		t.Fatalf("expected io.EOF from an empty ring, got %v", err)
+	}
+}
+
+func TestPeekLast(t *testing.T) {
+	for _, options := range []Options{{}, {Coalesce: 64}} {
+		r := openTestRing(t, options)
+		buf := make([]byte, 64)
+		if _, err := r.PeekLast(buf); err != io.EOF {
+			t.Fatalf("expected io.EOF from an empty ring, got %v", err)
+		}
+		writeRecords(t, r, "one", "two")
+		if err := r.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		n, err := r.PeekLast(buf)
+		if err != nil || string(buf[:n]) != "two" {
+			t.Fatalf("expected two, got %q (%v)", buf[:n], err)
+		}
+		if record := readRecord(t, r); record != "one" {
+			t.Fatalf("PeekLast consumed a record, read %q", record)
+		}
+	}
+}
+
+// overwriteRecords will write 100 byte records to the Ring until at least n
+// of them have been overwritten.
+func overwriteRecords(t *testing.T, r *Ring, n uint64) {
+	t.Helper()
+	for i := 0; r.Overwritten() < n; i++ {
+		writeRecords(t, r, fmt.Sprintf("%0100d", i))
+	}
+}
+
+func TestDropped(t *testing.T) {
+	r := openTestRing(t, Options{CreateSize: 4096, NonBlockingReads: true})
+	overwriteRecords(t, r, 5)
+	dropped := r.Overwritten()
+
+	rec, err := r.ReadRecord()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Dropped != dropped {
+		t.Fatalf("expected %d records to be dropped, got %d", dropped, rec.Dropped)
+	}
+	if rec, err = r.ReadRecord(); err != nil || rec.Dropped != 0 {
+		t.Fatalf("expected nothing more to be dropped, got %d (%v)", rec.Dropped, err)
+	}
+
+	overwriteRecords(t, r, dropped+3)
+	_, batch := fetchStrings(t, r, FetchOptions{MaxRecords: 1})
+	if want := r.Overwritten() - dropped; batch.Dropped != want {
+		t.Fatalf("expected %d records to be dropped, got %d", want, batch.Dropped)
+	}
+}
+
+func TestNonBlockingReads(t *testing.T) {
+	buf := make([]byte, 16)
+	r := openTestRing(t, Options{NonBlockingReads: true})
+	if _, err := r.Read(buf); err != ErrEmpty {
+		t.Fatalf("expected ErrEmpty, got %v", err)
+	}
+	r = openTestRing(t, Options{DontBlockReads: true})
+	if _, err := r.Read(buf); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+
+	// By default, Read waits for a record to be written.
+	r = openTestRing(t, Options{})
+	done := make(chan string)
+	go func() {
+		n, err := r.Read(buf)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- string(buf[:n])
+	}()
+	select {
+	case record := <-done:
+		t.Fatalf("expected Read to block, got %q", record)
+	case <-time.After(10 * time.Millisecond):
+	}
+	writeRecords(t, r, "late")
+	if record := <-done; record != "late" {
+		t.Fatalf("expected \"late\", got %q", record)
+	}
+}
+
+func TestReadTimeout(t *testing.T) {
+	r := openTestRing(t, Options{ReadTimeout: 20 * time.Millisecond})
+	buf := make([]byte, 16)
+	start := time.Now()
+	if _, err := r.Read(buf); err != ErrTimeout {
+		t.Fatalf("expected ErrTimeout, got %v", err)
+	}
+	if waited := time.Since(start); waited < 20*time.Millisecond {
+		t.Fatalf("expected Read to wait out the timeout, gave up after %s", waited)
+	}
+
+	// A record written before the timeout is up is read as usual.
+	r = openTestRing(t, Options{ReadTimeout: 5 * time.Second})
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		if _, err := r.Write([]byte("in time")); err != nil {
+			t.Error(err)
+		}
+	}()
+	if record := readRecord(t, r); record != "in time" {
+		t.Fatalf("expected \"in time\", got %q", record)
+	}
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,224 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNoFlags is returned when writing a record with flags to a Ring
+	// that doesn't store them (see Options.RecordFlags).
+	ErrNoFlags = errors.New("diskring: record flags aren't stored in this ring")
+
+	// ErrReservedFlags is returned when writing a record with any of the
+	// flags the library keeps for itself set.
+	ErrReservedFlags = errors.New("diskring: record flags use reserved bits")
+
+	// ErrNoContentTypes is returned when writing a record with a content
+	// type to a Ring that doesn't store them (see Options.ContentTypes).
+	ErrNoContentTypes = errors.New("diskring: content types aren't stored in this ring")
+
+	// ErrNoSchemaVersions is returned when writing a record with a schema
+	// version, or migrating records, in a Ring that doesn't store them
+	// (see Options.SchemaVersions).
+	ErrNoSchemaVersions = errors.New("diskring: schema versions aren't stored in this ring")
+)
+
+// Flags are bits stored alongside a record, saying how its data should be
+// treated. The library doesn't look at any of these itself; they're there
+// so that features can be layered on top of the Ring a record at a time,
+// without needing a new record format every time.
+type Flags uint16
+
+const (
+	// FlagCompressed marks a record whose data is compressed.
+	FlagCompressed Flags = 1 << iota
+
+	// FlagEncrypted marks a record whose data is encrypted.
+	FlagEncrypted
+
+	// FlagTombstone marks a record which deletes (or replaces) an earlier
+	// record, rather than carrying data of its own.
+	FlagTombstone
+
+	// FlagContinuation marks a record which carries on from the record
+	// before it.
+	FlagContinuation
+
+	// FlagUser is the first of the bits left for applications to use as
+	// they see fit, through to the top bit of Flags (FlagUser << 7).
+	FlagUser Flags = 1 << 8
+)
+
+// flagsReserved is the bits the library keeps for itself (or for flags it
+// adds later), which are never passed to or from the caller.
+const flagsReserved Flags = 0x00f0
+
+// Record is a single record, along with everything stored alongside it.
+type Record struct {
+	// Data is the data of the record.
+	Data []byte
+
+	// Key is the key stored alongside the record (see WriteKey), which is
+	// always empty if the Ring doesn't store keys.
+	Key []byte
+
+	// Flags are the flags stored alongside the record, which are always
+	// 0 if the Ring doesn't store flags.
+	Flags Flags
+
+	// ContentType says what kind of data the record holds, so that
+	// different kinds of records can share a Ring. This is always 0 if
+	// the Ring doesn't store content types.
+	ContentType ContentType
+
+	// Producer is the ID of the Producer that wrote the record, or 0 if
+	// it wasn't written by a Producer, or the Ring doesn't store producer
+	// IDs. Like Time, this is ignored when writing a record.
+	Producer uint16
+
+	// Schema is the version of the application's payload format the
+	// record was written in (see Migrate), which is always 0 if the Ring
+	// doesn't store schema versions. When writing a record, 0 means the
+	// Ring's SchemaVersion.
+	Schema uint8
+
+	// Dropped is the number of records that were overwritten by writers
+	// before they could be read, between the last record read and this
+	// one. This is only set by ReadRecord, and is ignored when writing a
+	// record.
+	Dropped uint64
+
+	// Time is when the record was written, which is the zero Time if
+	// the Ring doesn't store timestamps. This is ignored when writing a
+	// record.
+	Time time.Time
+}
+
+// WriteRecord will write a record into the disk ring, just like Write, but
+// with the flags, content type and key from the Record stored alongside the
+// data.
+func (r *Ring) WriteRecord(rec Record) (int, error) {
+	if rec.Flags&flagsReserved != 0 {
+		return 0, ErrReservedFlags
+	}
+	if rec.Flags != 0 && !r.layout.has(formatFlags) {
+		return 0, ErrNoFlags
+	}
+	if rec.ContentType != 0 && !r.layout.has(formatContentType) {
+		return 0, ErrNoContentTypes
+	}
+	if rec.Schema != 0 && !r.layout.has(formatSchema) {
+		return 0, ErrNoSchemaVersions
+	}
+	if err := r.checkKey(rec.Key); err != nil {
+		return 0, err
+	}
+	if err := r.validate(rec.Data); err != nil {
+		return 0, err
+	}
+
+	t := r.startTiming(OpWrite)
+	defer t.done()
+
+	r.writeMutex.Lock()
+	defer r.writeMutex.Unlock()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	t.lap(PhaseLockWait)
+	defer t.lap(PhaseCopy)
+
+	e := r.newEnvelope()
+	e.flags = uint16(rec.Flags)
+	e.contentType = uint16(rec.ContentType)
+	e.key = rec.Key
+	if rec.Schema != 0 {
+		e.schema = rec.Schema
+	}
+	return r.write(e, rec.Data)
+}
+
+// ReadRecord will read the record at the head of the Ring, along with
+// everything stored alongside it, and advance the head past it. This blocks
+// just like Read, but since a new buffer is allocated for the data, it can't
+// fail because the buffer is too small.
+func (r *Ring) ReadRecord() (Record, error) {
+	t := r.startTiming(OpRead)
+	defer t.done()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	t.lap(PhaseLockWait)
+
+	if err := r.waitForRecord(); err != nil {
+		return Record{}, err
+	}
+	t.skip()
+	defer t.lap(PhaseCopy)
+	rec, err := r.entryRecord(*r.head)
+	if err != nil {
+		return Record{}, err
+	}
+	rec.Dropped = r.dropped()
+	if r.entryFlags(*r.head)&flagBatch != 0 {
+		data, next, err := r.batched.next(r, *r.head, r.header.headSeq)
+		if err != nil {
+			return Record{}, err
+		}
+		rec.Data = append([]byte{}, data...)
+		return rec, r.pastBatched(next)
+	}
+	if err := r.advanceHead(); err != nil {
+		return Record{}, err
+	}
+	r.consumed()
+	return rec, nil
+}
+
+// UNSAFE
+//
+// Copy out the record in the entry at the provided offset, along with its
+// envelope.
+func (r *Ring) entryRecord(off uintptr) (Record, error) {
+	data, err := r.recordData(off)
+	if err != nil {
+		return Record{}, err
+	}
+	rec := Record{
+		Data:        append([]byte{}, data...),
+		Flags:       Flags(r.entryFlags(off)) &^ flagsReserved,
+		ContentType: ContentType(r.entryContentType(off)),
+		Producer:    r.entryProducer(off),
+		Schema:      r.entrySchema(off),
+	}
+	if key := r.entryKey(off); len(key) > 0 {
+		rec.Key = append([]byte{}, key...)
+	}
+	if r.layout.has(formatTimestamp) {
+		rec.Time = time.Unix(0, r.entryTime(off))
+	}
+	return rec, nil
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,59 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"testing"
+)
+
+func TestRecordFlags(t *testing.T) {
+	r := openTestRing(t, Options{RecordFlags: true, NonBlockingReads: true})
+	records := []Record{
+		{Data: []byte("plain")},
+		{Data: []byte("gone"), Flags: FlagTombstone},
+		{Data: []byte("mine"), Flags: FlagCompressed | FlagUser<<3},
+	}
+	for _, rec := range records {
+		if _, err := r.WriteRecord(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := r.WriteRecord(Record{Data: []byte("x"), Flags: 0x0010}); err != ErrReservedFlags {
+		t.Fatalf("expected ErrReservedFlags, got %v", err)
+	}
+
+	for _, want := range records {
+		rec, err := r.ReadRecord()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(rec.Data) != string(want.Data) || rec.Flags != want.Flags {
+			t.Fatalf("expected %q with flags %x, got %q with flags %x", want.Data, want.Flags, rec.Data, rec.Flags)
+		}
+	}
+
+	plain := openTestRing(t, Options{})
+	if _, err := plain.WriteRecord(Record{Data: []byte("x"), Flags: FlagTombstone}); err != ErrNoFlags {
+		t.Fatalf("expected ErrNoFlags, got %v", err)
+	}
+}
+
+// vim: foldmethod=marker
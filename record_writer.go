@@ -0,0 +1,144 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"errors"
+)
+
+// ErrRecordDone is returned when using a RecordWriter after it's been
+// committed or aborted.
+var ErrRecordDone = errors.New("diskring: record has already been committed or aborted")
+
+// RecordWriter streams the data of a single record into the Ring, a bit at a
+// time. The record only becomes visible to readers once it's committed.
+//
+// While a RecordWriter is open, all other writes to the Ring will wait
+// until it's been committed or aborted, so be sure to always do one or the
+// other.
+type RecordWriter struct {
+	r      *Ring
+	off    uintptr
+	length uintptr
+	done   bool
+}
+
+// BeginRecord will start a new record, which can be written to a bit at a
+// time, such as when serializing something large straight into the Ring
+// without building it up in memory first. The same size limit as Ring.Write
+// applies to the record as a whole.
+//
+// Space for the record is reserved as it's written, which may overwrite the
+// oldest records in the Ring, even if the record is aborted later.
+func (r *Ring) BeginRecord() (*RecordWriter, error) {
+	r.writeMutex.Lock()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if err := r.checkWrite(0); err != nil {
+		r.writeMutex.Unlock()
+		return nil, err
+	}
+	if err := r.flushBatch(); err != nil {
+		r.writeMutex.Unlock()
+		return nil, err
+	}
+	return &RecordWriter{r: r, off: r.stageOffset()}, nil
+}
+
+// Write will append buf to the data of the record.
+func (w *RecordWriter) Write(buf []byte) (int, error) {
+	if w.done {
+		return 0, ErrRecordDone
+	}
+
+	r := w.r
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if limit := r.maxRecord; w.length+uintptr(len(buf)) > limit {
+		return 0, &TooLargeError{
+			Size:  int(w.length) + len(buf),
+			Limit: int(limit),
+		}
+	}
+	if err := r.reserve(w.length + uintptr(len(buf))); err != nil {
+		return 0, err
+	}
+
+	start := w.off + r.layout.wordSize + r.layout.envelopeSize + w.length
+	m := copy(r.buf[start:], buf)
+	w.length += uintptr(m)
+	return m, nil
+}
+
+// Len will return the number of bytes written to the record so far.
+func (w *RecordWriter) Len() int {
+	return int(w.length)
+}
+
+// Commit will finish the record, and make it visible to readers. If the
+// ValidateWrite hook rejects the record, it's aborted.
+func (w *RecordWriter) Commit() error {
+	if w.done {
+		return ErrRecordDone
+	}
+
+	r := w.r
+	// Nothing else touches the data after the tail while we hold the
+	// writeMutex, so we can look at it without the mutex.
+	start := w.off + r.layout.wordSize + r.layout.envelopeSize
+	if err := r.validate(r.buf[start : start+w.length]); err != nil {
+		w.finish()
+		return err
+	}
+
+	r.mutex.Lock()
+	// If nothing was written, we haven't reserved any room yet.
+	if err := r.reserve(w.length); err != nil {
+		r.mutex.Unlock()
+		return err
+	}
+	r.publish(r.sealEntry(w.off, 0, r.newEnvelope(), w.length), 1, w.off)
+	r.mutex.Unlock()
+
+	w.finish()
+	return nil
+}
+
+// Abort will throw away the record.
+func (w *RecordWriter) Abort() error {
+	if w.done {
+		return ErrRecordDone
+	}
+	w.finish()
+	return nil
+}
+
+// finish will mark the RecordWriter as done, and release the Ring for other
+// writers.
+func (w *RecordWriter) finish() {
+	w.done = true
+	w.r.writeMutex.Unlock()
+}
+
+// vim: foldmethod=marker
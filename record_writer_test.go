@@ -0,0 +1,80 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRecordWriter(t *testing.T) {
+	r := openTestRing(t, Options{CreateSize: 4096, NonBlockingReads: true})
+	// Move the tail along, so the record wraps around the end.
+	for i := 0; i < 3; i++ {
+		writeRecords(t, r, string(bytes.Repeat([]byte{'x'}, 900)))
+		readRecord(t, r)
+	}
+
+	w, err := r.BeginRecord()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []byte
+	for _, chunk := range []string{"streamed ", "in ", "pieces "} {
+		for i := 0; i < 50; i++ {
+			if _, err := w.Write([]byte(chunk)); err != nil {
+				t.Fatal(err)
+			}
+			want = append(want, chunk...)
+		}
+	}
+	if w.Len() != len(want) {
+		t.Fatalf("expected %d bytes written, got %d", len(want), w.Len())
+	}
+	if r.Records() != 0 {
+		t.Fatal("the record was visible before it was committed")
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("late")); err != ErrRecordDone {
+		t.Fatalf("expected ErrRecordDone, got %v", err)
+	}
+	if record := readRecord(t, r); record != string(want) {
+		t.Fatalf("expected the streamed record, got %q", record)
+	}
+
+	w, err = r.BeginRecord()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("aborted")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Abort(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Read(make([]byte, 64)); err != ErrEmpty {
+		t.Fatalf("expected the aborted record to be gone, got %v", err)
+	}
+}
+
+// vim: foldmethod=marker
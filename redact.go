@@ -0,0 +1,185 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"fmt"
+	"os"
+)
+
+// Redact will scrub records in the Ring in place, such as when something
+// that should never have been written (like a credential) ends up in a log.
+// match is called with the data of every record between the head and the
+// tail, and redact is called with the data of each record it matches, to
+// overwrite whatever needs scrubbing. This returns the number of records
+// redacted. Batches of coalesced records (see Options.Coalesce) are
+// unpacked, and each record in a batch is matched on its own.
+//
+// A redacted record keeps its length, its place in the Ring, and everything
+// stored alongside it (including its key), so nothing else about the Ring
+// changes. Records that have already been consumed, but not yet overwritten,
+// aren't touched. The changes aren't on disk until the next Sync.
+//
+// The data passed to match and redact is a slice into the Ring (or a copy of
+// a spilled or compressed record), which must not be held on to after they
+// return. A record compressed with a dictionary (see Options.Dictionaries)
+// is compressed again once it's redacted, and if it no longer fits, it's
+// left empty. Any records waiting to be coalesced are written out first, so
+// that they're redacted too. This waits for any open Txn like Write.
+func (r *Ring) Redact(match func([]byte) bool, redact func([]byte)) (int, error) {
+	r.writeMutex.Lock()
+	defer r.writeMutex.Unlock()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.readOnly {
+		return 0, fmt.Errorf("diskring: read only")
+	}
+	// Records still waiting to be coalesced aren't in the Ring yet, and
+	// would be written out unredacted later on.
+	if err := r.flushBatch(); err != nil {
+		return 0, err
+	}
+
+	redacted := 0
+	for off := *r.head; off != *r.tail; off = r.nextEntry(off) {
+		var (
+			ok    bool
+			err   error
+			flags = r.entryFlags(off)
+			b     = batchRedaction{match: match, redact: redact}
+			m, rd = match, redact
+		)
+		if flags&flagBatch != 0 {
+			m, rd = b.matchBatch, b.redactBatch
+		}
+		if flags&flagSpilled != 0 {
+			ok, err = r.redactSpilled(off, m, rd)
+		} else if flags&flagDictionary != 0 {
+			ok, err = r.redactCompressed(off, m, rd)
+		} else {
+			ok = r.redactEntry(off, m, rd)
+		}
+		if err != nil {
+			return redacted, err
+		}
+		switch {
+		case !ok:
+		case flags&flagBatch != 0:
+			redacted += len(b.matched)
+		default:
+			redacted++
+		}
+	}
+	if redacted > 0 {
+		r.notifyRewritten()
+	}
+	r.check()
+	return redacted, nil
+}
+
+// batchRedaction is the match and redact passed to Redact, wrapped up to
+// be called with a whole batch of coalesced records, so that each record in
+// the batch is matched (and redacted) on its own.
+type batchRedaction struct {
+	match  func([]byte) bool
+	redact func([]byte)
+
+	// matched is each record in the batch that matched, as a slice of
+	// the batch passed to matchBatch.
+	matched [][]byte
+}
+
+// matchBatch will pass every record in the batch to match, returning true
+// if any of them matched.
+func (b *batchRedaction) matchBatch(data []byte) bool {
+	b.matched = b.matched[:0]
+	for pos := 0; pos < len(data); {
+		rec, next, err := unbatch(data, pos)
+		if err != nil {
+			// A corrupt batch can't be made sense of, so it's left
+			// alone.
+			return false
+		}
+		if b.match(rec) {
+			b.matched = append(b.matched, rec[:len(rec):len(rec)])
+		}
+		pos = next
+	}
+	return len(b.matched) > 0
+}
+
+// redactBatch will pass every record in the batch that matchBatch found to
+// redact. It has to be passed the same batch matchBatch was.
+func (b *batchRedaction) redactBatch(data []byte) {
+	for _, rec := range b.matched {
+		b.redact(rec)
+	}
+}
+
+// UNSAFE
+//
+// Redact the data of the entry at the provided offset in place, if it
+// matches, fixing up its checksum to match.
+func (r *Ring) redactEntry(off uintptr, match func([]byte) bool, redact func([]byte)) bool {
+	data := r.entryData(off)
+	if !match(data) {
+		return false
+	}
+	redact(data)
+
+	start := r.entryStart(off)
+	length := r.entryLength(off)
+	if r.layout.has(formatChecksum) {
+		r.putChecksum(start, length)
+	}
+	r.written(off, start-off+r.entrySize(length))
+	return true
+}
+
+// UNSAFE
+//
+// Redact the data of the spilled record in the entry at the provided offset,
+// if it matches, writing it back over its side file.
+func (r *Ring) redactSpilled(off uintptr, match func([]byte) bool, redact func([]byte)) (bool, error) {
+	reference := r.entryData(off)
+	data, err := r.unspillData(reference)
+	if err != nil {
+		return false, err
+	}
+	if !match(data) {
+		return false, nil
+	}
+	redact(data)
+
+	fd, err := os.OpenFile(r.spillPath(reference), os.O_WRONLY, 0)
+	if err != nil {
+		return false, err
+	}
+	if _, err := fd.WriteAt(data, 0); err != nil {
+		fd.Close()
+		return false, err
+	}
+	return true, fd.Close()
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,93 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+// redactSecrets will Redact every record in the Ring holding "hunter2",
+// overwriting it with Xs.
+func redactSecrets(t *testing.T, r *Ring) int {
+	t.Helper()
+	n, err := r.Redact(
+		func(data []byte) bool { return bytes.Contains(data, []byte("hunter2")) },
+		func(data []byte) {
+			at := bytes.Index(data, []byte("hunter2"))
+			copy(data[at:], "XXXXXXX")
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestRedact(t *testing.T) {
+	for name, options := range map[string]Options{
+		"Checksums": {Checksums: true},
+		"Coalesce":  {Coalesce: 128},
+	} {
+		t.Run(name, func(t *testing.T) {
+			options.NonBlockingReads = true
+			r := openTestRing(t, options)
+			writeRecords(t, r, "login ok", "password=hunter2", "logout", "again: hunter2!")
+
+			if n := redactSecrets(t, r); n != 2 {
+				t.Fatalf("expected 2 records redacted, got %d", n)
+			}
+			if err := r.Sync(); err != nil {
+				t.Fatal(err)
+			}
+			data, err := ioutil.ReadFile(r.file.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(data, []byte("hunter2")) {
+				t.Fatal("the secret is still in the file")
+			}
+
+			if options.Checksums {
+				r.mutex.Lock()
+				for off := *r.head; off != *r.tail; off = r.nextEntry(off) {
+					if !r.entryIntact(off, r.len()) {
+						t.Errorf("the checksum of the entry at %d wasn't fixed up", off)
+					}
+				}
+				r.mutex.Unlock()
+			}
+
+			var records []string
+			for i := 0; i < 4; i++ {
+				records = append(records, readRecord(t, r))
+			}
+			want := []string{"login ok", "password=XXXXXXX", "logout", "again: XXXXXXX!"}
+			if !reflect.DeepEqual(records, want) {
+				t.Fatalf("expected %q, got %q", want, records)
+			}
+		})
+	}
+}
+
+// vim: foldmethod=marker
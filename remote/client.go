@@ -0,0 +1,133 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package remote
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"pault.ag/go/diskring"
+)
+
+// Client is a connection to a Ring being served by Serve. It implements
+// diskring.Interface, so it can be used anywhere a local Ring would be.
+//
+// Requests are sent one at a time, so a blocking Read will hold up any
+// other calls on the same Client until it returns.
+type Client struct {
+	conn  net.Conn
+	r     *bufio.Reader
+	w     *bufio.Writer
+	mutex sync.Mutex
+}
+
+// Dial will connect to a Ring being served at the provided address.
+func Dial(network, address string) (*Client, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
+
+// NewClient will create a Client talking over an existing connection.
+func NewClient(conn net.Conn) *Client {
+	return &Client{
+		conn: conn,
+		r:    bufio.NewReader(conn),
+		w:    bufio.NewWriter(conn),
+	}
+}
+
+// Close will hang up the connection to the server.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Read will read the next record from the remote Ring into buf. Records
+// are sent back in a single frame, so no more than 64 MiB can be read at
+// once, however large buf is.
+func (c *Client) Read(buf []byte) (int, error) {
+	size := len(buf)
+	if size > maxFrameSize {
+		size = maxFrameSize
+	}
+	payload, err := c.call(opRead, uint32Payload(size))
+	if err != nil {
+		return 0, err
+	}
+	return copy(buf, payload), nil
+}
+
+// Write will write buf as a record into the remote Ring.
+func (c *Client) Write(buf []byte) (int, error) {
+	payload, err := c.call(opWrite, buf)
+	if err != nil {
+		return 0, err
+	}
+	return parseUint32(payload)
+}
+
+// Stats will return a summary of the state of the remote Ring.
+func (c *Client) Stats() (diskring.Stats, error) {
+	stats := diskring.Stats{}
+	payload, err := c.call(opStats, nil)
+	if err != nil {
+		return stats, err
+	}
+	return stats, json.Unmarshal(payload, &stats)
+}
+
+// call will send a request to the server, and wait for the response.
+func (c *Client) call(op byte, payload []byte) ([]byte, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if err := writeFrame(c.w, op, payload); err != nil {
+		return nil, err
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+
+	status, response, err := readFrame(c.r)
+	if err != nil {
+		return nil, err
+	}
+	switch status {
+	case statusOK:
+		return response, nil
+	case statusEOF:
+		return nil, io.EOF
+	case statusError:
+		return nil, decodeError(response)
+	default:
+		return nil, fmt.Errorf("remote: unknown status %d", status)
+	}
+}
+
+var _ diskring.Interface = (*Client)(nil)
+
+// vim: foldmethod=marker
@@ -0,0 +1,48 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+// Package remote contains a client and server for a diskring.Ring over a
+// network connection, so that a process can read from and write to a Ring
+// owned by another process (or another machine) with the same interface as
+// a local *diskring.Ring.
+//
+// The protocol is a simple request/response exchange of frames. Each frame
+// is a single byte (an operation for requests, a status for responses),
+// followed by a big endian uint32 length, followed by that many bytes of
+// payload.
+//
+//	read:  payload is a big endian uint32 of the largest record the client
+//	       can accept, response payload is the record.
+//	write: payload is the record, response payload is a big endian uint32
+//	       of the number of bytes written.
+//	stats: no payload, response payload is the JSON encoded diskring.Stats.
+//
+// A response with an EOF status means the Ring was empty. One with an error
+// status has a single byte code as the first byte of the payload, so that
+// the client can hand back the same errors (diskring.ErrEmpty, a
+// *diskring.ShortBufferError, and so on) as a local Ring would, with
+// anything else being sent as just its message.
+//
+// The server never trusts the size sent with a read to allocate a buffer;
+// it only grows the buffer to fit the record it finds, and refuses reads
+// larger than a frame.
+package remote
+
+// vim: foldmethod=marker
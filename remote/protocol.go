@@ -0,0 +1,179 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package remote
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"pault.ag/go/diskring"
+)
+
+const (
+	opRead  byte = 'r'
+	opWrite byte = 'w'
+	opStats byte = 's'
+
+	statusOK    byte = 0
+	statusEOF   byte = 1
+	statusError byte = 2
+
+	// maxFrameSize is the largest payload either side will accept, so
+	// that a bad length can't make us allocate the world.
+	maxFrameSize = 64 << 20
+)
+
+// Error codes, sent as the first byte of the payload of a response with an
+// error status, so that the client can hand back the same error the Ring
+// did rather than just its message.
+const (
+	codeOther       byte = 0
+	codeShortBuffer byte = 1
+	codeTooLarge    byte = 2
+	codeSentinel    byte = 3
+)
+
+// sentinels are the errors that are sent with codeSentinel, by their index
+// in this list. New errors may only be added to the end.
+var sentinels = []error{
+	diskring.ErrEmpty,
+	diskring.ErrTimeout,
+	diskring.ErrPaused,
+	diskring.ErrNoLease,
+	diskring.ErrQuotaExceeded,
+	diskring.ErrTruncated,
+	diskring.ErrReplaced,
+	diskring.ErrFault,
+	diskring.ErrStaleCursor,
+	diskring.ErrNoSpillDir,
+	diskring.ErrHeaderBusy,
+}
+
+// encodeError will turn an error into the payload of a response with an
+// error status.
+func encodeError(err error) []byte {
+	var short *diskring.ShortBufferError
+	if errors.As(err, &short) {
+		return append([]byte{codeShortBuffer}, uint32Pair(short.Need, short.Have)...)
+	}
+	var tooLarge *diskring.TooLargeError
+	if errors.As(err, &tooLarge) {
+		return append([]byte{codeTooLarge}, uint32Pair(tooLarge.Size, tooLarge.Limit)...)
+	}
+	for i, sentinel := range sentinels {
+		if errors.Is(err, sentinel) {
+			return []byte{codeSentinel, byte(i)}
+		}
+	}
+	return append([]byte{codeOther}, err.Error()...)
+}
+
+// decodeError will turn the payload of a response with an error status back
+// into the error the server sent.
+func decodeError(payload []byte) error {
+	if len(payload) == 0 {
+		return fmt.Errorf("remote: malformed error")
+	}
+	code, payload := payload[0], payload[1:]
+	switch code {
+	case codeOther:
+		return errors.New(string(payload))
+	case codeShortBuffer:
+		if len(payload) != 8 {
+			return fmt.Errorf("remote: malformed error")
+		}
+		return &diskring.ShortBufferError{
+			Need: int(binary.BigEndian.Uint32(payload)),
+			Have: int(binary.BigEndian.Uint32(payload[4:])),
+		}
+	case codeTooLarge:
+		if len(payload) != 8 {
+			return fmt.Errorf("remote: malformed error")
+		}
+		return &diskring.TooLargeError{
+			Size:  int(binary.BigEndian.Uint32(payload)),
+			Limit: int(binary.BigEndian.Uint32(payload[4:])),
+		}
+	case codeSentinel:
+		if len(payload) != 1 || int(payload[0]) >= len(sentinels) {
+			return fmt.Errorf("remote: unknown error")
+		}
+		return sentinels[payload[0]]
+	default:
+		return fmt.Errorf("remote: unknown error code %d", code)
+	}
+}
+
+// uint32Pair will encode two uint32s, one after the other.
+func uint32Pair(a, b int) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint32(buf[:], uint32(a))
+	binary.BigEndian.PutUint32(buf[4:], uint32(b))
+	return buf[:]
+}
+
+// writeFrame will write a single frame to the provided writer.
+func writeFrame(w io.Writer, kind byte, payload []byte) error {
+	var hdr [5]byte
+	hdr[0] = kind
+	binary.BigEndian.PutUint32(hdr[1:], uint32(len(payload)))
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// readFrame will read a single frame from the provided reader.
+func readFrame(r io.Reader) (byte, []byte, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	length := binary.BigEndian.Uint32(hdr[1:])
+	if length > maxFrameSize {
+		return 0, nil, fmt.Errorf("remote: frame too large (%d bytes)", length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return hdr[0], payload, nil
+}
+
+// uint32Payload will encode a uint32 as a frame payload.
+func uint32Payload(n int) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], uint32(n))
+	return buf[:]
+}
+
+// parseUint32 will decode a uint32 frame payload.
+func parseUint32(payload []byte) (int, error) {
+	if len(payload) != 4 {
+		return 0, fmt.Errorf("remote: malformed payload")
+	}
+	return int(binary.BigEndian.Uint32(payload)), nil
+}
+
+// vim: foldmethod=marker
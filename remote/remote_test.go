@@ -0,0 +1,111 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package remote
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"pault.ag/go/diskring"
+)
+
+// openTestClient will serve a Ring in a temporary directory over a pipe,
+// and return a Client talking to it.
+func openTestClient(t *testing.T, options diskring.Options) *Client {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "diskring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	options.CreateIfMissing = true
+	options.CreateSize = 1 << 16
+	ring, err := diskring.OpenWithOptions(filepath.Join(dir, "test.ring"), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ring.Close() })
+
+	server, client := net.Pipe()
+	go ServeConn(server, ring)
+	c := NewClient(client)
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestClientErrors(t *testing.T) {
+	c := openTestClient(t, diskring.Options{NonBlockingReads: true})
+
+	if _, err := c.Read(make([]byte, 16)); err != diskring.ErrEmpty {
+		t.Fatalf("expected ErrEmpty, got %v", err)
+	}
+
+	if _, err := c.Write(make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+	_, err := c.Read(make([]byte, 10))
+	var short *diskring.ShortBufferError
+	if !errors.As(err, &short) {
+		t.Fatalf("expected a ShortBufferError, got %v", err)
+	}
+	if short.Need != 100 || short.Have != 10 {
+		t.Fatalf("expected need=100 have=10, got need=%d have=%d", short.Need, short.Have)
+	}
+
+	_, err = c.Write(make([]byte, 1<<17))
+	if !errors.Is(err, diskring.ErrTooLarge) {
+		t.Fatalf("expected ErrTooLarge, got %v", err)
+	}
+}
+
+func TestClientLargeRead(t *testing.T) {
+	c := openTestClient(t, diskring.Options{NonBlockingReads: true})
+
+	record := make([]byte, 10000)
+	for i := range record {
+		record[i] = byte(i)
+	}
+	if _, err := c.Write(record); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, maxFrameSize+1)
+	n, err := c.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(record) || string(buf[:n]) != string(record) {
+		t.Fatalf("read back %d bytes, expected %d", n, len(record))
+	}
+}
+
+func TestServerRejectsLargeRead(t *testing.T) {
+	status, _ := handle(nil, opRead, uint32Payload(maxFrameSize+1))
+	if status != statusError {
+		t.Fatalf("expected an error status, got %d", status)
+	}
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,152 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package remote
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"pault.ag/go/diskring"
+)
+
+// readChunk is the size of the buffer a read starts with, before it's grown
+// to fit a larger record.
+const readChunk = 4096
+
+// Serve will accept connections on the provided listener, and serve the
+// provided Ring to each of them until the listener is closed.
+func Serve(l net.Listener, ring diskring.Interface) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			ServeConn(conn, ring)
+		}()
+	}
+}
+
+// ServeConn will serve the provided Ring over a single connection, until the
+// client hangs up or an error is hit talking to it.
+func ServeConn(conn io.ReadWriter, ring diskring.Interface) error {
+	w := bufio.NewWriter(conn)
+	r := bufio.NewReader(conn)
+	for {
+		op, payload, err := readFrame(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		status, response := handle(ring, op, payload)
+		if err := writeFrame(w, status, response); err != nil {
+			return err
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+}
+
+// handle will run a single request against the Ring, and return the status
+// and payload to send back.
+func handle(ring diskring.Interface, op byte, payload []byte) (byte, []byte) {
+	switch op {
+	case opRead:
+		size, err := parseUint32(payload)
+		if err != nil {
+			return errorResponse(err)
+		}
+		if size > maxFrameSize {
+			return errorResponse(fmt.Errorf("remote: read of %d bytes is larger than a frame", size))
+		}
+		buf, err := read(ring, size)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return statusOK, buf
+	case opWrite:
+		n, err := ring.Write(payload)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return statusOK, uint32Payload(n)
+	case opStats:
+		stats, err := ring.Stats()
+		if err != nil {
+			return errorResponse(err)
+		}
+		response, err := json.Marshal(stats)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return statusOK, response
+	default:
+		return errorResponse(errors.New("remote: unknown operation"))
+	}
+}
+
+// errorResponse will turn an error into a response to send to the client.
+func errorResponse(err error) (byte, []byte) {
+	if err == io.EOF {
+		return statusEOF, nil
+	}
+	return statusError, encodeError(err)
+}
+
+// read will read the next record from the Ring, into a buffer of no more
+// than size bytes. The client picks size, so rather than trusting it with
+// the allocation, this starts small and only grows the buffer to fit the
+// record it finds.
+func read(ring diskring.Interface, size int) ([]byte, error) {
+	have := size
+	if have > readChunk {
+		have = readChunk
+	}
+	for {
+		buf := make([]byte, have)
+		n, err := ring.Read(buf)
+		var short *diskring.ShortBufferError
+		if errors.As(err, &short) && have < size && short.Need > have {
+			// The record wasn't consumed, so try again with room
+			// for it (or as much room as the client has).
+			have = short.Need
+			if have > size {
+				have = size
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,122 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+var (
+	// ErrTruncated is returned once a file backing the Ring has been
+	// found to be smaller than the Ring (see CheckFile).
+	ErrTruncated = errors.New("diskring: file was truncated out from under the ring")
+
+	// ErrReplaced is returned once the file a Ring was opened from has
+	// been found to be moved, removed or replaced with another file (see
+	// CheckFile).
+	ErrReplaced = errors.New("diskring: file was replaced out from under the ring")
+)
+
+// CheckFile will check that the files backing the Ring are still big enough
+// to hold it, and, if the Ring was opened by path, that the path still
+// points at the file it was opened from, returning an ErrTruncated or an
+// ErrReplaced if not. Once either has been found, Read, ReadRecord and Write
+// return it too, rather than touching the Ring, and the Ring should be
+// closed (see Reopen).
+//
+// Touching a part of the mapping that's been truncated away from the file
+// kills the process with a SIGBUS, which Go can't recover from, so anything
+// that may have the file truncated out from under it (such as by logrotate
+// with copytruncate, or a careless operator) should call this every so
+// often, or set the CheckFileInterval option. This can only narrow the
+// window, not close it; nothing can stop a truncation between two checks.
+func (r *Ring) CheckFile() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.checkFile()
+}
+
+// checkFileInBackground is CheckFile, for the background checker, which has
+// nowhere to send the result. Anything it finds is kept in fileErr.
+func (r *Ring) checkFileInBackground() {
+	r.CheckFile()
+}
+
+// UNSAFE
+//
+// Check the files backing the ring, noting anything wrong with them in
+// fileErr, and waking up anyone waiting on a read so they find out too.
+func (r *Ring) checkFile() error {
+	if r.fileErr != nil {
+		return r.fileErr
+	}
+	for _, s := range r.segments {
+		size, err := fileSize(s.fd)
+		if err != nil {
+			return err
+		}
+		if size < s.offset+int64(s.size) {
+			r.fileErr = ErrTruncated
+		}
+	}
+	if r.fileErr == nil && r.path != "" {
+		opened, err := r.files[0].Stat()
+		if err != nil {
+			return err
+		}
+		current, err := os.Stat(r.path)
+		switch {
+		case os.IsNotExist(err):
+			r.fileErr = ErrReplaced
+		case err != nil:
+			return err
+		case !os.SameFile(opened, current):
+			r.fileErr = ErrReplaced
+		}
+	}
+	if r.fileErr != nil && r.waiting > 0 {
+		close(r.wakeup)
+		r.wakeup = make(chan struct{})
+	}
+	return r.fileErr
+}
+
+// Reopen will close the Ring, and open the file at the path it was opened
+// from again, with the same options, returning the new Ring. This is how to
+// recover from the file being replaced (or truncated) out from under the
+// Ring (see CheckFile), without restarting the process.
+//
+// Only a Ring opened by path (with Open, OpenWithOptions or OpenContext) can
+// be reopened. Nothing from the old Ring (such as an open Txn, or the
+// reader's place in a replaced file) carries over to the new one.
+func (r *Ring) Reopen() (*Ring, error) {
+	if r.path == "" {
+		return nil, fmt.Errorf("diskring: only a Ring opened by path can be reopened")
+	}
+	if err := r.Close(); err != nil {
+		return nil, err
+	}
+	return OpenWithOptions(r.path, r.options)
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,110 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"os"
+	"testing"
+)
+
+func TestCheckFileReplaced(t *testing.T) {
+	path := testRingPath(t)
+	r := openTestRingAt(t, path, Options{NonBlockingReads: true})
+	writeRecords(t, r, "one")
+	if err := r.CheckFile(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.CheckFile(); err != ErrReplaced {
+		t.Fatalf("expected ErrReplaced, got %v", err)
+	}
+	if _, err := r.Write([]byte("two")); err != ErrReplaced {
+		t.Fatalf("expected the write to fail with ErrReplaced, got %v", err)
+	}
+	if _, err := r.Read(make([]byte, 64)); err != ErrReplaced {
+		t.Fatalf("expected the read to fail with ErrReplaced, got %v", err)
+	}
+
+	r, err := r.Reopen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if err := r.CheckFile(); err != nil {
+		t.Fatal(err)
+	}
+	writeRecords(t, r, "two")
+	if record := readRecord(t, r); record != "two" {
+		t.Fatalf("expected two, got %q", record)
+	}
+}
+
+func TestCheckFileTruncated(t *testing.T) {
+	path := testRingPath(t)
+	r := openTestRingAt(t, path, Options{NonBlockingReads: true})
+	defer r.Close()
+	writeRecords(t, r, "one")
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, info.Size()/2); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.CheckFile(); err != ErrTruncated {
+		t.Fatalf("expected ErrTruncated, got %v", err)
+	}
+	if _, err := r.Write([]byte("two")); err != ErrTruncated {
+		t.Fatalf("expected the write to fail with ErrTruncated, got %v", err)
+	}
+
+	// Put the file back, so that closing the Ring doesn't touch a part
+	// of the mapping that's missing from the file.
+	if err := os.Truncate(path, info.Size()); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.CheckFile(); err != ErrTruncated {
+		t.Fatalf("expected ErrTruncated to stick, got %v", err)
+	}
+}
+
+func TestReopenWithoutPath(t *testing.T) {
+	path := testRingPath(t)
+	openTestRingAt(t, path, Options{}).Close()
+	fd, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := New(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := r.Reopen(); err == nil {
+		t.Fatal("expected a Ring without a path not to be reopened")
+	}
+}
+
+// vim: foldmethod=marker
@@ -21,10 +21,12 @@
 package diskring
 
 import (
+	"context"
+	"crypto/ed25519"
 	"fmt"
 	"os"
 	"sync"
-	"syscall"
+	"time"
 	"unsafe"
 )
 
@@ -37,34 +39,210 @@ type Cursor struct {
 
 // Ring contains internal state backing the actual diskring. This works by
 // mmapping a file into the Ring, and aligning it so that reads and writes
-// below the size of the buffer wrap.
+// below the size of the buffer wrap (or, when built with the
+// diskring_portable tag, by reading it into memory; see portable.go).
 type Ring struct {
 	file          *os.File
+	files         []*os.File
 	dontCloseFile bool
+	locked        bool
 
-	readOnly       bool
-	dontBlockReads bool
-	wakeup         chan struct{}
+	// segments is where the ring's data lives in the files, options are
+	// what the Ring was opened with, and path is where, if it was opened
+	// by path (see Reopen).
+	segments []segment
+	options  Options
+	path     string
 
-	ringBase uintptr
-	ringOne  uintptr
-	ringTwo  uintptr
+	// fileErr is set once the files have been found to be truncated or
+	// replaced (see CheckFile), after which the mapping can't be touched.
+	fileErr error
+
+	readOnly         bool
+	dontBlockReads   bool
+	nonBlockingReads bool
+	readTimeout      time.Duration
+	readahead        bool
+
+	// wakeup is closed (and replaced) whenever records are written, to
+	// wake up anyone waiting for them, if there's anyone (waiting) at all.
+	wakeup  chan struct{}
+	waiting int
 
 	size uintptr
 
-	headerBase uintptr
-	headerSize uintptr
-	cursor     *Cursor
+	// headerPage is the header page, or nil if the options didn't ask
+	// for one to be reserved.
+	headerPage []byte
+	header     *header
+
+	// head and tail are the offsets of the read and write cursors, which
+	// live either in the header, or in a Cursor from a CustomHeader.
+	head *uintptr
+	tail *uintptr
+
+	// libraryHeader is set if the header page is laid out as our header
+	// struct, rather than by a CustomHeader.
+	libraryHeader bool
+
+	// typedHeader is the HeaderType mapped onto the header page, if any.
+	typedHeader interface{}
+
+	// layout is where the optional fields of each entry's envelope live.
+	layout layout
+
+	// debug is set if the Ring should be checked over after every change
+	// to the cursor (see Options.Debug).
+	debug bool
+
+	// index is the offset of every entry, oldest first, if indexed is set.
+	index   []uintptr
+	indexed bool
+
+	// sparse is every sparseEvery-th entry, oldest first, if sparseEvery
+	// is set (see sparse.go).
+	sparse      []sparsePoint
+	sparseEvery uint64
+
+	// keys is the newest entry for each key, if the record format has
+	// keys (see key.go).
+	keys map[string]keyEntry
+
+	// sizes is the histogram of record sizes written. Entries that are
+	// only being written back (see rewriteEntries) aren't counted again,
+	// which is what rewriting is set for.
+	sizes     *sizeStats
+	rewriting bool
+
+	// align is what the Ring's size (and the header, if there is one) is
+	// a multiple of.
+	align uintptr
+
+	// maxRecord is the largest record that can be stored in the ring, and
+	// spillDir is where larger ones go, if anywhere.
+	maxRecord uintptr
+	spillDir  string
+
+	// perms is the mode and owner to give any file the ring creates.
+	perms filePerms
+
+	// validateWrite is run over every record before it's written.
+	validateWrite func([]byte) error
+
+	// producers is every registered Producer by ID, and owned is every
+	// record written by one of them that may still be in the ring, oldest
+	// first.
+	producers map[uint16]*Producer
+	owned     []ownedRecord
+
+	// namespaces is every Namespace created by ID.
+	namespaces map[uint16]*Namespace
+
+	// readSeq is the sequence number the reader has consumed up to, so
+	// that anything between it and the head was dropped, and overwritten
+	// is the number of records writers have overwritten.
+	readSeq     uint64
+	overwritten uint64
 
 	buf []byte
 
+	// staged is the number of bytes written after the tail by an open
+	// Txn, which aren't yet visible to readers.
+	staged uintptr
+
+	// backend is whatever state the code behind the mapping keeps (see
+	// mapping.go, or portable.go).
+	backend backend
+
+	// syncer flushes the ring out to disk in the background, if the
+	// options ask for it.
+	syncer *syncer
+
+	// heartbeat notes the time in the header every so often, if the
+	// options ask for it.
+	heartbeat *periodic
+
+	// leaseTerm is how long the write lease lasts, or 0 if writes don't
+	// need one, leaseOwner is the ID this Ring holds it under, lease is
+	// the lease word it last stored in the header, and leaseRenewer renews
+	// it while it's held (see lease.go).
+	leaseTerm    time.Duration
+	leaseOwner   uint16
+	lease        uint64
+	leaseRenewer *periodic
+
+	// compactor compacts the ring every so often, if the options ask for
+	// it.
+	compactor *periodic
+
+	// checker checks the files every so often, if the options ask for it.
+	checker *periodic
+
+	// tier is where records go as they leave the ring, if the options ask
+	// for it (see tier.go).
+	tier *tier
+
+	// chain is the hash of the last record sealed, which may not have
+	// been published yet (see HashChain).
+	chain [chainSize]byte
+
+	// coalesce is the size of the batches records smaller than it are
+	// packed into, batch is the batch being packed, and flusher writes it
+	// out every so often, if the options ask for it (see coalesce.go).
+	coalesce int
+	batch    []byte
+	flusher  *periodic
+
+	// batched is how far the head reader is through the batch at the
+	// head.
+	batched batchCursor
+
+	// dictionaries are what records are compressed with, and
+	// compressBelow is the size records have to be under to be
+	// compressed, if the options ask for it (see dictionary.go).
+	dictionaries  *dictionaries
+	compressBelow int
+
+	// signingKey signs each record written, and verifyKey checks the
+	// signature of each record read, if the options ask for either.
+	signingKey ed25519.PrivateKey
+	verifyKey  ed25519.PublicKey
+
+	// schema is the schema version stored alongside each record written
+	// without one, if the record format has them (see migrate.go).
+	schema uint8
+
+	// notifier is the NotifyFile, if the options ask for one (see
+	// notify.go).
+	notifier *notifier
+
+	// timings is the histogram of how long everything took, and
+	// observeTiming is told how long each Operation took, if the options
+	// ask for either.
+	timings       *timingStats
+	observeTiming func(Timing)
+
+	// consumers is every open Consumer by name. It's only changed with
+	// both the mutex and the consumersMutex held, so that Stats can read
+	// it with just the consumersMutex.
+	consumers      map[string]*Consumer
+	consumersMutex sync.Mutex
+
 	blockWrites bool
 	mutex       sync.Mutex
+
+	// writeMutex is held by anything writing to the ring, for as long as
+	// it may have staged data after the tail. It must be taken before the
+	// mutex.
+	writeMutex sync.Mutex
 }
 
 // New will create a new Ring Buffer using the underlying file
 // (`fd`) to read and write entries to. Ensure that the file was opened r/w and
 // the user is able to mmap the file.
+//
+// The file may also be a block device, in which case the ring will use the
+// whole device, rounded down to the page size.
 func New(fd *os.File) (*Ring, error) {
 	return NewWithOptions(fd, Options{
 		// Offset is 0
@@ -92,18 +270,81 @@ func Open(path string) (*Ring, error) {
 // Additionally, this will construct the Ring according to the options
 // set in the passed Options struct.
 func OpenWithOptions(path string, options Options) (*Ring, error) {
+	return OpenContext(context.Background(), path, options)
+}
+
+// OpenContext will open the existing file at the provided path, and return it
+// as a loaded Ring buffer, constructed according to the passed Options. If
+// the file doesn't exist, and the CreateIfMissing option is set, it's
+// created first.
+//
+// If the context is done before the Ring is ready (such as while waiting for
+// another process to release the lock on the file, or while counting the
+// records of a very large file), this will give up and return the context's
+// error.
+func OpenContext(ctx context.Context, path string, options Options) (*Ring, error) {
 	fd, err := os.OpenFile(path, os.O_RDWR, 0)
+	if os.IsNotExist(err) && options.CreateIfMissing {
+		fd, err = create(path, options)
+	}
 	if err != nil {
 		return nil, err
 	}
-	ring, err := NewWithOptions(fd, options)
+	// Nobody else has the file, so the Ring has to close it.
+	options.DontCloseFile = false
+	ring, err := newWithContext(ctx, []*os.File{fd}, options)
 	if err != nil {
 		fd.Close()
 		return nil, err
 	}
+	ring.path = path
 	return ring, nil
 }
 
+// create will create a new file at the provided path for a Ring with
+// CreateSize bytes of data (plus the header, if there is one), rounded up
+// to the alignment. If someone else creates the file first, their file is
+// opened instead.
+func create(path string, options Options) (*os.File, error) {
+	if options.CreateSize <= 0 {
+		return nil, fmt.Errorf("diskring: CreateIfMissing needs a CreateSize")
+	}
+	align := int64(options.Alignment)
+	if align == 0 {
+		align = int64(pageSize())
+	}
+	size := (int64(options.CreateSize) + align - 1) / align * align
+	if options.ReserveHeader {
+		size += align
+	}
+
+	fd, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		return os.OpenFile(path, os.O_RDWR, 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := fd.Truncate(size); err != nil {
+		fd.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	if !options.AllowSparse {
+		if err := preallocate(fd, size); err != nil {
+			fd.Close()
+			os.Remove(path)
+			return nil, fmt.Errorf("diskring: can't allocate the ring: %w", err)
+		}
+	}
+	if err := newFilePerms(options).apply(fd); err != nil {
+		fd.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	return fd, nil
+}
+
 // Options contains some "extra" configuration that can be used to control
 // the internals of the Ring. If you do not require these options, it's best
 // to invoke New, and let the library take care of defaults.
@@ -141,9 +382,9 @@ type Options struct {
 	// when ReadOnlyCursor is true will be blocked.
 	ReadOnlyCursor bool
 
-	// DontBlockReads will block reads until new data is written, rather than
-	// returning an io.EOF when the read cursor catches up to the write
-	// cursor.
+	// DontBlockReads will return an io.EOF when the read cursor catches up
+	// to the write cursor, rather than blocking reads until new data is
+	// written.
 	//
 	// Default: false
 	//
@@ -154,6 +395,20 @@ type Options struct {
 	// be 'false'.
 	DontBlockReads bool
 
+	// NonBlockingReads will have Read return an ErrEmpty when the Ring is
+	// empty, rather than blocking until new data is written. This is like
+	// DontBlockReads, but without an io.EOF, which most code built around
+	// an io.Reader takes to mean there's nothing left to read, ever.
+	//
+	// Default: false
+	NonBlockingReads bool
+
+	// ReadTimeout is how long a blocking Read will wait for something to
+	// be written to an empty Ring before giving up with an ErrTimeout.
+	//
+	// Default: 0 (wait forever)
+	ReadTimeout time.Duration
+
 	// CustomHeader will create a custom header given the provided base address
 	// and size (in bytes) within the diskring Header.
 	//
@@ -168,12 +423,581 @@ type Options struct {
 	// please.
 	//
 	// A nil value will mean using an in-memory cursor.
+	//
+	// Deprecated: HeaderType does the same job without the unsafe bits.
 	CustomHeader func(unsafe.Pointer, int) (*Cursor, error)
 
+	// HeaderType is a pointer to a struct (such as `(*MyHeader)(nil)`)
+	// which will be mapped onto the header page, and can be got at with
+	// Ring.Header. The struct may only contain numbers, and arrays and
+	// structs of them.
+	//
+	// If the struct has a Cursor field tagged `diskring:"cursor"`, the
+	// struct replaces the library's header, just like a CustomHeader.
+	// Otherwise, it's mapped into the space left for the user (see
+	// UserHeader), alongside the library's header.
+	//
+	// This is only used if ReserveHeader is 'true', and can't be used
+	// along with a CustomHeader.
+	HeaderType interface{}
+
 	// DontCloseFile will not call Close on the underlying *os.File that
 	// is held by the Ring buffer. This can be useful if the file lifecycle
-	// is required outside the lifecycle of the Ring.
+	// is required outside the lifecycle of the Ring, such as a file handed
+	// over by systemd (or any other parent process) that has to outlive
+	// it, so that it can be opened as a Ring again later.
+	//
+	// Default: false
+	//
+	// This has no effect on OpenWithOptions and OpenContext, since the
+	// file they open is never handed back to the caller (unless the Ring
+	// is closed with Detach, which never closes the file anyway).
 	DontCloseFile bool
+
+	// Lock will take an advisory lock (flock(2)) on the file for the
+	// lifetime of the Ring, waiting for any other process holding it to
+	// let go first. The lock is exclusive, unless ReadOnlyCursor is set,
+	// in which case it's shared with any other read only Rings.
+	//
+	// Default: false
+	//
+	// Use OpenContext to bound how long to wait for the lock. The lock is
+	// shared with any child process the file is handed to (see the package
+	// documentation).
+	Lock bool
+
+	// Timestamps will store the time each record was written alongside it.
+	//
+	// Default: false
+	//
+	// This changes how records are laid out in the file, so a file which
+	// has records in it must always be opened with the same value. If the
+	// Ring has a header on disk, this is checked when it's opened.
+	Timestamps bool
+
+	// TrailingLength will store the length of each record after its data,
+	// as well as before it, so that the Ring can be walked backwards from
+	// the newest record (see IterReverse).
+	//
+	// Default: false
+	//
+	// As with Timestamps, this changes how records are laid out in the
+	// file.
+	TrailingLength bool
+
+	// Index will keep the offset of every record in memory, so that any
+	// record can be found right away by its position (see At), rather
+	// than walking the Ring to find it.
+	//
+	// Default: false
+	//
+	// The index is built by walking the Ring when it's opened, and takes
+	// up a uintptr of memory per record.
+	Index bool
+
+	// SparseIndex will note where every SparseIndex-th record is (along
+	// with the time it was written, if Timestamps are on), so that
+	// SeekToSequence, SeekToTime and friends only have to walk the Ring
+	// from the nearest noted record, rather than from the head. This is
+	// a lot smaller than the Index, for a very large Ring.
+	//
+	// Default: 0 (no sparse index)
+	//
+	// If the Ring has a header on disk, the sparse index is kept in a
+	// sidecar file next to the Ring's file (ending in ".sparse") when the
+	// Ring is closed, and picked back up when it's opened again. Anything
+	// written since then is walked as needed.
+	SparseIndex int
+
+	// MaxRecordSize is the largest record that will be stored in the Ring.
+	// If this is 0, or larger than a quarter of the size of the Ring, a
+	// quarter of the size of the Ring is used.
+	MaxRecordSize int
+
+	// SpillDir will store the data of records larger than MaxRecordSize in
+	// a file in this directory, rather than refusing to write them. The
+	// Ring only holds a reference to the file, which is read back in
+	// when the record is read, and removed when the record is consumed
+	// or overwritten.
+	//
+	// Default: "" (records that are too large can't be written)
+	//
+	// This only applies to Write and WriteSequence. Since the reference is
+	// flagged in the envelope of the record, this changes how records are
+	// laid out in the file, just like Timestamps.
+	SpillDir string
+
+	// TierDir will keep every record that leaves the Ring, whether it was
+	// consumed or overwritten, in a chain of segment files in this
+	// directory, so that the Ring only has to hold the most recent
+	// records, while older ones can still be read back (see IterHistory).
+	// Records are appended to the newest segment until it's
+	// TierSegmentSize bytes, and then a new one is started. Segments are
+	// never changed once the next one is started.
+	//
+	// Default: "" (records that leave the Ring are gone)
+	//
+	// Records are buffered on their way into the tier, and only flushed
+	// out by Sync and Close (or when a HistoryIterator catches up with
+	// them), so a crash can lose the records that left the Ring since the
+	// last Sync. This has no effect with ReadOnlyCursor.
+	TierDir string
+
+	// TierSegmentSize is how large each segment in TierDir gets before the
+	// next one is started.
+	//
+	// Default: 64 MiB
+	TierSegmentSize int64
+
+	// TierMaxBytes will drop the oldest segments in TierDir whenever a new
+	// segment is started, for as long as all the segments together are
+	// larger than this. The newest segment is never dropped.
+	//
+	// Default: 0 (segments are kept forever)
+	TierMaxBytes int64
+
+	// SectorAlign will pad records so that the length of a record never
+	// straddles two 4K sectors, and neither does any record small enough
+	// to fit inside of one. If the power goes out partway through a write,
+	// only the sectors that were being written can be torn, so every
+	// record in the other sectors can still be found and trusted.
+	//
+	// Default: false
+	//
+	// The padding wastes up to a sector per record, so this works best
+	// with records much smaller than 4K. As with Timestamps, this changes
+	// how records are laid out in the file.
+	SectorAlign bool
+
+	// PageAlign will pad records like SectorAlign does, but to the page
+	// size (or the Alignment, if that's larger) rather than to 4K, and
+	// start any record too large to fit in a page at the start of a page
+	// of its own. Since no page then holds part of a record that's on
+	// another page, each one can be synced (or punched out of the file)
+	// on its own, and a write torn by the power going out only damages
+	// the records on the pages it was writing.
+	//
+	// Default: false
+	//
+	// The padding wastes up to a page per record. The page size is
+	// stored in the header with ReserveHeader (see Alignment), so it
+	// doesn't change if the file is moved to a system with another page
+	// size. As with Timestamps, this changes how records are laid out in
+	// the file.
+	PageAlign bool
+
+	// CompactLength will store the length of each record in 4 bytes rather
+	// than 8, which adds up when most records are only a few dozen bytes
+	// long. Records can't be larger than 2GB with this set.
+	//
+	// Default: false
+	//
+	// As with Timestamps, this changes how records are laid out in the
+	// file.
+	CompactLength bool
+
+	// RecordFlags will store Flags alongside each record (see WriteRecord
+	// and ReadRecord).
+	//
+	// Default: false (unless SpillDir, Dictionaries or Coalesce are set,
+	// which need them too)
+	//
+	// As with Timestamps, this changes how records are laid out in the
+	// file.
+	RecordFlags bool
+
+	// ContentTypes will store a ContentType alongside each record (see
+	// WriteRecord and ReadRecord), so that different kinds of records can
+	// share a Ring, and be told apart when they're read.
+	//
+	// Default: false
+	//
+	// As with Timestamps, this changes how records are laid out in the
+	// file.
+	ContentTypes bool
+
+	// Keys will store a key alongside each record (see WriteKey), and keep
+	// an index of the newest record for each key in memory, so that it can
+	// be found with Lookup. This turns the Ring into a handy cache of the
+	// latest value of each key.
+	//
+	// Default: false
+	//
+	// The key index is built by walking the Ring when it's opened. As
+	// with Timestamps, this changes how records are laid out in the file.
+	Keys bool
+
+	// CompactInterval will start a goroutine which compacts the Ring this
+	// often, dropping records that have been superseded by a newer record
+	// with the same key (see Compact). This requires Keys.
+	//
+	// Default: 0 (only compact when Compact is called)
+	CompactInterval time.Duration
+
+	// ValidateWrite is called with the data of every record before it's
+	// written, and if it returns an error, the record isn't written, and
+	// the error is returned (wrapped) by the write. This lets records that
+	// break the rules (such as a schema, or a size policy) be turned away
+	// by the writer, rather than tripping up readers later on.
+	//
+	// Default: nil (every record is written)
+	//
+	// This is called without any locks on the Ring held, so it must not
+	// hold on to the data after it returns.
+	ValidateWrite func([]byte) error
+
+	// ProducerIDs will store the ID of the Producer that wrote each record
+	// alongside it (see RegisterProducer), so that it's always possible to
+	// tell who's been filling up the Ring (see ProducerStats).
+	//
+	// Default: false
+	//
+	// As with Timestamps, this changes how records are laid out in the
+	// file.
+	ProducerIDs bool
+
+	// SyncMaxDelay will start a goroutine which flushes everything written
+	// to the Ring out to disk in the background, so that all the writes
+	// since the last flush only cost one sync between them. Anything
+	// written will be flushed within this long.
+	//
+	// Default: 0 (no limit, only SyncMaxBytes applies)
+	//
+	// Writers that need to know their records are safely on disk can use
+	// WaitSynced. If neither this nor SyncMaxBytes are set, nothing is
+	// flushed in the background.
+	SyncMaxDelay time.Duration
+
+	// SyncMaxBytes will have the background syncer flush as soon as this
+	// many bytes have been written since the last flush.
+	//
+	// Default: 0 (no limit, only SyncMaxDelay applies)
+	SyncMaxBytes int
+
+	// DontDump will ask the kernel to leave the Ring's memory (the data
+	// and the header) out of any core dump of the process, so that a
+	// Ring many gigabytes in size doesn't make the dump that much larger,
+	// and the records in it (which may well be sensitive) don't end up in
+	// it. This is only supported on Linux, and not by the portable
+	// backend. Where it isn't supported, the Ring fails to open, rather
+	// than leaving the records in the dump.
+	//
+	// Default: false
+	DontDump bool
+
+	// Readahead will ask the kernel to start reading all the records in
+	// the Ring in from disk when it's opened, and after SeekCursor (see
+	// Prefetch), so that the first pass over a large Ring that isn't in
+	// the page cache doesn't stall on every page.
+	//
+	// Default: false
+	Readahead bool
+
+	// RecoverByScan will rebuild the cursor by scanning the file for
+	// records when it's been lost, rather than starting out empty over
+	// whatever's in the file. The cursor is lost when it isn't kept on
+	// disk at all (without ReserveHeader or a CustomHeader), or when the
+	// header was wiped out, or torn with nothing to recover it from.
+	//
+	// The scan looks for the longest run of records that chain together
+	// with timestamps that never go backwards, so this needs Timestamps.
+	// Records that were already consumed, but not yet overwritten, can't
+	// be told apart from ones that weren't, so they'll be read again.
+	//
+	// Default: false
+	//
+	// Scanning a large Ring takes a while, since it may have to look at
+	// every byte. Use OpenContext to bound how long it can take.
+	RecoverByScan bool
+
+	// Checksums will store a checksum of each record alongside it, written
+	// after the data, so that a record that didn't make it out to disk in
+	// one piece can be told apart from one that did. When a Ring is opened
+	// without having been cleanly synced (see Sync) or closed, any records
+	// at the tail that don't match their checksum are rolled back, along
+	// with everything written after them.
+	//
+	// Default: false
+	//
+	// As with Timestamps, this changes how records are laid out in the
+	// file. The checksums are also used by RecoverByScan to tell records
+	// apart from whatever else is in the file.
+	Checksums bool
+
+	// CheckFileInterval will check the files backing the Ring every so
+	// often (see CheckFile), so that a file that's been truncated or
+	// replaced out from under the Ring is noticed before anything touches
+	// the missing part of it.
+	//
+	// Default: 0 (the files are only checked by CheckFile)
+	CheckFileInterval time.Duration
+
+	// HashChain will store a hash in each record of the hash of the
+	// record before it and its own data, and keep the hash of the last
+	// record in the header, so that the records in the Ring can be
+	// checked for anything that's been changed, dropped or slipped in
+	// between them after they were written (see VerifyChain).
+	//
+	// Default: false
+	//
+	// As with Timestamps, this changes how records are laid out in the
+	// file. Anything that changes records after they're written (Redact,
+	// Erase, Compact and the CompactInterval) breaks the chain where it's
+	// been, which VerifyChain reports, just like anything else would.
+	// For records spilled to the SpillDir, the chain covers where the
+	// record was spilled to, not what was spilled.
+	HashChain bool
+
+	// Coalesce will pack records written with Write that are smaller than
+	// this many bytes together into batches of up to this many bytes,
+	// each of which is written to the Ring as one entry. Each record then
+	// doesn't need an envelope of its own, or a Sync of the pages it
+	// touches, which is most of the cost of writing very small records.
+	// Everything that reads records back out of the Ring (Read, Fetch,
+	// an Iterator, a Consumer, Export, Erase and so on) unpacks the
+	// batches again, returning the records one at a time, other than At
+	// and ReadOffset, which return ErrCoalesced.
+	//
+	// Default: 0 (records aren't coalesced)
+	//
+	// A record sits in memory until its batch is written out, which is
+	// once the batch is full, the CoalesceDelay is up, or on the next
+	// Flush, Sync or Close, or anything written that isn't coalesced. A
+	// batch is one record as far as sequence numbers and Stats go, so
+	// every record in a batch has the batch's sequence number, and Fetch
+	// returns (and Commit consumes) a batch whole. A Consumer that's part
+	// way through a batch when it commits picks up from the start of the
+	// batch the next time it's opened. This needs RecordFlags, and turns
+	// them on.
+	Coalesce int
+
+	// CoalesceDelay is the longest a record can sit in a batch waiting to
+	// be written to the Ring.
+	//
+	// Default: 10ms
+	CoalesceDelay time.Duration
+
+	// Dictionaries are compression dictionaries for small records. Each
+	// record smaller than CompressBelow is compressed with DEFLATE, using
+	// the last dictionary here as a preset dictionary, and stored that way
+	// if it comes out smaller. Compressing a record of a few hundred bytes
+	// on its own barely does anything, but with a dictionary made up of
+	// what records usually look like (see TrainDictionary), it does a
+	// great deal better. Records are decompressed again as they're read.
+	//
+	// Default: nil (records aren't compressed)
+	//
+	// Each record notes which dictionary it was compressed with, so
+	// every dictionary that records still in the Ring were compressed
+	// with has to be here to read them, oldest first. This uses DEFLATE
+	// (from the standard library) rather than zstd, so that the library
+	// keeps to the standard library. This needs RecordFlags, and turns
+	// them on.
+	Dictionaries [][]byte
+
+	// CompressBelow is the size records have to be smaller than to be
+	// compressed with the Dictionaries. Larger records get more out of
+	// being compressed on their own, before they're written.
+	//
+	// Default: 256
+	CompressBelow int
+
+	// SigningKey will sign each record as it's written, storing the
+	// Ed25519 signature alongside it, so that records can be tied back to
+	// whoever holds the key, even if the machine the Ring is on is later
+	// taken over by someone who doesn't. With HashChain, the signature
+	// covers the record's hash too, and so everything before it.
+	//
+	// Default: nil
+	//
+	// As with Timestamps, this changes how records are laid out in the
+	// file. Signing takes a good deal longer than writing a record does.
+	SigningKey ed25519.PrivateKey
+
+	// VerifyKey will check the signature of every record as it's read
+	// against the public key of the SigningKey, failing the read with
+	// ErrBadSignature if it doesn't match. The record is left where it
+	// is, so a reader can Skip past it. A Ring opened with a VerifyKey
+	// but no SigningKey can't be written to.
+	//
+	// Default: nil (signatures aren't checked)
+	//
+	// Anything that changes records after they're written (Redact, or
+	// anyone with access to the file) leaves them failing to verify.
+	VerifyKey ed25519.PublicKey
+
+	// Timings will keep a histogram of how long each Phase (waiting for
+	// the locks, copying, and syncing) of each Write, Read and Sync takes,
+	// which is reported by Stats. This is handy for telling whether slow
+	// writes are down to lock contention, or waiting on the disk.
+	//
+	// Default: false
+	Timings bool
+
+	// ObserveTiming is called with how long each Phase of each Write,
+	// Read and Sync took, once it's done, for feeding into a metrics
+	// system of your own.
+	//
+	// Default: nil
+	//
+	// This is called without any locks on the Ring held, but it's called
+	// on every operation, so it should be quick.
+	ObserveTiming func(Timing)
+
+	// NotifyFile is the path to a small file which the writer rewrites
+	// with where its cursor is after every change, so that readers in
+	// other processes can follow along. A Ring opened with ReadOnlyCursor
+	// and a NotifyFile picks up the writer's cursor every time the file
+	// changes, waking up any blocked reads, rather than only ever seeing
+	// what was in the Ring when it was opened. On Linux, readers watch
+	// the file with inotify, so there's no polling; anywhere else (or on
+	// a filesystem without inotify), they check it every
+	// NotifyPollInterval.
+	//
+	// Default: "" (no NotifyFile)
+	//
+	// The file is created if it's not there yet, by whichever end gets to
+	// it first. A reader that falls a whole Ring behind the writer can
+	// read a record just as it's overwritten, so leave plenty of room.
+	// This can't be used with Index, SparseIndex or Keys on a reader.
+	NotifyFile string
+
+	// NotifyPollInterval is how often a reader checks the NotifyFile when
+	// it can't be watched with inotify.
+	//
+	// Default: 100ms
+	NotifyPollInterval time.Duration
+
+	// CreateIfMissing will have OpenWithOptions (and OpenContext) create
+	// the file if it doesn't exist yet, sized to hold CreateSize bytes of
+	// records, plus the header if ReserveHeader is set.
+	//
+	// Default: false
+	CreateIfMissing bool
+
+	// CreateSize is the size of the Ring's data when CreateIfMissing
+	// creates the file, which is rounded up to the Alignment. This has no
+	// effect on a file that already exists.
+	//
+	// Default: 0 (which CreateIfMissing won't take)
+	CreateSize int
+
+	// AllowSparse will leave holes in the file as they are, rather than
+	// having the filesystem set aside blocks for the whole file when it's
+	// created or opened. Writing into a hole when the disk is full kills
+	// the process with a SIGBUS, rather than returning an error (although
+	// the Ring turns that into an ErrFault where it can), so this should
+	// only be set when the disk space really can't be spared up front.
+	//
+	// Default: false
+	//
+	// This only makes a difference on Linux; elsewhere there's no way to
+	// set aside the blocks, so files are always left as they are.
+	AllowSparse bool
+
+	// FileMode is the permissions to give any file the Ring creates: the
+	// Ring's own file (with CreateIfMissing, or CloneTo), sidecar files
+	// (such as for the SparseIndex, or a Consumer's position) and spilled
+	// records (see SpillDir). Unlike the mode passed to open(2), this
+	// isn't masked by the umask.
+	//
+	// Default: 0 (0644 for the Ring's file, less the umask, and 0600 for
+	// everything else)
+	FileMode os.FileMode
+
+	// FileOwner is the owner and group to give any file the Ring creates,
+	// such as to let an unprivileged group read a Ring written by a
+	// daemon running as root. Changing the owner usually needs root.
+	//
+	// Default: nil (whoever's running the process)
+	FileOwner *FileOwner
+
+	// Alignment is what the size of the Ring's data (and the header, with
+	// ReserveHeader) has to be a multiple of, and what it's mapped at,
+	// which has to be a multiple of the page size. This only needs to be
+	// set for files that need more than that, such as files on hugetlbfs
+	// (or with MAP_HUGETLB in MapFlags), which need the huge page size.
+	//
+	// With ReserveHeader, the alignment is stored in the header, and used
+	// every time the Ring is opened after that, so a Ring created on a
+	// system with 4K pages can be opened on one with larger pages, as long
+	// as it was created with an Alignment they all divide. If it wasn't,
+	// the Ring fails to open, rather than reading the data from the wrong
+	// place.
+	//
+	// Default: 0 (the alignment in the header, or the page size)
+	Alignment int
+
+	// RoundSize will round the size of each file down to the Alignment,
+	// leaving anything past the end unused, rather than failing to open a
+	// file that isn't aligned.
+	//
+	// Default: false
+	RoundSize bool
+
+	// MapFlags are extra flags passed to mmap when mapping the Ring's
+	// data (but not the header), such as syscall.MAP_POPULATE to fault
+	// the whole Ring in up front, MAP_LOCKED to keep it in memory, or
+	// MAP_NORESERVE to skip reserving swap for it. These aren't checked
+	// beyond making sure they don't touch the flags the Ring needs
+	// (MAP_SHARED, MAP_PRIVATE, MAP_FIXED and MAP_ANONYMOUS), so whatever
+	// the kernel makes of them goes.
+	//
+	// Default: 0
+	//
+	// These are ignored by the portable backend, which doesn't use mmap.
+	MapFlags int
+
+	// Heartbeat will start a goroutine which notes the time in the header
+	// this often, so that readers can tell a writer that's gone away from
+	// one that just hasn't had anything to write (see LastWriterActivity).
+	//
+	// Default: 0 (no heartbeat)
+	Heartbeat time.Duration
+
+	// WriteLease will make writers take turns, even across processes,
+	// by only letting the Ring that holds a lease kept in the header
+	// write (see AcquireWrite). The lease is renewed every third of this
+	// while it's held, and if the process holding it goes away without
+	// letting it go, it runs out after this long, and someone else can
+	// take it. This needs ReserveHeader, and isn't supported by the
+	// portable backend.
+	//
+	// Default: 0 (no lease)
+	WriteLease time.Duration
+
+	// SchemaVersions will store a schema version byte alongside each
+	// record (see Record.Schema), saying which version of the
+	// application's payload format the record was written in, so that
+	// old records can be rewritten in a new format with Migrate, rather
+	// than the Ring having to be emptied out.
+	//
+	// Default: false
+	//
+	// As with Timestamps, this changes how records are laid out in the
+	// file.
+	SchemaVersions bool
+
+	// SchemaVersion is the schema version stored alongside records that
+	// are written without one, which is everything but WriteRecord with
+	// a Record.Schema. This needs SchemaVersions, and turns it on.
+	//
+	// Default: 0
+	SchemaVersion uint8
+
+	// Debug will check the Ring over after every change to the cursor,
+	// walking each record from the head to the tail to make sure that the
+	// lengths chain together, and panic with a description of what's wrong
+	// if they don't. A few canary bytes are written after the data of each
+	// record, and checked on the walk, to catch anything writing past the
+	// end of a record.
+	//
+	// Default: false
+	//
+	// This is very slow, and is meant for tracking down bugs, not for use
+	// in production. As with Timestamps, this changes how records are
+	// laid out in the file.
+	Debug bool
 }
 
 // NewWithOptions will create a new Ring Buffer using the underlying file
@@ -183,36 +1007,130 @@ type Options struct {
 // Additionally, this will construct the Ring according to the options
 // set in the passed Options struct.
 func NewWithOptions(fd *os.File, options Options) (*Ring, error) {
-	stat, err := fd.Stat()
+	return newWithContext(context.Background(), []*os.File{fd}, options)
+}
+
+// NewMulti will create a new Ring Buffer whose data is spread over all of
+// the provided files, in order, presented as one logical Ring. This allows
+// a Ring to be larger than any single file can be (such as when limited by
+// the size of a partition). Each file must be aligned to the page size.
+//
+// If the ReserveHeader option is set, the header is stored in the first
+// page of the first file. The same files must be provided in the same
+// order every time the Ring is opened.
+func NewMulti(files []*os.File, options Options) (*Ring, error) {
+	return newWithContext(context.Background(), files, options)
+}
+
+// segment is a chunk of a file which makes up part of the ring's data.
+type segment struct {
+	fd     *os.File
+	offset int64
+	size   uintptr
+}
+
+// newWithContext does the actual work of NewWithOptions, giving up if the
+// context is done before the Ring is ready.
+func newWithContext(ctx context.Context, files []*os.File, options Options) (_ *Ring, err error) {
+	if len(files) == 0 {
+		return nil, fmt.Errorf("diskring: no files provided")
+	}
+	fd := files[0]
+	// The options are changed below as a HeaderType is set up, so keep
+	// hold of what we were given.
+	given := options
+
+	var typed *typedHeader
+	if options.RecoverByScan && !options.Timestamps {
+		return nil, fmt.Errorf("diskring: RecoverByScan needs Timestamps")
+	}
+	if options.SigningKey != nil && len(options.SigningKey) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("diskring: SigningKey isn't an Ed25519 private key")
+	}
+	if options.VerifyKey != nil && len(options.VerifyKey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("diskring: VerifyKey isn't an Ed25519 public key")
+	}
+	if options.NotifyFile != "" && options.ReadOnlyCursor && (options.Index || options.SparseIndex > 0 || options.Keys) {
+		return nil, fmt.Errorf("diskring: NotifyFile can't follow a writer with Index, SparseIndex or Keys")
+	}
+
+	if options.HeaderType != nil {
+		if !options.ReserveHeader || options.CustomHeader != nil {
+			return nil, fmt.Errorf("diskring: HeaderType needs ReserveHeader, and no CustomHeader")
+		}
+		if typed, err = newTypedHeader(options.HeaderType); err != nil {
+			return nil, err
+		}
+		if typed.replacesHeader() {
+			options.CustomHeader = typed.customHeader
+		}
+	}
+
+	if options.Lock {
+		for i, file := range files {
+			if err := lockFile(ctx, file, !options.ReadOnlyCursor); err != nil {
+				unlockFiles(files[:i])
+				return nil, err
+			}
+		}
+		defer func() {
+			if err != nil {
+				unlockFiles(files)
+			}
+		}()
+	}
+
+	segments := make([]segment, len(files))
+	for i, file := range files {
+		size, err := fileSize(file)
+		if err != nil {
+			return nil, err
+		}
+		if !options.ReadOnlyCursor && !options.AllowSparse {
+			if err := fillHoles(file, size); err != nil {
+				return nil, err
+			}
+		}
+		segments[i] = segment{fd: file, size: uintptr(size)}
+	}
+
+	align, err := alignment(fd, options)
 	if err != nil {
 		return nil, err
 	}
 
 	var (
-		size             = uintptr(stat.Size())
-		offset     int64 = 0
-		cur              = &Cursor{head: 0, tail: 0}
-		headerBase uintptr
+		offset      int64 = 0
+		cur         *Cursor
+		hdr         = newHeader()
+		typedHeader interface{}
+		headerPage  []byte
+
+		// cursorLost is set if there was a header on disk, but the
+		// cursor in it couldn't be trusted.
+		cursorLost bool
 	)
 	if options.ReserveHeader {
-		offset = int64(syscall.Getpagesize())
-		size -= uintptr(offset)
+		offset = int64(align)
+		segments[0].offset = offset
+		if segments[0].size < uintptr(offset) {
+			segments[0].size = 0
+		} else {
+			segments[0].size -= uintptr(offset)
+		}
 
 		if offset <= int64(unsafe.Sizeof(Cursor{})) {
 			return nil, fmt.Errorf("offset can't store cursor")
 		}
 
-		// the 1st argument ("offset") is actually the size, since
-		// we're allocating the pre-offset fd hunk.
-		headerBase, err = mmap(0, uintptr(offset),
-			syscall.PROT_READ|syscall.PROT_WRITE,
-			syscall.MAP_SHARED,
-			int(fd.Fd()), 0)
+		// "offset" is actually the size, since we're mapping the
+		// pre-offset fd hunk.
+		headerPage, err = mapHeader(fd, uintptr(offset))
 		if err != nil {
 			return nil, err
 		}
 
-		unsafeHeaderBase := unsafe.Pointer(headerBase)
+		unsafeHeaderBase := unsafe.Pointer(&headerPage[0])
 
 		// OK, we have the header allocated and ready for use. Now let's
 		// check if this is user controlled, or we can use it for our
@@ -220,8 +1138,38 @@ func NewWithOptions(fd *os.File, options Options) (*Ring, error) {
 
 		if options.CustomHeader == nil {
 			// If we don't have a custom header layout, we can go ahead
-			// and use the whooooooooooooole 4k block for 2 uintptrs.
-			cur = (*Cursor)(unsafeHeaderBase)
+			// and use the start of the 4k block for our own header,
+			// which has the cursor in it, and leave the rest to the
+			// user (see UserHeader).
+			if unsafe.Sizeof(header{}) > shadowOffset || offset <= libraryHeaderSize {
+				return nil, fmt.Errorf("offset can't store header")
+			}
+			hdr = (*header)(unsafeHeaderBase)
+			if options.ReadOnlyCursor {
+				// Take a copy before we migrate anything, so that
+				// we don't touch what's on disk.
+				hdrCopy, err := hdr.load()
+				if err != nil {
+					return nil, err
+				}
+				hdr = &hdrCopy
+			} else if err := hdr.settle(options.Lock); err != nil {
+				return nil, err
+			}
+			// If the magic was torn, the header would look like it's
+			// from before the library had one, so go back to the
+			// shadow before it's migrated (see recoverHeader).
+			if hdr.magic != headerMagic {
+				if shadow := shadowHeader(headerPage); shadow.committed() {
+					*hdr = *shadow
+				} else {
+					cursorLost = true
+				}
+			}
+			hdr.migrate()
+			if hdr.alignment == 0 {
+				hdr.alignment = uint64(align)
+			}
 		} else {
 			// Let's ask the user nicely to allocate us space for a
 			// diskring.Cursor. If we get one, we can overwrite our
@@ -235,104 +1183,393 @@ func NewWithOptions(fd *os.File, options Options) (*Ring, error) {
 			}
 		}
 
-		if options.ReadOnlyCursor {
+		if options.ReadOnlyCursor && cur != nil {
 			cur = &Cursor{head: cur.head, tail: cur.tail}
 		}
+
+		if typed != nil {
+			if typedHeader, err = typed.at(headerPage, options.ReadOnlyCursor); err != nil {
+				return nil, err
+			}
+		}
 	}
 
-	if int(size)%syscall.Getpagesize() != 0 {
-		return nil, fmt.Errorf("File must be aligned to page size")
+	var size uintptr
+	for i := range segments {
+		if options.RoundSize {
+			segments[i].size -= segments[i].size % align
+		}
+		if segments[i].size == 0 || segments[i].size%align != 0 {
+			return nil, fmt.Errorf("diskring: file must be aligned to %d bytes (see Options.RoundSize)", align)
+		}
+		size += segments[i].size
 	}
 
-	// First, we need to mmap a chunk that's twice the size of the file that
-	// we'll mmap, so that we can mmap two fixed offset blocks inside that
-	// block.
-	ringBase, err := mmap(0, size<<1,
-		syscall.PROT_NONE,
-		syscall.MAP_ANONYMOUS|syscall.MAP_PRIVATE,
-		-1, offset)
+	buf, err := mapRing(segments, size, align, options.MapFlags)
 	if err != nil {
 		return nil, err
 	}
 
-	ringOne, err := mmap(ringBase, size,
-		syscall.PROT_READ|syscall.PROT_WRITE,
-		syscall.MAP_FIXED|syscall.MAP_SHARED, int(fd.Fd()), offset)
-	if err != nil {
-		return nil, err
+	r := &Ring{
+		file:          fd,
+		files:         files,
+		dontCloseFile: options.DontCloseFile,
+		locked:        options.Lock,
+		size:          size,
+		align:         uintptr(align),
+
+		readOnly:         options.ReadOnlyCursor,
+		dontBlockReads:   options.DontBlockReads,
+		nonBlockingReads: options.NonBlockingReads,
+		readTimeout:      options.ReadTimeout,
+		readahead:        options.Readahead,
+
+		sizes:     &sizeStats{},
+		maxRecord: size / 4,
+		spillDir:  options.SpillDir,
+
+		validateWrite: options.ValidateWrite,
+		compressBelow: options.CompressBelow,
+		signingKey:    options.SigningKey,
+		verifyKey:     options.VerifyKey,
+		schema:        options.SchemaVersion,
+		observeTiming: options.ObserveTiming,
+		perms:         newFilePerms(options),
+		debug:         options.Debug,
+		wakeup:        make(chan struct{}),
+
+		headerPage: headerPage,
+		header:     hdr,
+		head:       &hdr.head,
+		tail:       &hdr.tail,
+
+		libraryHeader: options.ReserveHeader && options.CustomHeader == nil,
+		typedHeader:   typedHeader,
+
+		buf:      buf,
+		backend:  newBackend(segments),
+		segments: segments,
+		options:  given,
+
+		mutex:       sync.Mutex{},
+		blockWrites: false,
+	}
+	if cur != nil {
+		// If the CustomHeader gave us a Cursor, that's where the head and
+		// tail live, rather than in our header.
+		r.head, r.tail = &cur.head, &cur.tail
 	}
 
-	if ringBase != ringOne {
-		return nil, fmt.Errorf("mmap split our MAP_FIXED call")
+	if r.libraryHeader && r.recoverHeader() {
+		cursorLost = true
 	}
 
-	ringTwo, err := mmap(ringBase+size, size,
-		syscall.PROT_READ|syscall.PROT_WRITE,
-		syscall.MAP_FIXED|syscall.MAP_SHARED, int(fd.Fd()), offset)
-	if err != nil {
-		return nil, err
+	// If the record format has changed, we can't read any records that
+	// are already in the file. If the header isn't on disk though, we've
+	// got no way to know, so we'll have to take the caller's word for it.
+	format := options.format()
+	if r.header.format != format {
+		if r.libraryHeader && !r.empty() {
+			r.unmap()
+			return nil, ErrFormatMismatch
+		}
+		r.header.format = format
 	}
-	if ringTwo != ringOne+size {
-		return nil, fmt.Errorf("mmap split our mirror MAP_FIXED call")
+	r.layout = newLayout(format, r.align)
+
+	// Entries have to start on a word boundary to be sector aligned,
+	// which an empty Ring that used to have another format might not.
+	if r.layout.has(formatSector) && r.empty() && *r.tail%r.layout.wordSize != 0 {
+		r.reset()
 	}
 
-	return &Ring{
-		file:          fd,
-		dontCloseFile: options.DontCloseFile,
-		size:          size,
+	if max := uintptr(options.MaxRecordSize); max > 0 && max < r.maxRecord {
+		r.maxRecord = max
+	}
+	// The length has to fit in the word, without running into the padBit.
+	if max := r.layout.padBit - 1; max < r.maxRecord {
+		r.maxRecord = max
+	}
+
+	if !r.readOnly && options.Coalesce > 0 {
+		r.coalesce = options.Coalesce
+		if max := int(r.maxRecord); r.coalesce > max {
+			r.coalesce = max
+		}
+	}
+
+	if options.RecoverByScan {
+		// A header without the magic might just be a bare Cursor from
+		// before the library had a header, which is fine unless it
+		// doesn't hold together (or doesn't point at anything).
+		if cursorLost && r.libraryHeader && !r.empty() {
+			_, err := r.checkInvariants()
+			cursorLost = err != nil
+		}
+		if cursorLost || (!r.libraryHeader && cur == nil) {
+			if err := r.recoverByScan(ctx); err != nil {
+				r.unmap()
+				return nil, err
+			}
+		}
+	}
 
-		readOnly:       options.ReadOnlyCursor,
-		dontBlockReads: options.DontBlockReads,
-		wakeup:         make(chan struct{}),
+	// If the header wasn't committed since the last write, the process
+	// (or the machine) may have gone down with the tail on disk, but not
+	// everything before it.
+	if r.layout.has(formatChecksum) && !(r.libraryHeader && r.header.committed()) {
+		r.rollBackTorn()
+	}
 
-		headerBase: headerBase,
-		headerSize: uintptr(offset),
-		cursor:     cur,
+	// If the sequence numbers don't agree with the cursor, they weren't
+	// stored alongside it, so we need to go count the records ourselves.
+	if (r.len() == 0) != (r.records() == 0) {
+		if err := r.recount(ctx); err != nil {
+			r.unmap()
+			return nil, err
+		}
+	}
 
-		ringBase: ringBase,
-		ringOne:  ringOne,
-		ringTwo:  ringTwo,
+	if r.layout.has(formatChain) {
+		r.loadChain()
+	}
 
-		buf: *asByteSlice(ringBase, int(size<<1)),
+	r.consumed()
 
-		mutex:       sync.Mutex{},
-		blockWrites: false,
-	}, nil
+	if options.Index {
+		if err := r.buildIndex(ctx); err != nil {
+			r.unmap()
+			return nil, err
+		}
+	}
+
+	if options.CompactInterval > 0 && !options.Keys {
+		r.unmap()
+		return nil, ErrNoKeys
+	}
+
+	if options.WriteLease > 0 {
+		if !r.libraryHeader || r.readOnly {
+			r.unmap()
+			return nil, fmt.Errorf("diskring: WriteLease needs ReserveHeader, and no ReadOnlyCursor")
+		}
+		if err := shareHeader(); err != nil {
+			r.unmap()
+			return nil, err
+		}
+		r.leaseTerm = options.WriteLease
+		r.leaseOwner = r.header.newLeaseOwner()
+	}
+
+	if options.DontDump {
+		if err := r.dontDump(); err != nil {
+			r.unmap()
+			return nil, err
+		}
+	}
+
+	if options.Timings {
+		r.timings = &timingStats{}
+	}
+
+	if options.Keys {
+		if err := r.buildKeys(ctx); err != nil {
+			r.unmap()
+			return nil, err
+		}
+	}
+
+	if options.TierDir != "" && !r.readOnly {
+		if r.tier, err = newTier(options.TierDir, options.TierSegmentSize, options.TierMaxBytes, r.perms); err != nil {
+			r.unmap()
+			return nil, err
+		}
+	}
+
+	if r.compressBelow <= 0 {
+		r.compressBelow = defaultCompressBelow
+	}
+	for _, dictionary := range options.Dictionaries {
+		r.addDictionary(dictionary)
+	}
+
+	if options.NotifyFile != "" {
+		if r.notifier, err = openNotifier(options.NotifyFile, r.readOnly, r.perms); err != nil {
+			if r.tier != nil {
+				r.tier.close()
+			}
+			r.unmap()
+			return nil, err
+		}
+		if r.readOnly {
+			r.follow()
+		} else {
+			r.notify()
+		}
+	}
+
+	if options.SparseIndex > 0 {
+		r.sparseEvery = uint64(options.SparseIndex)
+		if err := r.loadSparse(); err != nil {
+			r.unmap()
+			return nil, err
+		}
+	}
+
+	if options.Readahead {
+		// This is only advice, so there's no need to fail the open if the
+		// kernel won't take it.
+		r.Prefetch()
+	}
+
+	// Make sure whatever was already in the file is in good shape, rather
+	// than waiting for the first write to find out.
+	r.check()
+
+	if !r.readOnly && (options.SyncMaxDelay > 0 || options.SyncMaxBytes > 0) {
+		r.syncer = newSyncer(r, options.SyncMaxDelay, uintptr(options.SyncMaxBytes))
+	}
+
+	if !r.readOnly && options.Heartbeat > 0 {
+		r.heartbeat = newHeartbeat(r, options.Heartbeat)
+	}
+
+	if r.coalesce > 0 {
+		delay := options.CoalesceDelay
+		if delay <= 0 {
+			delay = defaultCoalesceDelay
+		}
+		r.flusher = newPeriodic(delay, r.flushInBackground)
+	}
+
+	if !r.readOnly && options.CompactInterval > 0 {
+		r.compactor = newPeriodic(options.CompactInterval, r.compactInBackground)
+	}
+
+	if options.CheckFileInterval > 0 {
+		r.checker = newPeriodic(options.CheckFileInterval, r.checkFileInBackground)
+	}
+
+	if r.notifier != nil && r.readOnly {
+		interval := options.NotifyPollInterval
+		if interval <= 0 {
+			interval = defaultNotifyPollInterval
+		}
+		r.notifier.watcher = watchFile(options.NotifyFile, interval, r.followInBackground)
+	}
+
+	return r, nil
 }
 
 // Close will unmap all mapped memory, as well as close the underlying
 // file handle.
 func (r *Ring) Close() error {
+	// The notifier's watcher, the flusher, the checker, the compactor, the
+	// syncer and the heartbeat all need the mutex, so they have to be
+	// stopped first.
+	if r.flusher != nil {
+		r.flusher.Close()
+	}
+	if r.notifier != nil && r.notifier.watcher != nil {
+		r.notifier.watcher.Close()
+		r.notifier.watcher = nil
+	}
+	if r.checker != nil {
+		r.checker.Close()
+	}
+	if r.compactor != nil {
+		r.compactor.Close()
+	}
+	if r.syncer != nil {
+		r.syncer.Close()
+	}
+	if r.heartbeat != nil {
+		r.heartbeat.Close()
+	}
+	r.stopRenewingLease()
+
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
-	if r.headerBase != 0 {
-		if err := munmap(r.headerBase, r.headerSize); err != nil {
-			return err
-		}
+	// Nothing can be staged with a batch waiting, since staging flushes
+	// it first, so this is safe without the writeMutex.
+	if err := r.flushBatch(); err != nil {
+		return err
 	}
-	if err := munmap(r.ringOne, r.size); err != nil {
+	if err := r.releaseLease(); err != nil {
 		return err
 	}
-	if err := munmap(r.ringTwo, r.size); err != nil {
+	if err := r.saveSparse(); err != nil {
 		return err
 	}
-	if err := munmap(r.ringBase, r.size<<1); err != nil {
+	if r.tier != nil {
+		if err := r.tier.close(); err != nil {
+			return err
+		}
+	}
+	if r.notifier != nil {
+		if err := r.notifier.close(); err != nil {
+			return err
+		}
+	}
+	if r.libraryHeader && !r.readOnly && r.fileErr == nil {
+		if err := r.commitHeader(); err != nil {
+			return err
+		}
+	}
+	if err := r.unmap(); err != nil {
 		return err
 	}
 	if r.dontCloseFile {
+		if r.locked {
+			return unlockFiles(r.files)
+		}
 		return nil
 	}
-	return r.file.Close()
+	var err error
+	for _, file := range r.files {
+		if closeErr := file.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
 }
 
 // Reset will reset the cursors to empty the ring buffer, and start again
 // with the entire buffer unallocated. This will discard any data currently
 // in the buffer.
+//
+// If a transaction is open (see Begin), this will wait until it's been
+// committed or rolled back.
 func (r *Ring) Reset() {
+	r.writeMutex.Lock()
+	defer r.writeMutex.Unlock()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.reset()
+}
+
+// Clear will empty the ring buffer just like Reset, and then zero out all of
+// the data in it, so that nothing that was written before can be recovered
+// from the file.
+func (r *Ring) Clear() error {
+	r.writeMutex.Lock()
+	defer r.writeMutex.Unlock()
+
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
+
+	if r.readOnly {
+		return fmt.Errorf("diskring: read only")
+	}
 	r.reset()
+	data := r.buf[:r.size]
+	for i := range data {
+		data[i] = 0
+	}
+	r.written(0, r.size)
+	return nil
 }
 
 // vim: foldmethod=marker
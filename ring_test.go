@@ -0,0 +1,184 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testRingPath will return the path of a Ring in a new temporary directory,
+// which is removed when the test is done.
+func testRingPath(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "diskring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return filepath.Join(dir, "test.ring")
+}
+
+// openTestRingAt will open the Ring at path with the provided Options
+// (creating the file, CreateSize bytes large, if it's not set). Closing it
+// is up to the caller, so that it can be opened again.
+func openTestRingAt(t *testing.T, path string, options Options) *Ring {
+	t.Helper()
+	options.CreateIfMissing = true
+	if options.CreateSize == 0 {
+		options.CreateSize = 1 << 16
+	}
+	r, err := OpenWithOptions(path, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// openTestRing will create a Ring in a temporary directory with the provided
+// Options (creating the file, CreateSize bytes large, if it's not set),
+// which is closed and removed when the test is done.
+func openTestRing(t *testing.T, options Options) *Ring {
+	t.Helper()
+	r := openTestRingAt(t, testRingPath(t), options)
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+// writeRecords will write each record to the Ring.
+func writeRecords(t *testing.T, r *Ring, records ...string) {
+	t.Helper()
+	for _, record := range records {
+		if _, err := r.Write([]byte(record)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readRecord will read the next record from the Ring as a string.
+func readRecord(t *testing.T, r *Ring) string {
+	t.Helper()
+	buf := make([]byte, 1<<16)
+	n, err := r.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
+
+func TestReset(t *testing.T) {
+	r := openTestRing(t, Options{NonBlockingReads: true})
+	writeRecords(t, r, "one", "two")
+	r.Reset()
+	if r.Len() != 0 || r.Records() != 0 {
+		t.Fatalf("expected an empty ring, got %d records in %d bytes", r.Records(), r.Len())
+	}
+	if _, err := r.Read(make([]byte, 64)); err != ErrEmpty {
+		t.Fatalf("expected ErrEmpty, got %v", err)
+	}
+
+	writeRecords(t, r, "secret")
+	if err := r.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range r.buf[:r.size] {
+		if b != 0 {
+			t.Fatal("Clear left data behind")
+		}
+	}
+	writeRecords(t, r, "three")
+	if record := readRecord(t, r); record != "three" {
+		t.Fatalf("expected three, got %q", record)
+	}
+}
+
+func TestCreateIfMissing(t *testing.T) {
+	path := testRingPath(t)
+	page := pageSize()
+	if _, err := OpenWithOptions(path, Options{}); !os.IsNotExist(err) {
+		t.Fatalf("expected the missing file to be reported, got %v", err)
+	}
+	if _, err := OpenWithOptions(path, Options{CreateIfMissing: true}); err == nil {
+		t.Fatal("expected CreateIfMissing without a CreateSize to be refused")
+	}
+
+	r, err := OpenWithOptions(path, Options{CreateIfMissing: true, CreateSize: 1000, ReserveHeader: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Cap() != page {
+		t.Fatalf("expected the size to be rounded up to %d, got %d", page, r.Cap())
+	}
+	writeRecords(t, r, "kept")
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if stat, err := os.Stat(path); err != nil || stat.Size() != int64(2*page) {
+		t.Fatalf("expected a %d byte file with the header (%v)", 2*page, err)
+	}
+
+	// A file that's already there is opened as it is.
+	r, err = OpenWithOptions(path, Options{CreateIfMissing: true, CreateSize: 1 << 20, ReserveHeader: true, NonBlockingReads: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if r.Cap() != page || readRecord(t, r) != "kept" {
+		t.Fatalf("expected the existing Ring to be opened, got %d bytes", r.Cap())
+	}
+}
+
+func TestDontCloseFile(t *testing.T) {
+	path := testRingPath(t)
+	for _, dontClose := range []bool{true, false} {
+		fd, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := fd.Truncate(1 << 16); err != nil {
+			t.Fatal(err)
+		}
+		r, err := NewWithOptions(fd, Options{DontCloseFile: dontClose})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fd.Stat(); (err == nil) != dontClose {
+			t.Fatalf("DontCloseFile is %t, but the file is closed: %t", dontClose, err != nil)
+		}
+		fd.Close()
+	}
+
+	// A file opened by path is always closed.
+	r := openTestRingAt(t, path, Options{DontCloseFile: true})
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.file.Stat(); err == nil {
+		t.Fatal("expected the file opened by path to be closed")
+	}
+}
+
+// vim: foldmethod=marker
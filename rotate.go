@@ -0,0 +1,100 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"fmt"
+	"os"
+)
+
+// Rotate will move every record in the Ring out to a new Ring at the
+// provided path, leaving this Ring empty, and return the new Ring. This is
+// for keeping hold of what was in the Ring at a point in time (such as when
+// something's gone wrong) while writes carry on: anything written after
+// Rotate goes into this Ring, and everything written before it is in the
+// returned Ring, for consumers to finish draining (or to be kept as an
+// archive). No record ends up in both, or neither.
+//
+// The returned Ring is opened with the same Options as this one, and its
+// file is created with the same size, mode and owner. The file must not
+// already exist. Sequence numbers carry on from where they were, so the
+// records in the returned Ring keep theirs, and the next record written to
+// this Ring gets the one after the last of them. Records spilled to side
+// files are handed over to the returned Ring.
+//
+// This needs the Ring to have its header on disk (see Options.ReserveHeader),
+// since the returned Ring is no good without its cursor. Everything using
+// the Ring is held up while the records are copied out, and it waits for any
+// open Txn like Write.
+func (r *Ring) Rotate(path string) (*Ring, error) {
+	r.writeMutex.Lock()
+	defer r.writeMutex.Unlock()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if !r.libraryHeader {
+		return nil, fmt.Errorf("diskring: Rotate needs the header on disk (see Options.ReserveHeader)")
+	}
+	if r.readOnly {
+		return nil, fmt.Errorf("diskring: read only")
+	}
+	if r.fileErr != nil {
+		return nil, r.fileErr
+	}
+
+	// The retired Ring gets the header as it stands, already committed,
+	// so that it opens without having to be checked over.
+	hdr := *r.header
+	hdr.checksum = hdr.sum()
+	headerPage := append([]byte{}, r.headerPage...)
+	copy(headerPage, hdr.bytes())
+	copy(headerPage[shadowOffset:], hdr.bytes())
+
+	fd, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.perms.apply(fd); err != nil {
+		fd.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	if err := r.cloneInto(fd, headerPage); err != nil {
+		fd.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	if err := fd.Close(); err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+
+	// Once the copy is safely on disk, the records belong to it, along
+	// with any side files they hold on to.
+	r.resetCursor()
+
+	options := r.options
+	options.CreateIfMissing = false
+	return OpenWithOptions(path, options)
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,81 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"os"
+	"testing"
+)
+
+func TestRotate(t *testing.T) {
+	r := openTestRing(t, Options{ReserveHeader: true, NonBlockingReads: true})
+	writeRecords(t, r, "one", "two")
+
+	path := testRingPath(t)
+	retired, err := r.Rotate(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer retired.Close()
+	if r.Records() != 0 {
+		t.Fatalf("expected the ring to be empty, got %d records", r.Records())
+	}
+	writeRecords(t, r, "three")
+
+	stats, err := retired.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.HeadSequence != 0 || stats.TailSequence != 2 {
+		t.Fatalf("expected the retired ring to hold 0 to 2, got %d to %d", stats.HeadSequence, stats.TailSequence)
+	}
+	if stats, err = r.Stats(); err != nil {
+		t.Fatal(err)
+	}
+	if stats.TailSequence != 3 {
+		t.Fatalf("expected the sequence numbers to carry on, got %d", stats.TailSequence)
+	}
+
+	for _, expected := range []string{"one", "two"} {
+		if record := readRecord(t, retired); record != expected {
+			t.Fatalf("expected %s from the retired ring, got %q", expected, record)
+		}
+	}
+	if _, err := retired.Read(make([]byte, 64)); err != ErrEmpty {
+		t.Fatalf("expected the retired ring to be drained, got %v", err)
+	}
+	if record := readRecord(t, r); record != "three" {
+		t.Fatalf("expected three, got %q", record)
+	}
+
+	if _, err := r.Rotate(path); !os.IsExist(err) {
+		t.Fatalf("expected rotating onto an existing file to fail, got %v", err)
+	}
+}
+
+func TestRotateWithoutHeader(t *testing.T) {
+	r := openTestRing(t, Options{})
+	if _, err := r.Rotate(testRingPath(t)); err == nil {
+		t.Fatal("expected Rotate to need the header on disk")
+	}
+}
+
+// vim: foldmethod=marker
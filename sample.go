@@ -0,0 +1,110 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// Sampling is which records a Consumer reads, when it only needs some of
+// them. Records that aren't picked are passed over without being copied (or
+// even looked at, beyond their length).
+//
+// A batch of coalesced records (see Options.Coalesce) is picked or passed
+// over as a whole, and counts as a single record.
+type Sampling struct {
+	// Every will have the Consumer read only one in every so many
+	// records, starting with the first one it comes to.
+	//
+	// Default: 0 (every record)
+	Every int
+
+	// Fraction will have the Consumer read each record at random, with
+	// this chance (between 0 and 1). If Every is also set, the Consumer
+	// reads one in every so many records, and then only this fraction of
+	// those.
+	//
+	// Default: 0 (every record)
+	Fraction float64
+}
+
+// sampler is a Consumer's Sampling, and how far through it the Consumer is.
+// This is protected by the Ring's mutex.
+type sampler struct {
+	Sampling
+
+	// count is the number of records the Consumer has come to since it
+	// was last told to sample.
+	count uint64
+	rand  *rand.Rand
+}
+
+// Sample will have the Consumer only read some of the records, passing over
+// the rest, which are counted by Skipped rather than Dropped. Records that
+// leave the Ring before the Consumer gets to them are still counted by
+// Dropped, whether or not they'd have been picked. A Sampling with nothing
+// set goes back to reading every record.
+func (c *Consumer) Sample(s Sampling) error {
+	if s.Every < 0 || s.Fraction < 0 || s.Fraction > 1 {
+		return fmt.Errorf("diskring: invalid sampling for consumer %q", c.name)
+	}
+
+	r := c.r
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	c.sampler = sampler{Sampling: s}
+	if s.Fraction > 0 {
+		c.sampler.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	return nil
+}
+
+// Skipped will return the number of records the Consumer has passed over
+// because of its Sampling, since it was opened.
+func (c *Consumer) Skipped() uint64 {
+	return atomic.LoadUint64(&c.skipped)
+}
+
+// UNSAFE
+//
+// Check if the Consumer's Sampling picks the next record it's come to,
+// counting it as skipped if not.
+func (c *Consumer) sample() bool {
+	s := &c.sampler
+	picked := true
+	if s.Every > 1 {
+		picked = s.count%uint64(s.Every) == 0
+		s.count++
+	}
+	if picked && s.rand != nil {
+		picked = s.rand.Float64() < s.Fraction
+	}
+	if !picked {
+		atomic.AddUint64(&c.skipped, 1)
+	}
+	return picked
+}
+
+// vim: foldmethod=marker
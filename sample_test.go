@@ -0,0 +1,73 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestSampleEvery(t *testing.T) {
+	r := openTestRing(t, Options{})
+	for i := 0; i < 10; i++ {
+		writeRecords(t, r, fmt.Sprint(i))
+	}
+	c, err := r.Cursor("sampler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Sample(Sampling{Every: -1}); err == nil {
+		t.Fatal("expected a negative Every to be refused")
+	}
+	if err := c.Sample(Sampling{Every: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if records := readAll(t, c); !reflect.DeepEqual(records, []string{"0", "3", "6", "9"}) {
+		t.Fatalf("expected every third record, got %q", records)
+	}
+	if c.Skipped() != 6 || c.Dropped() != 0 {
+		t.Fatalf("expected 6 records skipped and none dropped, got %d and %d", c.Skipped(), c.Dropped())
+	}
+}
+
+func TestSampleFraction(t *testing.T) {
+	r := openTestRing(t, Options{})
+	for i := 0; i < 1000; i++ {
+		writeRecords(t, r, fmt.Sprint(i))
+	}
+	c, err := r.Cursor("sampler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Sample(Sampling{Fraction: 0.5}); err != nil {
+		t.Fatal(err)
+	}
+	read := len(readAll(t, c))
+	if read < 350 || read > 650 {
+		t.Fatalf("expected about half of the records to be read, got %d", read)
+	}
+	if read+int(c.Skipped()) != 1000 {
+		t.Fatalf("expected every record to be read or skipped, got %d and %d", read, c.Skipped())
+	}
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,178 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"bytes"
+	"context"
+	"time"
+)
+
+// scanEpoch is the earliest timestamp a record found by scanning can have.
+// Anything before this is taken to be something other than a timestamp.
+var scanEpoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano()
+
+// UNSAFE
+//
+// Rebuild the cursor by scanning the ring for records, for when the cursor
+// was lost (see Options.RecoverByScan). There's nothing in the data that
+// says where the head and tail were, so this goes looking for the longest
+// run of records that chain together with timestamps that never go
+// backwards: the tail is where the newest record is followed by something
+// that isn't a newer record, and the head is the oldest record that still
+// chains all the way up to it.
+//
+// Records that were consumed, but not yet overwritten, look exactly like
+// records that weren't, so they'll come back. If nothing that looks like a
+// record is found, the ring is left empty.
+func (r *Ring) recoverByScan(ctx context.Context) error {
+	// Allow for the clock having been a little ahead when the records
+	// were written.
+	latest := time.Now().Add(24 * time.Hour).UnixNano()
+
+	step := uintptr(1)
+	if r.layout.has(formatSector) {
+		step = r.layout.wordSize
+	}
+
+	// First, find any record at all, and follow it forwards to the tail.
+	start, found, err := r.scanFrom(ctx, 0, step, func(off uintptr) bool {
+		return r.plausibleEntry(off, latest)
+	})
+	if err != nil || !found {
+		return err
+	}
+	tail, _, _ := r.chainFrom(start, r.size, latest)
+
+	// Then, the head is the first record after the tail (so, the oldest
+	// one) which chains up to it.
+	var count uint64
+	head, found, err := r.scanFrom(ctx, tail+step, step, func(off uintptr) bool {
+		if !r.plausibleEntry(off, latest) {
+			return false
+		}
+		end, n, ok := r.chainFrom(off, tail, latest)
+		count = n
+		return ok && end == tail
+	})
+	if err != nil || !found {
+		return err
+	}
+
+	r.beginUpdate()
+	r.setHead(head, r.header.headSeq)
+	r.setTail(tail, r.header.headSeq+count)
+	r.header.last = 0
+	r.endUpdate()
+	return nil
+}
+
+// UNSAFE
+//
+// Return the first offset in the ring, starting at from and moving along
+// by step bytes (wrapping around the end), that match returns true for.
+// On a very large ring this can take a while, so it'll give up if the
+// context is done.
+func (r *Ring) scanFrom(ctx context.Context, from, step uintptr, match func(uintptr) bool) (uintptr, bool, error) {
+	for i := uintptr(0); i < r.size; i += step {
+		if i%(1<<20) == 0 {
+			if err := ctx.Err(); err != nil {
+				return 0, false, err
+			}
+		}
+		if off := (from + i) % r.size; match(off) {
+			return off, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// UNSAFE
+//
+// Follow the records starting at the provided offset for as long as they
+// look like records, and their timestamps don't go backwards, or until
+// reaching until, returning where that stopped, how many records were
+// followed, and if it stopped at until. latest is the latest timestamp a
+// record can have.
+func (r *Ring) chainFrom(off, until uintptr, latest int64) (uintptr, uint64, bool) {
+	var (
+		count uint64
+		used  uintptr
+		last  int64
+	)
+	for off != until && r.plausibleEntry(off, latest) {
+		when := r.entryTime(off)
+		size := (r.nextEntry(off) + r.size - off) % r.size
+		if when < last || size == 0 || used+size >= r.size {
+			break
+		}
+		last = when
+		used += size
+		count++
+		off = (off + size) % r.size
+	}
+	return off, count, off == until
+}
+
+// UNSAFE
+//
+// Determine if there looks to be a record at the provided offset, going by
+// everything we can check without knowing where the head or tail are.
+// latest is the latest timestamp a record can have.
+func (r *Ring) plausibleEntry(off uintptr, latest int64) bool {
+	start := r.entryStart(off)
+	if start-off >= r.layout.sectorSize {
+		return false
+	}
+	if r.layout.has(formatSector) && start%r.layout.wordSize != 0 {
+		return false
+	}
+	length := r.word(start)
+	if length > r.maxRecord || r.entryKeyLength(off) > length {
+		return false
+	}
+	when := r.entryTime(off)
+	if when < scanEpoch || when > latest {
+		return false
+	}
+	size := r.entrySize(length)
+	if r.layout.has(formatCanary) {
+		end := start + r.layout.wordSize + r.layout.envelopeSize + length
+		if !bytes.Equal(r.buf[end:end+uintptr(len(canary))], canary[:]) {
+			return false
+		}
+	}
+	if r.layout.has(formatTrailer) {
+		wordSize := r.layout.wordSize
+		if r.word(start+size-wordSize) != length {
+			return false
+		}
+		if r.layout.has(formatSector) && r.word(start+size-2*wordSize) != start-off {
+			return false
+		}
+	}
+	if r.layout.has(formatChecksum) && !r.checksumMatches(start, length) {
+		return false
+	}
+	return true
+}
+
+// vim: foldmethod=marker
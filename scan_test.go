@@ -0,0 +1,63 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestRecoverByScan(t *testing.T) {
+	path := testRingPath(t)
+	// Without a header, the cursor isn't kept anywhere.
+	options := Options{CreateSize: 4096, Timestamps: true, RecoverByScan: true, NonBlockingReads: true}
+	r := openTestRingAt(t, path, options)
+	var written []string
+	for i := 0; i < 100; i++ {
+		record := fmt.Sprintf("record %03d %s", i, "padding padding padding padding padding")
+		writeRecords(t, r, record)
+		written = append(written, record)
+	}
+	kept := written[len(written)-r.Records():]
+	if len(kept) == len(written) {
+		t.Fatal("expected the records to wrap around")
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r = openTestRingAt(t, path, options)
+	defer r.Close()
+	var recovered []string
+	for r.Records() > 0 {
+		recovered = append(recovered, readRecord(t, r))
+	}
+	if !reflect.DeepEqual(recovered, kept) {
+		t.Fatalf("expected the %d records kept to be recovered, got %d:\n%q", len(kept), len(recovered), recovered)
+	}
+
+	if _, err := OpenWithOptions(path, Options{RecoverByScan: true}); err == nil {
+		t.Fatal("expected RecoverByScan without Timestamps to be refused")
+	}
+}
+
+// vim: foldmethod=marker
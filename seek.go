@@ -0,0 +1,123 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"time"
+)
+
+// SeekToSequence will move the reader forward to the record with the
+// provided sequence number, consuming everything before it. If that record
+// has already been consumed or overwritten, this will return an
+// EvictedError, and if it hasn't been written yet, an ErrOutOfRange.
+//
+// With the Index or SparseIndex options, this doesn't have to walk the
+// Ring from the head to find the record.
+func (r *Ring) SeekToSequence(seq uint64) error {
+	r.mutex.Lock()
+	err := r.seekToSequence(seq)
+	r.mutex.Unlock()
+	if err == nil && r.readahead {
+		r.Prefetch()
+	}
+	return err
+}
+
+// seekToSequence will move the reader forward to the record with the
+// provided sequence number.
+func (r *Ring) seekToSequence(seq uint64) error {
+	if seq < r.header.headSeq {
+		return &EvictedError{Offset: Offset(seq), Oldest: Offset(r.header.headSeq)}
+	}
+	if seq > r.header.tailSeq {
+		return ErrOutOfRange
+	}
+	off, err := r.findSequence(seq)
+	if err != nil {
+		return err
+	}
+	r.skipTo(off, seq)
+	return nil
+}
+
+// SeekToTime will move the reader forward to the oldest record written at
+// or after the provided time, consuming everything before it. If every
+// record was written before then, this will empty the Ring.
+//
+// This requires the Timestamps option. With the SparseIndex option, this
+// doesn't have to walk the Ring from the head to find the record.
+func (r *Ring) SeekToTime(when time.Time) error {
+	r.mutex.Lock()
+	err := r.seekToTime(when)
+	r.mutex.Unlock()
+	if err == nil && r.readahead {
+		r.Prefetch()
+	}
+	return err
+}
+
+// seekToTime will move the reader forward to the oldest record written at
+// or after the provided time.
+func (r *Ring) seekToTime(when time.Time) error {
+	if !r.layout.has(formatTimestamp) {
+		return ErrNoTimestamps
+	}
+	nanos := when.UnixNano()
+	off, seq := r.nearestTime(nanos)
+	for ; off != *r.tail && r.entryTime(off) < nanos; seq++ {
+		off = r.nextEntry(off)
+	}
+	r.skipTo(off, seq)
+	return nil
+}
+
+// UNSAFE
+//
+// Move the head forward to the entry at the provided offset, which has the
+// sequence number seq, without walking every entry in between, if we can
+// get away with it.
+func (r *Ring) skipTo(off uintptr, seq uint64) {
+	defer r.consumed()
+
+	if r.layout.has(formatFlags) || r.tier != nil {
+		// Entries might have data spilled into other files, which has
+		// to be cleaned up one by one, or have to go into the tier.
+		for r.header.headSeq < seq {
+			r.advanceHead()
+		}
+		return
+	}
+	if seq == r.header.headSeq {
+		return
+	}
+
+	n := seq - r.header.headSeq
+	r.beginUpdate()
+	r.setHead(off, seq)
+	r.endUpdate()
+	if r.indexed {
+		r.index = r.index[n:]
+	}
+	r.releaseOwned()
+	r.dropSparse()
+}
+
+// vim: foldmethod=marker
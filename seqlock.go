@@ -0,0 +1,144 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// The cursor and sequence numbers are guarded by a seqlock as well as the
+// mutex, so that monitoring (Stats, Len, Records and Free) can read them
+// without ever taking the mutex, and without ever holding up a writer.
+//
+// Anything moving the cursor must hold the mutex, and wrap the change in
+// beginUpdate and endUpdate, storing the new values atomically. Anything
+// holding the mutex can read them as normal.
+//
+// The seqlock lives in the header, so when the header is on disk, it's
+// shared with any other process that has the file mapped, and the same
+// protocol keeps them in step too:
+//
+//   - A writer copies the record (and anything else about it, such as its
+//     trailer and checksum) into the mapping first, with plain stores.
+//   - It then bumps the seqlock to odd, stores the new cursor, sequence
+//     numbers and anything else describing the records (such as the last
+//     entry and the hash chain) atomically, and bumps the seqlock back to
+//     even. The bumps are atomic read-modify-writes, which are full
+//     barriers, so none of the records' bytes can become visible after
+//     the tail that covers them (store-release), and nothing describing
+//     the records can become visible before the seqlock goes odd.
+//   - A reader loads the seqlock, waiting while it's odd, loads the cursor
+//     and sequence numbers atomically, and loads the seqlock again,
+//     starting over if it's changed. Those loads are acquires, so once a
+//     reader has seen a tail, every byte of every record before it is
+//     there to be read.
+//
+// Go's atomics are sequentially consistent, which is stronger than the
+// release and acquire this needs, and since they're ordinary instructions
+// on shared memory, they order things between processes just the same as
+// between goroutines. A custom header (see CustomHeader) keeps the seqlock
+// in memory, so only this process follows it.
+
+// snapshot is a consistent view of the cursor and sequence numbers.
+type snapshot struct {
+	head    uintptr
+	tail    uintptr
+	headSeq uint64
+	tailSeq uint64
+}
+
+// UNSAFE
+//
+// Mark the start of a change to the cursor or sequence numbers.
+func (r *Ring) beginUpdate() {
+	atomic.AddUint32(&r.header.update, 1)
+}
+
+// UNSAFE
+//
+// Mark the end of a change to the cursor or sequence numbers.
+func (r *Ring) endUpdate() {
+	atomic.AddUint32(&r.header.update, 1)
+	r.headerWritten()
+	r.check()
+}
+
+// UNSAFE
+//
+// Set the head, and the sequence number of the record at the head. This
+// must be between beginUpdate and endUpdate.
+func (r *Ring) setHead(head uintptr, seq uint64) {
+	atomic.StoreUintptr(r.head, head)
+	atomic.StoreUint64(&r.header.headSeq, seq)
+}
+
+// UNSAFE
+//
+// Set the tail, and the sequence number the next record written will get.
+// This must be between beginUpdate and endUpdate.
+func (r *Ring) setTail(tail uintptr, seq uint64) {
+	atomic.StoreUintptr(r.tail, tail)
+	atomic.StoreUint64(&r.header.tailSeq, seq)
+}
+
+// snapshot will read the cursor and sequence numbers without taking the
+// mutex. If a change is being made, this will spin until it's done, which
+// is never for long, unless another process sharing the header went away
+// partway through one. In that case, this gives up after the settleTimeout,
+// and returns ErrHeaderBusy.
+func (r *Ring) snapshot() (snapshot, error) {
+	var deadline time.Time
+	for {
+		update := atomic.LoadUint32(&r.header.update)
+		if update%2 == 0 {
+			s := snapshot{
+				head:    atomic.LoadUintptr(r.head),
+				tail:    atomic.LoadUintptr(r.tail),
+				headSeq: atomic.LoadUint64(&r.header.headSeq),
+				tailSeq: atomic.LoadUint64(&r.header.tailSeq),
+			}
+			if atomic.LoadUint32(&r.header.update) == update {
+				return s, nil
+			}
+		}
+		// Only look at the clock once the first try has failed, since
+		// that's almost always enough.
+		if deadline.IsZero() {
+			deadline = time.Now().Add(settleTimeout)
+		} else if time.Now().After(deadline) {
+			return snapshot{}, ErrHeaderBusy
+		}
+		runtime.Gosched()
+	}
+}
+
+// used will return the number of bytes between the head and the tail of
+// the snapshot, in a ring of the provided size.
+func (s snapshot) used(size uintptr) uintptr {
+	if s.head > s.tail {
+		return (size - s.head) + s.tail
+	}
+	return s.tail - s.head
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,81 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+//go:build !diskring_portable && !wasip1
+// +build !diskring_portable,!wasip1
+
+package diskring
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+// stickUpdate will leave the seqlock in r's header held, as a process that
+// crashed partway through moving the cursor would have.
+func stickUpdate(r *Ring) {
+	atomic.AddUint32(&r.header.update, 1)
+}
+
+func TestStatsHeaderBusy(t *testing.T) {
+	r := openTestRing(t, Options{ReserveHeader: true})
+	stickUpdate(r)
+	if _, err := r.Stats(); err != ErrHeaderBusy {
+		t.Fatalf("expected ErrHeaderBusy, got %v", err)
+	}
+}
+
+func TestOpenHeaderBusy(t *testing.T) {
+	r := openTestRing(t, Options{ReserveHeader: true})
+	stickUpdate(r)
+
+	for _, options := range []Options{
+		{ReserveHeader: true},
+		{ReserveHeader: true, ReadOnlyCursor: true},
+	} {
+		if other, err := OpenWithOptions(r.file.Name(), options); err != ErrHeaderBusy {
+			if other != nil {
+				other.Close()
+			}
+			t.Fatalf("expected ErrHeaderBusy, got %v", err)
+		}
+	}
+}
+
+func TestOpenLockedRepairsHeader(t *testing.T) {
+	r := openTestRing(t, Options{ReserveHeader: true})
+	if _, err := r.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	stickUpdate(r)
+
+	other, err := OpenWithOptions(r.file.Name(), Options{ReserveHeader: true, Lock: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if n := other.Records(); n != 1 {
+		t.Fatalf("expected 1 record, got %d", n)
+	}
+	if _, err := r.Stats(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,129 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"hash/crc32"
+	"unsafe"
+)
+
+// shadowOffset is where the shadow copy of the header lives in the header
+// page, after the header itself, but still inside the part of the page
+// kept for the library.
+//
+// The header is changed in place as the Ring is used, so if the machine
+// goes down in the middle of writing the header page back to disk, the
+// header on disk could be half old and half new. To make sure the Ring can
+// always be opened again, every Sync (and Close) commits the header: it
+// checksums the header and flushes it out, then copies it into the shadow
+// and flushes that out. Since the header doesn't change in between, only
+// one of the two copies is ever being written at a time, so at least one
+// of them always holds together.
+const shadowOffset = 1024
+
+// crcTable is the table used to checksum the header.
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// UNSAFE
+//
+// Return the checksum of the header, as it'd be stored in the header's
+// checksum field. The write lease and its owners are left out, since they're
+// changed by other processes without committing the header.
+func (h *header) sum() uint64 {
+	hdr := *h
+	hdr.checksum = 0
+	hdr.owners = 0
+	hdr.lease = 0
+	return uint64(crc32.Checksum(hdr.bytes(), crcTable))
+}
+
+// UNSAFE
+//
+// Determine if the header hasn't changed since it was last committed.
+func (h *header) committed() bool {
+	return h.magic == headerMagic && h.checksum != 0 && h.checksum == h.sum()
+}
+
+// UNSAFE
+//
+// Return the shadow copy of the header in the header page.
+func shadowHeader(headerPage []byte) *header {
+	return (*header)(unsafe.Pointer(&headerPage[shadowOffset]))
+}
+
+// UNSAFE
+//
+// Checksum the header, and flush it out to disk, then copy it into the
+// shadow, and flush that out too. The caller must hold the mutex, so that
+// nothing changes the header while this is going on.
+func (r *Ring) commitHeader() error {
+	r.header.checksum = r.header.sum()
+	r.headerWritten()
+	if err := r.syncHeader(); err != nil {
+		return err
+	}
+	*shadowHeader(r.headerPage) = *r.header
+	r.headerWritten()
+	return r.syncHeader()
+}
+
+// UNSAFE
+//
+// Check over the header after it's been mapped, and if it was torn by a
+// crash part way through being written out, go back to the shadow copy
+// from the last commit.
+//
+// If the header hasn't changed since it was committed, there's nothing to
+// check. Otherwise, the Ring was most likely still being used when the
+// process (or the machine) went down, and the header is newer than the
+// shadow, so we'll walk the records to see if it holds together before
+// falling back to the shadow, which loses anything since the last commit.
+// If the records the shadow points to have been overwritten since then
+// too, all we can do is empty the Ring, keeping the sequence numbers going,
+// and return true, since the cursor was lost.
+func (r *Ring) recoverHeader() bool {
+	shadow := shadowHeader(r.headerPage)
+	if !shadow.committed() || r.header.committed() {
+		return false
+	}
+
+	r.layout = newLayout(r.header.format, r.align)
+	if _, err := r.checkInvariants(); err == nil && r.header.magic == headerMagic {
+		return false
+	}
+
+	// Keep handing out new lease owners, since Rings that got theirs
+	// since the commit may still be open.
+	owners := r.header.owners
+	*r.header = *shadow
+	r.header.owners = owners
+	r.layout = newLayout(r.header.format, r.align)
+	lost := false
+	if _, err := r.checkInvariants(); err != nil {
+		r.header.head, r.header.headSeq = r.header.tail, r.header.tailSeq
+		r.header.last = 0
+		lost = true
+	}
+	r.headerWritten()
+	return lost
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,71 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"os"
+	"testing"
+	"unsafe"
+)
+
+func TestShadowHeader(t *testing.T) {
+	var h header
+	for name, field := range map[string]uintptr{
+		"headSeq": unsafe.Offsetof(h.headSeq),
+		"magic":   unsafe.Offsetof(h.magic),
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := testRingPath(t)
+			options := Options{ReserveHeader: true, NonBlockingReads: true}
+			r := openTestRingAt(t, path, options)
+			writeRecords(t, r, "one", "two", "three")
+			readRecord(t, r)
+			if err := r.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			// Tear the header, as if the machine went down part way
+			// through writing it out.
+			fd, err := os.OpenFile(path, os.O_RDWR, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			garbage := []byte{0xde, 0xad, 0xbe, 0xef, 0xde, 0xad, 0xbe, 0xef}
+			if _, err := fd.WriteAt(garbage, int64(field)); err != nil {
+				t.Fatal(err)
+			}
+			fd.Close()
+
+			r = openTestRingAt(t, path, options)
+			defer r.Close()
+			if stats, err := r.Stats(); err != nil || stats.HeadSequence != 1 || stats.Records != 2 {
+				t.Fatalf("expected 2 records from sequence 1, got %+v (%v)", stats, err)
+			}
+			for _, want := range []string{"two", "three"} {
+				if record := readRecord(t, r); record != want {
+					t.Fatalf("expected %q from the shadow header, got %q", want, record)
+				}
+			}
+		})
+	}
+}
+
+// vim: foldmethod=marker
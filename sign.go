@@ -0,0 +1,86 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"crypto/ed25519"
+	"errors"
+)
+
+// signatureSize is the size of the signature stored in each record with a
+// SigningKey.
+const signatureSize = ed25519.SignatureSize
+
+var (
+	// ErrBadSignature is returned when reading a record whose signature
+	// doesn't match the VerifyKey.
+	ErrBadSignature = errors.New("diskring: record signature doesn't match")
+
+	// ErrNoSigningKey is returned when writing to a Ring whose records
+	// are signed, without a SigningKey to sign them with.
+	ErrNoSigningKey = errors.New("diskring: records are signed, but there's no SigningKey")
+)
+
+// UNSAFE
+//
+// Return what's signed in the entry whose length (after any padding) is at
+// start: the hash chain, if there is one, and the key and data, which all
+// sit together at the end of the envelope.
+func (r *Ring) signedBytes(start, length uintptr) []byte {
+	base := start + r.layout.wordSize
+	from := base + r.layout.envelopeSize
+	if r.layout.has(formatChain) {
+		from = base + r.layout.chainOffset
+	}
+	return r.buf[from : base+r.layout.envelopeSize+length]
+}
+
+// UNSAFE
+//
+// Write the signature into the entry whose length is at start, or if it's
+// nil, sign the entry with the SigningKey.
+func (r *Ring) putSignature(start, length uintptr, signature []byte) {
+	if signature == nil {
+		signature = ed25519.Sign(r.signingKey, r.signedBytes(start, length))
+	}
+	copy(r.buf[start+r.layout.wordSize+r.layout.signatureOffset:], signature)
+}
+
+// UNSAFE
+//
+// Return the signature stored in the entry at the provided offset. This is
+// a slice into the mapping, so it must be copied out before the mutex is
+// released.
+func (r *Ring) entrySignature(off uintptr) []byte {
+	base := r.entryStart(off) + r.layout.wordSize + r.layout.signatureOffset
+	return r.buf[base : base+signatureSize]
+}
+
+// UNSAFE
+//
+// Determine if the signature of the entry at the provided offset matches
+// the VerifyKey.
+func (r *Ring) signatureValid(off uintptr) bool {
+	start := r.entryStart(off)
+	return ed25519.Verify(r.verifyKey, r.signedBytes(start, r.word(start)), r.entrySignature(off))
+}
+
+// vim: foldmethod=marker
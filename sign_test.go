@@ -0,0 +1,86 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"testing"
+)
+
+func TestSignatures(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := openTestRing(t, Options{SigningKey: private, VerifyKey: public, NonBlockingReads: true})
+	writeRecords(t, r, "one", "two", "three")
+	if record := readRecord(t, r); record != "one" {
+		t.Fatalf("expected one, got %q", record)
+	}
+
+	// A changed record fails to verify, and is left for the reader to
+	// skip past.
+	i := bytes.Index(r.buf[:r.size], []byte("two"))
+	if i < 0 {
+		t.Fatal("record not found in the ring")
+	}
+	r.buf[i] = 'T'
+	if _, err := r.Read(make([]byte, 16)); err != ErrBadSignature {
+		t.Fatalf("expected ErrBadSignature, got %v", err)
+	}
+	if _, err := r.Skip(1); err != nil {
+		t.Fatal(err)
+	}
+	if record := readRecord(t, r); record != "three" {
+		t.Fatalf("expected three, got %q", record)
+	}
+}
+
+func TestSignaturesWrongKey(t *testing.T) {
+	_, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := openTestRing(t, Options{SigningKey: private, VerifyKey: other})
+	writeRecords(t, r, "one")
+	if _, err := r.Read(make([]byte, 16)); err != ErrBadSignature {
+		t.Fatalf("expected ErrBadSignature, got %v", err)
+	}
+
+	r = openTestRing(t, Options{VerifyKey: other})
+	if _, err := r.Write([]byte("one")); err != ErrNoSigningKey {
+		t.Fatalf("expected ErrNoSigningKey, got %v", err)
+	}
+	if _, err := OpenWithOptions(testRingPath(t), Options{
+		CreateIfMissing: true,
+		CreateSize:      1 << 16,
+		VerifyKey:       other[:8],
+	}); err == nil {
+		t.Fatal("expected a short VerifyKey to be refused")
+	}
+}
+
+// vim: foldmethod=marker
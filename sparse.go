@@ -0,0 +1,181 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// sparsePoint is where an entry is, and when it was written, for the sparse
+// index.
+type sparsePoint struct {
+	seq  uint64
+	off  uint64
+	time int64
+}
+
+// sparsePointSize is the size of a sparsePoint in the sidecar file.
+const sparsePointSize = 24
+
+// UNSAFE
+//
+// Note the entry at the provided offset, with the sequence number seq, in
+// the sparse index, if it's one of the entries we keep.
+func (r *Ring) noteSparse(off uintptr, seq uint64) {
+	if r.sparseEvery == 0 || seq%r.sparseEvery != 0 {
+		return
+	}
+	p := sparsePoint{seq: seq, off: uint64(off)}
+	if r.layout.has(formatTimestamp) {
+		p.time = r.entryTime(off)
+	}
+	r.sparse = append(r.sparse, p)
+}
+
+// UNSAFE
+//
+// Walk the ring to build the sparse index again from scratch.
+func (r *Ring) buildSparse() {
+	r.sparse = r.sparse[:0]
+	seq := r.header.headSeq
+	for off := *r.head; off != *r.tail; off = r.nextEntry(off) {
+		r.noteSparse(off, seq)
+		seq++
+	}
+}
+
+// UNSAFE
+//
+// Drop everything from the sparse index that's no longer in the ring.
+func (r *Ring) dropSparse() {
+	n := 0
+	for n < len(r.sparse) && r.sparse[n].seq < r.header.headSeq {
+		n++
+	}
+	r.sparse = r.sparse[n:]
+}
+
+// UNSAFE
+//
+// Return the offset and sequence number of the closest entry at or before
+// the provided sequence number that we know the offset of without walking
+// the ring, which is the head if there's nothing in the sparse index.
+func (r *Ring) nearestSequence(seq uint64) (uintptr, uint64) {
+	i := sort.Search(len(r.sparse), func(i int) bool {
+		return r.sparse[i].seq > seq
+	})
+	if i == 0 {
+		return *r.head, r.header.headSeq
+	}
+	p := r.sparse[i-1]
+	return uintptr(p.off), p.seq
+}
+
+// UNSAFE
+//
+// Return the offset and sequence number of the closest entry written before
+// the provided time (in nanoseconds since the epoch) that we know the
+// offset of without walking the ring, which is the head if there's nothing
+// in the sparse index. This needs Timestamps.
+func (r *Ring) nearestTime(when int64) (uintptr, uint64) {
+	i := sort.Search(len(r.sparse), func(i int) bool {
+		return r.sparse[i].time >= when
+	})
+	if i == 0 {
+		return *r.head, r.header.headSeq
+	}
+	p := r.sparse[i-1]
+	return uintptr(p.off), p.seq
+}
+
+// sparsePath will return the path of the sparse index's sidecar file, or
+// an empty string if the sparse index can't be kept on disk.
+func (r *Ring) sparsePath() string {
+	if r.sparseEvery == 0 || !r.libraryHeader {
+		return ""
+	}
+	return r.file.Name() + ".sparse"
+}
+
+// UNSAFE
+//
+// Load the sparse index from the sidecar file, if there is one. Anything in
+// it which isn't in the ring any more is dropped, and if it's from another
+// Ring (or was kept for a different SparseIndex), it's ignored.
+func (r *Ring) loadSparse() error {
+	path := r.sparsePath()
+	if path == "" {
+		return nil
+	}
+	buf, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(buf) < 16 || (len(buf)-16)%sparsePointSize != 0 ||
+		binary.BigEndian.Uint64(buf[0:]) != r.header.generation ||
+		binary.BigEndian.Uint64(buf[8:]) != r.sparseEvery {
+		return nil
+	}
+
+	r.sparse = r.sparse[:0]
+	for buf = buf[16:]; len(buf) > 0; buf = buf[sparsePointSize:] {
+		p := sparsePoint{
+			seq:  binary.BigEndian.Uint64(buf[0:]),
+			off:  binary.BigEndian.Uint64(buf[8:]),
+			time: int64(binary.BigEndian.Uint64(buf[16:])),
+		}
+		if p.seq < r.header.headSeq || p.seq >= r.header.tailSeq || p.off >= uint64(r.size) {
+			continue
+		}
+		r.sparse = append(r.sparse, p)
+	}
+	return nil
+}
+
+// UNSAFE
+//
+// Save the sparse index to the sidecar file, if it's kept on disk.
+func (r *Ring) saveSparse() error {
+	path := r.sparsePath()
+	if path == "" || r.readOnly {
+		return nil
+	}
+
+	buf := make([]byte, 16, 16+len(r.sparse)*sparsePointSize)
+	binary.BigEndian.PutUint64(buf[0:], r.header.generation)
+	binary.BigEndian.PutUint64(buf[8:], r.sparseEvery)
+	for _, p := range r.sparse {
+		var point [sparsePointSize]byte
+		binary.BigEndian.PutUint64(point[0:], p.seq)
+		binary.BigEndian.PutUint64(point[8:], p.off)
+		binary.BigEndian.PutUint64(point[16:], uint64(p.time))
+		buf = append(buf, point[:]...)
+	}
+	return replaceFile(path, buf, r.perms)
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,87 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSparseIndex(t *testing.T) {
+	path := testRingPath(t)
+	options := Options{ReserveHeader: true, SparseIndex: 4, Timestamps: true, NonBlockingReads: true}
+	r := openTestRingAt(t, path, options)
+	for i := 0; i < 20; i++ {
+		writeRecords(t, r, fmt.Sprintf("record %d", i))
+	}
+	time.Sleep(2 * time.Millisecond)
+	when := time.Now()
+	for i := 20; i < 40; i++ {
+		writeRecords(t, r, fmt.Sprintf("record %d", i))
+	}
+
+	r.mutex.Lock()
+	if len(r.sparse) != 10 {
+		t.Errorf("expected every 4th of 40 records in the sparse index, got %d", len(r.sparse))
+	}
+	if _, at := r.nearestSequence(17); at != 16 {
+		t.Errorf("expected to start from record 16, got %d", at)
+	}
+	r.mutex.Unlock()
+
+	if err := r.SeekToSequence(17); err != nil {
+		t.Fatal(err)
+	}
+	if record := readRecord(t, r); record != "record 17" {
+		t.Fatalf("expected record 17, got %q", record)
+	}
+	if err := r.SeekToTime(when); err != nil {
+		t.Fatal(err)
+	}
+	if record := readRecord(t, r); record != "record 20" {
+		t.Fatalf("expected record 20, got %q", record)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".sparse"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The sparse index is picked back up from the sidecar file.
+	r = openTestRingAt(t, path, options)
+	defer r.Close()
+	r.mutex.Lock()
+	if len(r.sparse) != 4 {
+		t.Errorf("expected the 4 points left to be loaded, got %d", len(r.sparse))
+	}
+	r.mutex.Unlock()
+	if err := r.SeekToSequence(33); err != nil {
+		t.Fatal(err)
+	}
+	if record := readRecord(t, r); record != "record 33" {
+		t.Fatalf("expected record 33, got %q", record)
+	}
+}
+
+// vim: foldmethod=marker
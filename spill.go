@@ -0,0 +1,111 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// ErrNoSpillDir is returned when reading a record that was spilled to a side
+// file from a Ring opened without the SpillDir option.
+var ErrNoSpillDir = errors.New("diskring: record was spilled, but no SpillDir is set")
+
+// spill will write the data of a record too large for the ring into a new
+// side file in the spill directory, returning the name of the file.
+func (r *Ring) spill(buf []byte) (string, error) {
+	fd, err := ioutil.TempFile(r.spillDir, "diskring-*.spill")
+	if err != nil {
+		return "", err
+	}
+	name := fd.Name()
+	if err := r.perms.apply(fd); err != nil {
+		fd.Close()
+		os.Remove(name)
+		return "", err
+	}
+	if _, err := fd.Write(buf); err != nil {
+		fd.Close()
+		os.Remove(name)
+		return "", err
+	}
+	// The file has to be on disk before the entry naming it is, or a
+	// crash could leave the ring pointing at a file that's empty, or not
+	// there at all.
+	if err := fd.Sync(); err != nil {
+		fd.Close()
+		os.Remove(name)
+		return "", err
+	}
+	if err := fd.Close(); err != nil {
+		os.Remove(name)
+		return "", err
+	}
+	if err := syncDir(r.spillDir); err != nil {
+		os.Remove(name)
+		return "", err
+	}
+	return filepath.Base(name), nil
+}
+
+// syncDir will flush the directory out to disk, so that the files created in
+// it are there after a crash.
+func syncDir(dir string) error {
+	fd, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err := fd.Sync(); err != nil {
+		fd.Close()
+		return err
+	}
+	return fd.Close()
+}
+
+// unspillData will read back in the data of a record that was spilled,
+// given the data of the reference stored in the ring.
+func (r *Ring) unspillData(reference []byte) ([]byte, error) {
+	if r.spillDir == "" {
+		return nil, ErrNoSpillDir
+	}
+	return ioutil.ReadFile(r.spillPath(reference))
+}
+
+// unspill will remove the side file of a record, if it was spilled. This is
+// best-effort, since failing to clean up a file can't be allowed to stop
+// the ring from moving along.
+func (r *Ring) unspill(e envelope, reference []byte) {
+	if e.flags&flagSpilled == 0 || r.spillDir == "" {
+		return
+	}
+	os.Remove(r.spillPath(reference))
+}
+
+// spillPath will return the path to a side file given the reference to it.
+// Only the base name is ever stored, so we'll make sure a corrupt reference
+// can't point anywhere outside the spill directory.
+func (r *Ring) spillPath(reference []byte) string {
+	return filepath.Join(r.spillDir, filepath.Base(string(reference)))
+}
+
+// vim: foldmethod=marker
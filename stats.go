@@ -0,0 +1,226 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"io"
+	"math/bits"
+	"sync/atomic"
+)
+
+// Interface is the set of operations shared by a *Ring and anything that can
+// stand in for one (such as a client for a Ring served over the network), so
+// that code can be pointed at either interchangeably.
+type Interface interface {
+	io.ReadWriter
+
+	// Stats returns a point-in-time summary of the state of the Ring.
+	Stats() (Stats, error)
+}
+
+// Stats is a point-in-time summary of the state of the Ring.
+type Stats struct {
+	// Size is the number of bytes the Ring can hold, including the space
+	// used to store the length of each record.
+	Size int
+
+	// Used is the number of bytes currently used by records in the Ring.
+	Used int
+
+	// Records is the number of records currently in the Ring.
+	Records int
+
+	// HeadSequence is the sequence number of the oldest record in the
+	// Ring, and TailSequence is the sequence number the next record
+	// written will be given.
+	HeadSequence uint64
+	TailSequence uint64
+
+	// Head and Tail are the offsets of the read and write cursors into
+	// the Ring's data.
+	Head int
+	Tail int
+
+	// RecordSizes is a histogram of the size of the data of each record
+	// written since the Ring was opened, with a bucket for each power of
+	// two. Empty buckets are left out.
+	RecordSizes []SizeBucket
+
+	// Overhead is the fraction of the bytes written since the Ring was
+	// opened that went to storing the length (and any other envelope
+	// fields) of each record rather than the data.
+	Overhead float64
+
+	// Consumers is what each open Consumer has missed, sorted by name.
+	Consumers []ConsumerStats
+
+	// Timings is a histogram of how long each Phase of each Operation
+	// took, if the Timings option is set.
+	Timings []TimingHistogram
+
+	// Wraps is the number of times writing has wrapped around from the
+	// end of the Ring back to the start, and OverwrittenRecords and
+	// OverwrittenBytes are how many records (and how many bytes of keys
+	// and data) writers have overwritten to make room for new ones. With
+	// ReserveHeader, these are kept in the header, so they count
+	// everything since the Ring was created, rather than since it was
+	// opened. A Ring that's overwriting records well before anything
+	// reads them is too small for what's being written to it.
+	Wraps              uint64
+	OverwrittenRecords uint64
+	OverwrittenBytes   uint64
+}
+
+// add will add up the sizes, bytes used, records, wraps and overwrites of
+// other into s, for summing up the Stats of a number of Rings.
+func (s *Stats) add(other Stats) {
+	s.Size += other.Size
+	s.Used += other.Used
+	s.Records += other.Records
+	s.Wraps += other.Wraps
+	s.OverwrittenRecords += other.OverwrittenRecords
+	s.OverwrittenBytes += other.OverwrittenBytes
+}
+
+// SizeBucket is a single bucket of a size histogram.
+type SizeBucket struct {
+	// UpTo is the largest size counted in this bucket.
+	UpTo int
+
+	// Count is the number of records counted in this bucket.
+	Count uint64
+}
+
+// sizeStats is what we keep track of to build the size histogram. The
+// counters are only ever touched atomically, so that Stats can read them
+// without the mutex.
+type sizeStats struct {
+	buckets [65]uint64
+	data    uint64
+	total   uint64
+}
+
+// observe will count a record with length bytes of data, which took size
+// bytes in the ring.
+func (s *sizeStats) observe(length, size uintptr) {
+	atomic.AddUint64(&s.buckets[bits.Len64(uint64(length))], 1)
+	atomic.AddUint64(&s.data, uint64(length))
+	atomic.AddUint64(&s.total, uint64(size))
+}
+
+// histogram will return the non-empty buckets of the histogram.
+func (s *sizeStats) histogram() []SizeBucket {
+	var buckets []SizeBucket
+	for i := range s.buckets {
+		count := atomic.LoadUint64(&s.buckets[i])
+		if count == 0 {
+			continue
+		}
+		buckets = append(buckets, SizeBucket{
+			UpTo:  int(uint64(1)<<uint(i) - 1),
+			Count: count,
+		})
+	}
+	return buckets
+}
+
+// overhead will return the fraction of the bytes that weren't data.
+func (s *sizeStats) overhead() float64 {
+	data, total := atomic.LoadUint64(&s.data), atomic.LoadUint64(&s.total)
+	if total == 0 {
+		return 0
+	}
+	return float64(total-data) / float64(total)
+}
+
+// Stats will return a point-in-time summary of the state of the Ring. The
+// only error is ErrHeaderBusy, if another process sharing the header went
+// away partway through changing it.
+//
+// Stats never takes the Ring's lock, so it's safe to call as often as
+// monitoring likes without slowing down readers or writers.
+func (r *Ring) Stats() (Stats, error) {
+	s, err := r.snapshot()
+	if err != nil {
+		return Stats{}, err
+	}
+	return Stats{
+		Size:         int(r.size),
+		Used:         int(s.used(r.size)),
+		Records:      int(s.tailSeq - s.headSeq),
+		HeadSequence: s.headSeq,
+		TailSequence: s.tailSeq,
+		Head:         int(s.head),
+		Tail:         int(s.tail),
+		RecordSizes:  r.sizes.histogram(),
+		Overhead:     r.sizes.overhead(),
+		Consumers:    r.consumerStats(),
+		Timings:      r.timingHistograms(),
+
+		Wraps:              atomic.LoadUint64(&r.header.wraps),
+		OverwrittenRecords: atomic.LoadUint64(&r.header.overwrittenRecords),
+		OverwrittenBytes:   atomic.LoadUint64(&r.header.overwrittenBytes),
+	}, nil
+}
+
+// Len will return the number of bytes currently used by records in the Ring,
+// including the space used to store the length (and any other envelope
+// fields) of each record. Like Stats, this never takes the Ring's lock, and
+// if the cursor can't be read (see ErrHeaderBusy), this returns 0.
+func (r *Ring) Len() int {
+	s, err := r.snapshot()
+	if err != nil {
+		return 0
+	}
+	return int(s.used(r.size))
+}
+
+// Cap will return the number of bytes the Ring can hold.
+func (r *Ring) Cap() int {
+	return int(r.size)
+}
+
+// Records will return the number of records currently in the Ring. Like
+// Stats, this never takes the Ring's lock, and if the cursor can't be read
+// (see ErrHeaderBusy), this returns 0.
+func (r *Ring) Records() int {
+	s, err := r.snapshot()
+	if err != nil {
+		return 0
+	}
+	return int(s.tailSeq - s.headSeq)
+}
+
+// Free will return the number of bytes which are not used by records in the
+// Ring. Each record also needs space for its length (and any other envelope
+// fields), so the largest record that can be written without overwriting
+// anything is a little smaller than this. Like Stats, this never takes the
+// Ring's lock, and if the cursor can't be read (see ErrHeaderBusy), this
+// returns 0.
+func (r *Ring) Free() int {
+	s, err := r.snapshot()
+	if err != nil {
+		return 0
+	}
+	return int(r.size - s.used(r.size))
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,181 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// countSizes will add up the records counted in the Ring's histogram of
+// record sizes.
+func countSizes(t *testing.T, r *Ring) uint64 {
+	t.Helper()
+	stats, err := r.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count uint64
+	for _, bucket := range stats.RecordSizes {
+		count += bucket.Count
+	}
+	return count
+}
+
+func TestOccupancy(t *testing.T) {
+	r := openTestRing(t, Options{})
+	if r.Cap() != 1<<16 || r.Len() != 0 || r.Free() != r.Cap() || r.Records() != 0 {
+		t.Fatalf("expected an empty 64KiB ring, got cap %d, len %d, free %d, %d records", r.Cap(), r.Len(), r.Free(), r.Records())
+	}
+
+	writeRecords(t, r, "one", "two", "three")
+	if r.Records() != 3 || r.Len() <= len("onetwothree") || r.Len()+r.Free() != r.Cap() {
+		t.Fatalf("after writing 3 records, got len %d, free %d, %d records", r.Len(), r.Free(), r.Records())
+	}
+	used := r.Len()
+	readRecord(t, r)
+	if r.Records() != 2 || r.Len() >= used || r.Len()+r.Free() != r.Cap() {
+		t.Fatalf("after reading a record, got len %d, free %d, %d records", r.Len(), r.Free(), r.Records())
+	}
+}
+
+func TestRecordSizesAfterRewrite(t *testing.T) {
+	r := openTestRing(t, Options{Keys: true, SchemaVersion: 1})
+	for i := 0; i < 10; i++ {
+		if _, err := r.WriteKey([]byte(fmt.Sprint("key", i%5)), []byte(fmt.Sprint("v", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if count := countSizes(t, r); count != 10 {
+		t.Fatalf("expected 10 records counted, got %d", count)
+	}
+
+	if _, err := r.Erase(func(data []byte) bool { return string(data) == "v9" }); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Migrate(1, 2, func(old []byte) ([]byte, error) { return old, nil }); err != nil {
+		t.Fatal(err)
+	}
+	if count := countSizes(t, r); count != 10 {
+		t.Fatalf("moving records counted them again: %d counted", count)
+	}
+}
+
+func TestStatsWithoutMutex(t *testing.T) {
+	r := openTestRing(t, Options{})
+	writeRecords(t, r, "one", "two")
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	done := make(chan Stats)
+	go func() {
+		stats, err := r.Stats()
+		if err != nil {
+			t.Error(err)
+		}
+		done <- stats
+	}()
+	select {
+	case stats := <-done:
+		if stats.Records != 2 || r.Len() == 0 {
+			t.Fatalf("expected 2 records, got %d", stats.Records)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stats waited for the mutex")
+	}
+}
+
+func TestStatsConsistent(t *testing.T) {
+	r := openTestRing(t, Options{CreateSize: 4096})
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if _, err := r.Write([]byte(fmt.Sprintf("record %d", i))); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < 10000; i++ {
+		stats, err := r.Stats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if uint64(stats.Records) != stats.TailSequence-stats.HeadSequence {
+			t.Fatalf("%d records between sequences %d and %d", stats.Records, stats.HeadSequence, stats.TailSequence)
+		}
+		if stats.Used > stats.Size {
+			t.Fatalf("%d bytes used of %d", stats.Used, stats.Size)
+		}
+	}
+	close(stop)
+	<-done
+}
+
+func TestOverwrittenStats(t *testing.T) {
+	path := testRingPath(t)
+	options := Options{ReserveHeader: true, CreateSize: 4096}
+	r := openTestRingAt(t, path, options)
+	overwriteRecords(t, r, 50)
+	before, err := r.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before.OverwrittenRecords != r.Overwritten() || before.OverwrittenBytes != 100*r.Overwritten() {
+		t.Fatalf("expected %d records of 100 bytes to be overwritten, got %d in %d bytes",
+			r.Overwritten(), before.OverwrittenRecords, before.OverwrittenBytes)
+	}
+	// Each wrap overwrites a whole Ring's worth of records.
+	if before.Wraps == 0 || before.Wraps >= before.OverwrittenRecords {
+		t.Fatalf("expected a few wraps, got %d", before.Wraps)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The counters are kept in the header, so they carry on from where
+	// they were.
+	r = openTestRingAt(t, path, options)
+	defer r.Close()
+	writeRecords(t, r, fmt.Sprintf("%0100d", 0))
+	after, err := r.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.OverwrittenRecords != before.OverwrittenRecords+1 || after.Wraps < before.Wraps {
+		t.Fatalf("expected the counters to carry on from %d records and %d wraps, got %d records and %d wraps",
+			before.OverwrittenRecords, before.Wraps, after.OverwrittenRecords, after.Wraps)
+	}
+}
+
+// vim: foldmethod=marker
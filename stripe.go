@@ -0,0 +1,138 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// Striped spreads writes across a number of Rings (stripes), so that
+// writers on different cores aren't all fighting over one Ring's locks, and
+// merges them back together, in the order they were written, on the way
+// out. This is for producers hot enough that the Ring's locks are the
+// bottleneck (see Options.Timings to find out if they are).
+//
+// Go doesn't let us see which core (or P) a writer is running on, so each
+// write goes to the next stripe in turn. Writes only ever take the lock of
+// the stripe they go to, so they scale with the number of stripes, while
+// each Read has to take the lock of every stripe.
+type Striped struct {
+	stripes []*Ring
+	next    uint32
+}
+
+// NewStriped will create a Striped over the provided Rings, which must all
+// have Timestamps turned on, since that's how the records are put back in
+// order. Records already in the Rings are merged in too.
+func NewStriped(stripes ...*Ring) (*Striped, error) {
+	if len(stripes) == 0 {
+		return nil, fmt.Errorf("diskring: no stripes provided")
+	}
+	for _, r := range stripes {
+		if !r.layout.has(formatTimestamp) {
+			return nil, ErrNoTimestamps
+		}
+	}
+	return &Striped{stripes: stripes}, nil
+}
+
+// Write will write a block of data into the next stripe, just like
+// Ring.Write.
+func (s *Striped) Write(buf []byte) (int, error) {
+	n := atomic.AddUint32(&s.next, 1)
+	return s.stripes[n%uint32(len(s.stripes))].Write(buf)
+}
+
+// Read will read the oldest record in any of the stripes into buf, and
+// consume it. This won't block; if all of the stripes are empty, this will
+// return an io.EOF.
+//
+// Unlike a MergedReader, every stripe is locked while the oldest record is
+// picked, so a record can never be read before an older one that's still
+// being written: any write that hasn't been stamped yet will be stamped
+// after every record already in the stripes. This relies on the clock not
+// going backwards, just like ordering by Timestamps does.
+func (s *Striped) Read(buf []byte) (int, error) {
+	for _, r := range s.stripes {
+		r.mutex.Lock()
+	}
+	defer func() {
+		for _, r := range s.stripes {
+			r.mutex.Unlock()
+		}
+	}()
+
+	var oldest *Ring
+	for _, r := range s.stripes {
+		if r.fileErr != nil {
+			return 0, r.fileErr
+		}
+		if r.empty() {
+			continue
+		}
+		if oldest == nil || r.headKey() < oldest.headKey() {
+			oldest = r
+		}
+	}
+	if oldest == nil {
+		return 0, io.EOF
+	}
+	return oldest.readEntry(buf)
+}
+
+// Stats will return a summary of the state of the stripes together: the
+// sizes, bytes used and records of each stripe are added up. The offsets
+// and sequence numbers of each stripe can't be added up, so they're left
+// out; call Stats on the stripes themselves to see those.
+func (s *Striped) Stats() (Stats, error) {
+	var total Stats
+	for _, r := range s.stripes {
+		stats, err := r.Stats()
+		if err != nil {
+			return Stats{}, err
+		}
+		total.add(stats)
+	}
+	return total, nil
+}
+
+// Stripes will return the Rings the Striped is made up of.
+func (s *Striped) Stripes() []*Ring {
+	return s.stripes
+}
+
+// Close will close every stripe, returning the first error.
+func (s *Striped) Close() error {
+	var err error
+	for _, r := range s.stripes {
+		if closeErr := r.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// Striped can stand in for a Ring.
+var _ Interface = (*Striped)(nil)
+
+// vim: foldmethod=marker
@@ -0,0 +1,78 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestStriped(t *testing.T) {
+	if _, err := NewStriped(); err == nil {
+		t.Fatal("expected no stripes to be refused")
+	}
+	if _, err := NewStriped(openTestRing(t, Options{})); err != ErrNoTimestamps {
+		t.Fatalf("expected ErrNoTimestamps, got %v", err)
+	}
+
+	var stripes []*Ring
+	for i := 0; i < 3; i++ {
+		stripes = append(stripes, openTestRing(t, Options{Timestamps: true}))
+	}
+	s, err := NewStriped(stripes...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := s.Write([]byte(fmt.Sprint(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, r := range s.Stripes() {
+		if r.Records() == 0 {
+			t.Fatalf("expected stripe %d to be written to", i)
+		}
+	}
+	stats, err := s.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Records != 10 {
+		t.Fatalf("expected 10 records across the stripes, got %d", stats.Records)
+	}
+
+	buf := make([]byte, 16)
+	for i := 0; i < 10; i++ {
+		n, err := s.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if record := string(buf[:n]); record != fmt.Sprint(i) {
+			t.Fatalf("expected %d, got %q", i, record)
+		}
+	}
+	if _, err := s.Read(buf); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,196 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	// ErrNotFixedSize is returned by WriteStruct and ReadStruct when the
+	// value doesn't have a fixed size that encoding/binary can work out.
+	ErrNotFixedSize = errors.New("diskring: value doesn't have a fixed binary size")
+
+	// ErrStructSize is returned by ReadStruct when the record at the head
+	// of the Ring isn't the same size as the value being read into. The
+	// record is not consumed.
+	ErrStructSize = errors.New("diskring: record isn't the size of the struct")
+)
+
+// BinaryEncoder is implemented by fixed size types that can encode
+// themselves into a buffer without reflection, such as with generated code.
+// WriteStruct uses this when it's there, rather than encoding/binary.
+type BinaryEncoder interface {
+	// BinarySize is the number of bytes EncodeBinary writes, which must
+	// be the same every time.
+	BinarySize() int
+
+	// EncodeBinary writes the value into buf, which is BinarySize bytes.
+	EncodeBinary(buf []byte)
+}
+
+// BinaryDecoder is implemented by fixed size types that can decode
+// themselves from a buffer without reflection, such as with generated code.
+// ReadStruct uses this when it's there, rather than encoding/binary.
+type BinaryDecoder interface {
+	// BinarySize is the number of bytes DecodeBinary reads, which must be
+	// the same every time.
+	BinarySize() int
+
+	// DecodeBinary reads the value from buf, which is BinarySize bytes.
+	DecodeBinary(buf []byte)
+}
+
+// sliceWriter is an io.Writer which fills in a slice, so that
+// encoding/binary can write straight into the ring.
+type sliceWriter struct {
+	buf []byte
+}
+
+// Write implements the io.Writer interface.
+func (w *sliceWriter) Write(buf []byte) (int, error) {
+	n := copy(w.buf, buf)
+	w.buf = w.buf[n:]
+	return n, nil
+}
+
+// structSize will return the number of bytes v is encoded into.
+func structSize(v interface{}) (int, error) {
+	if e, ok := v.(BinaryEncoder); ok {
+		return e.BinarySize(), nil
+	}
+	if d, ok := v.(BinaryDecoder); ok {
+		return d.BinarySize(), nil
+	}
+	if size := binary.Size(v); size >= 0 {
+		return size, nil
+	}
+	return 0, ErrNotFixedSize
+}
+
+// WriteStruct will write a fixed size value (such as a struct of numbers)
+// into the disk ring as a record, encoded little endian by encoding/binary,
+// or by its EncodeBinary method if it's a BinaryEncoder. The value is
+// encoded straight into the Ring, so with a BinaryEncoder, nothing is
+// allocated at all.
+//
+// Otherwise, this works just like Write, including the ValidateWrite hook.
+func (r *Ring) WriteStruct(v interface{}) error {
+	size, err := structSize(v)
+	if err != nil {
+		return err
+	}
+	length := uintptr(size)
+
+	r.writeMutex.Lock()
+	defer r.writeMutex.Unlock()
+
+	r.mutex.Lock()
+	if err := r.checkWrite(size); err != nil {
+		r.mutex.Unlock()
+		return err
+	}
+	if err := r.flushBatch(); err != nil {
+		r.mutex.Unlock()
+		return err
+	}
+	if err := r.reserve(length); err != nil {
+		r.mutex.Unlock()
+		return err
+	}
+	off := r.stageOffset()
+	r.mutex.Unlock()
+
+	// Nothing else touches the space after the tail while we hold the
+	// writeMutex, so we can fill it in without the mutex, the same as a
+	// RecordWriter.
+	pad := r.entryPadding(off, length)
+	start := off + pad + r.layout.wordSize + r.layout.envelopeSize
+	buf := r.buf[start : start+length]
+	if e, ok := v.(BinaryEncoder); ok {
+		e.EncodeBinary(buf)
+	} else if err := binary.Write(&sliceWriter{buf: buf}, binary.LittleEndian, v); err != nil {
+		return err
+	}
+	if err := r.validate(buf); err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.publish(r.sealEntry(off, pad, r.newEnvelope(), length), 1, off)
+	return nil
+}
+
+// ReadStruct will read the record at the head of the Ring into a fixed size
+// value (which must be a pointer) written by WriteStruct, and advance the
+// head past it. The record is decoded straight out of the Ring, by the
+// value's DecodeBinary method if it's a BinaryDecoder, or by
+// encoding/binary otherwise.
+//
+// This blocks just like Read. If the record isn't the size of the value,
+// this returns ErrStructSize, and the record is left where it is.
+func (r *Ring) ReadStruct(v interface{}) error {
+	size, err := structSize(v)
+	if err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if err := r.waitForRecord(); err != nil {
+		return err
+	}
+	var (
+		data []byte
+		next int
+	)
+	batched := r.entryFlags(*r.head)&flagBatch != 0
+	if batched {
+		data, next, err = r.batched.next(r, *r.head, r.header.headSeq)
+	} else {
+		data, err = r.recordData(*r.head)
+	}
+	if err != nil {
+		return err
+	}
+	if len(data) != size {
+		return ErrStructSize
+	}
+	if d, ok := v.(BinaryDecoder); ok {
+		d.DecodeBinary(data)
+	} else if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, v); err != nil {
+		return err
+	}
+	if batched {
+		return r.pastBatched(next)
+	}
+	if err := r.advanceHead(); err != nil {
+		return err
+	}
+	r.consumed()
+	return nil
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,85 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+type testReading struct {
+	Sensor uint16
+	Value  int64
+	Unit   [4]byte
+}
+
+// testCounter encodes itself, rather than going through encoding/binary.
+type testCounter uint32
+
+func (c *testCounter) BinarySize() int { return 4 }
+
+func (c *testCounter) EncodeBinary(buf []byte) {
+	binary.BigEndian.PutUint32(buf, uint32(*c))
+}
+
+func (c *testCounter) DecodeBinary(buf []byte) {
+	*c = testCounter(binary.BigEndian.Uint32(buf))
+}
+
+func TestStruct(t *testing.T) {
+	r := openTestRing(t, Options{CreateSize: 4096, NonBlockingReads: true})
+	// Wrap around the end of the Ring along the way.
+	for i := 0; i < 200; i++ {
+		in := testReading{Sensor: uint16(i), Value: -int64(i), Unit: [4]byte{'d', 'e', 'g', 'C'}}
+		if err := r.WriteStruct(&in); err != nil {
+			t.Fatal(err)
+		}
+		var out testReading
+		if err := r.ReadStruct(&out); err != nil {
+			t.Fatal(err)
+		}
+		if out != in {
+			t.Fatalf("expected %+v, got %+v", in, out)
+		}
+	}
+
+	counter := testCounter(0x01020304)
+	if err := r.WriteStruct(&counter); err != nil {
+		t.Fatal(err)
+	}
+	if r.Len() != int(r.entrySize(4)) {
+		t.Fatalf("expected a 4 byte record, got %d bytes", r.Len())
+	}
+	var reading testReading
+	if err := r.ReadStruct(&reading); err != ErrStructSize {
+		t.Fatalf("expected ErrStructSize, got %v", err)
+	}
+	var decoded testCounter
+	if err := r.ReadStruct(&decoded); err != nil || decoded != counter {
+		t.Fatalf("expected %x, got %x (%v)", counter, decoded, err)
+	}
+
+	if err := r.WriteStruct(&struct{ Name string }{"no"}); err != ErrNotFixedSize {
+		t.Fatalf("expected ErrNotFixedSize, got %v", err)
+	}
+}
+
+// vim: foldmethod=marker
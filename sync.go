@@ -0,0 +1,144 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+// Sync will flush everything written to the Ring (and its header, and any
+// records on their way into the tier) out to disk, and block until that's
+// done.
+//
+// If the Ring has a header on disk, the data is flushed out before the
+// header is committed (see shadow.go), which holds up anything else using
+// the Ring for the time it takes to write out the header page twice.
+func (r *Ring) Sync() error {
+	t := r.startTiming(OpSync)
+	defer t.done()
+
+	if r.coalesce > 0 {
+		if err := r.Flush(); err != nil {
+			return err
+		}
+	}
+	if err := r.syncData(); err != nil {
+		return err
+	}
+	t.lap(PhaseSync)
+	return r.syncRest(&t)
+}
+
+// syncRest will do the part of a Sync that comes after the ring's data has
+// been flushed: syncing the tier, checking in on the notifier, and flushing
+// (or committing) the header.
+func (r *Ring) syncRest(t *timer) error {
+	if r.tier != nil {
+		r.mutex.Lock()
+		t.lap(PhaseLockWait)
+		err := r.tier.sync()
+		r.mutex.Unlock()
+		t.lap(PhaseSync)
+		if err != nil {
+			return err
+		}
+	}
+	if err := r.notifyErr(); err != nil {
+		return err
+	}
+	if r.headerPage == nil {
+		return nil
+	}
+	if !r.libraryHeader || r.readOnly {
+		defer t.lap(PhaseSync)
+		return r.syncHeader()
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	t.lap(PhaseLockWait)
+	if r.fileErr != nil {
+		return r.fileErr
+	}
+	defer t.lap(PhaseSync)
+	return r.commitHeader()
+}
+
+// SyncAsync will start flushing everything written to the Ring out to disk,
+// and return a channel which gets the result once it's done, so that the
+// caller can go on writing in the meantime. Anything written after
+// SyncAsync is called may or may not be flushed along with it.
+//
+// Where the kernel supports io_uring, flushing the data is handed off to
+// the kernel without tying up a thread, and the rest of what Sync does
+// (like committing the header) happens once that's done. Otherwise, this
+// falls back to calling Sync in another goroutine.
+func (r *Ring) SyncAsync() <-chan error {
+	ret := make(chan error, 1)
+	if r.coalesce > 0 {
+		if err := r.Flush(); err != nil {
+			ret <- err
+			return ret
+		}
+	}
+	if pending := r.fsyncAsync(); pending != nil {
+		go func() {
+			t := r.startTiming(OpSync)
+			defer t.done()
+
+			err := waitAll(pending)
+			t.lap(PhaseSync)
+			if err == nil {
+				err = r.syncRest(&t)
+			}
+			ret <- err
+		}()
+		return ret
+	}
+
+	go func() {
+		ret <- r.Sync()
+	}()
+	return ret
+}
+
+// waitAll will wait for every channel to get its result, and return the
+// first error any of them got.
+func waitAll(pending []<-chan error) error {
+	var err error
+	for _, ch := range pending {
+		if syncErr := <-ch; syncErr != nil && err == nil {
+			err = syncErr
+		}
+	}
+	return err
+}
+
+// Prefetch will ask the kernel to start reading the records in the Ring in
+// from disk in the background, so that reading through a large Ring that
+// isn't in the page cache doesn't stall on every page. This doesn't wait
+// for the data to be read.
+func (r *Ring) Prefetch() error {
+	r.mutex.Lock()
+	off, length := *r.head, r.len()
+	r.mutex.Unlock()
+	if length == 0 {
+		return nil
+	}
+	return r.prefetch(off, length)
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,77 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSyncAsyncFlushesBatch(t *testing.T) {
+	r := openTestRing(t, Options{Coalesce: 64, ReserveHeader: true})
+
+	if _, err := r.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-r.SyncAsync(); err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := OpenWithOptions(r.file.Name(), Options{Coalesce: 64, ReserveHeader: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if records := other.Records(); records != 1 {
+		t.Fatalf("expected the batch to be on disk, got %d records", records)
+	}
+}
+
+func TestReadahead(t *testing.T) {
+	path := testRingPath(t)
+	r := openTestRingAt(t, path, Options{CreateSize: 8192, ReserveHeader: true, NonBlockingReads: true})
+	// Wrap the records around the end of the Ring.
+	for i := 0; i < 40; i++ {
+		writeRecords(t, r, fmt.Sprintf("%0199d", i))
+		if i < 30 {
+			readRecord(t, r)
+		}
+	}
+	if err := r.Prefetch(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r = openTestRingAt(t, path, Options{ReserveHeader: true, Readahead: true, NonBlockingReads: true})
+	defer r.Close()
+	for i := 30; i < 40; i++ {
+		if record, want := readRecord(t, r), fmt.Sprintf("%0199d", i); record != want {
+			t.Fatalf("expected %q, got %q", want, record)
+		}
+	}
+	if err := r.Prefetch(); err != nil {
+		t.Fatalf("expected nothing to prefetch, got %v", err)
+	}
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,181 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// syncer flushes the Ring out to disk in the background, so that a whole
+// batch of writes only costs one sync (a group commit), rather than one
+// each.
+type syncer struct {
+	r        *Ring
+	maxDelay time.Duration
+	maxBytes uintptr
+
+	mutex    sync.Mutex
+	unsynced uintptr
+	dirty    bool
+
+	// synced is the tail sequence as of the last sync, and err is what
+	// that sync returned. notify is closed (and replaced) after every
+	// sync.
+	synced uint64
+	err    error
+	notify chan struct{}
+
+	kick chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// newSyncer will create a syncer for the Ring, and start it running.
+func newSyncer(r *Ring, maxDelay time.Duration, maxBytes uintptr) *syncer {
+	s := &syncer{
+		r:        r,
+		maxDelay: maxDelay,
+		maxBytes: maxBytes,
+		synced:   r.header.tailSeq,
+		notify:   make(chan struct{}),
+		kick:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// run will sync whenever the delay is up or enough bytes have been written,
+// until the syncer is stopped.
+func (s *syncer) run() {
+	defer close(s.done)
+
+	var tick <-chan time.Time
+	if s.maxDelay > 0 {
+		ticker := time.NewTicker(s.maxDelay)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-s.stop:
+			s.sync()
+			return
+		case <-s.kick:
+		case <-tick:
+		}
+		s.sync()
+	}
+}
+
+// written will note that size more bytes have been written to the Ring,
+// and kick off a sync if that's enough. This is called with the Ring's
+// mutex held.
+func (s *syncer) written(size uintptr) {
+	s.mutex.Lock()
+	s.unsynced += size
+	s.dirty = true
+	full := s.maxBytes > 0 && s.unsynced >= s.maxBytes
+	s.mutex.Unlock()
+
+	if full {
+		select {
+		case s.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// sync will flush the Ring out to disk, if anything has been written since
+// the last time, and let anyone waiting know.
+func (s *syncer) sync() {
+	s.r.mutex.Lock()
+	seq := s.r.header.tailSeq
+	s.r.mutex.Unlock()
+
+	s.mutex.Lock()
+	if !s.dirty {
+		s.mutex.Unlock()
+		return
+	}
+	s.dirty = false
+	s.unsynced = 0
+	s.mutex.Unlock()
+
+	err := s.r.Sync()
+
+	s.mutex.Lock()
+	s.synced = seq
+	s.err = err
+	close(s.notify)
+	s.notify = make(chan struct{})
+	s.mutex.Unlock()
+}
+
+// wait will block until everything up to seq has been synced, returning
+// the error from that sync.
+func (s *syncer) wait(ctx context.Context, seq uint64) error {
+	for {
+		s.mutex.Lock()
+		if s.synced >= seq {
+			err := s.err
+			s.mutex.Unlock()
+			return err
+		}
+		notify := s.notify
+		s.mutex.Unlock()
+
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Close will stop the syncer, after one last sync.
+func (s *syncer) Close() {
+	close(s.stop)
+	<-s.done
+}
+
+// WaitSynced will block until everything written to the Ring before it was
+// called has been flushed out to disk by the background syncer (see the
+// SyncMaxDelay and SyncMaxBytes options), or until the context is done.
+// Many writers waiting at once will all be covered by the same sync.
+//
+// If the background syncer isn't running, this will just call Sync.
+func (r *Ring) WaitSynced(ctx context.Context) error {
+	if r.syncer == nil {
+		return r.Sync()
+	}
+
+	r.mutex.Lock()
+	seq := r.header.tailSeq
+	r.mutex.Unlock()
+	return r.syncer.wait(ctx, seq)
+}
+
+// vim: foldmethod=marker
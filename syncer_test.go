@@ -0,0 +1,65 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWaitSynced(t *testing.T) {
+	for name, options := range map[string]Options{
+		"MaxDelay": {SyncMaxDelay: 10 * time.Millisecond},
+		"MaxBytes": {SyncMaxBytes: 64},
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := openTestRing(t, options)
+			writeRecords(t, r, "one", "two", "three", "a record long enough to go over SyncMaxBytes")
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := r.WaitSynced(ctx); err != nil {
+				t.Fatal(err)
+			}
+			r.syncer.mutex.Lock()
+			synced := r.syncer.synced
+			r.syncer.mutex.Unlock()
+			if synced != r.header.tailSeq {
+				t.Fatalf("expected the syncer to be up to %d, got %d", r.header.tailSeq, synced)
+			}
+		})
+	}
+}
+
+func TestWaitSyncedCancelled(t *testing.T) {
+	// Neither limit is ever reached, so nothing will be synced.
+	r := openTestRing(t, Options{SyncMaxBytes: 1 << 20})
+	writeRecords(t, r, "one")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := r.WaitSynced(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected the context's error, got %v", err)
+	}
+}
+
+// vim: foldmethod=marker
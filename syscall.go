@@ -18,6 +18,9 @@
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
 // THE SOFTWARE. }}}
 
+//go:build !diskring_portable && !wasip1
+// +build !diskring_portable,!wasip1
+
 package diskring
 
 import (
@@ -54,6 +57,25 @@ func munmap(addr uintptr, length uintptr) error {
 	return nil
 }
 
+// msync will flush the mapped memory at addr out to disk, waiting until
+// it's been written.
+func msync(addr uintptr, length uintptr) error {
+	_, _, e1 := syscall.Syscall(syscall.SYS_MSYNC, addr, length, syscall.MS_SYNC)
+	if e1 != 0 {
+		return e1
+	}
+	return nil
+}
+
+// madvise will give the kernel advice about the mapped memory at addr.
+func madvise(addr uintptr, length uintptr, advice int) error {
+	_, _, e1 := syscall.Syscall(syscall.SYS_MADVISE, addr, length, uintptr(advice))
+	if e1 != 0 {
+		return e1
+	}
+	return nil
+}
+
 // just.... just don't look at me.
 //
 // this is maybe the unsafest thing I've done in go. turn a pointer (provided
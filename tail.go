@@ -0,0 +1,113 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"fmt"
+)
+
+// Tail will return copies of the newest n records in the Ring (or every
+// record, if there aren't that many), oldest first, without consuming
+// anything. This is handy for looking at the last few things that happened.
+//
+// This is cheapest with the TrailingLength option, which lets Tail step
+// backwards from the newest record, or with the Index or SparseIndex
+// options, which let it jump straight to the first record it needs.
+// Otherwise, it has to walk the Ring from the head.
+//
+// Batches of coalesced records (see Options.Coalesce) are unpacked, so each
+// record in a batch counts on its own.
+func (r *Ring) Tail(n int) ([][]byte, error) {
+	if n < 0 {
+		return nil, fmt.Errorf("diskring: invalid tail length %d", n)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	offsets, err := r.tailEntries(n)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fill in the records from the newest back, since a batch can hold
+	// more records than we need.
+	ret := make([][]byte, n)
+	i := n
+	for j := len(offsets) - 1; j >= 0 && i > 0; j-- {
+		data, err := r.recordData(offsets[j])
+		if err != nil {
+			return nil, err
+		}
+		if r.entryFlags(offsets[j])&flagBatch == 0 {
+			i--
+			ret[i] = append([]byte{}, data...)
+			continue
+		}
+		records, err := unbatchAll(data)
+		if err != nil {
+			return nil, err
+		}
+		for k := len(records) - 1; k >= 0 && i > 0; k-- {
+			i--
+			ret[i] = append([]byte{}, records[k]...)
+		}
+	}
+	return ret[i:], nil
+}
+
+// UNSAFE
+//
+// Return the offsets of the newest n entries in the Ring, oldest first.
+func (r *Ring) tailEntries(n int) ([]uintptr, error) {
+	count := r.records()
+	if uint64(n) < count {
+		count = uint64(n)
+	}
+	offsets := make([]uintptr, count)
+	if count == 0 {
+		return offsets, nil
+	}
+
+	if r.layout.has(formatTrailer) {
+		off := r.lastEntry()
+		for i := len(offsets) - 1; ; i-- {
+			offsets[i] = off
+			if i == 0 {
+				break
+			}
+			off = r.prevEntry(off)
+		}
+		return offsets, nil
+	}
+
+	off, err := r.findSequence(r.header.tailSeq - count)
+	if err != nil {
+		return nil, err
+	}
+	for i := range offsets {
+		offsets[i] = off
+		off = r.nextEntry(off)
+	}
+	return offsets, nil
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,80 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestTail(t *testing.T) {
+	for name, options := range map[string]Options{
+		"walk":     {},
+		"trailing": {TrailingLength: true},
+		"index":    {Index: true},
+		"coalesce": {Coalesce: 64},
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := openTestRing(t, options)
+			if _, err := r.Tail(-1); err == nil {
+				t.Fatal("expected a negative length to be refused")
+			}
+			if records, err := r.Tail(3); err != nil || len(records) != 0 {
+				t.Fatalf("expected nothing from an empty ring, got %q (%v)", records, err)
+			}
+
+			var all []string
+			for i := 0; i < 10; i++ {
+				all = append(all, fmt.Sprint(i))
+			}
+			writeRecords(t, r, all...)
+			if err := r.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			records := r.Records()
+
+			for n, want := range map[int][]string{
+				0:  {},
+				3:  all[7:],
+				10: all,
+				20: all,
+			} {
+				tail, err := r.Tail(n)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got := []string{}
+				for _, record := range tail {
+					got = append(got, string(record))
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Fatalf("Tail(%d): expected %q, got %q", n, want, got)
+				}
+			}
+			if r.Records() != records {
+				t.Fatalf("expected Tail not to consume anything, got %d records left", r.Records())
+			}
+		})
+	}
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,79 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+)
+
+// errNoTmpfile is returned by openTmpfile when the filesystem (or the
+// platform) can't open a file without a name.
+var errNoTmpfile = errors.New("diskring: O_TMPFILE isn't supported")
+
+// NewTemp will create a new Ring Buffer backed by an unlinked temporary file
+// of the provided size in the directory dir (or the default directory for
+// temporary files, if dir is empty). Since the file has no name, it will
+// vanish as soon as the Ring is closed (or the process exits), without any
+// cleanup needed.
+//
+// As with any other Ring, the size must be aligned to the page size.
+func NewTemp(dir string, size int64) (*Ring, error) {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	fd, err := openTemp(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := fd.Truncate(size); err != nil {
+		fd.Close()
+		return nil, err
+	}
+	ring, err := New(fd)
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+	return ring, nil
+}
+
+// openTemp will open an unlinked file in the provided directory. This uses
+// O_TMPFILE where the filesystem supports it, so that the file never has a
+// name, and falls back to creating a file and unlinking it right away.
+func openTemp(dir string) (*os.File, error) {
+	if fd, err := openTmpfile(dir); err != errNoTmpfile {
+		return fd, err
+	}
+
+	file, err := ioutil.TempFile(dir, "diskring")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Remove(file.Name()); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,656 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	// tierFrameSize is the size of what's stored in front of each record in
+	// a tier segment: its sequence number, time, key and data lengths,
+	// flags, content type, producer, two bytes of padding, and a checksum
+	// of the whole frame.
+	tierFrameSize = 36
+
+	// defaultTierSegmentSize is how big a tier segment gets before the next
+	// one is started, if the options don't say.
+	defaultTierSegmentSize = 64 << 20
+)
+
+// tier is the chain of segment files that records fall into as they leave
+// the ring (see Options.TierDir).
+//
+// Each segment is named for the sequence number of the first record in it,
+// and holds records one after another, each after a frame describing it.
+// Only the newest segment is ever written to.
+type tier struct {
+	dir         string
+	segmentSize int64
+	maxBytes    int64
+	perms       filePerms
+
+	// segments is every segment in the directory, oldest first.
+	segments []tierSegment
+
+	// fd and w are the newest segment, if one's been started since the
+	// Ring was opened.
+	fd *os.File
+	w  *bufio.Writer
+
+	frame [tierFrameSize]byte
+}
+
+// tierSegment is a segment file in the tier directory.
+type tierSegment struct {
+	first uint64
+	size  int64
+}
+
+// newTier will pick up the segments already in the directory, creating it if
+// need be.
+func newTier(dir string, segmentSize, maxBytes int64, perms filePerms) (*tier, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	if segmentSize <= 0 {
+		segmentSize = defaultTierSegmentSize
+	}
+	t := &tier{dir: dir, segmentSize: segmentSize, maxBytes: maxBytes, perms: perms}
+	for _, file := range files {
+		var first uint64
+		if _, err := fmt.Sscanf(file.Name(), "%020d.tier", &first); err != nil || file.IsDir() {
+			continue
+		}
+		if filepath.Join(dir, file.Name()) != t.path(first) {
+			// Something else, such as a segment left half
+			// rewritten by erase.
+			continue
+		}
+		t.segments = append(t.segments, tierSegment{first: first, size: file.Size()})
+	}
+	sort.Slice(t.segments, func(i, j int) bool {
+		return t.segments[i].first < t.segments[j].first
+	})
+	return t, nil
+}
+
+// path will return the path to the segment starting at the provided
+// sequence number.
+func (t *tier) path(first uint64) string {
+	return filepath.Join(t.dir, fmt.Sprintf("%020d.tier", first))
+}
+
+// add will append a record with the provided sequence number to the newest
+// segment, starting a new one first if it's full (or none has been started
+// yet). The record is buffered, and may not be in the file until flush.
+func (t *tier) add(seq uint64, rec Record) error {
+	if t.fd == nil || t.segments[len(t.segments)-1].size >= t.segmentSize {
+		if err := t.roll(seq); err != nil {
+			return err
+		}
+	}
+
+	n, err := writeFrame(t.w, t.frame[:], seq, rec)
+	t.segments[len(t.segments)-1].size += n
+	return err
+}
+
+// writeFrame will write a record with the provided sequence number out to
+// w, after the frame describing it (using frame as scratch space), and
+// return the number of bytes written.
+func writeFrame(w io.Writer, frame []byte, seq uint64, rec Record) (int64, error) {
+	var nanos int64
+	if !rec.Time.IsZero() {
+		nanos = rec.Time.UnixNano()
+	}
+	binary.LittleEndian.PutUint64(frame[0:], seq)
+	binary.LittleEndian.PutUint64(frame[8:], uint64(nanos))
+	binary.LittleEndian.PutUint32(frame[16:], uint32(len(rec.Key)))
+	binary.LittleEndian.PutUint32(frame[20:], uint32(len(rec.Data)))
+	binary.LittleEndian.PutUint16(frame[24:], uint16(rec.Flags))
+	binary.LittleEndian.PutUint16(frame[26:], uint16(rec.ContentType))
+	binary.LittleEndian.PutUint16(frame[28:], rec.Producer)
+	binary.LittleEndian.PutUint16(frame[30:], 0)
+	sum := crc32.Update(crc32.Checksum(frame[:32], crcTable), crcTable, rec.Key)
+	binary.LittleEndian.PutUint32(frame[32:], crc32.Update(sum, crcTable, rec.Data))
+
+	var written int64
+	for _, buf := range [][]byte{frame, rec.Key, rec.Data} {
+		n, err := w.Write(buf)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// parseFrame will check a frame against the key and data that follow it
+// (buf), and return the record's sequence number, and the record, whose
+// Flags are as they were stored, and which shares buf.
+func parseFrame(frame, buf []byte) (uint64, Record, bool) {
+	sum := crc32.Update(crc32.Checksum(frame[:32], crcTable), crcTable, buf)
+	if sum != binary.LittleEndian.Uint32(frame[32:]) {
+		return 0, Record{}, false
+	}
+	keyLength := binary.LittleEndian.Uint32(frame[16:])
+	rec := Record{
+		Data:        buf[keyLength:],
+		Flags:       Flags(binary.LittleEndian.Uint16(frame[24:])),
+		ContentType: ContentType(binary.LittleEndian.Uint16(frame[26:])),
+		Producer:    binary.LittleEndian.Uint16(frame[28:]),
+	}
+	if keyLength > 0 {
+		rec.Key = buf[:keyLength]
+	}
+	if nanos := int64(binary.LittleEndian.Uint64(frame[8:])); nanos != 0 {
+		rec.Time = time.Unix(0, nanos)
+	}
+	return binary.LittleEndian.Uint64(frame[0:]), rec, true
+}
+
+// frameLength will return the number of bytes of key and data after a
+// frame. This comes straight from the frame, before anything's been
+// checked, so it must be checked against what's actually there before
+// anything is allocated for it.
+func frameLength(frame []byte) int64 {
+	return int64(binary.LittleEndian.Uint32(frame[16:])) + int64(binary.LittleEndian.Uint32(frame[20:]))
+}
+
+// roll will finish off the newest segment, and start a new one with the
+// record with the provided sequence number, dropping the oldest segments if
+// that puts the tier over its limit.
+//
+// If there's already a segment for that sequence number (such as when the
+// Ring went down after the records left the ring, but before its cursor
+// was flushed), it's appended to. Readers skip over anything they've seen.
+func (t *tier) roll(seq uint64) error {
+	if err := t.close(); err != nil {
+		return err
+	}
+	fd, err := os.OpenFile(t.path(seq), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if err := t.perms.apply(fd); err != nil {
+		fd.Close()
+		return err
+	}
+	stat, err := fd.Stat()
+	if err != nil {
+		fd.Close()
+		return err
+	}
+	t.fd, t.w = fd, bufio.NewWriter(fd)
+	if n := len(t.segments); n > 0 && t.segments[n-1].first == seq {
+		t.segments[n-1].size = stat.Size()
+	} else {
+		t.segments = append(t.segments, tierSegment{first: seq, size: stat.Size()})
+	}
+
+	if t.maxBytes <= 0 {
+		return nil
+	}
+	var total int64
+	for _, s := range t.segments {
+		total += s.size
+	}
+	for len(t.segments) > 1 && total > t.maxBytes {
+		if err := os.Remove(t.path(t.segments[0].first)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		total -= t.segments[0].size
+		t.segments = t.segments[1:]
+	}
+	return nil
+}
+
+// flush will write out anything buffered to the newest segment.
+func (t *tier) flush() error {
+	if t.w == nil {
+		return nil
+	}
+	return t.w.Flush()
+}
+
+// sync will write out anything buffered to the newest segment, and block
+// until it's on disk.
+func (t *tier) sync() error {
+	if err := t.flush(); err != nil || t.fd == nil {
+		return err
+	}
+	return t.fd.Sync()
+}
+
+// close will write out anything buffered to the newest segment, and close
+// it.
+func (t *tier) close() error {
+	if t.fd == nil {
+		return nil
+	}
+	err := t.flush()
+	if closeErr := t.fd.Close(); err == nil {
+		err = closeErr
+	}
+	t.fd, t.w = nil, nil
+	return err
+}
+
+// erase will rewrite every segment holding a record that match says should
+// go, leaving those records out, and return the number of records that
+// were. Batches of coalesced records are matched record by record. The
+// newest segment is closed first, and the next record to come along starts
+// a new one.
+func (t *tier) erase(match func([]byte) bool) (int, error) {
+	if err := t.close(); err != nil {
+		return 0, err
+	}
+	erased := 0
+	for i, segment := range t.segments {
+		n, size, err := t.eraseSegment(segment.first, match)
+		if err != nil {
+			return erased, err
+		}
+		if n > 0 {
+			t.segments[i].size = size
+		}
+		erased += n
+	}
+	return erased, nil
+}
+
+// eraseSegment will rewrite the segment starting at the provided sequence
+// number without the records that match says should go, if there are any,
+// returning how many there were, and the new size of the segment.
+func (t *tier) eraseSegment(first uint64, match func([]byte) bool) (int, int64, error) {
+	buf, err := ioutil.ReadFile(t.path(first))
+	if err != nil {
+		return 0, 0, err
+	}
+	var (
+		out    bytes.Buffer
+		erased int
+	)
+	for pos := 0; pos+tierFrameSize <= len(buf); {
+		frame := buf[pos : pos+tierFrameSize]
+		if frameLength(frame) > int64(len(buf)-pos-tierFrameSize) {
+			// The last record was only partly written out; it's
+			// dropped, just as a reader would skip it.
+			break
+		}
+		end := pos + tierFrameSize + int(frameLength(frame))
+		seq, rec, ok := parseFrame(frame, buf[pos+tierFrameSize:end])
+		if !ok {
+			return 0, 0, fmt.Errorf("diskring: tier segment %d is corrupt at offset %d", first, pos)
+		}
+		pos = end
+
+		if rec.Flags&Flags(flagBatch) == 0 {
+			if match(rec.Data) {
+				erased++
+				continue
+			}
+		} else {
+			gone, records, err := matchBatch(rec.Data, match)
+			if err != nil {
+				return 0, 0, err
+			}
+			erased += len(gone)
+			if len(gone) == records {
+				continue
+			}
+			if len(gone) > 0 {
+				if rec.Data, _, err = eraseBatch(rec.Data, gone); err != nil {
+					return 0, 0, err
+				}
+			}
+		}
+		if _, err := writeFrame(&out, t.frame[:], seq, rec); err != nil {
+			return 0, 0, err
+		}
+	}
+	if erased == 0 {
+		return 0, 0, nil
+	}
+	if err := replaceFile(t.path(first), out.Bytes(), t.perms); err != nil {
+		return 0, 0, err
+	}
+	return erased, int64(out.Len()), nil
+}
+
+// UNSAFE
+//
+// Add the entry at the head to the tier, since it's about to leave the
+// ring.
+func (r *Ring) tierHead() error {
+	rec, err := r.entryRecord(*r.head)
+	if err != nil {
+		return err
+	}
+	// A batch of coalesced records goes into the tier as it is, and is
+	// unpacked again when it's read back (see HistoryIterator).
+	rec.Flags |= Flags(r.entryFlags(*r.head) & flagBatch)
+	return r.tier.add(r.header.headSeq, rec)
+}
+
+// HistoryIterator walks over every record kept by the Ring, oldest first:
+// first the records that have left the Ring for its tier segments (see
+// Options.TierDir), then the records still in the Ring, without consuming
+// anything. Like Iterator, the Ring is only locked for as long as it takes
+// to find each record.
+//
+// Records the reader consumes, or writers overwrite, while iterating are
+// picked up from the tier, so nothing is skipped, unless the tier drops the
+// segment first (see Options.TierMaxBytes), or the record was discarded
+// without passing through the tier at all (such as by Reset, Compact or
+// Erase). Batches of coalesced records (see Options.Coalesce) are unpacked,
+// and each record in a batch is returned on its own, with the batch's
+// sequence number.
+type HistoryIterator struct {
+	r *Ring
+
+	// seq is the sequence number of the next record to return.
+	seq uint64
+
+	// fd is the segment being read, which starts at first, and pos is the
+	// offset of the next frame in it. If fd is nil, the records are coming
+	// out of the ring, and off is the offset of the entry with the
+	// sequence number offSeq, in the generation offGeneration.
+	fd    *os.File
+	first uint64
+	pos   int64
+
+	off           uintptr
+	offSeq        uint64
+	offGeneration uint64
+	offValid      bool
+
+	// batch is what's left of the batch of coalesced records the last
+	// record came out of.
+	batch []Record
+
+	rec    Record
+	recSeq uint64
+	err    error
+}
+
+// IterHistory will return a HistoryIterator over every record kept by the
+// Ring with a sequence number of at least from, oldest first, across its
+// tier segments and the Ring itself. Without the TierDir option, this is
+// just the records in the Ring.
+func (r *Ring) IterHistory(from uint64) *HistoryIterator {
+	return &HistoryIterator{r: r, seq: from}
+}
+
+// Next will move the HistoryIterator to the next record, returning false
+// when there are no more records, or if something went wrong (in which case
+// Err will return what).
+func (it *HistoryIterator) Next() bool {
+	if it.err == nil && len(it.batch) > 0 {
+		it.rec, it.batch = it.batch[0], it.batch[1:]
+		return true
+	}
+	for it.err == nil {
+		if it.fd != nil {
+			ok, err := it.readFrame()
+			if err != nil {
+				it.err = err
+				return false
+			}
+			if ok {
+				if it.recSeq < it.seq {
+					it.batch = nil
+					continue
+				}
+				it.seq = it.recSeq + 1
+				return true
+			}
+		}
+
+		it.r.mutex.Lock()
+		ok, done := it.step()
+		it.r.mutex.Unlock()
+		if done {
+			return ok
+		}
+	}
+	return false
+}
+
+// readFrame will read the next record from the segment being read, if
+// there's one there (as far as it's been written out).
+func (it *HistoryIterator) readFrame() (bool, error) {
+	var frame [tierFrameSize]byte
+	if _, err := it.fd.ReadAt(frame[:], it.pos); err == io.EOF {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	length := frameLength(frame[:])
+	if err := it.checkLength(length); err != nil {
+		return false, err
+	}
+	buf := make([]byte, length)
+	if _, err := it.fd.ReadAt(buf, it.pos+tierFrameSize); err == io.EOF {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	seq, rec, ok := parseFrame(frame[:], buf)
+	if !ok {
+		return false, it.corrupt()
+	}
+
+	it.pos += tierFrameSize + int64(len(buf))
+	it.recSeq, it.rec = seq, rec
+	return true, it.unbatch()
+}
+
+// checkLength will make sure that the segment being read has at least
+// length bytes after the frame at pos, so that a corrupt frame can't have
+// us allocate more than is there. The last record may still be on its way
+// out of the tier's buffer, so if it's not all there, the tier is flushed,
+// and if it's still not all there after that, the segment is corrupt.
+func (it *HistoryIterator) checkLength(length int64) error {
+	for flushed := false; ; flushed = true {
+		stat, err := it.fd.Stat()
+		if err != nil {
+			return err
+		}
+		if length <= stat.Size()-it.pos-tierFrameSize {
+			return nil
+		}
+		if flushed {
+			return it.corrupt()
+		}
+		it.r.mutex.Lock()
+		err = it.r.tier.flush()
+		it.r.mutex.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// corrupt will return the error for a segment that's corrupt at the frame
+// being read.
+func (it *HistoryIterator) corrupt() error {
+	return fmt.Errorf("diskring: tier segment %d is corrupt at offset %d", it.first, it.pos)
+}
+
+// unbatch will unpack the current record, if it's a batch of coalesced
+// records, moving on to the first record in it, and keeping the rest for
+// Next to return.
+func (it *HistoryIterator) unbatch() error {
+	batch := it.rec.Flags&Flags(flagBatch) != 0
+	it.rec.Flags &^= flagsReserved
+	it.batch = nil
+	if !batch {
+		return nil
+	}
+	records, err := unbatchRecord(it.rec, 0)
+	if err != nil {
+		return err
+	}
+	it.rec, it.batch = records[0], records[1:]
+	return nil
+}
+
+// UNSAFE
+//
+// Work out where the next record comes from, once the segment being read
+// (if any) has run out: more of the same segment once it's been flushed,
+// the next segment, or the ring. If it's in the ring, it's copied out, and
+// this returns done, with ok set if there was a record; otherwise Next has
+// to go read the segment.
+//
+// Running out of the tier and moving over to the ring has to happen with
+// the mutex held the whole time, so no records can leave the ring for the
+// tier in between and get skipped.
+func (it *HistoryIterator) step() (ok, done bool) {
+	r := it.r
+	checkTier := true
+	if it.fd != nil {
+		if it.err = r.tier.flush(); it.err != nil {
+			return false, true
+		}
+		stat, err := it.fd.Stat()
+		if err != nil {
+			it.err = err
+			return false, true
+		}
+		if stat.Size() > it.pos {
+			return false, false
+		}
+		it.fd.Close()
+		it.fd = nil
+		for _, s := range r.tier.segments {
+			if s.first > it.first {
+				it.err = it.open(s.first)
+				return false, it.err != nil
+			}
+		}
+		// Anything before the head that wasn't in the tier is gone.
+		checkTier = false
+	}
+
+	if checkTier && r.tier != nil && it.seq < r.header.headSeq && len(r.tier.segments) > 0 {
+		// Start at the newest segment that starts at or before the
+		// record we're after. If there isn't one, the tier's dropped it,
+		// and we'll start at the oldest we have.
+		first := r.tier.segments[0].first
+		for _, s := range r.tier.segments {
+			if s.first > it.seq {
+				break
+			}
+			first = s.first
+		}
+		if it.err = r.tier.flush(); it.err == nil {
+			it.err = it.open(first)
+		}
+		return false, it.err != nil
+	}
+
+	if it.seq < r.header.headSeq {
+		it.seq = r.header.headSeq
+	}
+	if it.seq >= r.header.tailSeq {
+		return false, true
+	}
+
+	off := it.off
+	if !it.offValid || it.offSeq != it.seq || it.offGeneration != r.header.generation {
+		var err error
+		if off, err = r.findSequence(it.seq); err != nil {
+			it.err = err
+			return false, true
+		}
+	}
+	rec, err := r.entryRecord(off)
+	if err != nil {
+		it.err = err
+		return false, true
+	}
+	it.rec, it.recSeq = rec, it.seq
+	it.rec.Flags |= Flags(r.entryFlags(off) & flagBatch)
+	if it.err = it.unbatch(); it.err != nil {
+		return false, true
+	}
+	it.seq++
+	it.off, it.offSeq, it.offGeneration, it.offValid = r.nextEntry(off), it.seq, r.header.generation, true
+	return true, true
+}
+
+// UNSAFE
+//
+// Open the tier segment starting at the provided sequence number for
+// reading.
+func (it *HistoryIterator) open(first uint64) error {
+	fd, err := os.Open(it.r.tier.path(first))
+	if err != nil {
+		return err
+	}
+	it.fd, it.first, it.pos = fd, first, 0
+	return nil
+}
+
+// Record will return the current record. Its Dropped is always 0.
+func (it *HistoryIterator) Record() Record {
+	return it.rec
+}
+
+// Sequence will return the sequence number of the current record.
+func (it *HistoryIterator) Sequence() uint64 {
+	return it.recSeq
+}
+
+// Err will return the error that stopped the HistoryIterator, if any.
+func (it *HistoryIterator) Err() error {
+	return it.err
+}
+
+// Close will release anything held by the HistoryIterator. This only needs
+// to be called if it's being given up on before Next returns false.
+func (it *HistoryIterator) Close() error {
+	if it.fd == nil {
+		return nil
+	}
+	err := it.fd.Close()
+	it.fd = nil
+	return err
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,76 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrNoTimestamps is returned when asking for the time a record was written,
+// if the Ring doesn't have Timestamps turned on.
+var ErrNoTimestamps = errors.New("diskring: timestamps are not enabled")
+
+// OldestTime will return the time the oldest record in the Ring was written.
+// Along with NewestTime, this gives the span of wall-clock history the Ring
+// currently holds.
+//
+// This requires the Timestamps option, and will return an io.EOF if the
+// Ring is empty.
+func (r *Ring) OldestTime() (time.Time, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if err := r.checkTimestamps(); err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, r.entryTime(*r.head)), nil
+}
+
+// NewestTime will return the time the newest record in the Ring was written.
+//
+// This requires the Timestamps option, and will return an io.EOF if the
+// Ring is empty.
+func (r *Ring) NewestTime() (time.Time, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if err := r.checkTimestamps(); err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, r.entryTime(r.lastEntry())), nil
+}
+
+// UNSAFE
+//
+// Check that there's a record with a timestamp to look at.
+func (r *Ring) checkTimestamps() error {
+	if !r.layout.has(formatTimestamp) {
+		return ErrNoTimestamps
+	}
+	if r.empty() {
+		return io.EOF
+	}
+	return nil
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,58 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func TestOldestNewestTime(t *testing.T) {
+	if _, err := openTestRing(t, Options{}).OldestTime(); err != ErrNoTimestamps {
+		t.Fatalf("expected ErrNoTimestamps, got %v", err)
+	}
+
+	r := openTestRing(t, Options{Timestamps: true})
+	if _, err := r.NewestTime(); err != io.EOF {
+		t.Fatalf("expected io.EOF from an empty ring, got %v", err)
+	}
+
+	before := time.Now()
+	writeRecords(t, r, "one")
+	time.Sleep(10 * time.Millisecond)
+	writeRecords(t, r, "two")
+	after := time.Now()
+
+	oldest, err := r.OldestTime()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newest, err := r.NewestTime()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if oldest.Before(before) || newest.After(after) || newest.Sub(oldest) < 10*time.Millisecond {
+		t.Fatalf("expected oldest and newest between %s and %s, 10ms apart, got %s and %s", before, after, oldest, newest)
+	}
+}
+
+// vim: foldmethod=marker
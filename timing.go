@@ -0,0 +1,233 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// Operation is something done to the Ring which can be timed (see
+// Options.Timings).
+type Operation int
+
+const (
+	// OpWrite is a Write or a WriteRecord.
+	OpWrite Operation = iota
+
+	// OpRead is a Read or a ReadRecord.
+	OpRead
+
+	// OpSync is a Sync.
+	OpSync
+
+	numOperations
+)
+
+// String will return the name of the Operation.
+func (op Operation) String() string {
+	switch op {
+	case OpWrite:
+		return "write"
+	case OpRead:
+		return "read"
+	case OpSync:
+		return "sync"
+	default:
+		return "unknown"
+	}
+}
+
+// Phase is a part of an Operation which is timed separately.
+type Phase int
+
+const (
+	// PhaseLockWait is the time spent waiting to take the Ring's locks.
+	PhaseLockWait Phase = iota
+
+	// PhaseCopy is the time spent copying records into or out of the
+	// Ring, with the locks held.
+	PhaseCopy
+
+	// PhaseSync is the time spent waiting for the Ring to be flushed out
+	// to disk.
+	PhaseSync
+
+	numPhases
+)
+
+// String will return the name of the Phase.
+func (p Phase) String() string {
+	switch p {
+	case PhaseLockWait:
+		return "lock_wait"
+	case PhaseCopy:
+		return "copy"
+	case PhaseSync:
+		return "sync"
+	default:
+		return "unknown"
+	}
+}
+
+// Timing is how long each Phase of a single Operation took. A Phase the
+// Operation doesn't have is 0, so a Write has no Sync, and a Sync has no
+// Copy. Time a Read spends waiting for a record to be written isn't counted
+// in any Phase.
+type Timing struct {
+	Op       Operation
+	LockWait time.Duration
+	Copy     time.Duration
+	Sync     time.Duration
+}
+
+// TimingHistogram is a histogram of how long one Phase of an Operation took.
+type TimingHistogram struct {
+	Op    Operation
+	Phase Phase
+
+	// Buckets has a bucket for each power of two nanoseconds. Empty
+	// buckets are left out.
+	Buckets []DurationBucket
+}
+
+// DurationBucket is a single bucket of a duration histogram.
+type DurationBucket struct {
+	// UpTo is the longest duration counted in this bucket.
+	UpTo time.Duration
+
+	// Count is the number of durations counted in this bucket.
+	Count uint64
+}
+
+// timingStats is the histogram of every Phase of every Operation. Like
+// sizeStats, the counters are only ever touched atomically.
+type timingStats struct {
+	buckets [numOperations][numPhases][65]uint64
+}
+
+// observe will count each Phase the Timing has.
+func (s *timingStats) observe(t Timing) {
+	for phase, d := range [numPhases]time.Duration{t.LockWait, t.Copy, t.Sync} {
+		if d > 0 {
+			atomic.AddUint64(&s.buckets[t.Op][phase][bits.Len64(uint64(d))], 1)
+		}
+	}
+}
+
+// histograms will return a histogram for each Phase of each Operation that's
+// been counted at all.
+func (s *timingStats) histograms() []TimingHistogram {
+	var histograms []TimingHistogram
+	for op := range s.buckets {
+		for phase := range s.buckets[op] {
+			var buckets []DurationBucket
+			for i := range s.buckets[op][phase] {
+				count := atomic.LoadUint64(&s.buckets[op][phase][i])
+				if count == 0 {
+					continue
+				}
+				buckets = append(buckets, DurationBucket{
+					UpTo:  time.Duration(uint64(1)<<uint(i) - 1),
+					Count: count,
+				})
+			}
+			if buckets != nil {
+				histograms = append(histograms, TimingHistogram{
+					Op:      Operation(op),
+					Phase:   Phase(phase),
+					Buckets: buckets,
+				})
+			}
+		}
+	}
+	return histograms
+}
+
+// timingHistograms will return the timing histograms, if the Ring is keeping
+// any.
+func (r *Ring) timingHistograms() []TimingHistogram {
+	if r.timings == nil {
+		return nil
+	}
+	return r.timings.histograms()
+}
+
+// timer times the Phases of a single Operation, if the Ring is keeping
+// track of timings at all.
+type timer struct {
+	r      *Ring
+	timing Timing
+	last   time.Time
+}
+
+// startTiming will start timing an Operation.
+func (r *Ring) startTiming(op Operation) timer {
+	if r.timings == nil && r.observeTiming == nil {
+		return timer{}
+	}
+	return timer{r: r, timing: Timing{Op: op}, last: time.Now()}
+}
+
+// lap will note that the provided Phase has just finished, and the next has
+// started.
+func (t *timer) lap(phase Phase) {
+	if t.r == nil {
+		return
+	}
+	now := time.Now()
+	d := now.Sub(t.last)
+	t.last = now
+	switch phase {
+	case PhaseLockWait:
+		t.timing.LockWait += d
+	case PhaseCopy:
+		t.timing.Copy += d
+	case PhaseSync:
+		t.timing.Sync += d
+	}
+}
+
+// skip will leave the time since the last Phase finished out of every
+// Phase.
+func (t *timer) skip() {
+	if t.r == nil {
+		return
+	}
+	t.last = time.Now()
+}
+
+// done will report the Timing of the Operation. This must be called without
+// the Ring's locks held, since the ObserveTiming hook could take a while.
+func (t *timer) done() {
+	if t.r == nil {
+		return
+	}
+	if t.r.timings != nil {
+		t.r.timings.observe(t.timing)
+	}
+	if t.r.observeTiming != nil {
+		t.r.observeTiming(t.timing)
+	}
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,89 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTimingHistograms(t *testing.T) {
+	var s timingStats
+	s.observe(Timing{Op: OpWrite, LockWait: 3, Copy: 1000})
+	s.observe(Timing{Op: OpWrite, Copy: 1000})
+	s.observe(Timing{Op: OpSync, Sync: time.Millisecond})
+
+	want := []TimingHistogram{
+		{Op: OpWrite, Phase: PhaseLockWait, Buckets: []DurationBucket{{UpTo: 3, Count: 1}}},
+		{Op: OpWrite, Phase: PhaseCopy, Buckets: []DurationBucket{{UpTo: 1023, Count: 2}}},
+		{Op: OpSync, Phase: PhaseSync, Buckets: []DurationBucket{{UpTo: 1<<20 - 1, Count: 1}}},
+	}
+	if histograms := s.histograms(); !reflect.DeepEqual(histograms, want) {
+		t.Fatalf("expected %+v, got %+v", want, histograms)
+	}
+}
+
+func TestObserveTiming(t *testing.T) {
+	var timings []Timing
+	r := openTestRing(t, Options{
+		Timings:       true,
+		ObserveTiming: func(timing Timing) { timings = append(timings, timing) },
+	})
+	writeRecords(t, r, "one", "two")
+	readRecord(t, r)
+	if err := r.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	ops := []Operation{}
+	for _, timing := range timings {
+		ops = append(ops, timing.Op)
+		if timing.Op != OpSync && (timing.Copy <= 0 || timing.Sync != 0) {
+			t.Fatalf("expected a %s to copy without syncing, got %+v", timing.Op, timing)
+		}
+		if timing.Op == OpSync && (timing.Copy != 0 || timing.Sync <= 0) {
+			t.Fatalf("expected a sync to sync without copying, got %+v", timing)
+		}
+	}
+	if want := []Operation{OpWrite, OpWrite, OpRead, OpSync}; !reflect.DeepEqual(ops, want) {
+		t.Fatalf("expected %v to be timed, got %v", want, ops)
+	}
+
+	stats, err := r.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var writes uint64
+	for _, histogram := range stats.Timings {
+		if histogram.Op != OpWrite || histogram.Phase != PhaseCopy {
+			continue
+		}
+		for _, bucket := range histogram.Buckets {
+			writes += bucket.Count
+		}
+	}
+	if writes != 2 {
+		t.Fatalf("expected 2 writes in the histograms, got %d", writes)
+	}
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,48 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+//go:build !diskring_portable && !wasip1
+// +build !diskring_portable,!wasip1
+
+package diskring
+
+import (
+	"os"
+	"syscall"
+)
+
+// oTmpfile is O_TMPFILE, which the syscall package doesn't know about.
+const oTmpfile = 0x400000 | syscall.O_DIRECTORY
+
+// openTmpfile will open a file without a name in the provided directory,
+// returning errNoTmpfile if the filesystem can't do that.
+func openTmpfile(dir string) (*os.File, error) {
+	fd, err := syscall.Open(dir, syscall.O_RDWR|syscall.O_CLOEXEC|oTmpfile, 0600)
+	switch err {
+	case nil:
+		return os.NewFile(uintptr(fd), dir), nil
+	case syscall.EOPNOTSUPP, syscall.EISDIR, syscall.EINVAL:
+		return nil, errNoTmpfile
+	default:
+		return nil, &os.PathError{Op: "open", Path: dir, Err: err}
+	}
+}
+
+// vim: foldmethod=marker
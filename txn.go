@@ -0,0 +1,140 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"errors"
+	"io"
+	"runtime/debug"
+)
+
+// ErrTxnDone is returned when using a Txn after it's been committed or
+// rolled back.
+var ErrTxnDone = errors.New("diskring: transaction has already been committed or rolled back")
+
+// Txn is a set of writes to the Ring which will become visible to readers
+// all at once when Commit is called, or be thrown away on Rollback.
+//
+// While a Txn is open, all other writes to the Ring will wait until it's
+// been committed or rolled back, so be sure to always do one or the other.
+type Txn struct {
+	r     *Ring
+	count uint64
+	last  uintptr
+	done  bool
+}
+
+// Begin will start a new transaction. Records written with the Txn are
+// staged after the tail of the Ring, and the tail only moves to include them
+// once the Txn is committed.
+//
+// Staging data still needs space in the Ring, so writing to a Txn may
+// overwrite the oldest records in the Ring, even if it's rolled back later.
+func (r *Ring) Begin() *Txn {
+	r.writeMutex.Lock()
+	return &Txn{r: r}
+}
+
+// Write will stage a block of data to be written to the Ring when the Txn
+// is committed. The same size limits as Ring.Write apply to each block, and
+// the Txn as a whole has to fit into the Ring.
+func (t *Txn) Write(buf []byte) (n int, err error) {
+	if t.done {
+		return 0, ErrTxnDone
+	}
+
+	r := t.r
+	if err := r.validate(buf); err != nil {
+		return 0, err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer r.catchFault(&err)
+
+	if err := r.checkWrite(len(buf)); err != nil {
+		return 0, err
+	}
+	if r.staged == 0 {
+		if err := r.flushBatch(); err != nil {
+			return 0, err
+		}
+	}
+	if err := r.reserve(uintptr(len(buf))); err != nil {
+		if err == io.EOF {
+			// Even an empty Ring doesn't have room for everything
+			// staged so far plus this, so the Txn as a whole is
+			// too large.
+			return 0, &TooLargeError{
+				Size:  int(r.staged + r.entrySize(uintptr(len(buf)))),
+				Limit: int(r.size - 1),
+			}
+		}
+		return 0, err
+	}
+	t.last = r.stageOffset()
+	r.staged += r.putEntry(t.last, r.newEnvelope(), buf)
+	t.count++
+	return len(buf), nil
+}
+
+// Commit will make all the records written to the Txn visible to readers,
+// and allow other writes to the Ring to continue.
+func (t *Txn) Commit() error {
+	if t.done {
+		return ErrTxnDone
+	}
+
+	r := t.r
+	r.mutex.Lock()
+	r.publish(r.staged, t.count, t.last)
+	r.staged = 0
+	r.mutex.Unlock()
+
+	t.finish()
+	return nil
+}
+
+// Rollback will throw away all the records written to the Txn, and allow
+// other writes to the Ring to continue.
+func (t *Txn) Rollback() error {
+	if t.done {
+		return ErrTxnDone
+	}
+
+	r := t.r
+	r.mutex.Lock()
+	r.staged = 0
+	r.chain = r.header.chain
+	r.mutex.Unlock()
+
+	t.finish()
+	return nil
+}
+
+// finish will mark the Txn as done, and release the Ring for other writers.
+func (t *Txn) finish() {
+	t.done = true
+	t.r.writeMutex.Unlock()
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,67 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTxn(t *testing.T) {
+	r := openTestRing(t, Options{})
+
+	txn := r.Begin()
+	for _, record := range []string{"one", "two"} {
+		if _, err := txn.Write([]byte(record)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := r.Records(); n != 0 {
+		t.Fatalf("staged records were visible before the commit: %d", n)
+	}
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := txn.Write([]byte("late")); err != ErrTxnDone {
+		t.Fatalf("expected ErrTxnDone, got %v", err)
+	}
+
+	txn = r.Begin()
+	if _, err := txn.Write([]byte("invalid")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Commit(); err != ErrTxnDone {
+		t.Fatalf("expected ErrTxnDone, got %v", err)
+	}
+
+	// Writes go on as normal once the Txn is done with.
+	if _, err := r.Write([]byte("three")); err != nil {
+		t.Fatal(err)
+	}
+	if records, _ := fetchStrings(t, r, FetchOptions{}); !reflect.DeepEqual(records, []string{"one", "two", "three"}) {
+		t.Fatalf("expected the committed records, got %v", records)
+	}
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,141 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"fmt"
+	"reflect"
+	"unsafe"
+)
+
+// cursorType is the type of a Cursor, for finding one in a HeaderType.
+var cursorType = reflect.TypeOf(Cursor{})
+
+// typedHeader is a HeaderType that's been checked over.
+type typedHeader struct {
+	typ reflect.Type
+
+	// cursor is the offset of the Cursor tagged `diskring:"cursor"`, or
+	// -1 if there isn't one.
+	cursor int
+}
+
+// newTypedHeader will check that the HeaderType is safe to map onto the
+// header page, and find the Cursor in it, if there is one.
+func newTypedHeader(v interface{}) (*typedHeader, error) {
+	t := reflect.TypeOf(v)
+	if t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("diskring: HeaderType must be a pointer to a struct, not %s", t)
+	}
+	t = t.Elem()
+	if err := checkPlainType(t); err != nil {
+		return nil, err
+	}
+
+	th := &typedHeader{typ: t, cursor: -1}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Tag.Get("diskring") != "cursor" {
+			continue
+		}
+		if field.Type != cursorType {
+			return nil, fmt.Errorf("diskring: HeaderType field %s is tagged as the cursor, but isn't a diskring.Cursor", field.Name)
+		}
+		if th.cursor >= 0 {
+			return nil, fmt.Errorf("diskring: HeaderType has more than one cursor")
+		}
+		th.cursor = int(field.Offset)
+	}
+	return th, nil
+}
+
+// checkPlainType will make sure that the type is only made up of plain old
+// data (numbers, and arrays and structs of them), since anything else (like
+// a pointer, or a string) can't be stored in a file.
+func checkPlainType(t reflect.Type) error {
+	switch t.Kind() {
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Uintptr, reflect.Float32, reflect.Float64,
+		reflect.Complex64, reflect.Complex128:
+		return nil
+	case reflect.Array:
+		return checkPlainType(t.Elem())
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if err := checkPlainType(t.Field(i).Type); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("diskring: HeaderType can't contain a %s", t)
+	}
+}
+
+// replacesHeader will check if the HeaderType has a Cursor, in which case it
+// takes the place of the library's header, just like a CustomHeader.
+// Otherwise, it goes in the space left for the user (see UserHeader).
+func (th *typedHeader) replacesHeader() bool {
+	return th.cursor >= 0
+}
+
+// customHeader implements the CustomHeader contract for the HeaderType.
+func (th *typedHeader) customHeader(base unsafe.Pointer, size int) (*Cursor, error) {
+	if int(th.typ.Size()) > size {
+		return nil, fmt.Errorf("diskring: HeaderType is too large for the header")
+	}
+	return (*Cursor)(unsafe.Pointer(uintptr(base) + uintptr(th.cursor))), nil
+}
+
+// UNSAFE
+//
+// Map the HeaderType onto the header page, returning a pointer to it. If
+// the header is read only, this will be a pointer to a copy instead.
+func (th *typedHeader) at(page []byte, readOnly bool) (interface{}, error) {
+	if !th.replacesHeader() {
+		page = page[libraryHeaderSize:]
+	}
+	if int(th.typ.Size()) > len(page) {
+		return nil, fmt.Errorf("diskring: HeaderType is too large for the header")
+	}
+
+	mapped := reflect.NewAt(th.typ, unsafe.Pointer(&page[0]))
+	if !readOnly {
+		return mapped.Interface(), nil
+	}
+	copied := reflect.New(th.typ)
+	copied.Elem().Set(mapped.Elem())
+	return copied.Interface(), nil
+}
+
+// Header will return a pointer to the header mapped from the HeaderType
+// option, which can be type asserted back to the HeaderType, or nil if the
+// option isn't set.
+//
+// Anything written to it is persisted along with the rest of the Ring; call
+// SyncHeader to flush it out to disk right away.
+func (r *Ring) Header() interface{} {
+	return r.typedHeader
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,103 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"testing"
+)
+
+type testMetadata struct {
+	Version uint32
+	Count   uint64
+}
+
+type testCursorHeader struct {
+	Magic  uint64
+	Cursor Cursor `diskring:"cursor"`
+	Count  uint64
+}
+
+func TestHeaderType(t *testing.T) {
+	for name, headerType := range map[string]interface{}{
+		"UserHeader": &testMetadata{},
+		"Cursor":     &testCursorHeader{},
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := testRingPath(t)
+			options := Options{ReserveHeader: true, HeaderType: headerType, NonBlockingReads: true}
+			r := openTestRingAt(t, path, options)
+			switch h := r.Header().(type) {
+			case *testMetadata:
+				h.Version, h.Count = 3, 42
+			case *testCursorHeader:
+				h.Magic, h.Count = 0xfeed, 42
+			default:
+				t.Fatalf("expected a %T, got a %T", headerType, h)
+			}
+			writeRecords(t, r, "one", "two")
+			readRecord(t, r)
+			if err := r.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			r = openTestRingAt(t, path, options)
+			defer r.Close()
+			switch h := r.Header().(type) {
+			case *testMetadata:
+				if h.Version != 3 || h.Count != 42 {
+					t.Fatalf("expected the header to be kept, got %+v", h)
+				}
+			case *testCursorHeader:
+				if h.Magic != 0xfeed || h.Count != 42 {
+					t.Fatalf("expected the header to be kept, got %+v", h)
+				}
+			}
+			if record := readRecord(t, r); record != "two" {
+				t.Fatalf("expected \"two\", got %q", record)
+			}
+		})
+	}
+}
+
+func TestHeaderTypeInvalid(t *testing.T) {
+	for name, headerType := range map[string]interface{}{
+		"NotPointer": testMetadata{},
+		"String":     &struct{ Name string }{},
+		"Pointer":    &struct{ Next *uint64 }{},
+		"NotCursor": &struct {
+			Cursor uint64 `diskring:"cursor"`
+		}{},
+		"TwoCursors": &struct {
+			A Cursor `diskring:"cursor"`
+			B Cursor `diskring:"cursor"`
+		}{},
+	} {
+		if _, err := newTypedHeader(headerType); err == nil {
+			t.Errorf("%s: expected %T to be refused", name, headerType)
+		}
+	}
+	path := testRingPath(t)
+	if _, err := OpenWithOptions(path, Options{CreateIfMissing: true, CreateSize: 1 << 16, HeaderType: &testMetadata{}}); err == nil {
+		t.Fatal("expected HeaderType to need ReserveHeader")
+	}
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,326 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"unsafe"
+)
+
+// lossyFormat is the bits of the record format that hold something about
+// each record, rather than just changing how it's laid out, so they can't
+// be dropped by UpgradeFile without losing it.
+const lossyFormat = formatTimestamp | formatFlags | formatContentType | formatProducer | formatKey | formatSchema
+
+// errUpgradeTooSmall is returned by UpgradeFile when the records don't fit
+// in a file of the size it was asked for.
+var errUpgradeTooSmall = errors.New("diskring: the records don't fit in the upgraded ring (see CreateSize)")
+
+// FormatVersion is the format a ring file is laid out in: the layout of its
+// header, and which optional fields are stored alongside each record (see
+// FileFormat and UpgradeFile).
+type FormatVersion struct {
+	// Header is the version of the layout of the header. Headers are
+	// always brought up to the latest version when the file is opened for
+	// writing, so this is only ever reported by FileFormat, and is
+	// ignored by UpgradeFile.
+	Header uint64
+
+	// Signed is set if the records in the file are signed (see
+	// Options.SigningKey). The Options can't say so without the key, so
+	// this is only ever reported by FileFormat, and UpgradeFile goes by
+	// the keys in the Options.
+	Signed bool
+
+	// Options are the Options that lay records out in this format. Only
+	// those that change how records are laid out in the file (see
+	// Options.Timestamps) decide the format, but UpgradeFile uses a few
+	// more while it copies records into a file of this format: SpillDir
+	// and Dictionaries, to read back records that were spilled or
+	// compressed, and to spill or compress them again; SigningKey, to
+	// sign them (and VerifyKey, to check the signatures of records
+	// that are already signed); CreateSize and Alignment, for the size of
+	// the file, which stays the same if they're 0 (unless the records no
+	// longer fit, and it has to grow); and SchemaVersion,
+	// CompressBelow, FileMode and FileOwner. Anything else is ignored.
+	Options Options
+}
+
+// FileFormat will return the format of the ring file at the provided path,
+// which must have its header on disk (see Options.ReserveHeader), since
+// that's where the format is kept. The file doesn't have to be opened with
+// the right Options first, so this is handy for upgrading files written
+// with older Options: change what's returned, and pass it to UpgradeFile.
+func FileFormat(path string) (FormatVersion, error) {
+	hdr, err := readHeader(path)
+	if err != nil {
+		return FormatVersion{}, err
+	}
+	options := formatOptions(hdr.format)
+	options.ReserveHeader = true
+	options.Alignment = int(hdr.alignment)
+	return FormatVersion{
+		Header:  hdr.version,
+		Signed:  hdr.format&formatSignature != 0,
+		Options: options,
+	}, nil
+}
+
+// readHeader will read the header of the ring file at the provided path
+// without mapping it, going back to the shadow copy if the header was
+// torn, and migrating a copy of it up to the current layout.
+func readHeader(path string) (header, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return header{}, err
+	}
+	defer fd.Close()
+
+	buf := make([]byte, shadowOffset+unsafe.Sizeof(header{}))
+	if _, err := io.ReadFull(fd, buf); err != nil {
+		return header{}, fmt.Errorf("diskring: can't read header: %w", err)
+	}
+	hdr := *(*header)(unsafe.Pointer(&buf[0]))
+	if hdr.magic != headerMagic {
+		if shadow := shadowHeader(buf); shadow.committed() {
+			hdr = *shadow
+		}
+	}
+	version := hdr.version
+	if hdr.magic != headerMagic {
+		version = 0
+	}
+	hdr.migrate()
+	hdr.version = version
+	return hdr, nil
+}
+
+// UpgradeFile will rewrite the ring file at the provided path in the target
+// format, keeping every record in it, along with the sequence numbers, the
+// checkpoint, the UserHeader, and the Consumers kept in the header. This is
+// how a file written with one set of Options is moved over to Options
+// that lay records out differently (such as to add Checksums), which
+// would otherwise fail to open it with ErrFormatMismatch. The file must
+// have its header on disk (see Options.ReserveHeader).
+//
+// The records are copied into a new file next to the old one, which is
+// renamed over it once it's been flushed to disk, so the file is either
+// upgraded or left just as it was, but there has to be room for both.
+// Anything that's moved to a new format only has to be written again, so
+// records are spilled, compressed, hash chained and signed afresh, as the
+// target says. If the target doesn't store something that's stored
+// alongside the records now (like their timestamps), nothing is changed,
+// and an error is returned. The same goes if the records no longer fit in
+// a file of the CreateSize asked for; without one, the file is grown by a
+// quarter at a time until they do. If the format
+// isn't changing, the header is just brought up to the latest version.
+//
+// Nothing else may have the file open while it's upgraded. It's opened with
+// Lock (where that's supported), to keep out anything else that takes the
+// lock. Positions of
+// Consumers kept anywhere other than the header (and any CursorState) can't
+// be used with the upgraded file, just like after Compact.
+func UpgradeFile(path string, target FormatVersion) error {
+	current, err := FileFormat(path)
+	if err != nil {
+		return err
+	}
+
+	from := current.Options
+	from.Lock = canLock
+	if from.RecordFlags {
+		from.SpillDir = target.Options.SpillDir
+		from.Dictionaries = target.Options.Dictionaries
+	}
+	if current.Signed {
+		from.SigningKey = target.Options.SigningKey
+		from.VerifyKey = target.Options.VerifyKey
+		if from.SigningKey == nil && from.VerifyKey == nil {
+			return ErrNoSigningKey
+		}
+	}
+
+	to := formatOptions(target.Options.format())
+	to.ReserveHeader = true
+	to.CreateIfMissing = true
+	to.CreateSize = target.Options.CreateSize
+	to.Alignment = target.Options.Alignment
+	to.SpillDir = target.Options.SpillDir
+	to.Dictionaries = target.Options.Dictionaries
+	to.CompressBelow = target.Options.CompressBelow
+	to.SigningKey = target.Options.SigningKey
+	to.VerifyKey = target.Options.VerifyKey
+	to.SchemaVersion = target.Options.SchemaVersion
+	to.FileMode = target.Options.FileMode
+	to.FileOwner = target.Options.FileOwner
+
+	if lost := from.format() & lossyFormat &^ to.format(); lost != 0 {
+		return fmt.Errorf("diskring: the target format doesn't store everything stored alongside the records now")
+	}
+	if to.format()&formatSignature != 0 && to.SigningKey == nil {
+		return ErrNoSigningKey
+	}
+
+	src, err := OpenWithOptions(path, from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if to.CreateSize == 0 {
+		to.CreateSize = int(src.size)
+	}
+	if to.Alignment == 0 {
+		to.Alignment = int(src.align)
+	}
+	if to.format() == from.format() && to.CreateSize == int(src.size) && to.Alignment == int(src.align) {
+		// Opening the file for writing has already brought the header
+		// up to date, which is all there is to do.
+		return nil
+	}
+	if to.FileMode == 0 {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		to.FileMode = info.Mode().Perm()
+	}
+
+	for {
+		err := src.upgradeTo(path, to)
+		if err == errUpgradeTooSmall && target.Options.CreateSize == 0 {
+			to.CreateSize += to.CreateSize / 4
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		break
+	}
+
+	// The upgraded file spilled its records to side files of its own,
+	// so the old ones aren't needed any more.
+	src.mutex.Lock()
+	for off := *src.head; off != *src.tail; off = src.nextEntry(off) {
+		src.dropEntry(off)
+	}
+	src.mutex.Unlock()
+	return nil
+}
+
+// upgradeTo will copy the records in the Ring into a new file laid out with
+// the provided Options, and rename it over the file at path.
+func (r *Ring) upgradeTo(path string, options Options) error {
+	// The upgraded file is created (exclusively) with a name nobody else
+	// is going to pick, so we take one, and let the Ring create it.
+	dir, base := filepath.Split(path)
+	fd, err := ioutil.TempFile(dir, base+".*")
+	if err != nil {
+		return err
+	}
+	name := fd.Name()
+	fd.Close()
+	os.Remove(name)
+
+	dst, err := OpenWithOptions(name, options)
+	if err != nil {
+		os.Remove(name)
+		return err
+	}
+	if err := r.upgradeInto(dst); err != nil {
+		dst.Close()
+		os.Remove(name)
+		return err
+	}
+	if err := dst.Sync(); err != nil {
+		dst.Close()
+		os.Remove(name)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(name)
+		return err
+	}
+	if err := os.Rename(name, path); err != nil {
+		os.Remove(name)
+		return err
+	}
+	return nil
+}
+
+// upgradeInto will write every record in the Ring into the empty Ring dst,
+// which lays them out in a different format, and carry over everything in
+// the header that isn't about where records are.
+func (r *Ring) upgradeInto(dst *Ring) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	dst.writeMutex.Lock()
+	defer dst.writeMutex.Unlock()
+
+	dst.mutex.Lock()
+	defer dst.mutex.Unlock()
+
+	headSeq := r.header.headSeq
+	dst.beginUpdate()
+	dst.setHead(*dst.head, headSeq)
+	dst.setTail(*dst.tail, headSeq)
+	dst.endUpdate()
+
+	for off := *r.head; off != *r.tail; off = r.nextEntry(off) {
+		data, err := r.recordData(off)
+		if err != nil {
+			return err
+		}
+		if _, err := dst.writeEntry(r.entryEnvelope(off), append([]byte{}, data...)); err != nil {
+			return err
+		}
+		if dst.header.headSeq != headSeq {
+			return errUpgradeTooSmall
+		}
+	}
+
+	dst.header.producers = r.header.producers
+	dst.header.checkpoint = r.header.checkpoint
+	dst.header.activity = r.header.activity
+	dst.header.overwrittenRecords = r.header.overwrittenRecords
+	dst.header.overwrittenBytes = r.header.overwrittenBytes
+	for i, slot := range r.header.consumers {
+		if slot.hash == 0 {
+			continue
+		}
+		off, err := dst.findSequence(slot.seq)
+		if err != nil {
+			off = *dst.head
+		}
+		slot.off = uint64(off)
+		dst.header.consumers[i] = slot
+	}
+	copy(dst.headerPage[libraryHeaderSize:], r.headerPage[libraryHeaderSize:])
+	return nil
+}
+
+// vim: foldmethod=marker
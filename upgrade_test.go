@@ -0,0 +1,80 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"testing"
+)
+
+func TestUpgradeFile(t *testing.T) {
+	path := testRingPath(t)
+	r := openTestRingAt(t, path, Options{ReserveHeader: true, Timestamps: true})
+	writeRecords(t, r, "one", "two", "three")
+	readRecord(t, r)
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	format, err := FileFormat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !format.Options.Timestamps || format.Options.Checksums {
+		t.Fatalf("expected a file with timestamps and no checksums, got %+v", format.Options)
+	}
+	upgraded := Options{ReserveHeader: true, Timestamps: true, Checksums: true, NonBlockingReads: true}
+	if _, err := OpenWithOptions(path, upgraded); err != ErrFormatMismatch {
+		t.Fatalf("expected ErrFormatMismatch before the upgrade, got %v", err)
+	}
+
+	// Dropping the timestamps would lose them, so that's refused.
+	if err := UpgradeFile(path, FormatVersion{Options: Options{Checksums: true}}); err == nil {
+		t.Fatal("expected an upgrade dropping the timestamps to be refused")
+	}
+
+	format.Options.Checksums = true
+	if err := UpgradeFile(path, format); err != nil {
+		t.Fatal(err)
+	}
+	if format, err = FileFormat(path); err != nil {
+		t.Fatal(err)
+	}
+	if !format.Options.Timestamps || !format.Options.Checksums {
+		t.Fatalf("expected a file with timestamps and checksums, got %+v", format.Options)
+	}
+
+	r = openTestRingAt(t, path, upgraded)
+	defer r.Close()
+	stats, err := r.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.HeadSequence != 1 || stats.TailSequence != 3 {
+		t.Fatalf("expected the sequence numbers to be kept, got %d to %d", stats.HeadSequence, stats.TailSequence)
+	}
+	for _, want := range []string{"two", "three"} {
+		if record := readRecord(t, r); record != want {
+			t.Fatalf("expected %s, got %q", want, record)
+		}
+	}
+}
+
+// vim: foldmethod=marker
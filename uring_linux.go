@@ -0,0 +1,298 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+//go:build linux && !diskring_portable
+// +build linux,!diskring_portable
+
+package diskring
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+// This is just enough of io_uring(7) to hand slow syscalls (like fsync) off
+// to the kernel and be told when they're done, without tying up a thread
+// per call. There's no io_uring support in the syscall package, so the
+// structures here are laid out to match <linux/io_uring.h>.
+
+const (
+	sysIOUringSetup = 425
+	sysIOUringEnter = 426
+
+	uringEntries = 64
+
+	uringOffSQRing = 0
+	uringOffCQRing = 0x8000000
+	uringOffSQEs   = 0x10000000
+
+	uringEnterGetEvents = 1 << 0
+
+	uringOpNop     = 0
+	uringOpFsync   = 3
+	uringOpMadvise = 25
+
+	// uringClose is the user data of the no-op submitted to wake the
+	// reaper up when the uring is being closed.
+	uringClose = 0
+)
+
+// errUringFull is returned when there's no room left in the submission
+// queue.
+var errUringFull = errors.New("diskring: io_uring submission queue is full")
+
+type uringSQOffsets struct {
+	head, tail, ringMask, ringEntries, flags, dropped, array, resv1 uint32
+	resv2                                                           uint64
+}
+
+type uringCQOffsets struct {
+	head, tail, ringMask, ringEntries, overflow, cqes, flags, resv1 uint32
+	resv2                                                           uint64
+}
+
+type uringParams struct {
+	sqEntries, cqEntries, flags, sqThreadCPU, sqThreadIdle, features, wqFd uint32
+	resv                                                                   [3]uint32
+	sqOff                                                                  uringSQOffsets
+	cqOff                                                                  uringCQOffsets
+}
+
+// uringSQE is a submission queue entry.
+type uringSQE struct {
+	opcode   uint8
+	flags    uint8
+	ioprio   uint16
+	fd       int32
+	off      uint64
+	addr     uint64
+	len      uint32
+	opFlags  uint32
+	userData uint64
+	pad      [3]uint64
+}
+
+// uringCQE is a completion queue entry.
+type uringCQE struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+// uring is an io_uring instance, with a goroutine reaping completions and
+// handing them back to whoever submitted them.
+type uring struct {
+	fd     int
+	sqRing []byte
+	cqRing []byte
+	sqes   []byte
+	params uringParams
+
+	mutex   sync.Mutex
+	next    uint64
+	pending map[uint64]chan error
+	closing bool
+	done    chan struct{}
+}
+
+// newURing will set up an io_uring, if the kernel lets us have one.
+func newURing() (*uring, error) {
+	u := &uring{
+		next:    uringClose + 1,
+		pending: map[uint64]chan error{},
+		done:    make(chan struct{}),
+	}
+	fd, _, e1 := syscall.Syscall(sysIOUringSetup, uringEntries,
+		uintptr(unsafe.Pointer(&u.params)), 0)
+	if e1 != 0 {
+		return nil, e1
+	}
+	u.fd = int(fd)
+
+	var err error
+	p := &u.params
+	u.sqRing, err = syscall.Mmap(u.fd, uringOffSQRing,
+		int(p.sqOff.array+p.sqEntries*4),
+		syscall.PROT_READ|syscall.PROT_WRITE,
+		syscall.MAP_SHARED|syscall.MAP_POPULATE)
+	if err != nil {
+		u.release()
+		return nil, err
+	}
+	u.cqRing, err = syscall.Mmap(u.fd, uringOffCQRing,
+		int(uintptr(p.cqOff.cqes)+uintptr(p.cqEntries)*unsafe.Sizeof(uringCQE{})),
+		syscall.PROT_READ|syscall.PROT_WRITE,
+		syscall.MAP_SHARED|syscall.MAP_POPULATE)
+	if err != nil {
+		u.release()
+		return nil, err
+	}
+	u.sqes, err = syscall.Mmap(u.fd, uringOffSQEs,
+		int(uintptr(p.sqEntries)*unsafe.Sizeof(uringSQE{})),
+		syscall.PROT_READ|syscall.PROT_WRITE,
+		syscall.MAP_SHARED|syscall.MAP_POPULATE)
+	if err != nil {
+		u.release()
+		return nil, err
+	}
+
+	go u.reap()
+	return u, nil
+}
+
+// word will return a pointer to the uint32 at off into the mapping.
+func word(ring []byte, off uint32) *uint32 {
+	return (*uint32)(unsafe.Pointer(&ring[off]))
+}
+
+// submit will queue up the operation, and return a channel which gets the
+// result once the kernel is done with it.
+func (u *uring) submit(sqe uringSQE) (<-chan error, error) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	if u.closing {
+		return nil, errUringFull
+	}
+	sqe.userData = u.next
+	if err := u.push(sqe); err != nil {
+		return nil, err
+	}
+	ch := make(chan error, 1)
+	u.pending[u.next] = ch
+	u.next++
+	return ch, nil
+}
+
+// push will put the entry on the submission queue, and tell the kernel
+// about it. The caller must hold the mutex.
+func (u *uring) push(sqe uringSQE) error {
+	p := &u.params
+	head := atomic.LoadUint32(word(u.sqRing, p.sqOff.head))
+	tail := *word(u.sqRing, p.sqOff.tail)
+	if tail-head >= p.sqEntries {
+		return errUringFull
+	}
+
+	idx := tail & *word(u.sqRing, p.sqOff.ringMask)
+	*(*uringSQE)(unsafe.Pointer(&u.sqes[uintptr(idx)*unsafe.Sizeof(sqe)])) = sqe
+	*word(u.sqRing, p.sqOff.array+idx*4) = idx
+	atomic.StoreUint32(word(u.sqRing, p.sqOff.tail), tail+1)
+
+	for {
+		_, _, e1 := syscall.Syscall6(sysIOUringEnter, uintptr(u.fd), 1, 0, 0, 0, 0)
+		switch e1 {
+		case 0:
+			return nil
+		case syscall.EINTR:
+			continue
+		default:
+			// We can't take the entry back out, but if the kernel
+			// didn't take it, it'll go with the next one.
+			return e1
+		}
+	}
+}
+
+// reap will wait for completions, and hand them back to whoever submitted
+// them, until the uring is closed and nothing is left in flight.
+func (u *uring) reap() {
+	defer close(u.done)
+
+	p := &u.params
+	for {
+		_, _, e1 := syscall.Syscall6(sysIOUringEnter, uintptr(u.fd), 0, 1,
+			uringEnterGetEvents, 0, 0)
+		if e1 != 0 && e1 != syscall.EINTR {
+			u.fail(e1)
+			return
+		}
+
+		u.mutex.Lock()
+		head := *word(u.cqRing, p.cqOff.head)
+		tail := atomic.LoadUint32(word(u.cqRing, p.cqOff.tail))
+		mask := *word(u.cqRing, p.cqOff.ringMask)
+		for ; head != tail; head++ {
+			off := uintptr(p.cqOff.cqes) + uintptr(head&mask)*unsafe.Sizeof(uringCQE{})
+			cqe := *(*uringCQE)(unsafe.Pointer(&u.cqRing[off]))
+			ch, ok := u.pending[cqe.userData]
+			if !ok {
+				continue
+			}
+			delete(u.pending, cqe.userData)
+			if cqe.res < 0 {
+				ch <- syscall.Errno(-cqe.res)
+			} else {
+				ch <- nil
+			}
+		}
+		atomic.StoreUint32(word(u.cqRing, p.cqOff.head), head)
+		finished := u.closing && len(u.pending) == 0
+		u.mutex.Unlock()
+
+		if finished {
+			return
+		}
+	}
+}
+
+// fail will hand err back to everything in flight.
+func (u *uring) fail(err error) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	for userData, ch := range u.pending {
+		ch <- err
+		delete(u.pending, userData)
+	}
+}
+
+// Close will wait for everything in flight to finish, and tear down the
+// uring.
+func (u *uring) Close() error {
+	u.mutex.Lock()
+	u.closing = true
+	err := u.push(uringSQE{opcode: uringOpNop, userData: uringClose})
+	u.mutex.Unlock()
+	if err == nil {
+		<-u.done
+	}
+	// If we couldn't wake the reaper up, it's stuck waiting on the
+	// kernel, so we'll have to leave it be, and not pull the mappings out
+	// from under it.
+	if err != nil {
+		return err
+	}
+	return u.release()
+}
+
+// release will unmap the rings and close the uring's fd.
+func (u *uring) release() error {
+	for _, ring := range [][]byte{u.sqes, u.cqRing, u.sqRing} {
+		if ring != nil {
+			syscall.Munmap(ring)
+		}
+	}
+	return syscall.Close(u.fd)
+}
+
+// vim: foldmethod=marker
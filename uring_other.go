@@ -0,0 +1,68 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+//go:build !linux && !diskring_portable && !wasip1
+// +build !linux,!diskring_portable,!wasip1
+
+package diskring
+
+import (
+	"errors"
+)
+
+// io_uring is only a thing on Linux, so everywhere else, newURing always
+// fails, and SyncAsync and Prefetch do things the slow way.
+
+const (
+	uringOpFsync   = 3
+	uringOpMadvise = 25
+)
+
+// errNoUring is returned by newURing where there's no io_uring.
+var errNoUring = errors.New("diskring: io_uring isn't supported here")
+
+// uringSQE is a submission queue entry.
+type uringSQE struct {
+	opcode  uint8
+	fd      int32
+	addr    uint64
+	len     uint32
+	opFlags uint32
+}
+
+// uring is never set up here.
+type uring struct{}
+
+// newURing always fails, since there's no io_uring.
+func newURing() (*uring, error) {
+	return nil, errNoUring
+}
+
+// submit is never called, since newURing never returns a uring.
+func (u *uring) submit(sqe uringSQE) (<-chan error, error) {
+	return nil, errNoUring
+}
+
+// Close has nothing to tear down.
+func (u *uring) Close() error {
+	return nil
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,60 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"errors"
+)
+
+// ErrNoHeader is returned when doing something with the header on disk, when
+// the Ring doesn't have one.
+var ErrNoHeader = errors.New("diskring: ring doesn't have a header on disk")
+
+// UserHeader will return the part of the header page that isn't used by the
+// library, for the application to keep its own metadata in (such as what's
+// in the records, or who wrote them). This is only available when the
+// ReserveHeader option is set without a CustomHeader, and is nil otherwise.
+//
+// The slice is mapped straight from the file, so anything written to it is
+// persisted along with the rest of the Ring; call SyncHeader to flush it
+// out to disk right away. If the ReadOnlyCursor option is set, this is a
+// copy instead, so that nothing on disk is changed.
+func (r *Ring) UserHeader() []byte {
+	if !r.libraryHeader {
+		return nil
+	}
+	user := r.headerPage[libraryHeaderSize:]
+	if r.readOnly {
+		return append([]byte{}, user...)
+	}
+	return user
+}
+
+// SyncHeader will flush the header page (including the UserHeader) out to
+// disk, and block until that's done.
+func (r *Ring) SyncHeader() error {
+	if r.headerPage == nil {
+		return ErrNoHeader
+	}
+	return r.syncHeader()
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,57 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"testing"
+)
+
+func TestUserHeader(t *testing.T) {
+	path := testRingPath(t)
+	r := openTestRingAt(t, path, Options{ReserveHeader: true})
+	user := r.UserHeader()
+	if len(user) == 0 {
+		t.Fatal("expected some spare header space")
+	}
+	copy(user, "application metadata")
+	if err := r.SyncHeader(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r = openTestRingAt(t, path, Options{ReserveHeader: true})
+	defer r.Close()
+	if got := string(r.UserHeader()[:20]); got != "application metadata" {
+		t.Fatalf("expected the metadata to be kept, got %q", got)
+	}
+
+	plain := openTestRing(t, Options{})
+	if plain.UserHeader() != nil {
+		t.Fatal("expected no UserHeader without ReserveHeader")
+	}
+	if err := plain.SyncHeader(); err != ErrNoHeader {
+		t.Fatalf("expected ErrNoHeader, got %v", err)
+	}
+}
+
+// vim: foldmethod=marker
@@ -22,7 +22,8 @@ package diskring
 
 import (
 	"fmt"
-	"unsafe"
+	"runtime/debug"
+	"sync/atomic"
 )
 
 // BlockWrites will prevent any new writes from hitting the Ring. This will
@@ -43,40 +44,228 @@ func (r *Ring) UnblockWrites() {
 
 // Write a block of data into the disk ring. If there's not enough data in the
 // diskring, this will advance the head until we can fit the data in. If the
-// data is more than 1/4 the size of the ring, the write will fail because
-// it's an arbitrary number I picked.
+// data is more than 1/4 the size of the ring (or the MaxRecordSize option),
+// the write will fail because it's an arbitrary number I picked, unless the
+// SpillDir option is set.
+//
+// If a transaction is open (see Begin), this will wait until it's been
+// committed or rolled back.
 func (r *Ring) Write(buf []byte) (int, error) {
-	if r.readOnly {
-		return 0, fmt.Errorf("diskring: read only")
+	if err := r.validate(buf); err != nil {
+		return 0, err
 	}
-	if len(buf) > int(r.size/4) {
-		return 0, fmt.Errorf("diskring: data is too large")
+
+	t := r.startTiming(OpWrite)
+	defer t.done()
+
+	r.writeMutex.Lock()
+	defer r.writeMutex.Unlock()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	t.lap(PhaseLockWait)
+	defer t.lap(PhaseCopy)
+	if r.coalesce > 0 && len(buf) < r.coalesce {
+		return r.coalesceWrite(buf)
 	}
+	return r.write(r.newEnvelope(), buf)
+}
+
+// WriteIfSequence will write a block of data into the Ring just like Write,
+// but only if the newest record in the Ring still has the sequence number
+// expectedLastSeq, returning a ConflictError (and not writing anything) if
+// something else has written to the Ring since the caller last looked. The
+// sequence number of the newest record is one less than the TailSequence in
+// Stats, which wraps around to the largest uint64 if nothing has ever been
+// written.
+//
+// This lets a writer read the Ring, decide what to write based on what it
+// saw, and write it, without holding any lock while it decides; if it loses
+// the race, it can look again and retry.
+//
+// Any batch of coalesced records is written out before checking, since it
+// holds records that were written before this one.
+func (r *Ring) WriteIfSequence(expectedLastSeq uint64, buf []byte) (int, error) {
+	if err := r.validate(buf); err != nil {
+		return 0, err
+	}
+
+	t := r.startTiming(OpWrite)
+	defer t.done()
+
+	r.writeMutex.Lock()
+	defer r.writeMutex.Unlock()
 
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
+	t.lap(PhaseLockWait)
+	defer t.lap(PhaseCopy)
+	if err := r.flushBatch(); err != nil {
+		return 0, err
+	}
+	if last := r.header.tailSeq - 1; last != expectedLastSeq {
+		return 0, &ConflictError{Expected: expectedLastSeq, Last: last}
+	}
+	return r.writeEntry(r.newEnvelope(), buf)
+}
+
+// UNSAFE
+//
+// Write a block of data into the disk ring with the provided envelope,
+// advancing the head as needed, after any batch of coalesced records. The
+// caller must hold both the writeMutex and the mutex.
+func (r *Ring) write(e envelope, buf []byte) (int, error) {
+	if err := r.flushBatch(); err != nil {
+		return 0, err
+	}
+	return r.writeEntry(e, buf)
+}
+
+// UNSAFE
+//
+// Write a block of data into the disk ring with the provided envelope,
+// advancing the head as needed. The caller must hold both the writeMutex
+// and the mutex.
+func (r *Ring) writeEntry(e envelope, buf []byte) (n int, err error) {
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer r.catchFault(&err)
 
-	blen := uintptr(len(buf))
-	for {
-		if (blen + uintptrSize) > r.freeBytes() {
-			if err := r.advanceHead(); err != nil {
-				return 0, err
-			}
-			continue
+	data := buf
+	if r.spillDir != "" && !r.readOnly && uintptr(len(e.key)+len(buf)) > r.maxRecord {
+		name, err := r.spill(buf)
+		if err != nil {
+			return 0, err
+		}
+		data = []byte(name)
+		e.flags |= flagSpilled
+	} else if r.dictionaries != nil && len(buf) < r.compressBelow {
+		if compressed := r.compress(buf); len(compressed) < len(buf) {
+			data = compressed
+			e.flags |= flagDictionary
 		}
-		break
 	}
 
-	m := copy(r.buf[r.cursor.tail+uintptrSize:], buf)
-	*(*uintptr)(unsafe.Pointer(&r.buf[r.cursor.tail])) = uintptr(m)
-	r.cursor.tail = ((r.cursor.tail + uintptrSize + uintptr(m)) % r.size)
+	if err := r.checkWrite(len(e.key) + len(data)); err != nil {
+		r.unspill(e, data)
+		return 0, err
+	}
+	if err := r.reserve(uintptr(len(e.key) + len(data))); err != nil {
+		r.unspill(e, data)
+		return 0, err
+	}
+	off := r.stageOffset()
+	r.publish(r.putEntry(off, e, data), 1, off)
+	return len(buf), nil
+}
 
-	select {
-	case r.wakeup <- struct{}{}:
-	default:
+// validate will run the ValidateWrite hook (if there is one) over a record
+// that's about to be written. This must be called without the mutex held,
+// since the hook could take a while.
+func (r *Ring) validate(buf []byte) error {
+	if r.validateWrite == nil {
+		return nil
+	}
+	if err := r.validateWrite(buf); err != nil {
+		return fmt.Errorf("diskring: write rejected: %w", err)
 	}
+	return nil
+}
 
-	return m, nil
+// UNSAFE
+//
+// Check that an entry with length bytes of data (including any key) is
+// allowed to be written to the ring at all.
+func (r *Ring) checkWrite(length int) error {
+	if r.fileErr != nil {
+		return r.fileErr
+	}
+	if r.readOnly {
+		return fmt.Errorf("diskring: read only")
+	}
+	if err := r.checkLease(); err != nil {
+		return err
+	}
+	if r.layout.has(formatSignature) && r.signingKey == nil {
+		return ErrNoSigningKey
+	}
+	if limit := int(r.maxRecord); length > limit {
+		return &TooLargeError{Size: length, Limit: limit}
+	}
+	return nil
+}
+
+// UNSAFE
+//
+// Advance the head until there's enough room to write an entry with length
+// bytes of data (and any padding in front of it) after the tail and
+// anything that's currently staged.
+//
+// If the entry filled the ring exactly, the tail would land right on top
+// of the head, and the ring would look empty. So we always have to keep at
+// least one byte free.
+func (r *Ring) reserve(length uintptr) error {
+	size := r.entryPadding(r.stageOffset(), length) + r.entrySize(length)
+	for (r.staged + size) >= r.freeBytes() {
+		length := uint64(r.entryLength(*r.head))
+		if err := r.advanceHead(); err != nil {
+			return err
+		}
+		r.overwritten++
+		atomic.AddUint64(&r.header.overwrittenRecords, 1)
+		atomic.AddUint64(&r.header.overwrittenBytes, length)
+	}
+	return nil
+}
+
+// UNSAFE
+//
+// Return the offset where the next entry should be written, which is after
+// the tail and any staged entries that haven't been published yet.
+func (r *Ring) stageOffset() uintptr {
+	return (*r.tail + r.staged) % r.size
+}
+
+// UNSAFE
+//
+// Move the tail forward by size bytes, making count entries visible to
+// readers, and wake up anyone waiting on a read. last is the offset of the
+// final entry being published.
+func (r *Ring) publish(size uintptr, count uint64, last uintptr) {
+	end := (*r.tail + size) % r.size
+	seq := r.header.tailSeq
+	for off := *r.tail; off != end; seq++ {
+		next := r.nextEntry(off)
+		if !r.rewriting {
+			r.sizes.observe(r.entryLength(off), (next+r.size-off)%r.size)
+		}
+		if r.indexed {
+			r.index = append(r.index, off)
+		}
+		r.noteSparse(off, seq)
+		r.noteKey(off, seq)
+		off = next
+	}
+	if *r.tail+size >= r.size {
+		atomic.AddUint64(&r.header.wraps, 1)
+	}
+	r.beginUpdate()
+	r.setTail((*r.tail+size)%r.size, r.header.tailSeq+count)
+	if count > 0 {
+		atomic.StoreUintptr(&r.header.last, last+1)
+	}
+	r.header.chain = r.chain
+	r.endUpdate()
+	if r.syncer != nil {
+		r.syncer.written(size)
+	}
+
+	if count > 0 {
+		r.notify()
+	}
+	if count > 0 && r.waiting > 0 {
+		close(r.wakeup)
+		r.wakeup = make(chan struct{})
+	}
 }
 
 // vim: foldmethod=marker
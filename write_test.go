@@ -0,0 +1,98 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com> 2020-2021
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package diskring
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestValidateWrite(t *testing.T) {
+	errBad := errors.New("bad record")
+	r := openTestRing(t, Options{
+		NonBlockingReads: true,
+		ValidateWrite: func(buf []byte) error {
+			if bytes.Contains(buf, []byte("bad")) {
+				return errBad
+			}
+			return nil
+		},
+	})
+
+	if _, err := r.Write([]byte("a bad record")); !errors.Is(err, errBad) {
+		t.Fatalf("expected the record to be rejected, got %v", err)
+	}
+	if _, err := r.WriteRecord(Record{Data: []byte("bad again")}); !errors.Is(err, errBad) {
+		t.Fatalf("expected the record to be rejected, got %v", err)
+	}
+	w, err := r.BeginRecord()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("streamed, but bad")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Commit(); !errors.Is(err, errBad) {
+		t.Fatalf("expected the record to be rejected, got %v", err)
+	}
+
+	writeRecords(t, r, "good")
+	if record := readRecord(t, r); record != "good" {
+		t.Fatalf("expected only the good record, got %q", record)
+	}
+	if r.Records() != 0 {
+		t.Fatalf("expected no more records, got %d", r.Records())
+	}
+}
+
+func TestWriteIfSequence(t *testing.T) {
+	r := openTestRing(t, Options{NonBlockingReads: true})
+
+	// With nothing ever written, the newest record is the one before 0.
+	if _, err := r.WriteIfSequence(^uint64(0), []byte("one")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.WriteIfSequence(0, []byte("two")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Someone else gets in first.
+	writeRecords(t, r, "three")
+	_, err := r.WriteIfSequence(1, []byte("four"))
+	if !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+	if conflict, ok := err.(*ConflictError); !ok || conflict.Expected != 1 || conflict.Last != 2 {
+		t.Fatalf("expected a conflict between 1 and 2, got %v", err)
+	}
+	if r.Records() != 3 {
+		t.Fatalf("expected the conflicting write not to be written, got %d records", r.Records())
+	}
+
+	// Reading doesn't change the newest record.
+	readRecord(t, r)
+	if _, err := r.WriteIfSequence(2, []byte("four")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// vim: foldmethod=marker